@@ -1,203 +0,0 @@
-package main
-
-import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"sync/atomic"
-	"time"
-)
-
-type key int
-
-// Service struct
-type Service struct {
-	ServiceName string `json:"serviceName"`
-	Value       int    `json:"value"`
-}
-
-const (
-	requestIDKey key    = 0
-	serverURL    string = "http://localhost:15000/post"
-	host         string = "0.0.0.0"
-	port         string = ":9000"
-)
-
-var (
-	listenAddr string
-	healthy    int32
-)
-
-var results []string
-
-var integers []int
-
-func main() {
-	var err error
-	listenAddr = host + port
-	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
-	logger.Println("Server is starting...")
-
-	router := http.NewServeMux()
-	router.Handle("/", index())
-	router.HandleFunc("/post", postCall)
-
-	nextRequestID := func() string {
-		return fmt.Sprintf("%d", time.Now().UnixNano())
-	}
-
-	// Deferred functions run in reverse order so this will be the last
-	// one called, after any tidy up.
-	defer func() {
-		if err != nil {
-			fmt.Println("error encountered:", err)
-			os.Exit(1)
-		} else {
-			fmt.Println("exiting")
-		}
-	}()
-
-	server := &http.Server{
-		Addr:         host + port,
-		Handler:      tracing(nextRequestID)(logging(logger)(router)),
-		ErrorLog:     logger,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
-	}
-
-	done := make(chan bool)
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-
-	go func() {
-		<-quit
-		logger.Println("Server is shutting down...")
-		atomic.StoreInt32(&healthy, 0)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		server.SetKeepAlivesEnabled(false)
-		if err := server.Shutdown(ctx); err != nil {
-			logger.Fatalf("Could not gracefully shutdown the server: %v\n", err)
-		}
-		close(done)
-	}()
-
-	logger.Println("Server is ready to handle requests at", listenAddr)
-	atomic.StoreInt32(&healthy, 1)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		logger.Fatalf("Could not listen on %s: %v\n", listenAddr, err)
-	}
-
-	<-done
-	logger.Println("Server stopped")
-}
-
-func index() http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-			return
-		}
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintln(w, "This is Server B!")
-	})
-}
-
-// postCall converts post request body to string
-func postCall(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			http.Error(w, "Error reading request body",
-				http.StatusInternalServerError)
-		}
-		servicea := Service{}
-		err = json.Unmarshal(body, &servicea)
-		if err != nil {
-			http.Error(w, "Error reading request body",
-				http.StatusInternalServerError)
-		}
-		fmt.Printf("received value %v\n", servicea)
-		results = append(results, string(servicea.Value))
-
-		// Send integer value to serverC
-		postValueToServer(servicea.Value + 100)
-		integers = append(integers, servicea.Value+100)
-
-		fmt.Fprint(w, "POST done")
-	} else {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-	}
-}
-
-func postValueToServer(value int) {
-	// converts it into a string
-	body := &Service{
-		ServiceName: "serverB",
-		Value:       value,
-	}
-	payloadBuf := new(bytes.Buffer)
-	err := json.NewEncoder(payloadBuf).Encode(body)
-	if err != nil {
-		panic(err)
-	}
-	// Prints the integer value generated
-	fmt.Printf("sending value %v\n", value)
-
-	req, _ := http.NewRequest("POST", serverURL, payloadBuf)
-	req.Header.Set("Content-Type", "application/text")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
-
-	fmt.Println("response Status:", resp.Status)
-	fmt.Println("response Headers:", resp.Header)
-	respBody, _ := ioutil.ReadAll(resp.Body)
-	fmt.Println("response Body:", string(respBody))
-	resp.Body.Close()
-
-}
-
-func logging(logger *log.Logger) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			defer func() {
-				requestID, ok := r.Context().Value(requestIDKey).(string)
-				if !ok {
-					requestID = "unknown"
-				}
-				logger.Println(requestID, r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
-			}()
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			requestID := r.Header.Get("X-Request-Id")
-			if requestID == "" {
-				requestID = nextRequestID()
-			}
-			ctx := context.WithValue(r.Context(), requestIDKey, requestID)
-			w.Header().Set("X-Request-Id", requestID)
-			next.ServeHTTP(w, r.WithContext(ctx))
-		})
-	}
-}
@@ -1,2 +1,2 @@
 #!/bin/bash
-/opt/app2 > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
+/opt/app2 -drain-delay 5s > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
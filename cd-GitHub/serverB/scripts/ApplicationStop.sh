@@ -1,3 +1,4 @@
 #!/bin/bash
-killall app2
+# Wait for the server to drain, so values in flight are not lost
+killall -w app2
 exit 0
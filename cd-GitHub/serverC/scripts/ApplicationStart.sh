@@ -1,2 +1,2 @@
 #!/bin/bash
-/opt/app1 > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
+/opt/app1 -archive-dir /var/lib/serverc > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
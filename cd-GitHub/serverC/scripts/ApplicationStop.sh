@@ -1,4 +1,5 @@
 #!/bin/bash
-killall /opt/app1
+# Wait for the server to drain, so values in flight are not lost
+killall -w /opt/app1
 rm /opt/app1
 exit 0
@@ -1,3 +0,0 @@
-module servicea
-
-go 1.14
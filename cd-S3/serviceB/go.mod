@@ -1,8 +0,0 @@
-module serverb
-
-go 1.14
-
-require (
-	golang.org/x/lint v0.0.0-20200302205851-738671d3881b // indirect
-	golang.org/x/tools v0.0.0-20201120155355-20be4ac4bd6e // indirect
-)
@@ -1,2 +1,2 @@
 #!/bin/bash
-/opt/serviceb > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
+/opt/serviceb -drain-delay 5s > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
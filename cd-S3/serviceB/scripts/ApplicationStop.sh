@@ -5,6 +5,8 @@ PIDCMD="pgrep -f "$COMMAND""
 if [[ $($PIDCMD) ]]
 then
      pgrep -f "$COMMAND" | xargs kill $1
+     # Wait for the server to drain, so values in flight are not lost
+     while pgrep -f "$COMMAND" > /dev/null; do sleep 1; done
 else
      echo "NO PID"
 fi
\ No newline at end of file
@@ -1,2 +1,2 @@
 #!/bin/bash
-curl --http1.1 http://localhost:9000
+curl --http1.1 --fail --retry 5 --retry-connrefused http://localhost:9000/healthz
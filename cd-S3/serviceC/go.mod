@@ -1,5 +0,0 @@
-module server
-
-go 1.14
-
-require github.com/gorilla/mux v1.8.0
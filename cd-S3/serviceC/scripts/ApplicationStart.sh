@@ -1,2 +1,4 @@
 #!/bin/bash
+# Settings such as ARCHIVE_BUCKET can be kept in /etc/default/servicec
+set -a; [ -f /etc/default/servicec ] && . /etc/default/servicec; set +a
 /opt/servicec > /dev/null 2> /dev/null < /dev/null &
\ No newline at end of file
@@ -1,2 +1,2 @@
 #!/bin/bash
-curl --http1.1 http://localhost:15000
+curl --http1.1 --fail --retry 5 --retry-connrefused http://localhost:15000/healthz
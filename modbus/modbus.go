@@ -29,6 +29,12 @@ func (s *Server) WriteRegister(address uint16, value uint16) {
 	s.s.HoldingRegisters[address] = value
 }
 
+// ReadRegister reads the value at the given address, for example one
+// written by a client
+func (s *Server) ReadRegister(address uint16) uint16 {
+	return s.s.HoldingRegisters[address]
+}
+
 // Close closes the server
 func (s *Server) Close() {
 	s.s.Close()
@@ -61,6 +67,12 @@ func (c *Client) ReadRegister(address uint16) (float32, error) {
 	return conversions.Float32FromBytes(result), nil
 }
 
+// WriteRegister writes a value to the given address
+func (c *Client) WriteRegister(address uint16, value uint16) error {
+	_, err := c.client.WriteSingleRegister(address, value)
+	return err
+}
+
 // Close closes the client
 func (c *Client) Close() error {
 	return c.handler.Close()
@@ -0,0 +1,32 @@
+module github.com/evergreen-innovations/blogs/modbus_simulators
+
+go 1.21
+
+require (
+	github.com/evergreen-innovations/blogs/modbus v0.0.0-20200627010824-8ff29584d6eb
+	github.com/gorilla/websocket v1.5.3
+	github.com/parquet-go/parquet-go v0.23.0
+	google.golang.org/grpc v1.64.1
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/goburrow/modbus v0.1.0 // indirect
+	github.com/goburrow/serial v0.1.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/mattn/go-runewidth v0.0.15 // indirect
+	github.com/olekukonko/tablewriter v0.0.5 // indirect
+	github.com/pierrec/lz4/v4 v4.1.21 // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	github.com/segmentio/encoding v0.4.0 // indirect
+	github.com/tbrandon/mbserver v0.0.0-20170611213546-993e1772cc62 // indirect
+	golang.org/x/net v0.26.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+)
+
+// The simulators are developed alongside the modbus package
+replace github.com/evergreen-innovations/blogs/modbus => ../modbus
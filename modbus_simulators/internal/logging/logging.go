@@ -0,0 +1,42 @@
+// Package logging sets up the structured logger shared by the simulators.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Flags holds the logging commandline options
+type Flags struct {
+	Level string
+	JSON  bool
+}
+
+// RegisterFlags adds the -log-level and -log-json options to the flag set
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.StringVar(&f.Level, "log-level", "info", "minimum level to log: debug, info, warn or error")
+	fs.BoolVar(&f.JSON, "log-json", false, "log as JSON rather than text, for shipping to a log aggregator")
+	return f
+}
+
+// New creates a logger writing to w with the options from the flags
+func (f *Flags) New(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(f.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", f.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if f.JSON {
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(w, opts)), nil
+}
+
+// Discard returns a logger which drops everything
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
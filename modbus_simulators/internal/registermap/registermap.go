@@ -0,0 +1,123 @@
+// Package registermap defines the Modbus registers exposed by the simulated
+// devices, shared by the simulators and the supervisor so the two sides
+// cannot drift apart.
+package registermap
+
+// Register addresses of the power meter
+const (
+	FrequencyAddr uint16 = 16384
+	PhaseV1Addr   uint16 = 16386
+	PhaseV2Addr   uint16 = 16388
+	PhaseV3Addr   uint16 = 16390
+	CurrentI1Addr uint16 = 16402
+	CurrentI2Addr uint16 = 16404
+	CurrentI3Addr uint16 = 16406
+)
+
+// WatchdogAddr is the register of the power meter the supervisor writes an
+// incrementing heartbeat to. The power meter is not a source of values for
+// it, so it is not listed in PowerMeter.
+const WatchdogAddr uint16 = 16416
+
+// FaultPattern is written to every output of the power meter when the
+// heartbeat stops, so a lost supervisor cannot mistake the values for
+// real ones.
+const FaultPattern uint16 = 0xFFFF
+
+// Register addresses of the battery inverter. The mode and power
+// setpoint are written by the supervisor to control the inverter.
+const (
+	StateOfChargeAddr  uint16 = 16640
+	ChargePowerAddr    uint16 = 16642
+	DischargePowerAddr uint16 = 16644
+	ModeAddr           uint16 = 16646
+	PowerSetpointAddr  uint16 = 16648
+	FaultCodeAddr      uint16 = 16650
+)
+
+// Register addresses of the wave energy converter
+const (
+	WaveHeightAddr     uint16 = 16896
+	PTOForceAddr       uint16 = 16898
+	GeneratorSpeedAddr uint16 = 16900
+	ProducedPowerAddr  uint16 = 16902
+	ProducedEnergyAddr uint16 = 16904
+)
+
+// Operating modes of the battery inverter, held in ModeAddr
+const (
+	ModeStandby uint16 = iota
+	ModeCharge
+	ModeDischarge
+)
+
+// Fault codes of the battery inverter, held in FaultCodeAddr
+const (
+	FaultNone uint16 = iota
+	FaultBatteryFull
+	FaultBatteryEmpty
+	FaultInvalidMode
+)
+
+// Units of the register values
+const (
+	Hertz       = "Hz"
+	Volts       = "V"
+	Ampere      = "A"
+	Percent     = "%"
+	Watts       = "W"
+	Centimetres = "cm"
+	Newtons     = "N"
+	RPM         = "rpm"
+	KiloWattHrs = "kWh"
+	None        = ""
+)
+
+// Register stores the name, address and unit of a register
+type Register struct {
+	Name    string
+	Address uint16
+	Unit    string
+}
+
+// PowerMeter lists the registers of the power meter
+var PowerMeter = []Register{
+	{"Frequency", FrequencyAddr, Hertz},
+	{"PhaseV1", PhaseV1Addr, Volts},
+	{"PhaseV2", PhaseV2Addr, Volts},
+	{"PhaseV3", PhaseV3Addr, Volts},
+	{"CurrentI1", CurrentI1Addr, Ampere},
+	{"CurrentI2", CurrentI2Addr, Ampere},
+	{"CurrentI3", CurrentI3Addr, Ampere},
+}
+
+// BatteryInverter lists the registers of the battery inverter
+var BatteryInverter = []Register{
+	{"StateOfCharge", StateOfChargeAddr, Percent},
+	{"ChargePower", ChargePowerAddr, Watts},
+	{"DischargePower", DischargePowerAddr, Watts},
+	{"Mode", ModeAddr, None},
+	{"PowerSetpoint", PowerSetpointAddr, Watts},
+	{"FaultCode", FaultCodeAddr, None},
+}
+
+// WaveEnergyConverter lists the registers of the wave energy converter
+var WaveEnergyConverter = []Register{
+	{"WaveHeight", WaveHeightAddr, Centimetres},
+	{"PTOForce", PTOForceAddr, Newtons},
+	{"GeneratorSpeed", GeneratorSpeedAddr, RPM},
+	{"ProducedPower", ProducedPowerAddr, Watts},
+	{"ProducedEnergy", ProducedEnergyAddr, KiloWattHrs},
+}
+
+// Lookup returns the register at the given address of any of the devices
+func Lookup(address uint16) (Register, bool) {
+	for _, registers := range [][]Register{PowerMeter, BatteryInverter, WaveEnergyConverter} {
+		for _, r := range registers {
+			if r.Address == address {
+				return r, true
+			}
+		}
+	}
+	return Register{}, false
+}
@@ -0,0 +1,120 @@
+package simulator
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Debugger wraps a device so its simulation can be paused, single-stepped
+// and its registers dumped over HTTP, which makes it easier to debug and
+// to take screenshots of.
+type Debugger struct {
+	device    Device
+	r         Registers
+	registers []registermap.Register
+	logger    *slog.Logger
+
+	mu     sync.Mutex // serialises updates and protects paused
+	paused bool
+}
+
+// NewDebugger creates a debugger for the device, which dumps the given
+// registers from r.
+func NewDebugger(device Device, r Registers, registers []registermap.Register, paused bool, logger *slog.Logger) *Debugger {
+	return &Debugger{
+		device:    device,
+		r:         r,
+		registers: registers,
+		logger:    logger,
+		paused:    paused,
+	}
+}
+
+// Update updates the device unless the debugger is paused
+func (d *Debugger) Update(r Registers, dt time.Duration, logger *slog.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.paused {
+		return
+	}
+	d.device.Update(r, dt, logger)
+}
+
+// SetPaused pauses or resumes the updates
+func (d *Debugger) SetPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.paused = paused
+}
+
+// Step pauses the updates and makes a single one
+func (d *Debugger) Step() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.paused = true
+	d.device.Update(d.r, UpdateInterval, d.logger)
+}
+
+// Dump writes a table of the registers and their current values
+func (d *Debugger) Dump(w *tabwriter.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := "running"
+	if d.paused {
+		state = "paused"
+	}
+	fmt.Fprintf(w, "Simulation %v\n\n", state)
+	fmt.Fprintln(w, "REGISTER\tADDRESS\tVALUE\tUNIT")
+	for _, r := range d.registers {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", r.Name, r.Address, d.r.ReadRegister(r.Address), r.Unit)
+	}
+	w.Flush()
+}
+
+// Handler returns the routes of the debugger:
+//
+//	POST /pause      stop updating the registers
+//	POST /resume     start updating the registers again
+//	POST /step       pause, then make a single update
+//	GET  /registers  dump the registers
+func (d *Debugger) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pause", d.command(func() { d.SetPaused(true) }))
+	mux.HandleFunc("/resume", d.command(func() { d.SetPaused(false) }))
+	mux.HandleFunc("/step", d.command(d.Step))
+	mux.HandleFunc("/registers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		d.Dump(tabwriter.NewWriter(w, 0, 8, 2, ' ', 0))
+	})
+	return mux
+}
+
+// command handles a route which runs f, then dumps the registers so the
+// effect can be seen straight away
+func (d *Debugger) command(f func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		f()
+		d.logger.Info("debug command", "path", r.URL.Path)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		d.Dump(tabwriter.NewWriter(w, 0, 8, 2, ' ', 0))
+	}
+}
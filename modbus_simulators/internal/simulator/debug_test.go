@@ -0,0 +1,67 @@
+package simulator
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestDebugger(t *testing.T) {
+	r := registers{}
+	meter := NewPowerMeter(rand.New(rand.NewSource(1)))
+	d := NewDebugger(meter, r, registermap.PowerMeter, true, logging.Discard())
+	handler := d.Handler()
+
+	send := func(method, path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(method, path, nil))
+		return response
+	}
+
+	// Paused from the start, so updates are skipped
+	d.Update(r, UpdateInterval, logging.Discard())
+	if len(r) != 0 {
+		t.Fatalf("registers written while paused: %v", r)
+	}
+
+	// A step writes once and stays paused
+	response := send(http.MethodPost, "/step")
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %v", response.Code)
+	}
+	stepped := r[registermap.FrequencyAddr]
+	if len(r) != len(registermap.PowerMeter) {
+		t.Errorf("got %v registers written by a step, want %v", len(r), len(registermap.PowerMeter))
+	}
+	d.Update(r, UpdateInterval, logging.Discard())
+	if r[registermap.FrequencyAddr] != stepped {
+		t.Error("registers updated after stepping")
+	}
+
+	// The dump lists every register
+	dump := send(http.MethodGet, "/registers").Body.String()
+	if !strings.Contains(dump, "Simulation paused") {
+		t.Errorf("dump does not show the simulation is paused:\n%v", dump)
+	}
+	for _, reg := range registermap.PowerMeter {
+		if !strings.Contains(dump, reg.Name) {
+			t.Errorf("dump is missing %v:\n%v", reg.Name, dump)
+		}
+	}
+
+	// Resuming lets the updates through again
+	send(http.MethodPost, "/resume")
+	d.Update(r, UpdateInterval, logging.Discard())
+	if r[registermap.FrequencyAddr] == stepped {
+		t.Error("registers not updated after resuming")
+	}
+
+	if got := send(http.MethodGet, "/pause").Code; got != http.StatusMethodNotAllowed {
+		t.Errorf("got status %v for GET /pause, want %v", got, http.StatusMethodNotAllowed)
+	}
+}
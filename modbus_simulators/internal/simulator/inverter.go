@@ -0,0 +1,66 @@
+package simulator
+
+import (
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Battery models the battery behind the inverter
+type Battery struct {
+	CapacityWh float64
+	MaxPowerW  float64
+	SOC        float64 // state of charge in percent
+}
+
+// Step advances the battery by dt in the given mode, charging or
+// discharging at the setpoint power limited to the maximum power. It
+// returns the charge and discharge power actually achieved and any
+// fault preventing the inverter from following the setpoint.
+func (b *Battery) Step(mode, setpoint uint16, dt time.Duration) (chargeW, dischargeW float64, fault uint16) {
+	power := math.Min(float64(setpoint), b.MaxPowerW)
+	energy := power * dt.Hours() / b.CapacityWh * 100
+
+	switch mode {
+	case registermap.ModeStandby:
+		return 0, 0, registermap.FaultNone
+	case registermap.ModeCharge:
+		if b.SOC >= 100 {
+			return 0, 0, registermap.FaultBatteryFull
+		}
+		b.SOC = math.Min(b.SOC+energy, 100)
+		return power, 0, registermap.FaultNone
+	case registermap.ModeDischarge:
+		if b.SOC <= 0 {
+			return 0, 0, registermap.FaultBatteryEmpty
+		}
+		b.SOC = math.Max(b.SOC-energy, 0)
+		return 0, power, registermap.FaultNone
+	default:
+		return 0, 0, registermap.FaultInvalidMode
+	}
+}
+
+// Update follows the mode and power setpoint written by the supervisor,
+// and writes back the state of the battery
+func (b *Battery) Update(r Registers, dt time.Duration, logger *slog.Logger) {
+	mode := r.ReadRegister(registermap.ModeAddr)
+	setpoint := r.ReadRegister(registermap.PowerSetpointAddr)
+	charge, discharge, fault := b.Step(mode, setpoint, dt)
+
+	r.WriteRegister(registermap.StateOfChargeAddr, uint16(math.Round(b.SOC)))
+	r.WriteRegister(registermap.ChargePowerAddr, uint16(charge))
+	r.WriteRegister(registermap.DischargePowerAddr, uint16(discharge))
+	r.WriteRegister(registermap.FaultCodeAddr, fault)
+
+	logger.Info("battery updated",
+		"mode", mode,
+		"setpoint", setpoint,
+		"soc", b.SOC,
+		"charge", charge,
+		"discharge", discharge,
+		"fault", fault,
+	)
+}
@@ -0,0 +1,47 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestBatteryStep(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		soc           float64
+		mode          uint16
+		setpoint      uint16
+		wantSOC       float64
+		wantCharge    float64
+		wantDischarge float64
+		wantFault     uint16
+	}{
+		{"standby", 50, registermap.ModeStandby, 1000, 50, 0, 0, registermap.FaultNone},
+		{"charging", 50, registermap.ModeCharge, 1000, 60, 1000, 0, registermap.FaultNone},
+		{"discharging", 50, registermap.ModeDischarge, 1000, 40, 0, 1000, registermap.FaultNone},
+		{"setpoint limited to max power", 50, registermap.ModeCharge, 5000, 70, 2000, 0, registermap.FaultNone},
+		{"charging stops when full", 95, registermap.ModeCharge, 1000, 100, 1000, 0, registermap.FaultNone},
+		{"full battery", 100, registermap.ModeCharge, 1000, 100, 0, 0, registermap.FaultBatteryFull},
+		{"empty battery", 0, registermap.ModeDischarge, 1000, 0, 0, 0, registermap.FaultBatteryEmpty},
+		{"invalid mode", 50, 7, 1000, 50, 0, 0, registermap.FaultInvalidMode},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			b := &Battery{CapacityWh: 10000, MaxPowerW: 2000, SOC: testCase.soc}
+			charge, discharge, fault := b.Step(testCase.mode, testCase.setpoint, time.Hour)
+
+			if b.SOC != testCase.wantSOC {
+				t.Errorf("got SOC %v, want %v", b.SOC, testCase.wantSOC)
+			}
+			if charge != testCase.wantCharge || discharge != testCase.wantDischarge {
+				t.Errorf("got power %v/%v, want %v/%v", charge, discharge, testCase.wantCharge, testCase.wantDischarge)
+			}
+			if fault != testCase.wantFault {
+				t.Errorf("got fault %v, want %v", fault, testCase.wantFault)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package simulator
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// PowerMeter writes random values to the power meter registers
+type PowerMeter struct {
+	rnd *rand.Rand
+}
+
+// NewPowerMeter creates a power meter drawing its values from rnd
+func NewPowerMeter(rnd *rand.Rand) *PowerMeter {
+	return &PowerMeter{rnd: rnd}
+}
+
+// Update writes a new random value to every register
+func (p *PowerMeter) Update(r Registers, dt time.Duration, logger *slog.Logger) {
+	for _, reg := range registermap.PowerMeter {
+		value := uint16(p.rnd.Int())
+		logger.Info("writing", "register", reg.Name, "address", reg.Address, "value", value)
+		r.WriteRegister(reg.Address, value)
+	}
+}
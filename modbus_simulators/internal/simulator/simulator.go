@@ -0,0 +1,42 @@
+// Package simulator holds the models of the simulated devices, so they can
+// be run on their own by the device binaries or together by the launcher.
+package simulator
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// UpdateInterval is how often the devices update their registers
+const UpdateInterval = 500 * time.Millisecond
+
+// Registers is the register table of a modbus server, which the devices
+// read their commands from and write their outputs to
+type Registers interface {
+	ReadRegister(address uint16) uint16
+	WriteRegister(address uint16, value uint16)
+}
+
+// Device is a simulated device
+type Device interface {
+	// Update advances the device by dt, reading any commands from the
+	// registers and writing back its outputs
+	Update(r Registers, dt time.Duration, logger *slog.Logger)
+}
+
+// Run updates the device every UpdateInterval until the context is
+// cancelled
+func Run(ctx context.Context, r Registers, d Device, logger *slog.Logger) {
+	ticker := time.NewTicker(UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.Update(r, UpdateInterval, logger)
+	}
+}
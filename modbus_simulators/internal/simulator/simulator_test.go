@@ -0,0 +1,44 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// registers is an in-memory register table
+type registers map[uint16]uint16
+
+func (r registers) ReadRegister(address uint16) uint16 {
+	return r[address]
+}
+
+func (r registers) WriteRegister(address uint16, value uint16) {
+	r[address] = value
+}
+
+func TestBatteryUpdate(t *testing.T) {
+	r := registers{
+		registermap.ModeAddr:          registermap.ModeCharge,
+		registermap.PowerSetpointAddr: 1000,
+	}
+	b := &Battery{CapacityWh: 10000, MaxPowerW: 5000, SOC: 50}
+
+	b.Update(r, time.Hour, logging.Discard())
+
+	want := registers{
+		registermap.ModeAddr:           registermap.ModeCharge,
+		registermap.PowerSetpointAddr:  1000,
+		registermap.StateOfChargeAddr:  60,
+		registermap.ChargePowerAddr:    1000,
+		registermap.DischargePowerAddr: 0,
+		registermap.FaultCodeAddr:      registermap.FaultNone,
+	}
+	for address, value := range want {
+		if r[address] != value {
+			t.Errorf("register %v: got %v, want %v", address, r[address], value)
+		}
+	}
+}
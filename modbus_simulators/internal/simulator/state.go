@@ -0,0 +1,146 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Persistent is a device whose state can be saved on shutdown and restored
+// on start, so long-running demos survive a restart.
+type Persistent interface {
+	Device
+
+	// MarshalState returns the state of the device, including any commands
+	// held in the registers.
+	MarshalState(r Registers) ([]byte, error)
+	// UnmarshalState restores the device and its registers from the state
+	UnmarshalState(data []byte, r Registers) error
+}
+
+// SaveState writes the state of the device to the file at path. The state
+// is written to a temporary file first, so a crash while saving cannot
+// leave a half-written state behind.
+func SaveState(path string, d Persistent, r Registers) error {
+	data, err := d.MarshalState(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadState restores the state of the device from the file at path. It
+// returns false, leaving the device as it is, if there is no saved state.
+func LoadState(path string, d Persistent, r Registers) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := d.UnmarshalState(data, r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// batteryState is the saved state of a battery inverter
+type batteryState struct {
+	SOC      float64 `json:"soc"`
+	Mode     uint16  `json:"mode"`
+	Setpoint uint16  `json:"setpoint"`
+}
+
+// MarshalState saves the state of charge, along with the mode and power
+// setpoint last written by the supervisor.
+func (b *Battery) MarshalState(r Registers) ([]byte, error) {
+	return json.Marshal(batteryState{
+		SOC:      b.SOC,
+		Mode:     r.ReadRegister(registermap.ModeAddr),
+		Setpoint: r.ReadRegister(registermap.PowerSetpointAddr),
+	})
+}
+
+// UnmarshalState restores the state of charge, and the mode and setpoint
+// so the inverter carries on before the supervisor reconnects.
+func (b *Battery) UnmarshalState(data []byte, r Registers) error {
+	var state batteryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	b.SOC = state.SOC
+	r.WriteRegister(registermap.ModeAddr, state.Mode)
+	r.WriteRegister(registermap.PowerSetpointAddr, state.Setpoint)
+	return nil
+}
+
+// wecState is the saved state of a wave energy converter. The sea is
+// saved too, as the phases of its waves are only meaningful alongside the
+// simulation time.
+type wecState struct {
+	Sea        Sea     `json:"sea"`
+	Time       float64 `json:"time"`
+	Position   float64 `json:"position"`
+	Velocity   float64 `json:"velocity"`
+	Energy     float64 `json:"energy"`
+	Elevation  float64 `json:"elevation"`
+	Crest      float64 `json:"crest"`
+	Trough     float64 `json:"trough"`
+	WaveHeight float64 `json:"wave_height"`
+}
+
+// MarshalState saves the sea and the motion of the buoy
+func (w *WEC) MarshalState(Registers) ([]byte, error) {
+	return json.Marshal(wecState{
+		Sea:        w.Sea,
+		Time:       w.Time,
+		Position:   w.Position,
+		Velocity:   w.Velocity,
+		Energy:     w.Energy,
+		Elevation:  w.elevation,
+		Crest:      w.crest,
+		Trough:     w.trough,
+		WaveHeight: w.waveHeight,
+	})
+}
+
+// UnmarshalState restores the sea and the motion of the buoy, replacing
+// the sea the WEC was created with.
+func (w *WEC) UnmarshalState(data []byte, _ Registers) error {
+	var state wecState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	w.Sea = state.Sea
+	w.Time = state.Time
+	w.Position = state.Position
+	w.Velocity = state.Velocity
+	w.Energy = state.Energy
+	w.elevation = state.Elevation
+	w.crest = state.Crest
+	w.trough = state.Trough
+	w.waveHeight = state.WaveHeight
+	return nil
+}
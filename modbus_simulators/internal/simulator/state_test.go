@@ -0,0 +1,76 @@
+package simulator
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestLoadStateMissing(t *testing.T) {
+	b := &Battery{SOC: 50}
+	ok, err := LoadState(filepath.Join(t.TempDir(), "missing.json"), b, registers{})
+	if ok || err != nil {
+		t.Errorf("got %v, %v, want false without an error", ok, err)
+	}
+	if b.SOC != 50 {
+		t.Errorf("SOC changed to %v", b.SOC)
+	}
+}
+
+func TestBatteryState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "battery.json")
+	r := registers{
+		registermap.ModeAddr:          registermap.ModeDischarge,
+		registermap.PowerSetpointAddr: 2000,
+	}
+	if err := SaveState(path, &Battery{SOC: 42.5}, r); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+
+	restored := &Battery{SOC: 50}
+	r = registers{}
+	if ok, err := LoadState(path, restored, r); !ok || err != nil {
+		t.Fatalf("loading: %v, %v", ok, err)
+	}
+	if restored.SOC != 42.5 || r[registermap.ModeAddr] != registermap.ModeDischarge || r[registermap.PowerSetpointAddr] != 2000 {
+		t.Errorf("got SOC %v and registers %v, want the saved state", restored.SOC, r)
+	}
+}
+
+func TestWECState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wec.json")
+	sea := NewSea(1.5, 7, rand.New(rand.NewSource(1)))
+	original := NewWEC(sea, 4, 30000, 30000)
+	original.Step(time.Minute)
+	if err := SaveState(path, original, registers{}); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+
+	// A restored WEC carries on exactly where the original left off, even
+	// when created in a different sea
+	restored := NewWEC(NewSea(4, 10, rand.New(rand.NewSource(2))), 4, 30000, 30000)
+	if ok, err := LoadState(path, restored, registers{}); !ok || err != nil {
+		t.Fatalf("loading: %v, %v", ok, err)
+	}
+	original.Step(time.Minute)
+	restored.Step(time.Minute)
+
+	if restored.Time != original.Time || restored.Position != original.Position ||
+		restored.Energy != original.Energy || restored.WaveHeight() != original.WaveHeight() {
+		t.Errorf("got %+v, want %+v", restored, original)
+	}
+	if original.Energy <= 0 {
+		t.Errorf("got energy %v, want some produced", original.Energy)
+	}
+
+	// Saving the state does not disturb the device
+	r := registers{}
+	original.Update(r, UpdateInterval, logging.Discard())
+	if r[registermap.ProducedEnergyAddr] != toRegister(original.Energy/1000) {
+		t.Errorf("got energy register %v", r[registermap.ProducedEnergyAddr])
+	}
+}
@@ -0,0 +1,59 @@
+package simulator
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Watchdog wraps a device as a PLC interlock would: when the heartbeat
+// written to the watchdog register stops changing for the timeout, the
+// outputs of the device are overwritten with the fault pattern until the
+// heartbeat resumes.
+type Watchdog struct {
+	device  Device
+	outputs []registermap.Register
+	timeout time.Duration
+
+	heartbeat uint16
+	silent    time.Duration // since the heartbeat last changed
+	tripped   bool
+}
+
+// NewWatchdog creates a watchdog over the given outputs of the device
+func NewWatchdog(device Device, outputs []registermap.Register, timeout time.Duration) *Watchdog {
+	return &Watchdog{
+		device:  device,
+		outputs: outputs,
+		timeout: timeout,
+	}
+}
+
+// Update updates the device while the heartbeat is alive, and writes the
+// fault pattern to its outputs otherwise
+func (w *Watchdog) Update(r Registers, dt time.Duration, logger *slog.Logger) {
+	if heartbeat := r.ReadRegister(registermap.WatchdogAddr); heartbeat != w.heartbeat {
+		w.heartbeat = heartbeat
+		w.silent = 0
+	} else {
+		w.silent += dt
+	}
+
+	if w.silent < w.timeout {
+		if w.tripped {
+			logger.Info("heartbeat resumed", "heartbeat", w.heartbeat)
+			w.tripped = false
+		}
+		w.device.Update(r, dt, logger)
+		return
+	}
+
+	if !w.tripped {
+		logger.Warn("heartbeat stopped, writing fault pattern", "heartbeat", w.heartbeat, "silent", w.silent)
+		w.tripped = true
+	}
+	for _, reg := range w.outputs {
+		r.WriteRegister(reg.Address, registermap.FaultPattern)
+	}
+}
@@ -0,0 +1,47 @@
+package simulator
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestWatchdog(t *testing.T) {
+	r := registers{}
+	meter := NewPowerMeter(rand.New(rand.NewSource(1)))
+	w := NewWatchdog(meter, registermap.PowerMeter, time.Second)
+
+	testCases := []struct {
+		desc      string
+		heartbeat uint16
+		wantFault bool
+	}{
+		{"first heartbeat", 1, false},
+		{"heartbeat", 2, false},
+		{"missed once", 2, false},
+		{"missed for the timeout", 2, true},
+		{"still missing", 2, true},
+		{"resumed", 3, false},
+	}
+
+	for _, testCase := range testCases {
+		r.WriteRegister(registermap.WatchdogAddr, testCase.heartbeat)
+		w.Update(r, 500*time.Millisecond, logging.Discard())
+
+		faulted := 0
+		for _, reg := range registermap.PowerMeter {
+			if r[reg.Address] == registermap.FaultPattern {
+				faulted++
+			}
+		}
+		if gotFault := faulted == len(registermap.PowerMeter); gotFault != testCase.wantFault {
+			t.Errorf("%v: got %v of %v outputs faulted, want fault %v", testCase.desc, faulted, len(registermap.PowerMeter), testCase.wantFault)
+		}
+		if r[registermap.WatchdogAddr] != testCase.heartbeat {
+			t.Errorf("%v: heartbeat overwritten", testCase.desc)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package simulator
+
+import (
+	"log/slog"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Constants for the sea water
+const (
+	gravity       = 9.81   // m/s^2
+	waterDensity  = 1025.0 // kg/m^3
+	maxStep       = 10 * time.Millisecond
+	seaComponents = 16
+)
+
+// WaveComponent is a single regular wave making up the sea
+type WaveComponent struct {
+	Amplitude float64 // m
+	Frequency float64 // rad/s
+	Phase     float64 // rad
+}
+
+// Sea is an irregular sea made up of regular wave components
+type Sea []WaveComponent
+
+// NewSea creates an irregular sea with the given significant wave height
+// and peak period by sampling a Pierson-Moskowitz spectrum with random
+// phases.
+func NewSea(hs, tp float64, rnd *rand.Rand) Sea {
+	wp := 2 * math.Pi / tp
+	lo, hi := 0.5*wp, 3*wp
+	dw := (hi - lo) / seaComponents
+
+	sea := make(Sea, seaComponents)
+	for i := range sea {
+		w := lo + (float64(i)+0.5)*dw
+		s := 5.0 / 16 * hs * hs * math.Pow(wp, 4) / math.Pow(w, 5) * math.Exp(-1.25*math.Pow(wp/w, 4))
+		sea[i] = WaveComponent{
+			Amplitude: math.Sqrt(2 * s * dw),
+			Frequency: w,
+			Phase:     rnd.Float64() * 2 * math.Pi,
+		}
+	}
+	return sea
+}
+
+// Elevation returns the height of the sea surface at time t in seconds
+func (s Sea) Elevation(t float64) float64 {
+	var eta float64
+	for _, c := range s {
+		eta += c.Amplitude * math.Cos(c.Frequency*t+c.Phase)
+	}
+	return eta
+}
+
+// WEC models a heaving point absorber: a floating buoy driven by the
+// waves, whose motion is resisted by a linear power take-off (PTO) which
+// turns a generator through a rack and pinion.
+type WEC struct {
+	Sea              Sea
+	Mass             float64 // buoy mass including added mass, kg
+	Stiffness        float64 // hydrostatic stiffness, N/m
+	RadiationDamping float64 // Ns/m
+	PTODamping       float64 // Ns/m
+	PinionRadius     float64 // m
+	GearRatio        float64
+	Efficiency       float64 // generator efficiency, 0 to 1
+
+	// State of the buoy
+	Time     float64 // s
+	Position float64 // m
+	Velocity float64 // m/s
+	Energy   float64 // electrical energy produced, Wh
+
+	// Wave height tracking between upward zero crossings of the surface
+	elevation  float64
+	crest      float64
+	trough     float64
+	waveHeight float64
+}
+
+// NewWEC creates a WEC with a cylindrical buoy of the given diameter in m,
+// and mass in kg, in the given sea.
+func NewWEC(sea Sea, diameter, mass, ptoDamping float64) *WEC {
+	area := math.Pi * diameter * diameter / 4
+	return &WEC{
+		Sea:              sea,
+		Mass:             mass,
+		Stiffness:        waterDensity * gravity * area,
+		RadiationDamping: 0.1 * ptoDamping,
+		PTODamping:       ptoDamping,
+		PinionRadius:     0.1,
+		GearRatio:        15,
+		Efficiency:       0.9,
+	}
+}
+
+// Step advances the simulation by dt, integrating the motion of the buoy
+// in steps small enough to follow the waves.
+func (w *WEC) Step(dt time.Duration) {
+	for dt > 0 {
+		h := maxStep
+		if dt < h {
+			h = dt
+		}
+		dt -= h
+		w.step(h.Seconds())
+	}
+}
+
+func (w *WEC) step(h float64) {
+	eta := w.Sea.Elevation(w.Time)
+	w.trackWaveHeight(eta)
+
+	// The waves push the buoy through the hydrostatic stiffness, which is
+	// a fair approximation for waves much longer than the buoy.
+	force := w.Stiffness*(eta-w.Position) - (w.RadiationDamping+w.PTODamping)*w.Velocity
+
+	// Semi-implicit Euler is stable for the oscillating buoy
+	w.Velocity += force / w.Mass * h
+	w.Position += w.Velocity * h
+	w.Time += h
+	w.Energy += w.Power() * h / 3600
+}
+
+// trackWaveHeight measures the crest to trough height of each wave as the
+// surface crosses zero on the way up, as a wave buoy would.
+func (w *WEC) trackWaveHeight(eta float64) {
+	if w.elevation < 0 && eta >= 0 {
+		w.waveHeight = w.crest - w.trough
+		w.crest, w.trough = 0, 0
+	}
+	w.crest = math.Max(w.crest, eta)
+	w.trough = math.Min(w.trough, eta)
+	w.elevation = eta
+}
+
+// WaveHeight returns the height of the last complete wave in m
+func (w *WEC) WaveHeight() float64 {
+	return w.waveHeight
+}
+
+// PTOForce returns the magnitude of the force resisting the buoy in N
+func (w *WEC) PTOForce() float64 {
+	return math.Abs(w.PTODamping * w.Velocity)
+}
+
+// GeneratorSpeed returns the speed of the generator in rpm
+func (w *WEC) GeneratorSpeed() float64 {
+	return math.Abs(w.Velocity) / w.PinionRadius * w.GearRatio * 60 / (2 * math.Pi)
+}
+
+// Power returns the electrical power produced in W
+func (w *WEC) Power() float64 {
+	return w.PTODamping * w.Velocity * w.Velocity * w.Efficiency
+}
+
+// Update moves the buoy with the waves and writes its outputs to the
+// registers
+func (w *WEC) Update(r Registers, dt time.Duration, logger *slog.Logger) {
+	w.Step(dt)
+
+	r.WriteRegister(registermap.WaveHeightAddr, toRegister(w.WaveHeight()*100))
+	r.WriteRegister(registermap.PTOForceAddr, toRegister(w.PTOForce()))
+	r.WriteRegister(registermap.GeneratorSpeedAddr, toRegister(w.GeneratorSpeed()))
+	r.WriteRegister(registermap.ProducedPowerAddr, toRegister(w.Power()))
+	r.WriteRegister(registermap.ProducedEnergyAddr, toRegister(w.Energy/1000))
+
+	logger.Info("wec updated",
+		"wave_height", w.WaveHeight(),
+		"pto_force", w.PTOForce(),
+		"generator_speed", w.GeneratorSpeed(),
+		"power", w.Power(),
+		"energy", w.Energy,
+	)
+}
+
+// toRegister rounds a value to fit in a register, saturating at the
+// largest value a register can hold.
+func toRegister(v float64) uint16 {
+	return uint16(math.Min(math.Round(v), math.MaxUint16))
+}
@@ -0,0 +1,54 @@
+package simulator
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWaveHeight(t *testing.T) {
+	sea := Sea{{Amplitude: 0.75, Frequency: 2 * math.Pi / 6}}
+	w := NewWEC(sea, 4, 30000, 30000)
+	w.Step(30 * time.Second)
+
+	if got := w.WaveHeight(); math.Abs(got-1.5) > 0.01 {
+		t.Errorf("got wave height %v, want 1.5", got)
+	}
+}
+
+func TestWECCalmSea(t *testing.T) {
+	w := NewWEC(nil, 4, 30000, 30000)
+	w.Step(time.Minute)
+
+	if w.WaveHeight() != 0 || w.PTOForce() != 0 || w.GeneratorSpeed() != 0 || w.Power() != 0 {
+		t.Errorf("got %v m, %v N, %v rpm, %v W in a calm sea, want all zero",
+			w.WaveHeight(), w.PTOForce(), w.GeneratorSpeed(), w.Power())
+	}
+}
+
+func TestWECCoupling(t *testing.T) {
+	sea := NewSea(1.5, 7, rand.New(rand.NewSource(1)))
+	w := NewWEC(sea, 4, 30000, 30000)
+
+	// The outputs are all driven by the velocity of the buoy, so they
+	// must rise and fall together.
+	for i := 0; i < 100; i++ {
+		w.Step(500 * time.Millisecond)
+
+		v := math.Abs(w.Velocity)
+		if got, want := w.PTOForce(), w.PTODamping*v; math.Abs(got-want) > 1e-6 {
+			t.Fatalf("got force %v, want %v", got, want)
+		}
+		if got, want := w.Power(), w.PTOForce()*v*w.Efficiency; math.Abs(got-want) > 1e-6 {
+			t.Fatalf("got power %v, want %v", got, want)
+		}
+		if w.Power() < 0 {
+			t.Fatalf("got negative power %v", w.Power())
+		}
+	}
+
+	if w.WaveHeight() == 0 {
+		t.Error("no waves measured")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/simulator"
+)
+
+const (
+	defaultHost     string  = "0.0.0.0"
+	defaultPort     string  = ":1504"
+	defaultCapacity float64 = 10000
+	defaultMaxPower float64 = 5000
+	defaultSOC      float64 = 50
+)
+
+func main() {
+	var mainErr error
+	logger := slog.Default()
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if mainErr != nil {
+			logger.Error("error encountered", "err", mainErr)
+			os.Exit(1)
+		} else {
+			logger.Info("exiting")
+		}
+	}()
+
+	// Set up the commandline options
+	host := flag.String("host", defaultHost, "host for the modbus server")
+	port := flag.String("port", defaultPort, "port for the modbus server")
+	capacity := flag.Float64("capacity", defaultCapacity, "battery capacity in Wh")
+	maxPower := flag.Float64("max-power", defaultMaxPower, "maximum charge and discharge power in W")
+	soc := flag.Float64("soc", defaultSOC, "initial state of charge in percent")
+	statePath := flag.String("state", "", "file to save the state of charge and commands to on shutdown and resume from on start (empty to not persist)")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	l, err := logFlags.New(os.Stderr)
+	if err != nil {
+		mainErr = err
+		return
+	}
+	logger = l
+
+	// Open the modbus server
+	addr := fmt.Sprintf("%s%s", *host, *port)
+	s, err := modbus.NewServer(addr)
+	if err != nil {
+		mainErr = fmt.Errorf("creating server: %v", err)
+		return
+	}
+	defer s.Close()
+
+	logger.Info("modbus server for battery inverter running", "addr", addr)
+
+	battery := &simulator.Battery{
+		CapacityWh: *capacity,
+		MaxPowerW:  *maxPower,
+		SOC:        *soc,
+	}
+
+	// Optionally resume from the state saved by a previous run
+	if *statePath != "" {
+		restored, err := simulator.LoadState(*statePath, battery, s)
+		if err != nil {
+			mainErr = fmt.Errorf("loading state: %v", err)
+			return
+		}
+		if restored {
+			logger.Info("resumed from saved state", "path", *statePath)
+		}
+	}
+
+	// The context is cancelled when a signal is trapped
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Closed once the simulation loop has stopped
+	simulated := make(chan struct{})
+
+	// Go-routine for following the commands written by the supervisor
+	// and updating the battery registers
+	go func() {
+		defer close(simulated)
+
+		simulator.Run(ctx, s, battery, logger)
+	}()
+
+	// Block execution until a signal is trapped, then wait for the
+	// simulation loop to stop before the deferred functions close the server.
+	<-ctx.Done()
+	logger.Info("signal trapped, shutting down")
+	<-simulated
+
+	if *statePath != "" {
+		if err := simulator.SaveState(*statePath, battery, s); err != nil {
+			mainErr = fmt.Errorf("saving state: %v", err)
+			return
+		}
+		logger.Info("saved state", "path", *statePath)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/simulator"
+)
+
+// maxUnitID is the highest unit ID a Modbus device can be given
+const maxUnitID = 247
+
+// Config lists the devices started by the launcher
+type Config struct {
+	Devices []DeviceConfig `json:"devices"`
+}
+
+// DeviceConfig describes a single simulated device. A unit ID is accepted
+// but not used, as the server answers requests for any; devices are told
+// apart by port.
+type DeviceConfig struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Port    string `json:"port"`
+	UnitID  int    `json:"unit_id"`
+	Profile string `json:"profile"`
+}
+
+// profile creates a device with a preset set of parameters
+type profile func(rnd *rand.Rand) simulator.Device
+
+// profiles holds the presets of each device type
+var profiles = map[string]map[string]profile{
+	"powermeter": {
+		"random": func(rnd *rand.Rand) simulator.Device {
+			return simulator.NewPowerMeter(rnd)
+		},
+	},
+	"inverter": {
+		"home": func(*rand.Rand) simulator.Device {
+			return &simulator.Battery{CapacityWh: 10000, MaxPowerW: 5000, SOC: 50}
+		},
+		"commercial": func(*rand.Rand) simulator.Device {
+			return &simulator.Battery{CapacityWh: 100000, MaxPowerW: 50000, SOC: 50}
+		},
+	},
+	"wec": {
+		"calm": func(rnd *rand.Rand) simulator.Device {
+			return simulator.NewWEC(simulator.NewSea(0.5, 5, rnd), 4, 30000, 30000)
+		},
+		"moderate": func(rnd *rand.Rand) simulator.Device {
+			return simulator.NewWEC(simulator.NewSea(1.5, 7, rnd), 4, 30000, 30000)
+		},
+		"storm": func(rnd *rand.Rand) simulator.Device {
+			return simulator.NewWEC(simulator.NewSea(4, 10, rnd), 4, 30000, 30000)
+		},
+	},
+}
+
+// defaultProfiles holds the preset used when a device does not name one
+var defaultProfiles = map[string]string{
+	"powermeter": "random",
+	"inverter":   "home",
+	"wec":        "moderate",
+}
+
+// LoadConfig reads and checks the launcher configuration from the JSON
+// file at path, filling in the default name and profile of each device.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	f, err := os.Open(path)
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&config); err != nil {
+		return config, fmt.Errorf("decoding %v: %v", path, err)
+	}
+
+	if err := config.check(); err != nil {
+		return config, fmt.Errorf("checking %v: %v", path, err)
+	}
+	return config, nil
+}
+
+func (c *Config) check() error {
+	if len(c.Devices) == 0 {
+		return fmt.Errorf("no devices listed")
+	}
+
+	names := make(map[string]bool)
+	ports := make(map[string]bool)
+	for i := range c.Devices {
+		d := &c.Devices[i]
+
+		if d.Name == "" {
+			d.Name = fmt.Sprintf("%v-%v", d.Type, i+1)
+		}
+		if names[d.Name] {
+			return fmt.Errorf("device %v listed more than once", d.Name)
+		}
+		names[d.Name] = true
+
+		// The server answers requests for any unit ID, so devices sharing
+		// a port cannot be told apart.
+		if d.Port == "" {
+			return fmt.Errorf("device %v has no port", d.Name)
+		}
+		if ports[d.Port] {
+			return fmt.Errorf("device %v: port %v already in use", d.Name, d.Port)
+		}
+		ports[d.Port] = true
+
+		if d.UnitID < 0 || d.UnitID > maxUnitID {
+			return fmt.Errorf("device %v: unit ID %v out of range 1 to %v", d.Name, d.UnitID, maxUnitID)
+		}
+
+		presets, ok := profiles[d.Type]
+		if !ok {
+			return fmt.Errorf("device %v: unknown type %q", d.Name, d.Type)
+		}
+		if d.Profile == "" {
+			d.Profile = defaultProfiles[d.Type]
+		}
+		if _, ok := presets[d.Profile]; !ok {
+			return fmt.Errorf("device %v: unknown %v profile %q", d.Name, d.Type, d.Profile)
+		}
+	}
+	return nil
+}
+
+// newDevice creates the simulated device described by the configuration
+func (d DeviceConfig) newDevice(rnd *rand.Rand) simulator.Device {
+	return profiles[d.Type][d.Profile](rnd)
+}
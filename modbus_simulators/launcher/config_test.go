@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		config  string
+		wantErr string
+	}{
+		{
+			"defaults filled in",
+			`{"devices": [{"type": "wec", "port": ":1505"}]}`,
+			"",
+		}, {
+			"unit ID accepted",
+			`{"devices": [{"type": "wec", "port": ":1505", "unit_id": 3}]}`,
+			"",
+		}, {
+			"no devices",
+			`{"devices": []}`,
+			"no devices listed",
+		}, {
+			"unknown field",
+			`{"devices": [{"type": "wec", "port": ":1505", "speed": 2}]}`,
+			"unknown field",
+		}, {
+			"unknown type",
+			`{"devices": [{"type": "toaster", "port": ":1505"}]}`,
+			`unknown type "toaster"`,
+		}, {
+			"unknown profile",
+			`{"devices": [{"type": "wec", "port": ":1505", "profile": "tsunami"}]}`,
+			`unknown wec profile "tsunami"`,
+		}, {
+			"shared port",
+			`{"devices": [{"type": "wec", "port": ":1505"}, {"type": "inverter", "port": ":1505"}]}`,
+			"port :1505 already in use",
+		}, {
+			"duplicate name",
+			`{"devices": [{"name": "a", "type": "wec", "port": ":1505"}, {"name": "a", "type": "wec", "port": ":1506"}]}`,
+			"device a listed more than once",
+		}, {
+			"unit ID out of range",
+			`{"devices": [{"type": "wec", "port": ":1505", "unit_id": 248}]}`,
+			"unit ID 248 out of range",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "fleet.json")
+			if err := os.WriteFile(path, []byte(testCase.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if testCase.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+					t.Fatalf("got error %v, want %q", err, testCase.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			d := config.Devices[0]
+			if d.Name != "wec-1" || d.Profile != "moderate" {
+				t.Errorf("got %+v, want the defaults filled in", d)
+			}
+		})
+	}
+}
+
+func TestExampleConfig(t *testing.T) {
+	if _, err := LoadConfig(defaultConfig); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/simulator"
+)
+
+const (
+	defaultHost   string = "0.0.0.0"
+	defaultConfig string = "fleet.json"
+)
+
+func main() {
+	var mainErr error
+	logger := slog.Default()
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if mainErr != nil {
+			logger.Error("error encountered", "err", mainErr)
+			os.Exit(1)
+		} else {
+			logger.Info("exiting")
+		}
+	}()
+
+	// Set up the commandline options
+	host := flag.String("host", defaultHost, "host for the modbus servers")
+	configPath := flag.String("config", defaultConfig, "JSON file listing the devices to simulate")
+	stateDir := flag.String("state-dir", "", "directory to save the state of each device to on shutdown and resume from on start (empty to not persist)")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	l, err := logFlags.New(os.Stderr)
+	if err != nil {
+		mainErr = err
+		return
+	}
+	logger = l
+
+	config, err := LoadConfig(*configPath)
+	if err != nil {
+		mainErr = fmt.Errorf("loading config: %v", err)
+		return
+	}
+
+	// Open a modbus server for every device before starting any of them,
+	// so a port clash stops the whole fleet rather than leaving it half
+	// running. The deferred functions close the servers already opened.
+	servers := make([]*modbus.Server, len(config.Devices))
+	for i, d := range config.Devices {
+		addr := fmt.Sprintf("%s%s", *host, d.Port)
+		s, err := modbus.NewServer(addr)
+		if err != nil {
+			mainErr = fmt.Errorf("creating server for %v: %v", d.Name, err)
+			return
+		}
+		defer s.Close()
+		servers[i] = s
+
+		logger.Info("modbus server running", "device", d.Name, "type", d.Type, "profile", d.Profile, "addr", addr)
+	}
+
+	// Create every device and load its saved state before starting any of
+	// them, so a bad state file stops the whole fleet rather than leaving
+	// it half running.
+	devices := make([]simulator.Device, len(config.Devices))
+	seed := time.Now().UnixNano()
+	for i, d := range config.Devices {
+		if d.UnitID != 0 {
+			logger.Warn("unit ID ignored, the server answers requests for any", "device", d.Name, "unit_id", d.UnitID)
+		}
+
+		// Each device gets its own source as they are not safe to share
+		// between go-routines
+		devices[i] = d.newDevice(rand.New(rand.NewSource(seed + int64(i))))
+
+		// Devices with state resume from, and save to, a file named after
+		// them. The power meter has no state worth keeping.
+		if persistent, ok := devices[i].(simulator.Persistent); ok && *stateDir != "" {
+			statePath := filepath.Join(*stateDir, d.Name+".json")
+			restored, err := simulator.LoadState(statePath, persistent, servers[i])
+			if err != nil {
+				mainErr = fmt.Errorf("loading state of %v: %v", d.Name, err)
+				return
+			}
+			if restored {
+				logger.Info("resumed from saved state", "device", d.Name, "path", statePath)
+			}
+		}
+	}
+
+	// The context is cancelled when a signal is trapped, which stops
+	// every device.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Go-routine for each device, tracked so that all have stopped before
+	// the deferred functions close the servers
+	var wg sync.WaitGroup
+	for i, d := range config.Devices {
+		device := devices[i]
+		deviceLogger := logger.With("device", d.Name)
+		persistent, _ := device.(simulator.Persistent)
+		statePath := filepath.Join(*stateDir, d.Name+".json")
+
+		wg.Add(1)
+		go func(s *modbus.Server) {
+			defer wg.Done()
+			simulator.Run(ctx, s, device, deviceLogger)
+
+			if *stateDir != "" && persistent != nil {
+				if err := simulator.SaveState(statePath, persistent, s); err != nil {
+					deviceLogger.Error("saving state", "path", statePath, "err", err)
+					return
+				}
+				deviceLogger.Info("saved state", "path", statePath)
+			}
+		}(servers[i])
+	}
+
+	logger.Info("fleet running", "devices", len(config.Devices))
+
+	// Block execution until a signal is trapped, then wait for every
+	// device to stop.
+	<-ctx.Done()
+	logger.Info("signal trapped, shutting down")
+	wg.Wait()
+}
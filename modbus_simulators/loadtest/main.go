@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+)
+
+const (
+	defaultAddr        string = "localhost:1503"
+	defaultConcurrency int    = 10
+	defaultDuration           = 10 * time.Second
+	defaultMix         string = "read=80,write=20"
+)
+
+func main() {
+	var mainErr error
+	logger := slog.Default()
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if mainErr != nil {
+			logger.Error("error encountered", "err", mainErr)
+			os.Exit(1)
+		}
+	}()
+
+	// Set up the commandline options
+	addr := flag.String("addr", defaultAddr, "address of the modbus server to test")
+	concurrency := flag.Int("concurrency", defaultConcurrency, "number of clients sending requests at once, each on its own connection")
+	duration := flag.Duration("duration", defaultDuration, "how long to send requests for")
+	mixFlag := flag.String("mix", defaultMix, "relative share of read and write requests, e.g. read=80,write=20")
+	address := flag.Uint("address", 0, "register to read and write, which should not be one the device uses")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	l, err := logFlags.New(os.Stderr)
+	if err != nil {
+		mainErr = err
+		return
+	}
+	logger = l
+
+	mix, err := ParseMix(*mixFlag)
+	if err != nil {
+		mainErr = fmt.Errorf("parsing mix: %v", err)
+		return
+	}
+	if *concurrency < 1 {
+		mainErr = fmt.Errorf("concurrency must be at least 1, got %v", *concurrency)
+		return
+	}
+	if *address > 0xFFFF {
+		mainErr = fmt.Errorf("address %v out of range", *address)
+		return
+	}
+
+	// Connect every client before starting, so the connection time is not
+	// counted in the results. The deferred functions close them.
+	clients := make([]*modbus.Client, *concurrency)
+	for i := range clients {
+		c, err := modbus.NewClient(*addr)
+		if err != nil {
+			mainErr = fmt.Errorf("creating client: %v", err)
+			return
+		}
+		defer c.Close()
+		clients[i] = c
+	}
+
+	logger.Info("load testing modbus server", "addr", *addr, "concurrency", *concurrency, "duration", *duration, "mix", *mixFlag)
+
+	// The test stops when the duration is up or a signal is trapped,
+	// reporting on the requests sent so far.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	// Each worker keeps its own stats, merged once all have finished
+	results := make([]map[string]*Stats, len(clients))
+	var wg sync.WaitGroup
+	start := time.Now()
+	seed := start.UnixNano()
+	for i, c := range clients {
+		results[i] = newStats(mix)
+		wg.Add(1)
+		go func(c *modbus.Client, stats map[string]*Stats, rnd *rand.Rand) {
+			defer wg.Done()
+			run(ctx, c, uint16(*address), mix, rnd, stats, logger)
+		}(c, results[i], rand.New(rand.NewSource(seed+int64(i))))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	stats := newStats(mix)
+	for _, r := range results {
+		for op, s := range r {
+			stats[op].Merge(s)
+		}
+	}
+	Report(os.Stdout, mix.Ops(), stats, elapsed)
+}
+
+func newStats(mix Mix) map[string]*Stats {
+	stats := make(map[string]*Stats)
+	for _, op := range mix.Ops() {
+		stats[op] = &Stats{}
+	}
+	return stats
+}
+
+// run sends requests one after another until the context is done
+func run(ctx context.Context, c *modbus.Client, address uint16, mix Mix, rnd *rand.Rand, stats map[string]*Stats, logger *slog.Logger) {
+	for ctx.Err() == nil {
+		op := mix.Pick(rnd)
+
+		var err error
+		sent := time.Now()
+		switch op {
+		case opRead:
+			_, err = c.ReadRegister(address)
+		case opWrite:
+			err = c.WriteRegister(address, uint16(rnd.Intn(0x10000)))
+		}
+		latency := time.Since(sent)
+
+		if err != nil {
+			logger.Debug("request failed", "op", op, "err", err)
+			stats[op].AddError()
+			continue
+		}
+		stats[op].Add(latency)
+	}
+}
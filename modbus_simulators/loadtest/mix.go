@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operations the load test can send
+const (
+	opRead  = "read"
+	opWrite = "write"
+)
+
+// Mix is the share of each operation in the requests sent
+type Mix struct {
+	ops     []string
+	weights []int
+	total   int
+}
+
+// ParseMix parses a mix such as "read=80,write=20". The weights are
+// relative, so "read=4,write=1" is the same mix.
+func ParseMix(s string) (Mix, error) {
+	var m Mix
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Mix{}, fmt.Errorf("invalid mix entry %q, want op=weight", part)
+		}
+		if op != opRead && op != opWrite {
+			return Mix{}, fmt.Errorf("unknown operation %q", op)
+		}
+		if seen[op] {
+			return Mix{}, fmt.Errorf("operation %q listed more than once", op)
+		}
+		seen[op] = true
+
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return Mix{}, fmt.Errorf("invalid weight %q for %v", weight, op)
+		}
+		m.ops = append(m.ops, op)
+		m.weights = append(m.weights, w)
+		m.total += w
+	}
+	if m.total == 0 {
+		return Mix{}, fmt.Errorf("mix %q has no requests", s)
+	}
+	return m, nil
+}
+
+// Pick chooses an operation at random, in proportion to the weights
+func (m Mix) Pick(rnd *rand.Rand) string {
+	n := rnd.Intn(m.total)
+	for i, w := range m.weights {
+		if n < w {
+			return m.ops[i]
+		}
+		n -= w
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+// Ops returns the operations in the mix, sorted by name
+func (m Mix) Ops() []string {
+	ops := append([]string(nil), m.ops...)
+	sort.Strings(ops)
+	return ops
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		mix     string
+		wantErr bool
+	}{
+		{"read and write", "read=80,write=20", false},
+		{"spaces", "read=4, write=1", false},
+		{"reads only", "read=1", false},
+		{"missing weight", "read", true},
+		{"unknown op", "read=1,delete=1", true},
+		{"repeated op", "read=1,read=2", true},
+		{"negative weight", "read=-1,write=2", true},
+		{"no requests", "read=0,write=0", true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			_, err := ParseMix(testCase.mix)
+			if gotErr := err != nil; gotErr != testCase.wantErr {
+				t.Errorf("got error %v, want error %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestMixPick(t *testing.T) {
+	mix, err := ParseMix("read=3,write=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[mix.Pick(rnd)]++
+	}
+	if share := float64(counts[opRead]) / 10000; share < 0.72 || share > 0.78 {
+		t.Errorf("got %v of reads, want about 0.75", share)
+	}
+}
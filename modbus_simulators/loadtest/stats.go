@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Stats holds the results of one operation
+type Stats struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// Add records the latency of a successful request
+func (s *Stats) Add(latency time.Duration) {
+	s.latencies = append(s.latencies, latency)
+}
+
+// AddError records a failed request
+func (s *Stats) AddError() {
+	s.errors++
+}
+
+// Merge adds the results of other, e.g. from another worker
+func (s *Stats) Merge(other *Stats) {
+	s.latencies = append(s.latencies, other.latencies...)
+	s.errors += other.errors
+}
+
+// Percentile returns the latency below which the fraction p of the
+// successful requests fell, using the nearest rank. It sorts the
+// latencies, so is not safe to call while adding to the stats.
+func (s *Stats) Percentile(p float64) time.Duration {
+	if len(s.latencies) == 0 {
+		return 0
+	}
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+
+	rank := int(math.Ceil(p*float64(len(s.latencies)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return s.latencies[rank]
+}
+
+// Report writes a table of the throughput and latency of each operation
+func Report(out io.Writer, ops []string, stats map[string]*Stats, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OP\tREQUESTS\tERRORS\tREQ/S\tP50\tP90\tP99\tMAX")
+
+	total := &Stats{}
+	for _, op := range ops {
+		s := stats[op]
+		writeRow(tw, op, s, elapsed)
+		total.Merge(s)
+	}
+	writeRow(tw, "total", total, elapsed)
+	tw.Flush()
+}
+
+func writeRow(w io.Writer, name string, s *Stats, elapsed time.Duration) {
+	fmt.Fprintf(w, "%v\t%v\t%v\t%.1f\t%v\t%v\t%v\t%v\n",
+		name,
+		len(s.latencies),
+		s.errors,
+		float64(len(s.latencies))/elapsed.Seconds(),
+		s.Percentile(0.5),
+		s.Percentile(0.9),
+		s.Percentile(0.99),
+		s.Percentile(1),
+	)
+}
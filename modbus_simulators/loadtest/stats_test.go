@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	s := &Stats{}
+	for i := 100; i >= 1; i-- {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+
+	testCases := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{0.5, 50 * time.Millisecond},
+		{0.99, 99 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+	}
+	for _, testCase := range testCases {
+		if got := s.Percentile(testCase.p); got != testCase.want {
+			t.Errorf("p%v: got %v, want %v", testCase.p*100, got, testCase.want)
+		}
+	}
+
+	if got := (&Stats{}).Percentile(0.5); got != 0 {
+		t.Errorf("got %v with no requests, want 0", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	read := &Stats{}
+	read.Add(time.Millisecond)
+	read.Add(3 * time.Millisecond)
+	write := &Stats{}
+	write.AddError()
+
+	var buf bytes.Buffer
+	Report(&buf, []string{opRead, opWrite}, map[string]*Stats{opRead: read, opWrite: write}, time.Second)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %v lines, want a header, a row per op and the total:\n%v", len(lines), buf.String())
+	}
+	if total := strings.Fields(lines[3]); total[0] != "total" || total[1] != "2" || total[2] != "1" || total[3] != "2.0" {
+		t.Errorf("got total row %q", lines[3])
+	}
+}
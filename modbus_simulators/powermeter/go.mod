@@ -1,5 +0,0 @@
-module powermeter
-
-go 1.14
-
-require github.com/evergreen-innovations/blogs/modbus v0.0.0-20200627010824-8ff29584d6eb // indirect
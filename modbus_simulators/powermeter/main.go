@@ -1,45 +1,23 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/simulator"
 )
 
-// Defining register values for the demo
-const (
-	FrequencyAddr uint16 = 16384
-	PhaseV1Addr   uint16 = 16386
-	PhaseV2Addr   uint16 = 16388
-	PhaseV3Addr   uint16 = 16390
-	CurrentI1Addr uint16 = 16402
-	CurrentI2Addr uint16 = 16404
-	CurrentI3Addr uint16 = 16406
-)
-
-// Register stores the name and address of a register
-type Register struct {
-	Name    string
-	Address uint16
-}
-
-var registers = []Register{
-	{"Frequency", FrequencyAddr},
-	{"PhaseV1", PhaseV1Addr},
-	{"PhaseV2", PhaseV2Addr},
-	{"PhaseV3", PhaseV3Addr},
-	{"CurrentI1", CurrentI1Addr},
-	{"CurrentI2", CurrentI2Addr},
-	{"CurrentI3", CurrentI3Addr},
-}
-
 const (
 	defaultHost string = "0.0.0.0"
 	defaultPort string = ":1503"
@@ -47,23 +25,35 @@ const (
 
 func main() {
 	var mainErr error
+	logger := slog.Default()
 
 	// Deferred functions run in reverse order so this will be the last
 	// one called, after any tidy up.
 	defer func() {
 		if mainErr != nil {
-			log.Println("error encountered:", mainErr)
+			logger.Error("error encountered", "err", mainErr)
 			os.Exit(1)
 		} else {
-			log.Println("exiting")
+			logger.Info("exiting")
 		}
 	}()
 
 	// Set up the commandline options
 	host := flag.String("host", defaultHost, "host for the modbus server")
 	port := flag.String("port", defaultPort, "port for the modbus server")
+	debugAddr := flag.String("debug", "", "address for the HTTP routes to pause, step and dump the simulation, e.g. :8081 (empty to disable)")
+	paused := flag.Bool("paused", false, "start with the simulation paused, to step it with the debug routes")
+	watchdog := flag.Duration("watchdog", 0, "write the fault pattern to every output when the supervisor's heartbeat stops for this long, e.g. 3s (0 to disable)")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
+	l, err := logFlags.New(os.Stderr)
+	if err != nil {
+		mainErr = err
+		return
+	}
+	logger = l
+
 	// Open the modbus server
 	addr := fmt.Sprintf("%s%s", *host, *port)
 	s, err := modbus.NewServer(addr)
@@ -73,39 +63,69 @@ func main() {
 	}
 	defer s.Close()
 
-	fmt.Println("Modbus server for power meter running at address", addr)
+	logger.Info("modbus server for power meter running", "addr", addr)
 
-	// Channel to capture any errors from the go-routines
-	// that make up the program.
-	errs := make(chan error)
+	// The context is cancelled when a signal is trapped
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-	// Go-routine for writing to the registers
-	go func() {
-		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-		ticker := time.NewTicker(500 * time.Millisecond)
-		for range ticker.C {
-			// Loop over the register address values from map and write the values
-			for range ticker.C {
-				// Loop over the register address values from map and write the values
-				for _, r := range registers {
-					value := uint16(rnd.Int())
-					fmt.Printf("writing to %v[%v] value: %v\n", r.Name, r.Address, value)
-					s.WriteRegister(r.Address, value)
-				}
-			}
+	// Channel to capture any error from the debug server. It is buffered
+	// so the server never blocks on sending once main has stopped listening.
+	errs := make(chan error, 1)
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var device simulator.Device = simulator.NewPowerMeter(rnd)
+
+	// Optionally fault the outputs when the supervisor's heartbeat stops
+	if *watchdog > 0 {
+		device = simulator.NewWatchdog(device, registermap.PowerMeter, *watchdog)
+		logger.Info("watching heartbeat", "address", registermap.WatchdogAddr, "timeout", *watchdog)
+	}
+
+	// Optionally serve the routes to pause, step and dump the simulation
+	var server *http.Server
+	if *debugAddr != "" {
+		debugger := simulator.NewDebugger(device, s, registermap.PowerMeter, *paused, logger)
+		device = debugger
+
+		server = &http.Server{
+			Addr:    *debugAddr,
+			Handler: debugger.Handler(),
 		}
+		go func() {
+			logger.Info("serving debug routes", "addr", *debugAddr, "paused", *paused)
+			if err := server.ListenAndServe(); err != http.ErrServerClosed {
+				errs <- fmt.Errorf("debug server: %v", err)
+			}
+		}()
+	}
 
-		errs <- fmt.Errorf("ticker loop closed")
-	}()
+	// Closed once the writing loop has stopped
+	written := make(chan struct{})
 
-	// Trap any signals to exit gracefully
+	// Go-routine for writing to the registers
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errs <- fmt.Errorf("signal trapped: %v", <-c)
+		defer close(written)
+
+		simulator.Run(ctx, s, device, logger)
 	}()
 
-	// Block execution until any errors are encountered.
-	// Deferred functions will be run afterwards.
-	mainErr = <-errs
+	// Block execution until a signal is trapped or the debug server fails,
+	// then wait for the writing loop to stop before the deferred functions
+	// close the server.
+	select {
+	case <-ctx.Done():
+		logger.Info("signal trapped, shutting down")
+	case mainErr = <-errs:
+	}
+	cancel()
+	<-written
+
+	if server != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("shutting down debug server", "err", err)
+		}
+	}
 }
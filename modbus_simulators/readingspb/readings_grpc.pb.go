@@ -0,0 +1,152 @@
+// Readings streamed by the supervisor over gRPC. Generate the Go code
+// from the modbus_simulators directory with
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//     readingspb/readings.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: readingspb/readings.proto
+
+package readingspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Supervisor_Subscribe_FullMethodName = "/supervisor.v1.Supervisor/Subscribe"
+)
+
+// SupervisorClient is the client API for Supervisor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Supervisor streams the readings polled from the simulated devices
+type SupervisorClient interface {
+	// Subscribe streams every reading polled after the call is made, until
+	// the client cancels the call or the supervisor shuts down.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Supervisor_SubscribeClient, error)
+}
+
+type supervisorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSupervisorClient(cc grpc.ClientConnInterface) SupervisorClient {
+	return &supervisorClient{cc}
+}
+
+func (c *supervisorClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (Supervisor_SubscribeClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Supervisor_ServiceDesc.Streams[0], Supervisor_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &supervisorSubscribeClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Supervisor_SubscribeClient interface {
+	Recv() (*Reading, error)
+	grpc.ClientStream
+}
+
+type supervisorSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *supervisorSubscribeClient) Recv() (*Reading, error) {
+	m := new(Reading)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SupervisorServer is the server API for Supervisor service.
+// All implementations must embed UnimplementedSupervisorServer
+// for forward compatibility
+//
+// Supervisor streams the readings polled from the simulated devices
+type SupervisorServer interface {
+	// Subscribe streams every reading polled after the call is made, until
+	// the client cancels the call or the supervisor shuts down.
+	Subscribe(*SubscribeRequest, Supervisor_SubscribeServer) error
+	mustEmbedUnimplementedSupervisorServer()
+}
+
+// UnimplementedSupervisorServer must be embedded to have forward compatible implementations.
+type UnimplementedSupervisorServer struct {
+}
+
+func (UnimplementedSupervisorServer) Subscribe(*SubscribeRequest, Supervisor_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedSupervisorServer) mustEmbedUnimplementedSupervisorServer() {}
+
+// UnsafeSupervisorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SupervisorServer will
+// result in compilation errors.
+type UnsafeSupervisorServer interface {
+	mustEmbedUnimplementedSupervisorServer()
+}
+
+func RegisterSupervisorServer(s grpc.ServiceRegistrar, srv SupervisorServer) {
+	s.RegisterService(&Supervisor_ServiceDesc, srv)
+}
+
+func _Supervisor_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SupervisorServer).Subscribe(m, &supervisorSubscribeServer{ServerStream: stream})
+}
+
+type Supervisor_SubscribeServer interface {
+	Send(*Reading) error
+	grpc.ServerStream
+}
+
+type supervisorSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *supervisorSubscribeServer) Send(m *Reading) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Supervisor_ServiceDesc is the grpc.ServiceDesc for Supervisor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Supervisor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "supervisor.v1.Supervisor",
+	HandlerType: (*SupervisorServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Supervisor_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "readingspb/readings.proto",
+}
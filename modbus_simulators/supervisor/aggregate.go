@@ -0,0 +1,101 @@
+package main
+
+import "time"
+
+// aggregate accumulates the samples of one register within a window
+type aggregate struct {
+	first   Reading
+	sum     float64
+	count   int // good and stale samples included in sum
+	good    int
+	samples int
+}
+
+// Aggregator downsamples readings by averaging them over a fixed window,
+// so a long-running demo stores one row per register per window rather
+// than every poll.
+type Aggregator struct {
+	window     time.Duration
+	windowEnd  time.Time
+	aggregates map[readingKey]*aggregate
+	order      []readingKey
+}
+
+// NewAggregator creates an aggregator for the given window. A zero window
+// passes every reading straight through.
+func NewAggregator(window time.Duration) *Aggregator {
+	return &Aggregator{
+		window:     window,
+		aggregates: make(map[readingKey]*aggregate),
+	}
+}
+
+// Add adds a reading to the current window and returns the averaged
+// readings of the previous window once it has closed.
+func (a *Aggregator) Add(r Reading) []Reading {
+	if a.window <= 0 {
+		return []Reading{r}
+	}
+
+	var closed []Reading
+	if !r.Time.Before(a.windowEnd) {
+		closed = a.Flush()
+		a.windowEnd = r.Time.Truncate(a.window).Add(a.window)
+	}
+
+	agg, ok := a.aggregates[r.key()]
+	if !ok {
+		agg = &aggregate{first: r}
+		a.aggregates[r.key()] = agg
+		a.order = append(a.order, r.key())
+	}
+	agg.samples++
+	if r.Quality == QualityGood {
+		agg.good++
+	}
+	if r.Quality != QualityBad {
+		agg.sum += float64(r.Value)
+		agg.count++
+	}
+
+	return closed
+}
+
+// Flush returns the averaged readings of the current window and starts
+// a new one. Values are averaged over the good and stale samples; the
+// result is only good if every sample in the window was.
+func (a *Aggregator) Flush() []Reading {
+	var readings []Reading
+	for _, key := range a.order {
+		agg := a.aggregates[key]
+
+		reading := agg.first
+		reading.Time = a.windowEnd
+		switch {
+		case agg.count == 0:
+			reading.Value = 0
+			reading.Quality = QualityBad
+		case agg.good < agg.samples:
+			reading.Value = float32(agg.sum / float64(agg.count))
+			reading.Quality = QualityStale
+		default:
+			reading.Value = float32(agg.sum / float64(agg.count))
+			reading.Quality = QualityGood
+		}
+		readings = append(readings, reading)
+	}
+
+	a.aggregates = make(map[readingKey]*aggregate)
+	a.order = nil
+	return readings
+}
+
+// Pending returns the number of samples held in the current window,
+// waiting to be stored when it closes.
+func (a *Aggregator) Pending() int {
+	var n int
+	for _, agg := range a.aggregates {
+		n += agg.samples
+	}
+	return n
+}
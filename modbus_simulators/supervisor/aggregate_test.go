@@ -0,0 +1,148 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	reading := func(after time.Duration, value float32, quality Quality) Reading {
+		return Reading{
+			Name:    "Frequency",
+			Address: registermap.FrequencyAddr,
+			Value:   value,
+			Time:    start.Add(after),
+			Quality: quality,
+		}
+	}
+
+	testCases := []struct {
+		desc        string
+		readings    []Reading
+		wantValue   float32
+		wantQuality Quality
+	}{
+		{
+			"all good",
+			[]Reading{reading(0, 10, QualityGood), reading(time.Second, 20, QualityGood)},
+			15,
+			QualityGood,
+		}, {
+			"bad samples are ignored",
+			[]Reading{reading(0, 10, QualityGood), reading(time.Second, 0, QualityBad)},
+			10,
+			QualityStale,
+		}, {
+			"no usable samples",
+			[]Reading{reading(0, 10, QualityBad), reading(time.Second, 20, QualityBad)},
+			0,
+			QualityBad,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			a := NewAggregator(10 * time.Second)
+			for _, r := range testCase.readings {
+				if got := a.Add(r); len(got) != 0 {
+					t.Fatalf("window closed early with %v", got)
+				}
+			}
+
+			// The first reading of the next window closes this one
+			got := a.Add(reading(10*time.Second, 0, QualityGood))
+			if len(got) != 1 {
+				t.Fatalf("got %v readings, want 1", len(got))
+			}
+			if got[0].Value != testCase.wantValue || got[0].Quality != testCase.wantQuality {
+				t.Errorf("got %v (%v), want %v (%v)", got[0].Value, got[0].Quality, testCase.wantValue, testCase.wantQuality)
+			}
+			if want := start.Add(10 * time.Second); !got[0].Time.Equal(want) {
+				t.Errorf("got time %v, want %v", got[0].Time, want)
+			}
+		})
+	}
+}
+
+func TestAggregatorWindows(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	reading := func(address uint16, after time.Duration, value float32) Reading {
+		return Reading{
+			Address: address,
+			Value:   value,
+			Time:    start.Add(after),
+			Quality: QualityGood,
+		}
+	}
+
+	testCases := []struct {
+		desc     string
+		window   time.Duration
+		readings []Reading
+		// addresses and values returned by each call to Add
+		want [][]Reading
+	}{
+		{
+			"zero window passes readings through",
+			0,
+			[]Reading{reading(registermap.FrequencyAddr, 0, 1), reading(registermap.PhaseV1Addr, 0, 2)},
+			[][]Reading{
+				{reading(registermap.FrequencyAddr, 0, 1)},
+				{reading(registermap.PhaseV1Addr, 0, 2)},
+			},
+		}, {
+			"registers keep the order they were first seen in",
+			10 * time.Second,
+			[]Reading{
+				reading(registermap.PhaseV1Addr, 0, 2),
+				reading(registermap.FrequencyAddr, 0, 1),
+				reading(registermap.CurrentI1Addr, 0, 3),
+				reading(registermap.PhaseV1Addr, 10*time.Second, 0),
+			},
+			[][]Reading{
+				nil,
+				nil,
+				nil,
+				{
+					reading(registermap.PhaseV1Addr, 10*time.Second, 2),
+					reading(registermap.FrequencyAddr, 10*time.Second, 1),
+					reading(registermap.CurrentI1Addr, 10*time.Second, 3),
+				},
+			},
+		}, {
+			"gap spanning several windows",
+			10 * time.Second,
+			[]Reading{
+				reading(registermap.FrequencyAddr, 0, 1),
+				reading(registermap.FrequencyAddr, 35*time.Second, 5),
+				reading(registermap.FrequencyAddr, 41*time.Second, 7),
+			},
+			[][]Reading{
+				nil,
+				{reading(registermap.FrequencyAddr, 10*time.Second, 1)},
+				{reading(registermap.FrequencyAddr, 40*time.Second, 5)},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			a := NewAggregator(testCase.window)
+			for i, r := range testCase.readings {
+				got := a.Add(r)
+				want := testCase.want[i]
+				if len(got) != len(want) {
+					t.Fatalf("reading %v: got %v readings, want %v", i, len(got), len(want))
+				}
+				for j := range got {
+					if got[j].Address != want[j].Address || got[j].Value != want[j].Value || !got[j].Time.Equal(want[j].Time) {
+						t.Errorf("reading %v: got %+v, want %+v", i, got[j], want[j])
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+)
+
+const (
+	// Good readings of a register seen before it can be flagged, so the
+	// bands have settled
+	anomalyWarmup = 20
+
+	// Number of anomalies kept for the /anomalies route
+	recentAnomalies = 100
+
+	// Smallest standard deviation a band is given, so a register that has
+	// held a constant value has a finite score when it changes
+	minSpread = 1e-6
+)
+
+// band tracks the exponentially weighted mean and variance of a register
+type band struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// Anomaly is an event raised for a reading outside the normal band of
+// its register
+type Anomaly struct {
+	Reading Reading `json:"reading"`
+	// Mean is the expected value of the register before the reading
+	Mean float64 `json:"mean"`
+	// Score is how many standard deviations the reading is from the mean
+	Score float64 `json:"score"`
+}
+
+// AnomalyDetector flags readings that stray from the recent behaviour of
+// their register. Each register has a band around the exponentially
+// weighted moving average (EWMA) of its values, and a reading is anomalous
+// when its distance from the average is more than threshold times the
+// weighted standard deviation.
+type AnomalyDetector struct {
+	alpha     float64
+	threshold float64
+	bands     map[readingKey]*band
+
+	mu     sync.Mutex // protects recent, which is read by the /anomalies route
+	recent []Anomaly
+}
+
+// NewAnomalyDetector creates a detector with the weight alpha given to each
+// new reading, between 0 and 1, and the threshold in standard deviations.
+func NewAnomalyDetector(alpha, threshold float64) *AnomalyDetector {
+	return &AnomalyDetector{
+		alpha:     alpha,
+		threshold: threshold,
+		bands:     make(map[readingKey]*band),
+	}
+}
+
+// Observe checks a reading against the band of its register, then moves
+// the band towards it. Only good readings are checked, as stale and bad
+// readings do not hold a new value.
+func (d *AnomalyDetector) Observe(r Reading) (Anomaly, bool) {
+	if r.Quality != QualityGood {
+		return Anomaly{}, false
+	}
+
+	b, ok := d.bands[r.key()]
+	if !ok {
+		d.bands[r.key()] = &band{mean: float64(r.Value), samples: 1}
+		return Anomaly{}, false
+	}
+
+	// Score against the band before the reading moves it
+	diff := float64(r.Value) - b.mean
+	score := math.Abs(diff) / math.Max(math.Sqrt(b.variance), minSpread)
+	anomaly := Anomaly{Reading: r, Mean: b.mean, Score: score}
+	anomalous := b.samples >= anomalyWarmup && score > d.threshold
+
+	// The band is seeded with the plain mean and variance of the warmup
+	// readings, as the weighted ones start out biased towards the first.
+	// Anomalies still update the band, so that a lasting change in level
+	// becomes the new normal rather than being flagged forever.
+	b.samples++
+	if b.samples <= anomalyWarmup {
+		n := float64(b.samples)
+		b.mean += diff / n
+		b.variance += (diff*(float64(r.Value)-b.mean) - b.variance) / n
+	} else {
+		b.mean += d.alpha * diff
+		b.variance = (1 - d.alpha) * (b.variance + d.alpha*diff*diff)
+	}
+
+	if anomalous {
+		d.mu.Lock()
+		d.recent = append(d.recent, anomaly)
+		if len(d.recent) > recentAnomalies {
+			d.recent = d.recent[1:]
+		}
+		d.mu.Unlock()
+	}
+	return anomaly, anomalous
+}
+
+// Recent returns the latest anomalies, oldest first
+func (d *AnomalyDetector) Recent() []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]Anomaly{}, d.recent...)
+}
+
+// serveAnomalies handles the /anomalies route, responding with the latest
+// anomalies
+func (d *AnomalyDetector) serveAnomalies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(d.Recent())
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestAnomalyDetector(t *testing.T) {
+	// A frequency wobbling around 50 Hz for n readings, followed by last
+	normal := func(n int, last float32) []float32 {
+		var values []float32
+		for i := 0; i < n; i++ {
+			values = append(values, 50+0.1*float32(i%3-1))
+		}
+		return append(values, last)
+	}
+	constant := func(n int, last float32) []float32 {
+		var values []float32
+		for i := 0; i < n; i++ {
+			values = append(values, 50)
+		}
+		return append(values, last)
+	}
+
+	testCases := []struct {
+		desc    string
+		values  []float32
+		quality Quality
+		want    bool // whether the last value is flagged
+	}{
+		{"normal", normal(anomalyWarmup, 50.1), QualityGood, false},
+		{"spike", normal(anomalyWarmup, 55), QualityGood, true},
+		{"dip", normal(anomalyWarmup, 45), QualityGood, true},
+		{"spike during warmup", normal(anomalyWarmup/2, 55), QualityGood, false},
+		{"change from a constant value", constant(anomalyWarmup, 50.5), QualityGood, true},
+		{"stale", normal(anomalyWarmup, 55), QualityStale, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			d := NewAnomalyDetector(0.1, 4)
+			start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+
+			var flagged bool
+			for i, v := range tc.values {
+				r := Reading{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Value: v, Time: start.Add(time.Duration(i) * time.Second), Quality: QualityGood}
+				last := i == len(tc.values)-1
+				if last {
+					r.Quality = tc.quality
+				}
+				_, ok := d.Observe(r)
+				if ok && !last {
+					t.Fatalf("value %v at %v flagged", v, i)
+				}
+				flagged = ok
+			}
+			if flagged != tc.want {
+				t.Errorf("got flagged %v, want %v", flagged, tc.want)
+			}
+			if got := len(d.Recent()); (got == 1) != tc.want {
+				t.Errorf("got %v recent anomalies", got)
+			}
+		})
+	}
+}
+
+func TestAnomalyDetectorLevelShift(t *testing.T) {
+	// A lasting change in level is flagged at first, then becomes normal
+	d := NewAnomalyDetector(0.1, 4)
+	var flagged int
+	for i := 0; i < 100; i++ {
+		value := float32(50 + 0.1*float64(i%3-1))
+		if i >= 50 {
+			value += 5
+		}
+		r := Reading{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Value: value, Quality: QualityGood}
+		if _, ok := d.Observe(r); ok {
+			flagged++
+		}
+	}
+	if flagged == 0 || flagged > 10 {
+		t.Errorf("got %v anomalies, want the start of the shift flagged", flagged)
+	}
+}
+
+func TestServeAnomalies(t *testing.T) {
+	d := NewAnomalyDetector(0.1, 4)
+	for i := 0; i <= anomalyWarmup; i++ {
+		r := Reading{Device: "inverter", Name: "StateOfCharge", Address: registermap.StateOfChargeAddr, Value: 50, Quality: QualityGood}
+		if i == anomalyWarmup {
+			r.Value = 80
+		}
+		d.Observe(r)
+	}
+
+	response := httptest.NewRecorder()
+	d.serveAnomalies(response, httptest.NewRequest("GET", "/anomalies", nil))
+
+	var got []Anomaly
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if len(got) != 1 || got[0].Reading.Value != 80 || got[0].Mean != 50 {
+		t.Errorf("got %+v, want the jump to 80", got)
+	}
+}
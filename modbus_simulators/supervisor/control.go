@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+var inverterModes = map[string]uint16{
+	"standby":   registermap.ModeStandby,
+	"charge":    registermap.ModeCharge,
+	"discharge": registermap.ModeDischarge,
+}
+
+// InverterCommand sets the operating mode and power of the battery inverter
+type InverterCommand struct {
+	Mode  string `json:"mode"`
+	Power uint16 `json:"power"`
+}
+
+// sendInverterCommand writes the command to the inverter's control registers
+func sendInverterCommand(c *modbus.Client, cmd InverterCommand) error {
+	mode, ok := inverterModes[cmd.Mode]
+	if !ok {
+		return fmt.Errorf("invalid mode %q", cmd.Mode)
+	}
+
+	// Write the setpoint first so the inverter never runs the new mode
+	// at the old power.
+	if err := c.WriteRegister(registermap.PowerSetpointAddr, cmd.Power); err != nil {
+		return fmt.Errorf("writing power setpoint: %v", err)
+	}
+	if err := c.WriteRegister(registermap.ModeAddr, mode); err != nil {
+		return fmt.Errorf("writing mode: %v", err)
+	}
+	return nil
+}
+
+// inverterControl handles the /inverter route, which accepts a JSON
+// InverterCommand to control the battery inverter
+func inverterControl(c *modbus.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var cmd InverterCommand
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		if _, ok := inverterModes[cmd.Mode]; !ok {
+			http.Error(w, fmt.Sprintf("invalid mode %q", cmd.Mode), http.StatusBadRequest)
+			return
+		}
+
+		if err := sendInverterCommand(c, cmd); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, "command sent")
+	}
+}
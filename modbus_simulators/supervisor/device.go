@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Device is a simulated device polled by the supervisor
+type Device struct {
+	Name      string
+	Client    *modbus.Client
+	Registers []registermap.Register
+}
+
+// readingKey identifies a register of a particular device, as different
+// devices may use the same addresses.
+type readingKey struct {
+	device  string
+	address uint16
+}
+
+func (r Reading) key() readingKey {
+	return readingKey{device: r.Device, address: r.Address}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// Layout of the day partitions, named so that pandas and Athena read
+	// the day as a column of the dataset.
+	partitionLayout = "date=2006-01-02"
+
+	// Rows held in memory before they are written out as a row group
+	rowGroupSize = 10000
+)
+
+// parquetRow is a reading as stored in the Parquet files. The value is
+// null for bad readings, as there is no trustworthy value to store.
+type parquetRow struct {
+	Time    time.Time `parquet:"time,timestamp(millisecond)"`
+	Device  string    `parquet:"device,dict"`
+	Name    string    `parquet:"name,dict"`
+	Address int32     `parquet:"address"`
+	Value   *float32  `parquet:"value,optional"`
+	Quality string    `parquet:"quality,dict"`
+}
+
+// Exporter writes readings to Parquet files partitioned by day, for loading
+// into analytics tools. A file is opened for each day the readings fall
+// on, in UTC, and is only complete once it has been closed.
+type Exporter struct {
+	dir  string
+	day  time.Time // day of the open file
+	f    *os.File  // nil until the first reading
+	w    *parquet.GenericWriter[parquetRow]
+	path string
+}
+
+// NewExporter creates an exporter writing under the directory dir
+func NewExporter(dir string) (*Exporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Exporter{dir: dir}, nil
+}
+
+// Write adds a reading to the file of the day it was taken on, closing
+// the file of the previous day when the day changes.
+func (e *Exporter) Write(reading Reading) error {
+	day := reading.Time.UTC().Truncate(24 * time.Hour)
+	if e.f == nil || !day.Equal(e.day) {
+		if err := e.Close(); err != nil {
+			return err
+		}
+		if err := e.open(day); err != nil {
+			return err
+		}
+	}
+
+	row := parquetRow{
+		Time:    reading.Time,
+		Device:  reading.Device,
+		Name:    reading.Name,
+		Address: int32(reading.Address),
+		Quality: string(reading.Quality),
+	}
+	if reading.Quality != QualityBad {
+		value := reading.Value
+		row.Value = &value
+	}
+	_, err := e.w.Write([]parquetRow{row})
+	return err
+}
+
+// open creates a new file in the partition of the day. Each file is named
+// after the time it was opened so a restarted supervisor adds to the day
+// rather than overwriting it.
+func (e *Exporter) open(day time.Time) error {
+	partition := filepath.Join(e.dir, day.Format(partitionLayout))
+	if err := os.MkdirAll(partition, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(partition, fmt.Sprintf("readings-%s.parquet", time.Now().UTC().Format("20060102T150405.000Z")))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	e.day = day
+	e.f = f
+	e.path = path
+	e.w = parquet.NewGenericWriter[parquetRow](f,
+		parquet.Compression(&parquet.Snappy),
+		parquet.MaxRowsPerRowGroup(rowGroupSize),
+	)
+	return nil
+}
+
+// Close writes the footer of the open file, if any, and closes it
+func (e *Exporter) Close() error {
+	if e.f == nil {
+		return nil
+	}
+	f := e.f
+	e.f = nil
+
+	if err := e.w.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %v: %v", e.path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestExporter(t *testing.T) {
+	midnight := time.Date(2020, 6, 28, 0, 0, 0, 0, time.UTC)
+	readings := []Reading{
+		{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Value: 50, Time: midnight.Add(-time.Second), Quality: QualityGood},
+		{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Time: midnight.Add(-time.Second / 2), Quality: QualityBad},
+		{Device: "inverter", Name: "StateOfCharge", Address: registermap.StateOfChargeAddr, Value: 60.5, Time: midnight, Quality: QualityStale},
+	}
+
+	dir := t.TempDir()
+	exporter, err := NewExporter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range readings {
+		if err := exporter.Write(r); err != nil {
+			t.Fatalf("writing: %v", err)
+		}
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatalf("closing: %v", err)
+	}
+
+	// The readings either side of midnight go to separate days
+	wantDays := map[string][]Reading{
+		"date=2020-06-27": readings[:2],
+		"date=2020-06-28": readings[2:],
+	}
+	for day, want := range wantDays {
+		paths, err := filepath.Glob(filepath.Join(dir, day, "*.parquet"))
+		if err != nil || len(paths) != 1 {
+			t.Fatalf("%v: got files %v, want one", day, paths)
+		}
+		rows, err := parquet.ReadFile[parquetRow](paths[0])
+		if err != nil {
+			t.Fatalf("%v: reading: %v", day, err)
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("%v: got %v rows, want %v", day, len(rows), len(want))
+		}
+
+		for i, row := range rows {
+			got := Reading{
+				Device:  row.Device,
+				Name:    row.Name,
+				Address: uint16(row.Address),
+				Time:    row.Time,
+				Quality: Quality(row.Quality),
+			}
+			if row.Value != nil {
+				got.Value = *row.Value
+			}
+			if !got.Time.Equal(want[i].Time) {
+				t.Errorf("%v row %v: got time %v, want %v", day, i, got.Time, want[i].Time)
+			}
+			got.Time = want[i].Time
+			if got != want[i] {
+				t.Errorf("%v row %v: got %+v, want %+v", day, i, got, want[i])
+			}
+			if (row.Value == nil) != (want[i].Quality == QualityBad) {
+				t.Errorf("%v row %v: got value %v, want null only when bad", day, i, row.Value)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/readingspb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var protoQualities = map[Quality]readingspb.Quality{
+	QualityGood:  readingspb.Quality_QUALITY_GOOD,
+	QualityStale: readingspb.Quality_QUALITY_STALE,
+	QualityBad:   readingspb.Quality_QUALITY_BAD,
+}
+
+// readingsServer streams the readings published to the hub to gRPC clients
+type readingsServer struct {
+	readingspb.UnimplementedSupervisorServer
+	hub *Hub
+
+	// ctx is cancelled when the supervisor shuts down, to end the
+	// streams so the server can stop gracefully.
+	ctx context.Context
+}
+
+// Subscribe streams readings to the client until either side is done
+func (g *readingsServer) Subscribe(req *readingspb.SubscribeRequest, stream readingspb.Supervisor_SubscribeServer) error {
+	devices := make(map[string]bool)
+	for _, d := range req.Devices {
+		devices[d] = true
+	}
+
+	readings := g.hub.subscribe()
+	defer g.hub.unsubscribe(readings)
+
+	for {
+		select {
+		case <-g.ctx.Done():
+			return nil
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case r := <-readings:
+			if len(devices) > 0 && !devices[r.Device] {
+				continue
+			}
+			if err := stream.Send(r.proto()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// proto converts the reading to its protobuf message, leaving the value
+// out of bad readings as for the websocket stream.
+func (r Reading) proto() *readingspb.Reading {
+	p := &readingspb.Reading{
+		Device:  r.Device,
+		Name:    r.Name,
+		Address: uint32(r.Address),
+		Time:    timestamppb.New(r.Time),
+		Quality: protoQualities[r.Quality],
+	}
+	if r.Quality != QualityBad {
+		value := r.Value
+		p.Value = &value
+	}
+	return p
+}
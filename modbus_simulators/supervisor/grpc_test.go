@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/readingspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestSubscribe(t *testing.T) {
+	h := NewHub(logging.Discard())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	readingspb.RegisterSupervisorServer(server, &readingsServer{hub: h, ctx: ctx})
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	callCtx, callCancel := context.WithTimeout(ctx, time.Second)
+	defer callCancel()
+	client := readingspb.NewSupervisorClient(conn)
+	stream, err := client.Subscribe(callCtx, &readingspb.SubscribeRequest{Devices: []string{"inverter"}})
+	if err != nil {
+		t.Fatalf("subscribing: %v", err)
+	}
+	waitForClients(t, h, 1)
+
+	now := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	h.Publish(Reading{Device: "powermeter", Name: "Frequency", Value: 50, Time: now, Quality: QualityGood})
+	h.Publish(Reading{Device: "inverter", Name: "StateOfCharge", Address: 16640, Value: 60, Time: now, Quality: QualityGood})
+	h.Publish(Reading{Device: "inverter", Name: "ChargePower", Address: 16642, Time: now, Quality: QualityBad})
+
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	if got.Device != "inverter" || got.Name != "StateOfCharge" || got.GetValue() != 60 ||
+		!got.Time.AsTime().Equal(now) || got.Quality != readingspb.Quality_QUALITY_GOOD {
+		t.Errorf("got %v, want the inverter state of charge", got)
+	}
+
+	got, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("receiving: %v", err)
+	}
+	if got.Name != "ChargePower" || got.Value != nil || got.Quality != readingspb.Quality_QUALITY_BAD {
+		t.Errorf("got %v, want a bad reading without a value", got)
+	}
+
+	// Shutting down ends the stream
+	cancel()
+	if _, err := stream.Recv(); err == nil {
+		t.Error("stream still open after shutting down")
+	}
+}
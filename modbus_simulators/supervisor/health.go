@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errorRateWindow is how far back the error rate of a device looks
+const errorRateWindow = time.Minute
+
+// pollResult counts the reads of a device on one poll
+type pollResult struct {
+	time   time.Time
+	reads  int
+	errors int
+}
+
+// DeviceHealth describes how the polling of a device is going
+type DeviceHealth struct {
+	Connected bool      `json:"connected"`
+	LastPoll  time.Time `json:"last_poll"`
+	Reads     int       `json:"reads"`
+	Errors    int       `json:"errors"`
+	// ErrorRate is the fraction of reads that failed over the last minute
+	ErrorRate float64 `json:"error_rate"`
+
+	recent []pollResult
+}
+
+// StorageHealth describes the recording of the readings
+type StorageHealth struct {
+	Enabled bool `json:"enabled"`
+	// Backlog is the number of samples held by the aggregator, waiting to
+	// be written when the window closes
+	Backlog   int       `json:"backlog"`
+	LastWrite time.Time `json:"last_write"`
+}
+
+// HealthReport is the body of the /healthz route
+type HealthReport struct {
+	Healthy bool                     `json:"healthy"`
+	Devices map[string]*DeviceHealth `json:"devices"`
+	Storage StorageHealth            `json:"storage"`
+}
+
+// Health collects the diagnostics of the supervisor. The polling loop
+// records each poll, and the /healthz route reports on them.
+type Health struct {
+	// healthy is set once the supervisor is ready and cleared again when
+	// it shuts down, as in the HTTP services.
+	healthy int32
+
+	mu      sync.Mutex // protects the fields below
+	devices map[string]*DeviceHealth
+	storage StorageHealth
+	maxAge  time.Duration
+	now     func() time.Time
+}
+
+// NewHealth creates the diagnostics of the given devices. A device counts
+// as unhealthy when it has not been polled for maxAge.
+func NewHealth(devices []Device, recording bool, maxAge time.Duration) *Health {
+	h := &Health{
+		devices: make(map[string]*DeviceHealth),
+		storage: StorageHealth{Enabled: recording},
+		maxAge:  maxAge,
+		now:     time.Now,
+	}
+	for _, d := range devices {
+		h.devices[d.Name] = &DeviceHealth{}
+	}
+	return h
+}
+
+// SetHealthy marks the supervisor as ready or shutting down
+func (h *Health) SetHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	atomic.StoreInt32(&h.healthy, v)
+}
+
+// RecordPoll records the reads of one poll of a device. The device is
+// connected while at least one of its registers can be read.
+func (h *Health) RecordPoll(device string, reads, errors int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	d := h.devices[device]
+	d.Connected = errors < reads
+	d.LastPoll = now
+	d.Reads += reads
+	d.Errors += errors
+
+	// Keep only the polls within the error rate window
+	d.recent = append(d.recent, pollResult{time: now, reads: reads, errors: errors})
+	for len(d.recent) > 0 && now.Sub(d.recent[0].time) > errorRateWindow {
+		d.recent = d.recent[1:]
+	}
+}
+
+// RecordStorage records the backlog of the recorder, and the time of the
+// last write if any readings were written.
+func (h *Health) RecordStorage(backlog int, written bool, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.storage.Backlog = backlog
+	if written {
+		h.storage.LastWrite = now
+	}
+}
+
+// Report returns the current diagnostics
+func (h *Health) Report() HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := HealthReport{
+		Healthy: atomic.LoadInt32(&h.healthy) == 1,
+		Devices: make(map[string]*DeviceHealth),
+		Storage: h.storage,
+	}
+
+	now := h.now()
+	for name, d := range h.devices {
+		device := *d
+		device.recent = nil
+
+		var reads, errors int
+		for _, p := range d.recent {
+			reads += p.reads
+			errors += p.errors
+		}
+		if reads > 0 {
+			device.ErrorRate = float64(errors) / float64(reads)
+		}
+
+		if !d.Connected || now.Sub(d.LastPoll) > h.maxAge {
+			report.Healthy = false
+		}
+		report.Devices[name] = &device
+	}
+	return report
+}
+
+// serveHealthz handles the /healthz route, responding with the
+// diagnostics and a 503 status when the supervisor is unhealthy
+func (h *Health) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	report := h.Report()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if !report.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
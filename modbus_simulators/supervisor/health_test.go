@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthReport(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	devices := []Device{{Name: "powermeter"}, {Name: "inverter"}}
+
+	testCases := []struct {
+		desc        string
+		ready       bool
+		polls       []pollResult // of the inverter, the power meter is always fine
+		now         time.Time
+		wantHealthy bool
+		wantRate    float64
+	}{
+		{
+			"not ready",
+			false,
+			[]pollResult{{start, 6, 0}},
+			start,
+			false,
+			0,
+		}, {
+			"all connected",
+			true,
+			[]pollResult{{start, 6, 0}},
+			start,
+			true,
+			0,
+		}, {
+			"some reads failing",
+			true,
+			[]pollResult{{start, 6, 0}, {start.Add(time.Second), 6, 3}},
+			start.Add(time.Second),
+			true,
+			0.25,
+		}, {
+			"disconnected",
+			true,
+			[]pollResult{{start, 6, 6}},
+			start,
+			false,
+			1,
+		}, {
+			"not polled recently",
+			true,
+			[]pollResult{{start, 6, 0}},
+			start.Add(10 * time.Second),
+			false,
+			0,
+		}, {
+			"old errors leave the rate",
+			true,
+			[]pollResult{{start, 6, 6}, {start.Add(2 * time.Minute), 6, 0}},
+			start.Add(2 * time.Minute),
+			true,
+			0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			h := NewHealth(devices, false, 5*time.Second)
+			h.now = func() time.Time { return testCase.now }
+			h.SetHealthy(testCase.ready)
+
+			h.RecordPoll("powermeter", 7, 0, testCase.now)
+			for _, p := range testCase.polls {
+				h.RecordPoll("inverter", p.reads, p.errors, p.time)
+			}
+
+			report := h.Report()
+			if report.Healthy != testCase.wantHealthy {
+				t.Errorf("got healthy %v, want %v", report.Healthy, testCase.wantHealthy)
+			}
+			if got := report.Devices["inverter"].ErrorRate; got != testCase.wantRate {
+				t.Errorf("got error rate %v, want %v", got, testCase.wantRate)
+			}
+		})
+	}
+}
+
+func TestServeHealthz(t *testing.T) {
+	now := time.Now()
+	h := NewHealth([]Device{{Name: "powermeter"}}, true, 5*time.Second)
+	h.RecordPoll("powermeter", 7, 0, now)
+	h.RecordStorage(14, true, now)
+
+	testCases := []struct {
+		desc  string
+		ready bool
+		want  int
+	}{
+		{"healthy", true, http.StatusOK},
+		{"shutting down", false, http.StatusServiceUnavailable},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			h.SetHealthy(testCase.ready)
+
+			response := httptest.NewRecorder()
+			h.serveHealthz(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if response.Code != testCase.want {
+				t.Errorf("got status %v, want %v", response.Code, testCase.want)
+			}
+
+			var report HealthReport
+			if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+				t.Fatalf("decoding report: %v", err)
+			}
+			if report.Storage.Backlog != 14 || !report.Devices["powermeter"].Connected {
+				t.Errorf("got %+v, want the recorded diagnostics", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// heartbeat writes an incrementing value to the watchdog register every
+// interval until the context is cancelled, so the device can tell the
+// supervisor is still alive. Failed writes are only logged, as a missed
+// heartbeat is exactly what the device is watching for.
+func heartbeat(ctx context.Context, c *modbus.Client, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var beat uint16
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Wraps around at the largest value a register can hold
+		beat++
+		if err := c.WriteRegister(registermap.WatchdogAddr, beat); err != nil {
+			logger.Warn("writing heartbeat", "address", registermap.WatchdogAddr, "err", err)
+		}
+	}
+}
@@ -1,105 +1,338 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/readingspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
 )
 
-//Defining register values for the demo
 const (
-	defaultHost   string = "0.0.0.0"
-	defaultPort   string = ":1503"
-	FrequencyAddr uint16 = 16384
-	PhaseV1Addr   uint16 = 16386
-	PhaseV2Addr   uint16 = 16388
-	PhaseV3Addr   uint16 = 16390
-	CurrentI1Addr uint16 = 16402
-	CurrentI2Addr uint16 = 16404
-	CurrentI3Addr uint16 = 16406
+	defaultHost  string = "0.0.0.0"
+	defaultPort  string = ":1503"
+	defaultHTTP  string = ":8080"
+	defaultGRPC  string = ":9090"
+	defaultStale        = 5 * time.Second
+	defaultAlpha        = 0.1
 )
 
-// Register stores the name and address of a register
-type Register struct {
-	Name    string
-	Address uint16
-}
-
-var registers = []Register{
-	{"Frequency", FrequencyAddr},
-	{"PhaseV1", PhaseV1Addr},
-	{"PhaseV2", PhaseV2Addr},
-	{"PhaseV3", PhaseV3Addr},
-	{"CurrentI1", CurrentI1Addr},
-	{"CurrentI2", CurrentI2Addr},
-	{"CurrentI3", CurrentI3Addr},
-}
-
 func main() {
 	var mainErr error
+	exitLogger := slog.Default()
 
 	// Deferred functions run in reverse order so this will be the last
 	// one called, after any tidy up.
 	defer func() {
 		if mainErr != nil {
-			log.Println("error encountered:", mainErr)
+			exitLogger.Error("error encountered", "err", mainErr)
 			os.Exit(1)
 		} else {
-			log.Println("exiting")
+			exitLogger.Info("exiting")
 		}
 	}()
 
 	// Set up the commandline options
 	host := flag.String("host", defaultHost, "host for the modbus listener")
 	port := flag.String("port", defaultPort, "port for the modbus listener")
+	httpAddr := flag.String("http", defaultHTTP, "address for the web page and websocket stream")
+	grpcAddr := flag.String("grpc", defaultGRPC, "address for the gRPC stream")
+	tui := flag.Bool("tui", false, "show a live table of the readings instead of printing each one")
+	record := flag.String("record", "", "CSV file to record the readings to")
+	export := flag.String("export", "", "directory to export the readings to as Parquet files partitioned by day")
+	aggregateWindow := flag.Duration("aggregate", 0, "window to average readings over before recording or exporting them, e.g. 10s (0 stores every reading)")
+	staleAfter := flag.Duration("stale-after", defaultStale, "how long to hold the last good value after failed reads before flagging it bad")
+	anomalyThreshold := flag.Float64("anomaly-threshold", 0, "flag readings this many standard deviations from their register's moving average, e.g. 4 (0 to disable)")
+	anomalyAlpha := flag.Float64("anomaly-alpha", defaultAlpha, "weight of each new reading in the moving average and deviation, between 0 and 1")
+	inverterAddr := flag.String("inverter", "", "address of the battery inverter, e.g. localhost:1504 (empty to only read the power meter)")
+	inverterMode := flag.String("inverter-mode", "standby", "initial inverter mode: standby, charge or discharge")
+	inverterPower := flag.Uint("inverter-power", 0, "initial inverter power setpoint in W")
+	wecAddr := flag.String("wec", "", "address of the wave energy converter, e.g. localhost:1505 (empty to not read it)")
+	heartbeatInterval := flag.Duration("heartbeat", 0, "write an incrementing heartbeat to the power meter's watchdog register at this interval, e.g. 1s (0 to disable)")
+	replayPath := flag.String("replay", "", "CSV recording to replay instead of polling the devices")
+	replaySpeed := flag.Float64("replay-speed", 1, "how many times faster than recorded to replay, 0 for as fast as possible")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
 	flag.Parse()
 
-	// Start a listener modbus client
-	addr := fmt.Sprintf("%s%s", *host, *port)
-	c, err := modbus.NewClient(addr)
+	l, err := logFlags.New(os.Stderr)
 	if err != nil {
-		mainErr = fmt.Errorf("error creating client: %v", err)
+		mainErr = err
+		return
+	}
+	exitLogger = l
+
+	if *anomalyThreshold > 0 && (*anomalyAlpha <= 0 || *anomalyAlpha > 1) {
+		mainErr = fmt.Errorf("anomaly alpha %v must be between 0 and 1", *anomalyAlpha)
+		return
+	}
+	if *inverterPower > math.MaxUint16 {
+		mainErr = fmt.Errorf("inverter power %v must be between 0 and %v", *inverterPower, math.MaxUint16)
+		return
+	}
+	if *replaySpeed < 0 {
+		mainErr = fmt.Errorf("replay speed %v must not be negative", *replaySpeed)
 		return
 	}
-	defer c.Close()
 
-	fmt.Println("Reading from Modbus Server at port:", addr)
+	// Logging between redraws would break up the table, so only the
+	// exit message is logged in the terminal dashboard mode.
+	logger := exitLogger
+	if *tui {
+		logger = logging.Discard()
+	}
 
-	// Channel to capture any errors from the go-routines
-	// that make up the program.
-	errs := make(chan error)
+	// Either replay a recording, or connect to the devices to poll them
+	var (
+		recording []Reading
+		devices   []Device
+		inverter  *modbus.Client
 
-	//Go routine for Client to start reading values
-	go func() {
-		ticker := time.NewTicker(500 * time.Millisecond)
-		for range ticker.C {
-			// Loop over the register address values from map and read the values
-			for _, r := range registers {
-				v, err := c.ReadRegister(r.Address)
-				if err != nil {
-					fmt.Printf("error reading %v[%v]: %v\n", r.Name, r.Address, err)
-					continue
-				}
-				fmt.Printf("read %v[%v]: %v\n", r.Name, r.Address, v)
+		// The power meter's client when writing a heartbeat to it
+		heartbeatClient *modbus.Client
+	)
+	if *replayPath != "" {
+		recording, err = loadRecording(*replayPath)
+		if err != nil {
+			mainErr = fmt.Errorf("loading recording: %v", err)
+			return
+		}
+		devices = devicesFromRecording(recording)
+
+		logger.Info("replaying recording", "path", *replayPath, "readings", len(recording), "speed", *replaySpeed)
+	} else {
+		// Start a listener modbus client
+		addr := fmt.Sprintf("%s%s", *host, *port)
+		c, err := modbus.NewClient(addr)
+		if err != nil {
+			mainErr = fmt.Errorf("error creating client: %v", err)
+			return
+		}
+		defer c.Close()
+
+		logger.Info("reading from modbus server", "addr", addr)
+
+		devices = []Device{
+			{Name: "powermeter", Client: c, Registers: registermap.PowerMeter},
+		}
+
+		// Optionally keep the power meter's watchdog fed
+		if *heartbeatInterval > 0 {
+			heartbeatClient = c
+			logger.Info("writing heartbeat", "address", registermap.WatchdogAddr, "interval", *heartbeatInterval)
+		}
+
+		// Optionally control and read the battery inverter as well
+		if *inverterAddr != "" {
+			inverter, err = modbus.NewClient(*inverterAddr)
+			if err != nil {
+				mainErr = fmt.Errorf("error creating inverter client: %v", err)
+				return
+			}
+			defer inverter.Close()
+
+			cmd := InverterCommand{Mode: *inverterMode, Power: uint16(*inverterPower)}
+			if err := sendInverterCommand(inverter, cmd); err != nil {
+				mainErr = fmt.Errorf("setting up inverter: %v", err)
+				return
+			}
+			logger.Info("controlling inverter", "addr", *inverterAddr, "mode", cmd.Mode, "power", cmd.Power)
+
+			devices = append(devices, Device{Name: "inverter", Client: inverter, Registers: registermap.BatteryInverter})
+		}
+
+		// Optionally read the wave energy converter as well
+		if *wecAddr != "" {
+			wec, err := modbus.NewClient(*wecAddr)
+			if err != nil {
+				mainErr = fmt.Errorf("error creating wec client: %v", err)
+				return
 			}
+			defer wec.Close()
+
+			logger.Info("reading from wave energy converter", "addr", *wecAddr)
+
+			devices = append(devices, Device{Name: "wec", Client: wec, Registers: registermap.WaveEnergyConverter})
+		}
+	}
+
+	// Optionally record the readings to a CSV file
+	var recorder *Recorder
+	if *record != "" {
+		recorder, err = NewRecorder(*record)
+		if err != nil {
+			mainErr = fmt.Errorf("creating recorder: %v", err)
+			return
+		}
+	}
+
+	// Optionally export the readings as Parquet for analytics
+	var exporter *Exporter
+	if *export != "" {
+		exporter, err = NewExporter(*export)
+		if err != nil {
+			mainErr = fmt.Errorf("creating exporter: %v", err)
+			return
+		}
+	}
+
+	// The context is cancelled when a signal is trapped, or below when
+	// any of the go-routines fail, to shut the rest of the program down.
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Channel to capture any errors from the go-routines that make up
+	// the program. It is buffered so that a go-routine never blocks
+	// on sending once main has stopped listening.
+	errs := make(chan error, 3)
+
+	hub := NewHub(logger)
+	health := NewHealth(devices, recorder != nil || exporter != nil, *staleAfter)
+
+	// Serve the live plot and the websocket stream
+	router := http.NewServeMux()
+	router.HandleFunc("/", serveIndex)
+	router.HandleFunc("/ws", hub.serveWS)
+	router.HandleFunc("/healthz", health.serveHealthz)
+	if inverter != nil {
+		router.HandleFunc("/inverter", inverterControl(inverter))
+	}
+
+	// Optionally flag abnormal readings, listing the latest at /anomalies
+	var detector *AnomalyDetector
+	if *anomalyThreshold > 0 {
+		detector = NewAnomalyDetector(*anomalyAlpha, *anomalyThreshold)
+		router.HandleFunc("/anomalies", detector.serveAnomalies)
+		logger.Info("detecting anomalies", "threshold", *anomalyThreshold, "alpha", *anomalyAlpha)
+	}
+	server := &http.Server{
+		Addr:    *httpAddr,
+		Handler: router,
+	}
+	go func() {
+		logger.Info("streaming readings", "addr", *httpAddr)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			errs <- fmt.Errorf("http server: %v", err)
 		}
-		errs <- fmt.Errorf("ticker loop closed")
 	}()
 
-	// Trap any signals to exit gracefully
+	// Stream the readings over gRPC. Reflection is registered so tools such
+	// as grpcurl can be used without the proto file.
+	listener, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		mainErr = fmt.Errorf("listening for grpc: %v", err)
+		return
+	}
+	grpcServer := grpc.NewServer()
+	readingspb.RegisterSupervisorServer(grpcServer, &readingsServer{hub: hub, ctx: ctx})
+	reflection.Register(grpcServer)
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-		errs <- fmt.Errorf("signal trapped: %v", <-c)
+		logger.Info("streaming readings over grpc", "addr", *grpcAddr)
+		if err := grpcServer.Serve(listener); err != nil {
+			errs <- fmt.Errorf("grpc server: %v", err)
+		}
 	}()
 
-	// Block execution until any errors are encountered.
-	// Deferred functions will be run afterwards.
-	mainErr = <-errs
+	pipeline := &Pipeline{
+		logger:     logger,
+		hub:        hub,
+		health:     health,
+		aggregator: NewAggregator(*aggregateWindow),
+		recorder:   recorder,
+		exporter:   exporter,
+		detector:   detector,
+	}
+	if *tui {
+		pipeline.dashboard = NewDashboard(os.Stdout, devices)
+	}
+
+	// Closed once the readings have stopped and the pipeline has finished
+	// with the recorder
+	polled := make(chan struct{})
+
+	// Closed if the whole recording has been replayed, to shut down
+	replayed := make(chan struct{})
+
+	// Go-routine for passing the readings through the pipeline
+	go func() {
+		defer close(polled)
+		defer pipeline.Close()
+
+		var err error
+		if *replayPath != "" {
+			err = replay(ctx, recording, *replaySpeed, health, pipeline)
+		} else {
+			err = poll(ctx, devices, NewQualityTracker(*staleAfter), health, pipeline)
+		}
+		if err != nil {
+			errs <- err
+			return
+		}
+		if *replayPath != "" && ctx.Err() == nil {
+			close(replayed)
+		}
+	}()
+
+	// Closed once the heartbeat has stopped writing to the power meter
+	beating := make(chan struct{})
+	go func() {
+		defer close(beating)
+		if heartbeatClient != nil {
+			heartbeat(ctx, heartbeatClient, *heartbeatInterval, logger)
+		}
+	}()
+
+	health.SetHealthy(true)
+
+	// Block execution until a signal is trapped or any errors are
+	// encountered.
+	select {
+	case <-ctx.Done():
+		logger.Info("signal trapped, shutting down")
+	case <-replayed:
+		logger.Info("replay finished, shutting down")
+	case mainErr = <-errs:
+	}
+	health.SetHealthy(false)
+	cancel()
+
+	// Shut down in order: the readings and heartbeat first so the
+	// recorder and exporter are flushed and closed, then the web and gRPC
+	// servers. The modbus clients are closed by their deferred functions
+	// afterwards.
+	<-polled
+	<-beating
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutting down http server", "err", err)
+	}
+
+	// The streams end once the context is cancelled, so this does not
+	// wait on the clients.
+	grpcServer.GracefulStop()
+}
+
+// loadRecording reads the recording at path
+func loadRecording(path string) ([]Reading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadRecording(f)
 }
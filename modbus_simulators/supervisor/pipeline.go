@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Pipeline passes each reading on to every output of the supervisor: the
+// log or terminal dashboard, the live streams, the recording and the
+// Parquet export. Readings go through the same pipeline whether they are
+// polled or replayed.
+type Pipeline struct {
+	logger     *slog.Logger
+	dashboard  *Dashboard // nil unless showing the terminal dashboard
+	hub        *Hub
+	health     *Health
+	aggregator *Aggregator
+	recorder   *Recorder        // nil unless recording
+	exporter   *Exporter        // nil unless exporting
+	detector   *AnomalyDetector // nil unless detecting anomalies
+}
+
+// Handle passes on a reading. err is the error reading the register, if
+// the read failed.
+func (p *Pipeline) Handle(reading Reading, err error) error {
+	switch {
+	case p.dashboard != nil:
+		p.dashboard.Update(reading, err)
+	case err != nil:
+		p.logger.Warn("error reading", "device", reading.Device, "register", reading.Name, "address", reading.Address, "err", err, "quality", reading.Quality)
+	default:
+		p.logger.Info("read", "device", reading.Device, "register", reading.Name, "address", reading.Address, "value", reading.Value)
+	}
+	p.hub.Publish(reading)
+
+	if p.detector != nil {
+		if a, ok := p.detector.Observe(reading); ok {
+			p.logger.Warn("anomaly", "device", reading.Device, "register", reading.Name, "address", reading.Address, "value", reading.Value, "mean", a.Mean, "score", a.Score)
+		}
+	}
+
+	if p.recorder == nil && p.exporter == nil {
+		return nil
+	}
+	closed := p.aggregator.Add(reading)
+	if err := p.store(closed); err != nil {
+		return err
+	}
+	p.health.RecordStorage(p.aggregator.Pending(), len(closed) > 0, time.Now())
+	return nil
+}
+
+// Render redraws the terminal dashboard, if shown
+func (p *Pipeline) Render() {
+	if p.dashboard != nil {
+		p.dashboard.Render()
+	}
+}
+
+// Close stores the last partial window and closes the recorder and
+// exporter. The pipeline is the only user of them, so this must only be
+// called once no more readings will be handled.
+func (p *Pipeline) Close() {
+	if p.recorder == nil && p.exporter == nil {
+		return
+	}
+	if err := p.store(p.aggregator.Flush()); err != nil {
+		p.logger.Error("storing last window", "err", err)
+	}
+	if p.recorder != nil {
+		if err := p.recorder.Close(); err != nil {
+			p.logger.Error("closing recorder", "err", err)
+		}
+	}
+	if p.exporter != nil {
+		if err := p.exporter.Close(); err != nil {
+			p.logger.Error("closing exporter", "err", err)
+		}
+	}
+}
+
+// store writes the readings of closed windows to the recording and the
+// Parquet export
+func (p *Pipeline) store(readings []Reading) error {
+	if p.recorder != nil {
+		if err := recordAll(p.recorder, readings); err != nil {
+			return fmt.Errorf("recording readings: %v", err)
+		}
+	}
+	if p.exporter != nil {
+		for _, r := range readings {
+			if err := p.exporter.Write(r); err != nil {
+				return fmt.Errorf("exporting readings: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// recordAll writes the readings to the recorder and flushes them to disk
+func recordAll(recorder *Recorder, readings []Reading) error {
+	for _, r := range readings {
+		if err := recorder.Write(r); err != nil {
+			return err
+		}
+	}
+	return recorder.Flush()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// pollInterval is how often the devices are polled
+const pollInterval = 500 * time.Millisecond
+
+// poll reads every register of the devices on each tick, passing the
+// readings through the pipeline, until the context is cancelled.
+func poll(ctx context.Context, devices []Device, quality *QualityTracker, health *Health, pipeline *Pipeline) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Loop over the registers of each device and read the values
+		for _, device := range devices {
+			failed := 0
+			for _, r := range device.Registers {
+				v, err := device.Client.ReadRegister(r.Address)
+				if err != nil {
+					failed++
+				}
+				reading := quality.Assess(device.Name, r, v, err, time.Now())
+				if err := pipeline.Handle(reading, err); err != nil {
+					return err
+				}
+			}
+			health.RecordPoll(device.Name, len(device.Registers), failed, time.Now())
+		}
+		pipeline.Render()
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// Quality describes how far a reading can be trusted, in the same way
+// SCADA historians flag their samples.
+type Quality string
+
+const (
+	// QualityGood is a value read successfully on this poll
+	QualityGood Quality = "good"
+	// QualityStale is the last good value, held after a failed read
+	QualityStale Quality = "stale"
+	// QualityBad means there is no recent good value to fall back on
+	QualityBad Quality = "bad"
+)
+
+// QualityTracker remembers the last good reading of each register so
+// failed reads can be flagged as stale or bad.
+type QualityTracker struct {
+	staleAfter time.Duration
+	lastGood   map[readingKey]Reading
+}
+
+// NewQualityTracker creates a tracker which holds the last good value for
+// up to staleAfter before flagging a register as bad.
+func NewQualityTracker(staleAfter time.Duration) *QualityTracker {
+	return &QualityTracker{
+		staleAfter: staleAfter,
+		lastGood:   make(map[readingKey]Reading),
+	}
+}
+
+// Assess turns the result of reading a register of the named device
+// into a flagged reading
+func (q *QualityTracker) Assess(device string, r registermap.Register, value float32, err error, now time.Time) Reading {
+	reading := Reading{
+		Device:  device,
+		Name:    r.Name,
+		Address: r.Address,
+		Value:   value,
+		Time:    now,
+		Quality: QualityGood,
+	}
+
+	if err == nil {
+		q.lastGood[reading.key()] = reading
+		return reading
+	}
+
+	// Fall back on the last good value while it is recent enough
+	last, ok := q.lastGood[reading.key()]
+	reading.Value = last.Value
+	reading.Quality = QualityStale
+	if !ok || now.Sub(last.Time) > q.staleAfter {
+		reading.Quality = QualityBad
+	}
+	return reading
+}
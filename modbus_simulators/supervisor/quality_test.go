@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestQualityTracker(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	r := registermap.PowerMeter[0]
+	errRead := errors.New("read failed")
+
+	testCases := []struct {
+		desc        string
+		device      string
+		after       time.Duration
+		value       float32
+		err         error
+		wantValue   float32
+		wantQuality Quality
+	}{
+		{"no good value yet", "powermeter", 0, 0, errRead, 0, QualityBad},
+		{"successful read", "powermeter", time.Second, 50, nil, 50, QualityGood},
+		{"failed read holds last value", "powermeter", 2 * time.Second, 0, errRead, 50, QualityStale},
+		{"failed read past stale limit", "powermeter", 10 * time.Second, 0, errRead, 50, QualityBad},
+		{"recovers after a good read", "powermeter", 11 * time.Second, 49, nil, 49, QualityGood},
+		{"devices are tracked separately", "inverter", 12 * time.Second, 0, errRead, 0, QualityBad},
+	}
+
+	q := NewQualityTracker(5 * time.Second)
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			got := q.Assess(testCase.device, r, testCase.value, testCase.err, start.Add(testCase.after))
+			if got.Value != testCase.wantValue || got.Quality != testCase.wantQuality {
+				t.Errorf("got %v (%v), want %v (%v)", got.Value, got.Quality, testCase.wantValue, testCase.wantQuality)
+			}
+		})
+	}
+}
+
+func TestReadingJSON(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		quality   Quality
+		wantValue bool
+	}{
+		{"good reading has a value", QualityGood, true},
+		{"stale reading has a value", QualityStale, true},
+		{"bad reading has no value", QualityBad, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			b, err := json.Marshal(Reading{Name: "Frequency", Value: 50, Quality: testCase.quality})
+			if err != nil {
+				t.Fatalf("marshalling: %v", err)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatalf("unmarshalling %s: %v", b, err)
+			}
+			if _, ok := got["value"]; ok != testCase.wantValue {
+				t.Errorf("got %s, want value present %v", b, testCase.wantValue)
+			}
+			if got["quality"] != string(testCase.quality) {
+				t.Errorf("got quality %v, want %v", got["quality"], testCase.quality)
+			}
+		})
+	}
+}
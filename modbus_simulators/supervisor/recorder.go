@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{"time", "device", "name", "address", "value", "quality"}
+
+// Recorder appends readings to a CSV file
+type Recorder struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// NewRecorder opens the CSV file at path for appending, writing the
+// header row if the file is new.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		if err := w.Write(csvHeader); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return &Recorder{f: f, w: w}, nil
+}
+
+// Write adds a reading to the file. Rows are buffered until Flush is called.
+func (r *Recorder) Write(reading Reading) error {
+	return r.w.Write([]string{
+		reading.Time.Format(time.RFC3339Nano),
+		reading.Device,
+		reading.Name,
+		strconv.Itoa(int(reading.Address)),
+		strconv.FormatFloat(float64(reading.Value), 'f', -1, 32),
+		string(reading.Quality),
+	})
+}
+
+// Flush writes any buffered rows to the file
+func (r *Recorder) Flush() error {
+	r.w.Flush()
+	return r.w.Error()
+}
+
+// Close flushes and closes the file
+func (r *Recorder) Close() error {
+	if err := r.Flush(); err != nil {
+		r.f.Close()
+		return fmt.Errorf("flushing: %v", err)
+	}
+	return r.f.Close()
+}
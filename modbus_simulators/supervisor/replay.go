@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// errRecordedFailure stands in for the error behind a stale or bad reading
+// in a recording, as the error itself is not recorded
+var errRecordedFailure = errors.New("read failed when recorded")
+
+// ReadRecording reads the readings from a CSV file written by a Recorder
+func ReadRecording(r io.Reader) ([]Reading, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	for i, h := range header {
+		if h != csvHeader[i] {
+			return nil, fmt.Errorf("unexpected header %q, want %q", header, csvHeader)
+		}
+	}
+
+	var readings []Reading
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return readings, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		reading, err := parseRecord(record)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		readings = append(readings, reading)
+	}
+}
+
+func parseRecord(record []string) (Reading, error) {
+	t, err := time.Parse(time.RFC3339Nano, record[0])
+	if err != nil {
+		return Reading{}, fmt.Errorf("parsing time: %v", err)
+	}
+	address, err := strconv.ParseUint(record[3], 10, 16)
+	if err != nil {
+		return Reading{}, fmt.Errorf("parsing address: %v", err)
+	}
+	value, err := strconv.ParseFloat(record[4], 32)
+	if err != nil {
+		return Reading{}, fmt.Errorf("parsing value: %v", err)
+	}
+	quality := Quality(record[5])
+	if quality != QualityGood && quality != QualityStale && quality != QualityBad {
+		return Reading{}, fmt.Errorf("unknown quality %q", record[5])
+	}
+
+	return Reading{
+		Device:  record[1],
+		Name:    record[2],
+		Address: uint16(address),
+		Value:   float32(value),
+		Time:    t,
+		Quality: quality,
+	}, nil
+}
+
+// devicesFromRecording lists the devices and registers found in the
+// readings, in the order they first appear, so the dashboard and health
+// report can be set up without connecting to the devices.
+func devicesFromRecording(readings []Reading) []Device {
+	var devices []Device
+	index := make(map[string]int)
+	seen := make(map[readingKey]bool)
+	for _, r := range readings {
+		if seen[r.key()] {
+			continue
+		}
+		seen[r.key()] = true
+
+		i, ok := index[r.Device]
+		if !ok {
+			i = len(devices)
+			index[r.Device] = i
+			devices = append(devices, Device{Name: r.Device})
+		}
+
+		register := registermap.Register{Name: r.Name, Address: r.Address}
+		if known, ok := registermap.Lookup(r.Address); ok && known.Name == r.Name {
+			register = known
+		}
+		devices[i].Registers = append(devices[i].Registers, register)
+	}
+	return devices
+}
+
+// replay passes the readings through the pipeline, waiting between them
+// for the time that passed when they were recorded divided by speed. A
+// speed of zero replays them as fast as possible. The readings keep the
+// times they were recorded at.
+func replay(ctx context.Context, readings []Reading, speed float64, health *Health, pipeline *Pipeline) error {
+	for i, r := range readings {
+		if i > 0 && speed > 0 {
+			if gap := r.Time.Sub(readings[i-1].Time); gap > 0 {
+				// Redraw once all the readings at one time have been handled
+				pipeline.Render()
+
+				timer := time.NewTimer(time.Duration(float64(gap) / speed))
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				case <-timer.C:
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var err error
+		failed := 0
+		if r.Quality != QualityGood {
+			err = errRecordedFailure
+			failed = 1
+		}
+		if err := pipeline.Handle(r, err); err != nil {
+			return err
+		}
+		health.RecordPoll(r.Device, 1, failed, time.Now())
+	}
+	pipeline.Render()
+	return nil
+}
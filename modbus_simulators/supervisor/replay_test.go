@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestReadRecording(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	want := []Reading{
+		{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Value: 50, Time: start, Quality: QualityGood},
+		{Device: "inverter", Name: "StateOfCharge", Address: registermap.StateOfChargeAddr, Value: 60.5, Time: start, Quality: QualityStale},
+		{Device: "powermeter", Name: "Frequency", Address: registermap.FrequencyAddr, Time: start.Add(time.Second), Quality: QualityBad},
+	}
+
+	// Round trip the readings through a recording
+	path := filepath.Join(t.TempDir(), "readings.csv")
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recordAll(recorder, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadRecording(path)
+	if err != nil {
+		t.Fatalf("loading recording: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v readings, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reading %v: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	devices := devicesFromRecording(got)
+	if len(devices) != 2 || devices[0].Name != "powermeter" || len(devices[0].Registers) != 1 || devices[0].Registers[0].Unit != registermap.Hertz {
+		t.Errorf("got devices %+v, want the power meter frequency then the inverter", devices)
+	}
+}
+
+func TestReadRecordingErrors(t *testing.T) {
+	header := strings.Join(csvHeader, ",") + "\n"
+	testCases := []struct {
+		desc      string
+		recording string
+		wantErr   string
+	}{
+		{"empty", "", "reading header"},
+		{"wrong header", "time,name,value\n", "wrong number of fields"},
+		{"bad time", header + "yesterday,powermeter,Frequency,16384,50,good\n", "line 2: parsing time"},
+		{"bad quality", header + "2020-06-27T10:00:00Z,powermeter,Frequency,16384,50,great\n", `unknown quality "great"`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			_, err := ReadRecording(strings.NewReader(testCase.recording))
+			if err == nil || !strings.Contains(err.Error(), testCase.wantErr) {
+				t.Errorf("got error %v, want %q", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	readings := []Reading{
+		{Device: "powermeter", Name: "Frequency", Value: 50, Time: start, Quality: QualityGood},
+		{Device: "powermeter", Name: "Frequency", Value: 51, Time: start.Add(time.Second), Quality: QualityGood},
+		{Device: "powermeter", Name: "Frequency", Time: start.Add(2 * time.Second), Quality: QualityBad},
+	}
+
+	testCases := []struct {
+		desc    string
+		speed   float64
+		minTime time.Duration
+	}{
+		{"as fast as possible", 0, 0},
+		{"sped up", 20, 100 * time.Millisecond},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "replayed.csv")
+			recorder, err := NewRecorder(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			health := NewHealth(devicesFromRecording(readings), true, time.Minute)
+			pipeline := &Pipeline{
+				logger:     logging.Discard(),
+				hub:        NewHub(logging.Discard()),
+				health:     health,
+				aggregator: NewAggregator(0),
+				recorder:   recorder,
+			}
+
+			began := time.Now()
+			if err := replay(context.Background(), readings, testCase.speed, health, pipeline); err != nil {
+				t.Fatalf("replaying: %v", err)
+			}
+			if took := time.Since(began); took < testCase.minTime {
+				t.Errorf("took %v, want at least %v", took, testCase.minTime)
+			}
+			pipeline.Close()
+
+			// The replayed readings are recorded unchanged
+			got, err := loadRecording(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(readings) || got[1] != readings[1] || got[2].Quality != QualityBad {
+				t.Errorf("got %+v, want %+v", got, readings)
+			}
+
+			d := health.Report().Devices["powermeter"]
+			if d.Reads != 3 || d.Errors != 1 {
+				t.Errorf("got %v reads and %v errors, want 3 and 1", d.Reads, d.Errors)
+			}
+		})
+	}
+}
+
+func TestReplayStops(t *testing.T) {
+	start := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	readings := []Reading{
+		{Device: "powermeter", Time: start, Quality: QualityGood},
+		{Device: "powermeter", Time: start.Add(time.Hour), Quality: QualityGood},
+	}
+	pipeline := &Pipeline{logger: logging.Discard(), hub: NewHub(logging.Discard())}
+	health := NewHealth(devicesFromRecording(readings), false, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error)
+	go func() { done <- replay(ctx, readings, 1, health, pipeline) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("got error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replay did not stop when the context was cancelled")
+	}
+}
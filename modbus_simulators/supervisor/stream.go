@@ -0,0 +1,149 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// indexHTML is a small page that connects to the /ws endpoint and
+// plots the readings as they arrive.
+//
+//go:embed index.html
+var indexHTML []byte
+
+const (
+	// Number of readings buffered per client before new readings
+	// are skipped for that client.
+	clientBufferSize = 64
+	writeTimeout     = 5 * time.Second
+)
+
+// Reading stores a single value read from a register
+type Reading struct {
+	Device  string    `json:"device"`
+	Name    string    `json:"name"`
+	Address uint16    `json:"address"`
+	Value   float32   `json:"value"`
+	Time    time.Time `json:"time"`
+	Quality Quality   `json:"quality"`
+}
+
+// MarshalJSON leaves the value out of bad readings, as there is no
+// trustworthy value to send.
+func (r Reading) MarshalJSON() ([]byte, error) {
+	// reading has the same fields but not this method, so it can be
+	// marshalled without recursing.
+	type reading Reading
+	if r.Quality != QualityBad {
+		return json.Marshal(reading(r))
+	}
+
+	return json.Marshal(struct {
+		reading
+		Value *float32 `json:"value,omitempty"`
+	}{reading: reading(r)})
+}
+
+// Hub fans readings out to all of the connected websocket clients
+type Hub struct {
+	logger  *slog.Logger
+	mu      sync.Mutex // protects the clients map
+	clients map[chan Reading]struct{}
+}
+
+// NewHub creates a hub with no connected clients
+func NewHub(logger *slog.Logger) *Hub {
+	return &Hub{
+		logger:  logger,
+		clients: make(map[chan Reading]struct{}),
+	}
+}
+
+// Publish sends the reading to every client. Clients whose buffer is
+// full are skipped rather than blocking the polling loop.
+func (h *Hub) Publish(r Reading) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		select {
+		case c <- r:
+		default:
+		}
+	}
+}
+
+func (h *Hub) subscribe() chan Reading {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan Reading, clientBufferSize)
+	h.clients[c] = struct{}{}
+	return c
+}
+
+func (h *Hub) unsubscribe(c chan Reading) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, c)
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// serveWS handles the /ws route, streaming readings as JSON messages
+func (h *Hub) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Warn("upgrading websocket", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	readings := h.subscribe()
+	defer h.unsubscribe(readings)
+
+	// The browser never sends us anything useful, but we must read to
+	// notice when the connection is closed.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case reading := <-readings:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(reading); err != nil {
+				h.logger.Warn("writing to websocket", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// serveIndex handles the / route
+func serveIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(indexHTML)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/gorilla/websocket"
+)
+
+// waitForClients blocks until the hub has n subscribed clients
+func waitForClients(t *testing.T, h *Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		got := len(h.clients)
+		h.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %v clients", n)
+}
+
+func TestHubStreamsReadings(t *testing.T) {
+	h := NewHub(logging.Discard())
+	server := httptest.NewServer(http.HandlerFunc(h.serveWS))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing websocket: %v", err)
+	}
+	defer conn.Close()
+	waitForClients(t, h, 1)
+
+	want := Reading{
+		Name:    "Frequency",
+		Address: 16384,
+		Value:   50,
+		Time:    time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC),
+	}
+	h.Publish(want)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var got Reading
+	if err := conn.ReadJSON(&got); err != nil {
+		t.Fatalf("reading message: %v", err)
+	}
+	if got.Name != want.Name || got.Address != want.Address || got.Value != want.Value || !got.Time.Equal(want.Time) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestHubSkipsFullClients(t *testing.T) {
+	h := NewHub(logging.Discard())
+	h.subscribe() // never read from
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < clientBufferSize+10; i++ {
+			h.Publish(Reading{Name: "Frequency"})
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full client")
+	}
+}
+
+func TestServeIndex(t *testing.T) {
+	testCases := []struct {
+		desc string
+		path string
+		want int
+	}{
+		{"index page", "/", http.StatusOK},
+		{"unknown path", "/foo", http.StatusNotFound},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+			response := httptest.NewRecorder()
+			serveIndex(response, request)
+
+			if response.Code != testCase.want {
+				t.Errorf("got status %v, want %v", response.Code, testCase.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+// ANSI escape sequence to move the cursor home and clear the screen
+const clearScreen = "\033[H\033[2J"
+
+// dashboardRow holds the latest state of a single register
+type dashboardRow struct {
+	device   string
+	register registermap.Register
+	value    float32
+	previous float32
+	quality  Quality
+	seen     bool
+	err      error
+}
+
+// Dashboard renders the latest register values as a table in the terminal
+type Dashboard struct {
+	out     io.Writer
+	rows    []dashboardRow
+	index   map[readingKey]int // row of each register
+	updated time.Time
+}
+
+// NewDashboard creates a dashboard for the registers of the given devices
+func NewDashboard(out io.Writer, devices []Device) *Dashboard {
+	d := &Dashboard{
+		out:   out,
+		index: make(map[readingKey]int),
+	}
+	for _, device := range devices {
+		for _, r := range device.Registers {
+			d.index[readingKey{device: device.Name, address: r.Address}] = len(d.rows)
+			d.rows = append(d.rows, dashboardRow{device: device.Name, register: r})
+		}
+	}
+	return d
+}
+
+// Update records the result of reading a register. Readings of registers
+// not on the dashboard are ignored.
+func (d *Dashboard) Update(reading Reading, err error) {
+	i, ok := d.index[reading.key()]
+	if !ok {
+		return
+	}
+	row := &d.rows[i]
+	row.err = err
+	row.quality = reading.Quality
+	if err != nil {
+		return
+	}
+
+	row.previous = row.value
+	if !row.seen {
+		row.previous = reading.Value
+	}
+	row.value = reading.Value
+	row.seen = true
+	d.updated = reading.Time
+}
+
+// Render redraws the whole table
+func (d *Dashboard) Render() {
+	fmt.Fprint(d.out, clearScreen)
+	fmt.Fprintf(d.out, "Supervisor - last update %v\n\n", d.updated.Format("15:04:05.000"))
+
+	tw := tabwriter.NewWriter(d.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tREGISTER\tADDRESS\tVALUE\tUNIT\tQUALITY\tTREND")
+	for _, row := range d.rows {
+		r := row.register
+
+		// Show the error in place of the trend when the last read failed
+		value, note := "-", ""
+		if row.seen {
+			value = fmt.Sprint(row.value)
+			note = trend(row.previous, row.value)
+		}
+		if row.err != nil {
+			note = row.err.Error()
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", row.device, r.Name, r.Address, value, r.Unit, row.quality, note)
+	}
+	tw.Flush()
+}
+
+// trend returns an arrow showing the direction of change between two values
+func trend(previous, current float32) string {
+	switch {
+	case current > previous:
+		return "↑"
+	case current < previous:
+		return "↓"
+	default:
+		return "→"
+	}
+}
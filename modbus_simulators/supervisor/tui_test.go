@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/registermap"
+)
+
+func TestTrend(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		previous float32
+		current  float32
+		want     string
+	}{
+		{"rising", 1, 2, "↑"},
+		{"falling", 2, 1, "↓"},
+		{"steady", 2, 2, "→"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			if got := trend(testCase.previous, testCase.current); got != testCase.want {
+				t.Errorf("got %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDashboardRender(t *testing.T) {
+	now := time.Date(2020, 6, 27, 10, 0, 0, 0, time.UTC)
+	r := registermap.PowerMeter[0]
+
+	testCases := []struct {
+		desc    string
+		updates []Reading
+		err     error
+		want    []string
+	}{
+		{
+			"not read yet",
+			nil,
+			nil,
+			[]string{"powermeter", "Frequency", "-", "Hz"},
+		}, {
+			"rising value",
+			[]Reading{
+				{Device: "powermeter", Name: r.Name, Address: r.Address, Value: 49, Time: now, Quality: QualityGood},
+				{Device: "powermeter", Name: r.Name, Address: r.Address, Value: 50, Time: now, Quality: QualityGood},
+			},
+			nil,
+			[]string{"Frequency", "16384", "50", "Hz", "good", "↑"},
+		}, {
+			"failed read",
+			[]Reading{
+				{Device: "powermeter", Name: r.Name, Address: r.Address, Value: 50, Time: now, Quality: QualityStale},
+			},
+			errors.New("timeout"),
+			[]string{"Frequency", "stale", "timeout"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			devices := []Device{{Name: "powermeter", Registers: registermap.PowerMeter[:1]}}
+			d := NewDashboard(&buf, devices)
+			for _, u := range testCase.updates {
+				d.Update(u, testCase.err)
+			}
+			d.Render()
+
+			out := buf.String()
+			if !strings.HasPrefix(out, clearScreen) {
+				t.Errorf("output does not start by clearing the screen: %q", out)
+			}
+			lines := strings.Split(strings.TrimSpace(out), "\n")
+			row := lines[len(lines)-1]
+			for _, want := range testCase.want {
+				if !strings.Contains(row, want) {
+					t.Errorf("row %q does not contain %q", row, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/evergreen-innovations/blogs/modbus"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/logging"
+	"github.com/evergreen-innovations/blogs/modbus_simulators/internal/simulator"
+)
+
+const (
+	defaultHost       string  = "0.0.0.0"
+	defaultPort       string  = ":1505"
+	defaultWaveHeight float64 = 1.5
+	defaultWavePeriod float64 = 7
+	defaultDiameter   float64 = 4
+	defaultMass       float64 = 30000
+	defaultDamping    float64 = 30000
+)
+
+func main() {
+	var mainErr error
+	logger := slog.Default()
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if mainErr != nil {
+			logger.Error("error encountered", "err", mainErr)
+			os.Exit(1)
+		} else {
+			logger.Info("exiting")
+		}
+	}()
+
+	// Set up the commandline options
+	host := flag.String("host", defaultHost, "host for the modbus server")
+	port := flag.String("port", defaultPort, "port for the modbus server")
+	waveHeight := flag.Float64("wave-height", defaultWaveHeight, "significant wave height of the sea in m")
+	wavePeriod := flag.Float64("wave-period", defaultWavePeriod, "peak period of the sea in s")
+	diameter := flag.Float64("diameter", defaultDiameter, "diameter of the buoy in m")
+	mass := flag.Float64("mass", defaultMass, "mass of the buoy, including added mass, in kg")
+	damping := flag.Float64("damping", defaultDamping, "damping of the power take-off in Ns/m")
+	statePath := flag.String("state", "", "file to save the sea and the buoy to on shutdown and resume from on start (empty to not persist)")
+	logFlags := logging.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	l, err := logFlags.New(os.Stderr)
+	if err != nil {
+		mainErr = err
+		return
+	}
+	logger = l
+
+	// Open the modbus server
+	addr := fmt.Sprintf("%s%s", *host, *port)
+	s, err := modbus.NewServer(addr)
+	if err != nil {
+		mainErr = fmt.Errorf("creating server: %v", err)
+		return
+	}
+	defer s.Close()
+
+	logger.Info("modbus server for wave energy converter running", "addr", addr)
+
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	sea := simulator.NewSea(*waveHeight, *wavePeriod, rnd)
+	wec := simulator.NewWEC(sea, *diameter, *mass, *damping)
+
+	// Optionally resume from the state saved by a previous run
+	if *statePath != "" {
+		restored, err := simulator.LoadState(*statePath, wec, s)
+		if err != nil {
+			mainErr = fmt.Errorf("loading state: %v", err)
+			return
+		}
+		if restored {
+			logger.Info("resumed from saved state", "path", *statePath)
+		}
+	}
+
+	// The context is cancelled when a signal is trapped
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// Closed once the simulation loop has stopped
+	simulated := make(chan struct{})
+
+	// Go-routine for moving the buoy with the waves and updating the
+	// registers
+	go func() {
+		defer close(simulated)
+
+		simulator.Run(ctx, s, wec, logger)
+	}()
+
+	// Block execution until a signal is trapped, then wait for the
+	// simulation loop to stop before the deferred functions close the server.
+	<-ctx.Done()
+	logger.Info("signal trapped, shutting down")
+	<-simulated
+
+	if *statePath != "" {
+		if err := simulator.SaveState(*statePath, wec, s); err != nil {
+			mainErr = fmt.Errorf("saving state: %v", err)
+			return
+		}
+		logger.Info("saved state", "path", *statePath)
+	}
+}
@@ -0,0 +1,36 @@
+#!/bin/sh
+# Copies a deployment directory, with the code of its service and the
+# shared module, into a checkout of the repository it is deployed from,
+# so its workflows can build it there:
+#
+#   pipeline/publish.sh cd-S3/serviceC ../serviceC
+#
+# The service is copied into a directory named after it, next to shared,
+# so the replace directive in its go.mod finds the shared module as it
+# does here. The copies are replaced each time; nothing else in the
+# checkout is removed.
+set -e
+
+if [ $# -ne 2 ]; then
+    echo "usage: $0 <deployment directory> <repository checkout>" >&2
+    exit 2
+fi
+deploy=$1
+out=$2
+
+case $(basename "$deploy") in
+    serviceA) service=serviceA ;;
+    serverB | serviceB) service=serverB ;;
+    serverC | serviceC) service=serverC ;;
+    *)
+        echo "$0: no service is deployed from $deploy" >&2
+        exit 2
+        ;;
+esac
+
+pipeline=$(cd "$(dirname "$0")" && pwd)
+mkdir -p "$out"
+cp -R "$deploy/." "$out/"
+rm -rf "$out/$service" "$out/shared"
+cp -R "$pipeline/$service" "$out/$service"
+cp -R "$pipeline/shared" "$out/shared"
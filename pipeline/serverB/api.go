@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"shared/httpserver"
+	"shared/jsonschema"
+	"shared/openapi"
+)
+
+// The HTTP API is versioned, so it can change without breaking the
+// clients deployed against it. Each version is served under its prefix,
+// and v1 also without one, as it was before versions were introduced. v1
+// keeps the behaviour its clients were built against; v2 is stricter
+// about what it accepts and describes what it did in its responses.
+
+// Limits on the fields posted to the /v2/post route, which the post
+// schema sets
+const (
+	maxServiceNameLen = 64
+	minValue          = -1000000
+	maxValue          = 1000000
+)
+
+// Limits on the requests to the API, so oversized or slow clients cannot
+// tie up the server
+const (
+	maxPostBytes = 4 << 10
+	// postTimeout is longer than defaultForwardTimeout, and forwarding is
+	// cut to fit within it, so a value that cannot be forwarded is
+	// answered with a 502 rather than a 408
+	postTimeout = 8 * time.Second
+	getTimeout  = 5 * time.Second
+)
+
+// apiV1 returns the routes of version 1 of the API
+func (sm *GlobalVarManager) apiV1() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/post": postRoute(sm.postCall),
+		"/get":  getRoute(sm.getCall),
+	}
+}
+
+// apiV2 returns the routes of version 2 of the API
+func (sm *GlobalVarManager) apiV2() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/post": postRoute(sm.postCallV2),
+		"/get":  getRoute(sm.getCallV2),
+	}
+}
+
+// postRoute wraps the handler of a post with the body limit, timeout and
+// idempotency of every version. Each route has its own idempotency cache.
+func postRoute(handler http.HandlerFunc) http.Handler {
+	return httpserver.Chain(handler,
+		httpserver.Timeout(postTimeout),
+		httpserver.MaxBytes(maxPostBytes),
+		httpserver.Idempotent(httpserver.NewIdempotencyCache(httpserver.IdempotencyTTL)),
+	)
+}
+
+// getRoute wraps the handler of a get with its timeout
+func getRoute(handler http.HandlerFunc) http.Handler {
+	return httpserver.Timeout(getTimeout)(handler)
+}
+
+// mountAPI registers the routes of a version of the API under prefix
+func mountAPI(router *http.ServeMux, prefix string, routes map[string]http.Handler) {
+	for path, handler := range routes {
+		router.Handle(prefix+path, handler)
+	}
+}
+
+// postRequest is the body of the /v2/post route. Value is a pointer so a
+// missing value can be told apart from zero.
+type postRequest struct {
+	ServiceName string `json:"serviceName"`
+	Value       *int   `json:"value"`
+}
+
+// postResponse is the body of a successful /v2/post, holding the value
+// recorded once it has been forwarded to serverC
+type postResponse struct {
+	Value
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// valuesList is the body of /v2/get
+type valuesList struct {
+	Values []Value `json:"values"`
+	Total  int     `json:"total"`
+}
+
+// postCallV2 handles the /v2/post route. Unlike v1, the body must be
+// declared as JSON and match the post schema, holding a single object with
+// only the fields of postRequest; one that is not JSON or holds fields of
+// the wrong type or unknown ones is a 400, and one whose fields are out of
+// bounds a 422. The value recorded is returned as JSON.
+func (sm *GlobalVarManager) postCallV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if httpserver.BodyTooLarge(err) {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", maxPostBytes))
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("reading body: %v", err))
+		return
+	}
+
+	// The body is checked against the post schema before it is decoded
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		writeError(w, http.StatusBadRequest, "body must hold a single JSON object")
+		return
+	}
+	if err := postSchema.Validate(doc); err != nil {
+		status := http.StatusUnprocessableEntity
+		var se *jsonschema.Error
+		if errors.As(err, &se) && se.Malformed() {
+			status = http.StatusBadRequest
+		}
+		writeRequestError(w, status, err)
+		return
+	}
+
+	var req postRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	value, downstreamID, err := sm.receive(r.Context(), req.ServiceName, *req.Value)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	setDownstreamRequestID(w, downstreamID)
+
+	requestID, _ := httpserver.RequestIDFrom(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(postResponse{Value: value, RequestID: requestID})
+}
+
+// getCallV2 handles the /v2/get route, listing the values received so far
+// along with their number. It takes no query parameters, and rejects any
+// given rather than ignoring them.
+func (sm *GlobalVarManager) getCallV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+	if r.URL.RawQuery != "" {
+		writeError(w, http.StatusBadRequest, "query parameters are not accepted")
+		return
+	}
+
+	values := sm.list()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(valuesList{Values: values, Total: len(values)})
+}
+
+// apiSpec describes both versions of the API, from the types their
+// handlers decode and encode. v1 is described under its prefix, though it
+// is also served without one.
+func apiSpec() *openapi.Spec {
+	spec := openapi.New("serverB", "2",
+		"Receives the values of the demo pipeline from serviceA and forwards them on to serverC. "+
+			"Version 1 is also served without the /v1 prefix.")
+
+	// The limits validate checks on a v2 post
+	request := spec.Component(postRequest{})
+	request.Properties["serviceName"].MaxLength = openapi.IntRef(maxServiceNameLen)
+	request.Properties["value"].Minimum = openapi.IntRef(minValue)
+	request.Properties["value"].Maximum = openapi.IntRef(maxValue)
+
+	// Values are timestamped as RFC3339 strings
+	spec.Component(Value{}).Properties["timestamp"].Format = "date-time"
+
+	errorBody := spec.Schema(errorResponse{})
+	failed := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSONContent(errorBody)}
+	}
+	text := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: map[string]openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}}}
+	}
+
+	idempotencyKey := openapi.Parameter{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "Retries of a post with the same key are answered with the first response, rather than forwarding the value again",
+		Schema:      &openapi.Schema{Type: "string", MaxLength: openapi.IntRef(httpserver.MaxIdempotencyKeyLen)},
+	}
+
+	spec.Add(http.MethodPost, "/v1/post", &openapi.Operation{
+		Summary:     "Receive a value",
+		Description: "Records the value posted and forwards it plus 100 to serverC.",
+		Tags:        []string{"v1"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSONContent(spec.Schema(Service{}))},
+		Responses: map[string]openapi.Response{
+			"200": text("The value was forwarded"),
+			"400": failed("The body is not valid JSON"),
+			"405": text("The method is not POST"),
+			"408": failed("The post was not handled in time"),
+			"409": failed("A post with the same Idempotency-Key is still being handled"),
+			"413": failed("The body is larger than the limit"),
+			"422": failed("The Idempotency-Key was used with a different body"),
+			"500": text("The body could not be read"),
+			"502": failed("The value could not be forwarded to serverC"),
+		},
+	})
+	spec.Add(http.MethodGet, "/v1/get", &openapi.Operation{
+		Summary: "List the values received",
+		Tags:    []string{"v1"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The values received so far", Content: openapi.JSONContent(spec.Schema([]Value{}))},
+			"408": failed("The values were not listed in time"),
+		},
+	})
+
+	spec.Add(http.MethodPost, "/v2/post", &openapi.Operation{
+		Summary:     "Receive a value",
+		Description: "Records the value posted and forwards it plus 100 to serverC, returning the value recorded.",
+		Tags:        []string{"v2"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSONContent(spec.Schema(postRequest{}))},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The value recorded", Content: openapi.JSONContent(spec.Schema(postResponse{}))},
+			"400": failed("The body is not a single JSON object with the fields of a postRequest"),
+			"405": failed("The method is not POST"),
+			"408": failed("The post was not handled in time"),
+			"409": failed("A post with the same Idempotency-Key is still being handled"),
+			"413": failed("The body is larger than the limit"),
+			"415": failed("The body is not declared as JSON"),
+			"422": failed("The fields are not valid, or the Idempotency-Key was used with a different body"),
+			"502": failed("The value could not be forwarded to serverC"),
+		},
+	})
+	spec.Add(http.MethodGet, "/v2/get", &openapi.Operation{
+		Summary:     "List the values received",
+		Description: "Takes no query parameters.",
+		Tags:        []string{"v2"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The values received so far", Content: openapi.JSONContent(spec.Schema(valuesList{}))},
+			"400": failed("Query parameters were given"),
+			"405": failed("The method is not GET"),
+			"408": failed("The values were not listed in time"),
+		},
+	})
+
+	return spec
+}
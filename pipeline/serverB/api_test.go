@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIVersions(t *testing.T) {
+	forwarder := &testSender{}
+	gm := NewGlobalVarManager(forwarder)
+	router := http.NewServeMux()
+	v1 := gm.apiV1()
+	mountAPI(router, "", v1)
+	mountAPI(router, "/v1", v1)
+	mountAPI(router, "/v2", gm.apiV2())
+
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	// v1 is served with and without its prefix, as before
+	for _, path := range []string{"/post", "/v1/post"} {
+		if got := do(http.MethodPost, path, "", `{"serviceName":"serviceA","value":8}`); got.Code != http.StatusOK || got.Body.String() != "POST done" {
+			t.Errorf("%v: got %v %q, want 200 \"POST done\"", path, got.Code, got.Body)
+		}
+	}
+
+	testCases := []struct {
+		desc        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"valid", "application/json", `{"serviceName":"serviceA","value":8}`, http.StatusOK},
+		{"no content type", "", `{"serviceName":"serviceA","value":8}`, http.StatusUnsupportedMediaType},
+		{"not JSON", "application/json", `8`, http.StatusBadRequest},
+		{"two objects", "application/json", `{"serviceName":"serviceA","value":8}{}`, http.StatusBadRequest},
+		{"unknown field", "application/json", `{"serviceName":"serviceA","value":8,"extra":1}`, http.StatusBadRequest},
+		{"missing service", "application/json", `{"value":8}`, http.StatusUnprocessableEntity},
+		{"value too large", "application/json", `{"serviceName":"serviceA","value":2000000}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		t.Run("v2 post "+tc.desc, func(t *testing.T) {
+			response := do(http.MethodPost, "/v2/post", tc.contentType, tc.body)
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v: %s", response.Code, tc.wantStatus, response.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var got postResponse
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ServiceName != "serviceA" || got.Value.Value != 8 || got.Timestamp == "" {
+				t.Errorf("got %+v, want the value recorded", got)
+			}
+		})
+	}
+
+	forwarder.err = errors.New("serverC is down")
+	if got := do(http.MethodPost, "/v2/post", "application/json", `{"serviceName":"serviceA","value":8}`).Code; got != http.StatusBadGateway {
+		t.Errorf("got status %v when forwarding fails, want %v", got, http.StatusBadGateway)
+	}
+
+	t.Run("v2 get", func(t *testing.T) {
+		var list valuesList
+		if err := json.NewDecoder(do(http.MethodGet, "/v2/get", "", "").Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != len(list.Values) || list.Total != 4 {
+			t.Errorf("got %v values and a total of %v, want 4", len(list.Values), list.Total)
+		}
+		if got := do(http.MethodGet, "/v2/get?serviceName=serviceA", "", "").Code; got != http.StatusBadRequest {
+			t.Errorf("got status %v with a query, want %v", got, http.StatusBadRequest)
+		}
+	})
+}
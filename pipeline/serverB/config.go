@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"shared/configfile"
+	"shared/discovery"
+)
+
+// The defaults of the CORS settings, and the response headers scripts
+// on the allowed origins may read
+const (
+	defaultCORSMethods = "GET, HEAD, POST"
+	defaultCORSHeaders = "Content-Type, Idempotency-Key, Last-Event-ID, X-Request-Id"
+	corsExposedHeaders = "X-Downstream-Request-Id, X-Request-Id, X-Total-Count"
+)
+
+// Config holds the runtime settings of serverB, each tagged with the name
+// of its flag
+type Config struct {
+	ListenAddr string     `flag:"listen-addr"`
+	LogLevel   slog.Level `flag:"log-level"`
+
+	DownstreamURL     string        `flag:"downstream-url"`
+	DownstreamSRV     string        `flag:"downstream-srv"`
+	DiscoveryInterval time.Duration `flag:"discovery-interval"`
+	ForwardTimeout    time.Duration `flag:"forward-timeout"`
+	DownstreamGRPC    string        `flag:"downstream-grpc"`
+	NATSURL           string        `flag:"nats-url"`
+	GRPCAddr          string        `flag:"grpc-addr"`
+	DrainDelay        time.Duration `flag:"drain-delay"`
+
+	TLSCert        string `flag:"tls-cert"`
+	TLSKey         string `flag:"tls-key"`
+	TLSClientCA    string `flag:"tls-client-ca"`
+	DownstreamCert string `flag:"downstream-cert"`
+	DownstreamKey  string `flag:"downstream-key"`
+	DownstreamCA   string `flag:"downstream-ca"`
+
+	CORSOrigins string `flag:"cors-origins"`
+	CORSMethods string `flag:"cors-methods"`
+	CORSHeaders string `flag:"cors-headers"`
+
+	AdminToken string `flag:"admin-token"`
+}
+
+// parseConfig reads the config from args, the command line without the
+// program name, writing usage and errors to output
+func parseConfig(args []string, output io.Writer) (Config, error) {
+	var c Config
+	level, err := configfile.EnvLevel("LOG_LEVEL")
+	if err != nil {
+		return c, err
+	}
+
+	fs := flag.NewFlagSet("serverB", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file of settings keyed by flag name, read again on SIGHUP, also set by CONFIG_FILE")
+	fs.StringVar(&c.ListenAddr, "listen-addr", envOr("LISTEN_ADDR", defaultListenAddr), "server listen address, also set by LISTEN_ADDR")
+	fs.TextVar(&c.LogLevel, "log-level", level, "lowest level logged: debug, info, warn or error, also set by LOG_LEVEL")
+	// The downstream URL can be set in the environment, for deployments
+	// where the services run on different hosts, and the flag overrides it
+	fs.StringVar(&c.DownstreamURL, "downstream-url", envOr("DOWNSTREAM_URL", defaultDownstreamURL), "URL of serverC's /post endpoint, also set by DOWNSTREAM_URL")
+	fs.StringVar(&c.DownstreamSRV, "downstream-srv", os.Getenv("DOWNSTREAM_SRV"), "DNS SRV record, such as _http._tcp.serverc.service.consul, listing the serverC instances to forward to in place of the host of -downstream-url, also set by DOWNSTREAM_SRV")
+	fs.DurationVar(&c.DiscoveryInterval, "discovery-interval", discovery.DefaultInterval, "how often to look up -downstream-srv again")
+	fs.DurationVar(&c.ForwardTimeout, "forward-timeout", defaultForwardTimeout, "how long to keep retrying a value before responding 502")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "certificate file to serve HTTPS with")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "key file of -tls-cert")
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", "", "CA file to verify client certificates with, requiring clients to present one (mutual TLS)")
+	fs.StringVar(&c.DownstreamCert, "downstream-cert", "", "certificate file to present to serverC (mutual TLS)")
+	fs.StringVar(&c.DownstreamKey, "downstream-key", "", "key file of -downstream-cert")
+	fs.StringVar(&c.DownstreamCA, "downstream-ca", "", "CA file to verify serverC's certificate with, instead of the system's")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", "", "address to also serve the gRPC pipeline on, such as :9001")
+	fs.StringVar(&c.DownstreamGRPC, "downstream-grpc", "", "address of serverC's gRPC pipeline, such as localhost:15001, to forward values over gRPC instead of HTTP")
+	fs.DurationVar(&c.DrainDelay, "drain-delay", 0, "how long to keep serving after /readyz starts failing on shutdown, so load balancers stop sending requests first")
+	fs.StringVar(&c.NATSURL, "nats-url", "", "NATS server, such as nats://localhost:4222, to consume values from and forward them to serverC through, instead of HTTP")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", os.Getenv("CORS_ORIGINS"), "comma separated origins, such as https://dash.example.com, whose pages may call the API from a browser, or * for any, also set by CORS_ORIGINS")
+	fs.StringVar(&c.CORSMethods, "cors-methods", envOr("CORS_METHODS", defaultCORSMethods), "comma separated methods the -cors-origins may use, also set by CORS_METHODS")
+	fs.StringVar(&c.CORSHeaders, "cors-headers", envOr("CORS_HEADERS", defaultCORSHeaders), "comma separated request headers the -cors-origins may send, also set by CORS_HEADERS")
+	fs.StringVar(&c.AdminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "token the /admin routes must be called with, as an Authorization: Bearer header; they are not served without one, also set by ADMIN_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if *configFile != "" {
+		if err := configfile.Apply(fs, *configFile); err != nil {
+			return c, err
+		}
+	}
+	return c, c.validate()
+}
+
+// validate returns the first problem found with the settings
+func (c Config) validate() error {
+	if c.DownstreamGRPC != "" && c.NATSURL != "" {
+		return errors.New("-downstream-grpc and -nats-url cannot both be set")
+	}
+	if c.DownstreamSRV != "" && (c.DownstreamGRPC != "" || c.NATSURL != "") {
+		return errors.New("-downstream-srv only applies to forwarding over HTTP")
+	}
+	if c.DiscoveryInterval <= 0 {
+		return errors.New("-discovery-interval must be positive")
+	}
+	if c.ForwardTimeout <= 0 {
+		return errors.New("-forward-timeout must be positive")
+	}
+	return validateURL(c.DownstreamURL)
+}
+
+// reloader applies the configs read on SIGHUP to the running server. The
+// log level, and the downstream URL and forward timeout of the values
+// forwarded from then on, change straight away; the other settings take
+// effect on restart.
+type reloader struct {
+	current   Config
+	level     *slog.LevelVar
+	forwarder Sender
+}
+
+// apply changes the running server to the config, logging the settings
+// changed. Only the settings the forwarder in use takes are applied and
+// kept as current, so the others are logged as pending on every reload
+// until the restart.
+func (r *reloader) apply(next Config) {
+	old := r.current
+	r.level.Set(next.LogLevel)
+	r.current.LogLevel = next.LogLevel
+	switch f := r.forwarder.(type) {
+	case *Forwarder:
+		f.Configure(next.DownstreamURL, next.ForwardTimeout)
+		r.current.DownstreamURL = next.DownstreamURL
+		r.current.ForwardTimeout = next.ForwardTimeout
+	case *GRPCForwarder:
+		f.SetTimeout(next.ForwardTimeout)
+		r.current.ForwardTimeout = next.ForwardTimeout
+	}
+
+	slog.Info("reloaded config", "applied", configfile.Changed(old, r.current))
+	if pending := configfile.Changed(r.current, next); len(pending) > 0 {
+		slog.Warn("settings changed that take effect on restart", "settings", pending)
+	}
+}
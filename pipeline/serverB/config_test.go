@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"shared/configfile"
+)
+
+// writeConfigFile writes the JSON settings to a file, returning its name
+func writeConfigFile(t *testing.T, settings string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseConfigPrecedence(t *testing.T) {
+	t.Setenv("DOWNSTREAM_URL", "http://env:15000/post")
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("LISTEN_ADDR", ":9100")
+	file := writeConfigFile(t, `{"downstream-url": "http://file:15000/post", "log-level": "debug", "forward-timeout": "2s"}`)
+
+	config, err := parseConfig([]string{"-config", file, "-log-level", "error"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The command line beats the file, which beats the environment
+	if config.LogLevel != slog.LevelError {
+		t.Errorf("got log level %v, want %v", config.LogLevel, slog.LevelError)
+	}
+	if want := "http://file:15000/post"; config.DownstreamURL != want {
+		t.Errorf("got downstream url %q, want %q", config.DownstreamURL, want)
+	}
+	if config.ForwardTimeout != 2*time.Second {
+		t.Errorf("got forward timeout %v, want 2s", config.ForwardTimeout)
+	}
+	if config.ListenAddr != ":9100" {
+		t.Errorf("got listen addr %q, want %q", config.ListenAddr, ":9100")
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		settings string
+		args     []string
+	}{
+		{"unknown setting", `{"downstream-urls": "http://localhost:15000/post"}`, nil},
+		{"config in the file", `{"config": "other.json"}`, nil},
+		{"not JSON", `downstream-url: http://localhost:15000/post`, nil},
+		{"bad value", `{"forward-timeout": "soon"}`, nil},
+		{"bad URL", `{"downstream-url": "localhost:15000"}`, nil},
+		{"both transports", `{"downstream-grpc": "localhost:15001"}`, []string{"-nats-url", "nats://localhost:4222"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			args := append([]string{"-config", writeConfigFile(t, tc.settings)}, tc.args...)
+			if _, err := parseConfig(args, io.Discard); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
+
+func TestChangedSettings(t *testing.T) {
+	old := Config{DownstreamURL: "http://a/post", ForwardTimeout: time.Second, ListenAddr: ":9000"}
+	next := old
+	next.DownstreamURL = "http://b/post"
+	next.ListenAddr = ":9100"
+
+	want := []string{"listen-addr", "downstream-url"}
+	if got := configfile.Changed(old, next); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestReloaderApply(t *testing.T) {
+	config := Config{DownstreamURL: "http://a/post", ForwardTimeout: time.Second, ListenAddr: ":9000"}
+	var level slog.LevelVar
+	f := NewForwarder(config.DownstreamURL, nil, config.ForwardTimeout)
+	r := &reloader{current: config, level: &level, forwarder: f}
+
+	next := config
+	next.DownstreamURL = "http://b/post"
+	next.ForwardTimeout = 3 * time.Second
+	next.LogLevel = slog.LevelDebug
+	next.ListenAddr = ":9100"
+	r.apply(next)
+
+	if url, timeout := f.settings(); url != next.DownstreamURL || timeout != next.ForwardTimeout {
+		t.Errorf("got forwarder %q %v, want %q %v", url, timeout, next.DownstreamURL, next.ForwardTimeout)
+	}
+	if level.Level() != slog.LevelDebug {
+		t.Errorf("got log level %v, want %v", level.Level(), slog.LevelDebug)
+	}
+	// Settings taking effect on restart are still pending on the next
+	// reload
+	if want := []string{"listen-addr"}; !reflect.DeepEqual(configfile.Changed(r.current, next), want) {
+		t.Errorf("got %v pending, want %v", configfile.Changed(r.current, next), want)
+	}
+}
+
+// The gRPC forwarder has no URL, so a changed one is not applied
+func TestReloaderApplyGRPC(t *testing.T) {
+	config := Config{DownstreamURL: "http://a/post", ForwardTimeout: time.Second}
+	var level slog.LevelVar
+	f := NewGRPCForwarder(nil, config.ForwardTimeout)
+	r := &reloader{current: config, level: &level, forwarder: f}
+
+	next := config
+	next.DownstreamURL = "http://b/post"
+	next.ForwardTimeout = 3 * time.Second
+	r.apply(next)
+
+	if timeout := time.Duration(f.timeout); timeout != next.ForwardTimeout {
+		t.Errorf("got timeout %v, want %v", timeout, next.ForwardTimeout)
+	}
+	if want := []string{"downstream-url"}; !reflect.DeepEqual(configfile.Changed(r.current, next), want) {
+		t.Errorf("got %v pending, want %v", configfile.Changed(r.current, next), want)
+	}
+}
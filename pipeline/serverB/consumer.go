@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"shared/natsqueue"
+)
+
+// consume handles a value from serviceA sent over the queue, as /post
+// does. A message that cannot be decoded is rejected; one that could not
+// be forwarded is redelivered, so the value may be recorded more than once.
+func (sm *GlobalVarManager) consume(ctx context.Context, data []byte) error {
+	var msg natsqueue.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return natsqueue.Reject(fmt.Errorf("invalid JSON body: %v", err))
+	}
+	_, _, err := sm.receive(ctx, msg.ServiceName, msg.Value)
+	return err
+}
+
+// QueueForwarder sends values on to serverC over the queue
+type QueueForwarder struct {
+	queue *natsqueue.Queue
+}
+
+// Forward publishes the value for serverC, with the request ID carried by
+// ctx. It returns once the value is stored in the stream, rather than
+// once serverC has handled it, so there is no request ID from serverC to
+// return.
+func (f *QueueForwarder) Forward(ctx context.Context, value int) (string, error) {
+	return "", f.queue.Publish(context.WithoutCancel(ctx), natsqueue.SubjectServerC, "serverB", value)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"shared/natsqueue"
+)
+
+func TestConsume(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		data         string
+		forwardErr   error
+		wantRejected bool
+		wantErr      bool
+		wantValues   int
+	}{
+		{"forwarded", `{"serviceName":"serviceA","value":8}`, nil, false, false, 1},
+		{"not forwarded", `{"serviceName":"serviceA","value":8}`, errors.New("serverC is down"), false, true, 1},
+		{"invalid JSON", `{"serviceName":`, nil, true, true, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			forwarder := &testSender{err: tc.forwardErr}
+			gm := NewGlobalVarManager(forwarder)
+
+			err := gm.consume(context.Background(), []byte(tc.data))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			var rejected natsqueue.RejectedError
+			if errors.As(err, &rejected) != tc.wantRejected {
+				t.Errorf("got error %v, want rejected %v", err, tc.wantRejected)
+			}
+			if got := len(gm.list()); got != tc.wantValues {
+				t.Errorf("got %v values recorded, want %v", got, tc.wantValues)
+			}
+			if tc.wantValues > 0 && (len(forwarder.values) != 1 || forwarder.values[0] != 108) {
+				t.Errorf("got %v forwarded, want 108", forwarder.values)
+			}
+		})
+	}
+}
+
+// testSender records the values forwarded to it, acknowledging them with
+// requestID or failing with err
+type testSender struct {
+	requestID string
+	err       error
+	values    []int
+}
+
+func (s *testSender) Forward(ctx context.Context, value int) (string, error) {
+	s.values = append(s.values, value)
+	return s.requestID, s.err
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"shared/circuit"
+	"shared/httpserver"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 2 * time.Second
+	defaultForwardTimeout = 5 * time.Second
+	// deadlineBudget is the share of the time left before the caller's
+	// deadline that forwarding a value may take, leaving the rest for
+	// serverB to respond before the caller, or its own timeouts, give up
+	deadlineBudget = 0.8
+)
+
+// Tuning of the connections to serverC. Every value goes to the same host, so enough
+// connections are kept idle to reuse one for each post rather than
+// dialling again, and each stage of an attempt is bounded so a hung one is
+// retried well within the forward timeout.
+const (
+	forwardMaxIdleConns          = 32
+	forwardIdleConnTimeout       = 90 * time.Second
+	forwardDialTimeout           = 2 * time.Second
+	forwardTLSHandshakeTimeout   = 2 * time.Second
+	forwardResponseHeaderTimeout = 3 * time.Second
+)
+
+// Sender passes values on to serverC, returning the request ID serverC
+// acknowledged the value with, or "" if the value was sent on without
+// serverC answering
+type Sender interface {
+	Forward(ctx context.Context, value int) (string, error)
+}
+
+// Forwarder posts values on to serverC. Failed posts are retried with
+// exponential backoff until the deadline, so a brief outage or redeploy of
+// serverC does not lose values.
+type Forwarder struct {
+	client *http.Client
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu  sync.Mutex // protects the fields below, which are shared by the handlers
+	url string
+	// timeout is how long to keep trying a value before giving up
+	timeout time.Duration
+	rnd     *rand.Rand
+}
+
+// newForwardTransport returns the transport for posts to serverC, using
+// the TLS config if it is not nil. It is shared by every post, so their
+// connections are pooled.
+func newForwardTransport(config *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	transport.DialContext = (&net.Dialer{
+		Timeout:   forwardDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.MaxIdleConns = forwardMaxIdleConns
+	transport.MaxIdleConnsPerHost = forwardMaxIdleConns
+	transport.IdleConnTimeout = forwardIdleConnTimeout
+	transport.TLSHandshakeTimeout = forwardTLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = forwardResponseHeaderTimeout
+	return transport
+}
+
+// NewForwarder creates a forwarder posting to url through the transport,
+// which is http.DefaultTransport if nil. Its client is used for every
+// value, so connections to serverC are reused.
+func NewForwarder(url string, transport http.RoundTripper, timeout time.Duration) *Forwarder {
+	return &Forwarder{
+		url:            url,
+		client:         &http.Client{Transport: transport},
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+		timeout:        timeout,
+		rnd:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Forward posts the value to serverC, retrying until it is accepted or the
+// timeout has passed. Connection errors and 5xx responses are retried;
+// any other response is returned as an error straight away, as sending
+// the same value again would not change it. An open circuit breaker also
+// fails straight away, as serverC is known to be down.
+//
+// The request ID carried by ctx is sent on to serverC, as is its
+// idempotency key, or a new one if it has none, so serverC handles the
+// value once however many times it is sent. Cancelling ctx stops the value
+// being forwarded, and if ctx has a deadline the timeout is cut to fit
+// within it, as given by forwardBudget. The request ID returned is the one
+// serverC responded with.
+func (f *Forwarder) Forward(ctx context.Context, value int) (string, error) {
+	url, timeout := f.settings()
+	ctx, cancel := context.WithTimeout(ctx, forwardBudget(ctx, timeout))
+	defer cancel()
+	if _, ok := httpserver.IdempotencyKeyFrom(ctx); !ok {
+		ctx = httpserver.WithIdempotencyKey(ctx, httpserver.NewRequestID())
+	}
+
+	body, err := json.Marshal(&Service{
+		ServiceName: "serverB",
+		Value:       value,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 0; ; attempt++ {
+		slog.InfoContext(ctx, "sending value", "value", value, "attempt", attempt+1)
+
+		requestID, retry, err := f.post(ctx, url, body)
+		if err == nil || !retry {
+			return requestID, err
+		}
+
+		wait := f.backoff(attempt)
+		slog.WarnContext(ctx, "forwarding failed, retrying", "wait", wait, "err", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", fmt.Errorf("gave up after %d attempts: %v", attempt+1, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// forwardBudget returns how long to keep trying a value: the timeout, or
+// deadlineBudget of the time left before the deadline of ctx if that is
+// sooner, so a slow serverC cannot hold a request past its own deadline
+func forwardBudget(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	if budget := time.Duration(float64(time.Until(deadline)) * deadlineBudget); budget < timeout {
+		slog.DebugContext(ctx, "forward timeout cut to the deadline budget", "timeout", timeout, "budget", budget)
+		return budget
+	}
+	return timeout
+}
+
+// URL returns the URL values are posted to
+func (f *Forwarder) URL() string {
+	url, _ := f.settings()
+	return url
+}
+
+// Configure changes the URL and timeout of the values forwarded from now
+// on. Values being forwarded keep the ones they started with.
+func (f *Forwarder) Configure(url string, timeout time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.url = url
+	f.timeout = timeout
+}
+
+func (f *Forwarder) settings() (string, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.url, f.timeout
+}
+
+// post makes a single attempt at posting the body to url, returning the
+// request ID serverC responded with, or reporting whether a failure is
+// worth retrying
+func (f *Forwarder) post(ctx context.Context, url string, body []byte) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "serverB")
+	if requestID, ok := httpserver.RequestIDFrom(ctx); ok {
+		req.Header.Set("X-Request-Id", requestID)
+	}
+	if idempotencyKey, ok := httpserver.IdempotencyKeyFrom(ctx); ok {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := f.client.Do(req)
+	if errors.Is(err, circuit.ErrOpen) {
+		return "", false, err
+	}
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+
+	// The body is read to the end, so the connection can be reused
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	slog.InfoContext(ctx, "serverC responded", "status", resp.Status, "body", string(respBody))
+
+	switch {
+	case resp.StatusCode >= 500:
+		return "", true, fmt.Errorf("serverC responded %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return "", false, fmt.Errorf("serverC responded %s", resp.Status)
+	default:
+		return acknowledgedID(resp.Header, respBody), false, nil
+	}
+}
+
+// acknowledgedID returns the request ID serverC stored a value with: the
+// requestId of a /v2/post body, or else the X-Request-Id header, which
+// serverC sets on every response
+func acknowledgedID(header http.Header, body []byte) string {
+	var posted struct {
+		RequestID string `json:"requestId"`
+	}
+	if json.Unmarshal(body, &posted) == nil && posted.RequestID != "" {
+		return posted.RequestID
+	}
+	return header.Get("X-Request-Id")
+}
+
+// backoff returns how long to wait before the retry following the given
+// attempt. The wait is picked at random up to a ceiling that doubles with
+// each attempt ("full jitter"), so servers retrying together spread out
+// rather than hitting serverC in step.
+func (f *Forwarder) backoff(attempt int) time.Duration {
+	ceiling := f.maxBackoff
+	if attempt < 30 && f.initialBackoff<<uint(attempt) < ceiling {
+		ceiling = f.initialBackoff << uint(attempt)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Duration(f.rnd.Int63n(int64(ceiling) + 1))
+}
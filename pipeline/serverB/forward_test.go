@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"shared/httpserver"
+)
+
+// newTestServer returns a serverC that responds with the given statuses in
+// turn, then 200, counting the requests it receives
+func newTestServer(statuses ...int) (*httptest.Server, *int32) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if int(n) <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	return server, &requests
+}
+
+func newTestForwarder(url string, timeout time.Duration) *Forwarder {
+	f := NewForwarder(url, nil, timeout)
+	f.initialBackoff = time.Millisecond
+	f.maxBackoff = 5 * time.Millisecond
+	return f
+}
+
+func TestForward(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{"accepted", nil, false, 1},
+		{"recovers", []int{http.StatusServiceUnavailable, http.StatusBadGateway}, false, 3},
+		{"not retried", []int{http.StatusBadRequest}, true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server, requests := newTestServer(tc.statuses...)
+			defer server.Close()
+
+			_, err := newTestForwarder(server.URL, time.Second).Forward(context.Background(), 108)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if got := atomic.LoadInt32(requests); got != tc.wantRequests {
+				t.Errorf("got %v requests, want %v", got, tc.wantRequests)
+			}
+		})
+	}
+}
+
+func TestForwardRequestID(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("X-Request-Id")
+	}))
+	defer server.Close()
+
+	ctx := httpserver.WithRequestID(context.Background(), "abc")
+	if _, err := newTestForwarder(server.URL, time.Second).Forward(ctx, 108); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if requestID := <-got; requestID != "abc" {
+		t.Errorf("got request ID %q, want %q", requestID, "abc")
+	}
+}
+
+// The request ID returned is the one serverC responded with, from the body
+// of /v2/post or else the X-Request-Id header
+func TestForwardAcknowledgedID(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"v1", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", "fromC")
+			fmt.Fprint(w, "POST done")
+		}, "fromC"},
+		{"v2", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"serviceName":"serverB","value":108,"requestId":"fromC"}`)
+		}, "fromC"},
+		{"none", func(w http.ResponseWriter, r *http.Request) {}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			server := httptest.NewServer(tc.handler)
+			defer server.Close()
+
+			ctx := httpserver.WithRequestID(context.Background(), "abc")
+			requestID, err := newTestForwarder(server.URL, time.Second).Forward(ctx, 108)
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if requestID != tc.want {
+				t.Errorf("got request ID %q, want %q", requestID, tc.want)
+			}
+		})
+	}
+}
+
+// The body is declared as JSON, so it is accepted by serverC's /v2/post
+// as well as /v1/post
+func TestForwardContentType(t *testing.T) {
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get("Content-Type")
+	}))
+	defer server.Close()
+
+	if _, err := newTestForwarder(server.URL, time.Second).Forward(context.Background(), 108); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if contentType := <-got; contentType != "application/json" {
+		t.Errorf("got Content-Type %q, want %q", contentType, "application/json")
+	}
+}
+
+// Values are forwarded over the same connection, rather than each dialling
+// a new one
+func TestForwardReusesConnections(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("POST done"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	f := NewForwarder(server.URL, newForwardTransport(nil), time.Second)
+	for value := 0; value < 10; value++ {
+		if _, err := f.Forward(context.Background(), value); err != nil {
+			t.Fatalf("got error %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns); got != 1 {
+		t.Errorf("got %d connections, want 1", got)
+	}
+}
+
+func TestForwardIdempotencyKey(t *testing.T) {
+	testCases := []struct {
+		desc string
+		key  string
+	}{
+		{"passed on", "abc"},
+		{"made up", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var keys []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				keys = append(keys, r.Header.Get("Idempotency-Key"))
+				if len(keys) < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer server.Close()
+
+			ctx := context.Background()
+			if tc.key != "" {
+				ctx = httpserver.WithIdempotencyKey(ctx, tc.key)
+			}
+			if _, err := newTestForwarder(server.URL, time.Second).Forward(ctx, 108); err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			// Every retry carries the same key
+			if len(keys) != 3 || keys[0] == "" || keys[1] != keys[0] || keys[2] != keys[0] {
+				t.Fatalf("got keys %q, want the same key on each of 3 attempts", keys)
+			}
+			if tc.key != "" && keys[0] != tc.key {
+				t.Errorf("got key %q, want %q", keys[0], tc.key)
+			}
+		})
+	}
+}
+
+func TestForwardTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	propagator := propagation.TraceContext{}
+
+	got := make(chan trace.SpanContext, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		got <- trace.SpanContextFromContext(ctx)
+	}))
+	defer server.Close()
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "post")
+	transport := otelhttp.NewTransport(nil,
+		otelhttp.WithTracerProvider(provider),
+		otelhttp.WithPropagators(propagator),
+	)
+	f := newTestForwarder(server.URL, time.Second)
+	f.client.Transport = transport
+	if _, err := f.Forward(ctx, 108); err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	span.End()
+
+	remote := <-got
+	if remote.TraceID() != span.SpanContext().TraceID() {
+		t.Errorf("got trace %v at serverC, want %v", remote.TraceID(), span.SpanContext().TraceID())
+	}
+	if spans := recorder.Ended(); len(spans) != 2 {
+		t.Errorf("got %d spans, want the post and forward spans", len(spans))
+	}
+}
+
+func TestForwardGivesUp(t *testing.T) {
+	// Nothing is listening once the server is closed
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	start := time.Now()
+	_, err := newTestForwarder(server.URL, 50*time.Millisecond).Forward(context.Background(), 108)
+	if err == nil {
+		t.Fatal("got no error forwarding to a closed server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("took %v to give up, want about the timeout", elapsed)
+	}
+}
+
+// A slow serverC is given up on within the deadline of the request the
+// value came in on, rather than the longer forward timeout
+func TestForwardDeadlineBudget(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := newTestForwarder(server.URL, 5*time.Second).Forward(ctx, 108); err == nil {
+		t.Fatal("got no error from a serverC slower than the deadline")
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("gave up after %v, want within the deadline of 100ms", elapsed)
+	}
+
+	// Without a deadline the forward timeout is kept
+	if got := forwardBudget(context.Background(), 5*time.Second); got != 5*time.Second {
+		t.Errorf("got budget %v with no deadline, want the timeout of 5s", got)
+	}
+}
+
+func TestForwardCancelled(t *testing.T) {
+	server, requests := newTestServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newTestForwarder(server.URL, time.Second).Forward(ctx, 108); err == nil {
+		t.Error("got no error forwarding for a cancelled request")
+	}
+	if got := atomic.LoadInt32(requests); got != 0 {
+		t.Errorf("got %v requests, want none once the caller gave up", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	f := NewForwarder("", nil, time.Second)
+	for attempt := 0; attempt < 64; attempt++ {
+		ceiling := f.maxBackoff
+		if attempt < 5 {
+			ceiling = f.initialBackoff << uint(attempt)
+		}
+		if got := f.backoff(attempt); got < 0 || got > ceiling {
+			t.Errorf("attempt %v: got %v, want up to %v", attempt, got, ceiling)
+		}
+	}
+}
+
+func TestPostBadGateway(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	gm := NewGlobalVarManager(newTestForwarder(server.URL, 20*time.Millisecond))
+
+	body, _ := json.Marshal(Service{ServiceName: "serviceA", Value: 8})
+	response := httptest.NewRecorder()
+	gm.postCall(response, httptest.NewRequest(http.MethodPost, "/post", bytes.NewReader(body)))
+
+	if response.Code != http.StatusBadGateway {
+		t.Errorf("got status %v, want %v", response.Code, http.StatusBadGateway)
+	}
+	var got struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Error == "" {
+		t.Errorf("got body error %q (%v), want a JSON error", got.Error, err)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"serverb/pipelinepb"
+	"shared/circuit"
+	"shared/configfile"
+	"shared/discovery"
+	"shared/health"
+	"shared/httpserver"
+	"shared/jsonschema"
+	"shared/logging"
+	"shared/natsqueue"
+	"shared/openapi"
+	"shared/rpc"
+	"shared/telemetry"
+	"shared/tlsconfig"
+)
+
+// Service struct
+type Service struct {
+	ServiceName string `json:"serviceName"`
+	Value       int    `json:"value"`
+}
+
+// Value struct
+type Value struct {
+	Timestamp   string `json:"timestamp"`
+	ServiceName string `json:"serviceName"`
+	Value       int    `json:"value"`
+}
+
+type GlobalVarManager struct {
+	forwarder Sender
+	// inflight tracks the values being forwarded, so shutdown can wait
+	// for them, and forwarding counts them
+	inflight   sync.WaitGroup
+	forwarding int32
+
+	mu     sync.RWMutex // protects the fields below
+	values []Value
+}
+
+func NewGlobalVarManager(forwarder Sender) *GlobalVarManager {
+	return &GlobalVarManager{
+		forwarder: forwarder,
+		values:    make([]Value, 0),
+	}
+}
+
+const (
+	defaultDownstreamURL string = "http://localhost:15000/post"
+	defaultListenAddr    string = "0.0.0.0:9000"
+)
+
+func main() {
+	var err error
+	config, err := parseConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	var level slog.LevelVar
+	level.Set(config.LogLevel)
+	logger := logging.New(os.Stdout, &level)
+	slog.SetDefault(logger)
+	if err != nil {
+		logger.Error("invalid config", "err", err)
+		os.Exit(1)
+	}
+
+	serverTLS, serverCert, err := tlsconfig.Server(config.TLSCert, config.TLSKey, config.TLSClientCA)
+	if err != nil {
+		logger.Error("invalid server TLS", "err", err)
+		os.Exit(1)
+	}
+	clientTLS, clientCert, err := tlsconfig.Client(config.DownstreamCert, config.DownstreamKey, config.DownstreamCA)
+	if err != nil {
+		logger.Error("invalid downstream TLS", "err", err)
+		os.Exit(1)
+	}
+	tlsconfig.ReloadOnSIGHUP(serverCert, clientCert)
+
+	logger.Info("server is starting")
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "serverB")
+	if err != nil {
+		logger.Error("could not set up tracing", "err", err)
+		os.Exit(1)
+	}
+
+	// Values are forwarded over the queue or gRPC if either is given, and
+	// HTTP otherwise. The breaker only applies to gRPC and HTTP, as the
+	// queue holds values while serverC is down.
+	var (
+		breaker         *circuit.Breaker
+		forwarder       Sender
+		downstreamCheck func() error
+		queue           *natsqueue.Queue
+	)
+	if config.NATSURL != "" {
+		logger.Info("forwarding values", "downstream", config.NATSURL, "subject", natsqueue.SubjectServerC)
+		queue, err = natsqueue.Connect(context.Background(), config.NATSURL, "serverB")
+		if err != nil {
+			logger.Error("could not connect to NATS", "url", config.NATSURL, "err", err)
+			os.Exit(1)
+		}
+		defer queue.Close()
+		breaker = circuit.NewBreaker(nil, config.NATSURL, circuit.DefaultFailures, circuit.DefaultCooldown)
+		forwarder = &QueueForwarder{queue: queue}
+		downstreamCheck = queue.Check
+	} else if config.DownstreamGRPC != "" {
+		logger.Info("forwarding values", "downstream", config.DownstreamGRPC, "grpc", true)
+		breaker = circuit.NewBreaker(nil, config.DownstreamGRPC, circuit.DefaultFailures, circuit.DefaultCooldown)
+		conn, err := rpc.Dial(config.DownstreamGRPC, clientTLS, breaker.UnaryClientInterceptor())
+		if err != nil {
+			logger.Error("invalid downstream gRPC address", "err", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		forwarder = NewGRPCForwarder(conn, config.ForwardTimeout)
+		downstreamCheck = rpc.CheckHealth(conn)
+	} else {
+		logger.Info("forwarding values", "downstream", config.DownstreamURL, "srv", config.DownstreamSRV)
+		var transport http.RoundTripper = newForwardTransport(clientTLS)
+		// With a SRV record, the values fail over between the serverC
+		// instances it lists, and the breaker only opens once none can
+		// be reached
+		if config.DownstreamSRV != "" {
+			resolver := discovery.NewResolver(transport, config.DownstreamSRV, config.DiscoveryInterval)
+			if err := resolver.Refresh(context.Background()); err != nil {
+				logger.Error("could not discover serverC", "err", err)
+				os.Exit(1)
+			}
+			defer resolver.Start()()
+			transport = resolver
+		}
+		breaker = circuit.NewBreaker(transport, config.DownstreamURL, circuit.DefaultFailures, circuit.DefaultCooldown)
+		f := NewForwarder(config.DownstreamURL, telemetry.Transport(breaker), config.ForwardTimeout)
+		forwarder = f
+		// The URL is looked up on each check, as it changes on reload
+		downstreamCheck = func() error { return health.CheckHealthz(f.URL(), transport)() }
+	}
+	reload := &reloader{current: config, level: &level, forwarder: forwarder}
+	configfile.ReloadOnSIGHUP(os.Args[1:], parseConfig, reload.apply)
+	gm := NewGlobalVarManager(forwarder)
+	corsConfig := httpserver.NewCORSConfig(config.CORSOrigins, config.CORSMethods, config.CORSHeaders, corsExposedHeaders)
+
+	// Values from serviceA are consumed from the queue as well as posted
+	stopConsuming := func() {}
+	if queue != nil {
+		stopConsuming, err = queue.Consume(context.Background(), "serverB", natsqueue.SubjectServerB, logger, gm.consume)
+		if err != nil {
+			logger.Error("could not consume values", "subject", natsqueue.SubjectServerB, "err", err)
+			os.Exit(1)
+		}
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/", httpserver.Index("This is Server B!"))
+	v1 := gm.apiV1()
+	mountAPI(router, "", v1)
+	mountAPI(router, "/v1", v1)
+	mountAPI(router, "/v2", gm.apiV2())
+	router.HandleFunc("/openapi.json", openapi.Serve(apiSpec()))
+	router.HandleFunc("/docs", openapi.SwaggerUI("serverB API", "/openapi.json"))
+	router.HandleFunc("/status", breaker.ServeStatus)
+	router.HandleFunc("/healthz", health.Healthz)
+	router.HandleFunc("/readyz", health.Readyz(health.Check{Name: "downstream", Check: downstreamCheck}))
+
+	// Failures can be injected into the requests at /admin/chaos, to show
+	// the retries and circuit breakers of the callers working, and the
+	// health checks failed at /admin/health, to drill draining an instance.
+	// /admin/vars serves the panics recovered along with the runtime's
+	// memory statistics.
+	chaos := httpserver.NewChaos()
+	if config.AdminToken != "" {
+		admin := httpserver.Admin(config.AdminToken)
+		router.Handle("/admin/chaos", admin(chaos))
+		router.Handle("/admin/health", admin(http.HandlerFunc(health.Admin)))
+		router.Handle("/admin/vars", admin(expvar.Handler()))
+	}
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if err != nil {
+			logger.Error("error encountered", "err", err)
+			os.Exit(1)
+		} else {
+			logger.Info("exiting")
+		}
+	}()
+
+	server := httpserver.NewServer(httpserver.Options{
+		Addr: config.ListenAddr,
+		Handler: httpserver.Chain(router,
+			telemetry.Middleware("serverB"),
+			httpserver.RequestID(httpserver.NewRequestID),
+			httpserver.Logging(logger),
+			httpserver.Recover(logger),
+			chaos.Middleware(),
+			httpserver.CORS(corsConfig),
+		),
+		TLSConfig:  serverTLS,
+		Logger:     logger,
+		DrainDelay: config.DrainDelay,
+	})
+
+	// Failing /readyz first lets load balancers move traffic away while
+	// requests are still served
+	server.OnDrain(func() { health.SetHealthy(false) })
+	server.BeforeShutdown(func(context.Context) { stopConsuming() })
+
+	// The gRPC pipeline shares the HTTP server's TLS config and is stopped
+	// along with it
+	if config.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			logger.Error("could not listen", "addr", config.GRPCAddr, "err", err)
+			os.Exit(1)
+		}
+		grpcServer, grpcHealth := rpc.NewServer(logger, serverTLS)
+		pipelinepb.RegisterPipelineServer(grpcServer, &pipelineServer{gm: gm})
+		go func() {
+			logger.Info("serving gRPC", "addr", config.GRPCAddr)
+			if err := grpcServer.Serve(listener); err != nil {
+				logger.Error("could not serve gRPC", "addr", config.GRPCAddr, "err", err)
+				os.Exit(1)
+			}
+		}()
+		server.OnDrain(grpcHealth.Shutdown)
+		server.BeforeShutdown(func(ctx context.Context) { rpc.Stop(ctx, grpcServer) })
+	}
+
+	// Values consumed from the queue may still be being forwarded, as
+	// stopping the consumer does not wait for them
+	server.AfterShutdown(func(ctx context.Context) {
+		if n := gm.drain(ctx); n > 0 {
+			logger.Warn("gave up waiting for forwards to serverC", "in_flight", n)
+		} else {
+			logger.Info("forwards to serverC drained")
+		}
+	})
+
+	health.SetHealthy(true)
+	if err := server.Run(context.Background()); err != nil {
+		logger.Error("server failed", "addr", config.ListenAddr, "err", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("could not flush traces", "err", err)
+	}
+	logger.Info("server stopped")
+}
+
+// postCall handles the /post route
+func (sm *GlobalVarManager) postCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if httpserver.BodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", maxPostBytes))
+			return
+		} else if err != nil {
+			http.Error(w, "Error reading request body",
+				http.StatusInternalServerError)
+			return
+		}
+		servicea := Service{}
+		if err := json.Unmarshal(body, &servicea); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		_, downstreamID, err := sm.receive(r.Context(), servicea.ServiceName, servicea.Value)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		setDownstreamRequestID(w, downstreamID)
+		fmt.Fprint(w, "POST done")
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// setDownstreamRequestID passes on the request ID serverC acknowledged a
+// value with in the X-Downstream-Request-Id header, so the sender can tell
+// the value reached serverC. X-Request-Id cannot be used, as it echoes the
+// ID the request arrived with.
+func setDownstreamRequestID(w http.ResponseWriter, requestID string) {
+	if requestID != "" {
+		w.Header().Set("X-Downstream-Request-Id", requestID)
+	}
+}
+
+// receive records a value from serviceA and forwards it on to serverC,
+// whether it arrived over HTTP or gRPC. It returns the value recorded and
+// the request ID serverC acknowledged it with, if known.
+func (sm *GlobalVarManager) receive(ctx context.Context, serviceName string, value int) (Value, string, error) {
+	slog.InfoContext(ctx, "received value", "service_name", serviceName, "value", value)
+	v := Value{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		ServiceName: serviceName,
+		Value:       value,
+	}
+	sm.add(v)
+
+	// Send integer value to serverC
+	downstreamID, err := sm.forward(ctx, value+100)
+	if err != nil {
+		return v, "", fmt.Errorf("forwarding to serverC: %v", err)
+	}
+	return v, downstreamID, nil
+}
+
+// forward passes the value on to serverC, tracking it until it has been
+// sent or given up on
+func (sm *GlobalVarManager) forward(ctx context.Context, value int) (string, error) {
+	sm.inflight.Add(1)
+	atomic.AddInt32(&sm.forwarding, 1)
+	defer func() {
+		atomic.AddInt32(&sm.forwarding, -1)
+		sm.inflight.Done()
+	}()
+	return sm.forwarder.Forward(ctx, value)
+}
+
+// drain waits for the values being forwarded, returning how many were
+// still in flight when ctx was done. Nothing new must be received once
+// draining starts.
+func (sm *GlobalVarManager) drain(ctx context.Context) int {
+	drained := make(chan struct{})
+	go func() {
+		sm.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return 0
+	case <-ctx.Done():
+		return int(atomic.LoadInt32(&sm.forwarding))
+	}
+}
+
+// add records a received value. The lock is only held while appending,
+// so /get is not held up by forwarding to serverC.
+func (sm *GlobalVarManager) add(v Value) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.values = append(sm.values, v)
+}
+
+// list returns a copy of the values received so far
+func (sm *GlobalVarManager) list() []Value {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	values := make([]Value, len(sm.values))
+	copy(values, sm.values)
+	return values
+}
+
+// getCall handles the /get route, listing the values received so far
+func (sm *GlobalVarManager) getCall(w http.ResponseWriter, r *http.Request) {
+	jsonVal, err := json.Marshal(sm.list())
+	if err != nil {
+		http.Error(w, "Error converting results to json",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(jsonVal)
+	if err != nil {
+		http.Error(w, "Error sending response body", http.StatusInternalServerError)
+	}
+}
+
+// errorResponse is the body of the responses to requests that failed
+type errorResponse struct {
+	Error string `json:"error"`
+	// The fields of a body failing its schema
+	Fields []jsonschema.FieldError `json:"fields,omitempty"`
+}
+
+// writeError responds with the status and a JSON body describing the error
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+// writeRequestError responds with the status and a JSON body describing
+// err, listing the fields at fault if it is a *jsonschema.Error
+func writeRequestError(w http.ResponseWriter, status int, err error) {
+	body := errorResponse{Error: err.Error()}
+	var schemaErr *jsonschema.Error
+	if errors.As(err, &schemaErr) {
+		body.Fields = schemaErr.Fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// envOr returns the value of the environment variable, or def if it is unset
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// validateURL checks that rawURL is an absolute http or https URL
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use http or https", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
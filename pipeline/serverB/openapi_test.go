@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shared/openapi"
+)
+
+func TestAPISpec(t *testing.T) {
+	response := httptest.NewRecorder()
+	openapi.Serve(apiSpec())(response, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if got := response.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("got Content-Type %q, want application/json", got)
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(response.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every route of each version is described
+	gm := NewGlobalVarManager(&testSender{})
+	for prefix, routes := range map[string]map[string]http.Handler{"/v1": gm.apiV1(), "/v2": gm.apiV2()} {
+		for path := range routes {
+			if _, ok := doc.Paths[prefix+path]; !ok {
+				t.Errorf("%v%v is not described", prefix, path)
+			}
+		}
+	}
+
+	// Every reference is to a component
+	var checkRefs func(s *openapi.Schema)
+	checkRefs = func(s *openapi.Schema) {
+		if s == nil {
+			return
+		}
+		if name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/"); ok {
+			if _, ok := doc.Components.Schemas[name]; !ok {
+				t.Errorf("%v is not a component", s.Ref)
+			}
+		}
+		checkRefs(s.Items)
+		for _, property := range s.Properties {
+			checkRefs(property)
+		}
+	}
+	for path, operations := range doc.Paths {
+		for method, op := range operations {
+			if len(op.Responses) == 0 {
+				t.Errorf("%v %v has no responses", method, path)
+			}
+			for _, r := range op.Responses {
+				for _, media := range r.Content {
+					checkRefs(media.Schema)
+				}
+			}
+			if op.RequestBody != nil {
+				for _, media := range op.RequestBody.Content {
+					checkRefs(media.Schema)
+				}
+			}
+		}
+	}
+
+	request := doc.Components.Schemas["postRequest"]
+	if request == nil || !reflect.DeepEqual(request.Required, []string{"serviceName", "value"}) {
+		t.Fatalf("got postRequest %+v, want serviceName and value required", request)
+	}
+	if got := request.Properties["serviceName"].MaxLength; got == nil || *got != maxServiceNameLen {
+		t.Errorf("got serviceName maxLength %v, want %v", got, maxServiceNameLen)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"serverb/pipelinepb"
+	"shared/rpc"
+)
+
+// pipelineServer serves the gRPC variant of the /post route
+type pipelineServer struct {
+	pipelinepb.UnimplementedPipelineServer
+	gm *GlobalVarManager
+}
+
+// Send records the value and forwards it on to serverC. A value that could
+// not be forwarded fails with UNAVAILABLE, as /post responds 502.
+func (s *pipelineServer) Send(ctx context.Context, req *pipelinepb.SendRequest) (*pipelinepb.SendResponse, error) {
+	if _, _, err := s.gm.receive(ctx, req.ServiceName, int(req.Value)); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pipelinepb.SendResponse{}, nil
+}
+
+// GRPCForwarder sends values on to serverC over gRPC. Calls failing with
+// UNAVAILABLE are retried by the connection, with backoff, until the
+// timeout has passed.
+type GRPCForwarder struct {
+	client pipelinepb.PipelineClient
+	// timeout is how long, in nanoseconds, to keep trying a value before
+	// giving up. It is changed by SetTimeout while values are forwarded.
+	timeout int64
+}
+
+// NewGRPCForwarder creates a forwarder calling serverC over conn
+func NewGRPCForwarder(conn *grpc.ClientConn, timeout time.Duration) *GRPCForwarder {
+	return &GRPCForwarder{
+		client:  pipelinepb.NewPipelineClient(conn),
+		timeout: int64(timeout),
+	}
+}
+
+// SetTimeout changes the timeout of the values forwarded from now on
+func (f *GRPCForwarder) SetTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&f.timeout, int64(timeout))
+}
+
+// Forward sends the value to serverC, with the request ID carried by ctx.
+// As with Forwarder, cancelling ctx stops the value being forwarded, and
+// its deadline cuts the timeout. The request ID returned is the one in
+// serverC's trailer.
+func (f *GRPCForwarder) Forward(ctx context.Context, value int) (string, error) {
+	timeout := time.Duration(atomic.LoadInt64(&f.timeout))
+	ctx, cancel := context.WithTimeout(ctx, forwardBudget(ctx, timeout))
+	defer cancel()
+
+	slog.InfoContext(ctx, "sending value", "value", value)
+	var trailer metadata.MD
+	_, err := f.client.Send(ctx, &pipelinepb.SendRequest{
+		ServiceName: "serverB",
+		Value:       int64(value),
+	}, grpc.Trailer(&trailer))
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "serverC accepted value")
+	var requestID string
+	if ids := trailer.Get(rpc.RequestIDMetadata); len(ids) > 0 {
+		requestID = ids[0]
+	}
+	return requestID, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"serverb/pipelinepb"
+	"shared/circuit"
+	"shared/httpserver"
+	"shared/logging"
+	"shared/rpc"
+)
+
+// testPipeline is a serverC failing with the given codes in turn, then
+// accepting values, recording the requests and request IDs it receives
+type testPipeline struct {
+	pipelinepb.UnimplementedPipelineServer
+	codes []codes.Code
+
+	mu         sync.Mutex
+	requests   []*pipelinepb.SendRequest
+	requestIDs []string
+}
+
+func (p *testPipeline) Send(ctx context.Context, req *pipelinepb.SendRequest) (*pipelinepb.SendResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, req)
+	requestID, _ := httpserver.RequestIDFrom(ctx)
+	p.requestIDs = append(p.requestIDs, requestID)
+	if n := len(p.requests); n <= len(p.codes) {
+		return nil, status.Error(p.codes[n-1], "test failure")
+	}
+	return &pipelinepb.SendResponse{}, nil
+}
+
+// serveTestPipeline serves the pipeline on a local port, returning its
+// address
+func serveTestPipeline(t *testing.T, p *testPipeline) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := rpc.NewServer(logging.New(io.Discard, nil), nil)
+	pipelinepb.RegisterPipelineServer(server, p)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String()
+}
+
+func TestGRPCForward(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		codes        []codes.Code
+		wantErr      bool
+		wantRequests int
+	}{
+		{"accepted", nil, false, 1},
+		{"recovers", []codes.Code{codes.Unavailable, codes.Unavailable}, false, 3},
+		{"not retried", []codes.Code{codes.InvalidArgument}, true, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			p := &testPipeline{codes: tc.codes}
+			conn, err := rpc.Dial(serveTestPipeline(t, p), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			ctx := httpserver.WithRequestID(context.Background(), "abc123")
+			requestID, err := NewGRPCForwarder(conn, 5*time.Second).Forward(ctx, 108)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && requestID != "abc123" {
+				t.Errorf("got request ID %q acknowledged, want abc123 from the trailer", requestID)
+			}
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if len(p.requests) != tc.wantRequests {
+				t.Fatalf("got %v requests, want %v", len(p.requests), tc.wantRequests)
+			}
+			for i, req := range p.requests {
+				if req.ServiceName != "serverB" || req.Value != 108 {
+					t.Errorf("got request %v, want serverB sending 108", req)
+				}
+				if p.requestIDs[i] != "abc123" {
+					t.Errorf("got request ID %q, want abc123", p.requestIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerInterceptor(t *testing.T) {
+	b := circuit.NewBreaker(nil, "serverC", 2, time.Hour)
+	interceptor := b.UnaryClientInterceptor()
+
+	var calls int
+	call := func(err error) error {
+		return interceptor(context.Background(), "/pipeline.v1.Pipeline/Send", nil, nil, nil,
+			func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+				calls++
+				return err
+			})
+	}
+
+	// Rejected values are not failures of serverC
+	for i := 0; i < 3; i++ {
+		call(status.Error(codes.InvalidArgument, "bad value"))
+	}
+	if got := b.Status().State; got != "closed" {
+		t.Fatalf("got state %v after rejected values, want closed", got)
+	}
+
+	call(status.Error(codes.Unavailable, "down"))
+	call(status.Error(codes.DeadlineExceeded, "slow"))
+	if got := b.Status().State; got != "open" {
+		t.Fatalf("got state %v after failures, want open", got)
+	}
+	if err := call(nil); !errors.Is(err, circuit.ErrOpen) {
+		t.Errorf("got error %v while open, want ErrOpen", err)
+	}
+	if calls != 5 {
+		t.Errorf("got %v calls, want 5 as the open breaker fails fast", calls)
+	}
+}
+
+// A call without a request ID is given one, which is echoed in the trailer
+func TestGRPCRequestIDGenerated(t *testing.T) {
+	p := &testPipeline{}
+	conn, err := rpc.Dial(serveTestPipeline(t, p), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var trailer metadata.MD
+	client := pipelinepb.NewPipelineClient(conn)
+	if _, err := client.Send(context.Background(), &pipelinepb.SendRequest{ServiceName: "serviceA", Value: 8}, grpc.Trailer(&trailer)); err != nil {
+		t.Fatal(err)
+	}
+	got := trailer.Get(rpc.RequestIDMetadata)
+	if len(got) != 1 || got[0] == "" || got[0] != p.requestIDs[0] {
+		t.Errorf("got request ID trailer %v, want the ID %q serverC saw", got, p.requestIDs[0])
+	}
+}
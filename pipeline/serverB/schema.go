@@ -0,0 +1,16 @@
+package main
+
+import (
+	_ "embed"
+
+	"shared/jsonschema"
+)
+
+// postSchemaJSON is the JSON Schema of the body of a post, the contract
+// with the services posting values. It is checked before the body is
+// decoded, so a client learns every field at fault in one response.
+//
+//go:embed schemas/post.json
+var postSchemaJSON []byte
+
+var postSchema = jsonschema.MustParse(postSchemaJSON)
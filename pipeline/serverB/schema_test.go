@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shared/jsonschema"
+)
+
+// The schema and the limits the OpenAPI description gives must agree
+func TestPostSchemaLimits(t *testing.T) {
+	serviceName, value := postSchema.Properties["serviceName"], postSchema.Properties["value"]
+	if serviceName.MaxLength == nil || *serviceName.MaxLength != maxServiceNameLen {
+		t.Errorf("got serviceName maxLength %v, want %v", serviceName.MaxLength, maxServiceNameLen)
+	}
+	if value.Minimum == nil || *value.Minimum != minValue || value.Maximum == nil || *value.Maximum != maxValue {
+		t.Errorf("got value between %v and %v, want %v and %v", value.Minimum, value.Maximum, minValue, maxValue)
+	}
+}
+
+func TestPostFieldErrors(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		body       string
+		wantStatus int
+		want       []jsonschema.FieldError
+	}{
+		{"out of bounds", `{"serviceName":" ","value":-1000001}`, http.StatusUnprocessableEntity, []jsonschema.FieldError{
+			{Field: "/serviceName", Keyword: "pattern", Message: `must match \S`},
+			{Field: "/value", Keyword: "minimum", Message: "must be at least -1000000"},
+		}},
+		{"wrong types", `{"serviceName":["serviceA"],"value":8.5,"extra":1}`, http.StatusBadRequest, []jsonschema.FieldError{
+			{Field: "/extra", Keyword: "additionalProperties", Message: "is not allowed"},
+			{Field: "/serviceName", Keyword: "type", Message: "must be a string"},
+			{Field: "/value", Keyword: "type", Message: "must be an integer"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			gm := NewGlobalVarManager(&testSender{})
+			request := httptest.NewRequest(http.MethodPost, "/v2/post", strings.NewReader(tc.body))
+			request.Header.Set("Content-Type", "application/json")
+			response := httptest.NewRecorder()
+			gm.postCallV2(response, request)
+
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v: %s", response.Code, tc.wantStatus, response.Body)
+			}
+			var got errorResponse
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Fields, tc.want) {
+				t.Errorf("got %+v, want the fields %+v", got.Fields, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shared/httpserver"
+)
+
+// TestConcurrentPostAndGet exercises the handlers from many goroutines at
+// once, for the race detector: go test -race
+func TestConcurrentPostAndGet(t *testing.T) {
+	server, _ := newTestServer()
+	defer server.Close()
+	gm := NewGlobalVarManager(newTestForwarder(server.URL, time.Second))
+
+	const posts = 50
+	var wg sync.WaitGroup
+	for i := 0; i < posts; i++ {
+		wg.Add(2)
+		go func(value int) {
+			defer wg.Done()
+			body, _ := json.Marshal(Service{ServiceName: "serviceA", Value: value})
+			response := httptest.NewRecorder()
+			gm.postCall(response, httptest.NewRequest(http.MethodPost, "/post", bytes.NewReader(body)))
+			if response.Code != http.StatusOK {
+				t.Errorf("post: got status %v, want %v", response.Code, http.StatusOK)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			response := httptest.NewRecorder()
+			gm.getCall(response, httptest.NewRequest(http.MethodGet, "/get", nil))
+			var values []Value
+			if err := json.NewDecoder(response.Body).Decode(&values); err != nil {
+				t.Errorf("get: decoding: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := len(gm.list()); got != posts {
+		t.Errorf("got %v values, want %v", got, posts)
+	}
+}
+
+func TestPostMalformedBody(t *testing.T) {
+	sender := &testSender{}
+	gm := NewGlobalVarManager(sender)
+
+	response := httptest.NewRecorder()
+	gm.postCall(response, httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(`{"serviceName":`)))
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("got status %v, want %v", response.Code, http.StatusBadRequest)
+	}
+	var got struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Error == "" {
+		t.Errorf("got body error %q (%v), want a JSON error", got.Error, err)
+	}
+	if len(sender.values) != 0 || len(gm.list()) != 0 {
+		t.Errorf("got %v forwarded and %v recorded, want none", sender.values, gm.list())
+	}
+}
+
+// The request ID serverC acknowledged a value with is passed back, apart
+// from the one the request arrived with
+func TestPostDownstreamRequestID(t *testing.T) {
+	gm := NewGlobalVarManager(&testSender{requestID: "fromC"})
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+	}{
+		{"v1", gm.postCall},
+		{"v2", gm.postCallV2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			handler := httpserver.Chain(tc.handler, httpserver.RequestID(httpserver.NewRequestID))
+			request := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(`{"serviceName":"serviceA","value":8}`))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("X-Request-Id", "abc")
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %v, want %v", response.Code, http.StatusOK)
+			}
+			if got := response.Header().Get("X-Downstream-Request-Id"); got != "fromC" {
+				t.Errorf("got X-Downstream-Request-Id %q, want %q", got, "fromC")
+			}
+			if got := response.Header().Get("X-Request-Id"); got != "abc" {
+				t.Errorf("got X-Request-Id %q, want %q", got, "abc")
+			}
+		})
+	}
+}
+
+// blockingSender holds each value until released
+type blockingSender struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingSender) Forward(ctx context.Context, value int) (string, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return "", nil
+}
+
+func TestDrain(t *testing.T) {
+	forwarder := &blockingSender{started: make(chan struct{}), release: make(chan struct{})}
+	gm := NewGlobalVarManager(forwarder)
+
+	received := make(chan error)
+	go func() {
+		_, _, err := gm.receive(context.Background(), "serviceA", 8)
+		received <- err
+	}()
+	<-forwarder.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if got := gm.drain(ctx); got != 1 {
+		t.Errorf("got %v in flight after timing out, want 1", got)
+	}
+
+	close(forwarder.release)
+	if err := <-received; err != nil {
+		t.Fatal(err)
+	}
+	if got := gm.drain(context.Background()); got != 0 {
+		t.Errorf("got %v in flight once forwarded, want 0", got)
+	}
+}
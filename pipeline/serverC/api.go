@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"shared/httpserver"
+	"shared/openapi"
+)
+
+// The HTTP API is versioned, so it can change without breaking the
+// clients deployed against it. Each version is served under its prefix,
+// and v1 also without one, as it was before versions were introduced. v1
+// keeps the behaviour its clients were built against; v2 is stricter
+// about what it accepts and describes what it did in its responses.
+
+// Limits on the requests to the API, so oversized or slow clients cannot
+// tie up the server
+const (
+	maxPostBytes = 4 << 10
+	postTimeout  = 5 * time.Second
+	// queryTimeout bounds /get and /stats, which may read many values
+	queryTimeout = 8 * time.Second
+)
+
+// apiV1 returns the routes of version 1 of the API
+func (sm *GlobalVarManager) apiV1() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/post":  postRoute(sm.postCall),
+		"/get":   queryRoute(sm.getCall),
+		"/stats": queryRoute(sm.statsCall),
+	}
+}
+
+// apiV2 returns the routes of version 2 of the API
+func (sm *GlobalVarManager) apiV2() map[string]http.Handler {
+	return map[string]http.Handler{
+		"/post":     postRoute(sm.postCallV2),
+		"/get":      queryRoute(sm.getCallV2),
+		"/stats":    queryRoute(sm.statsCall),
+		"/values/":  queryRoute(sm.valueCall),
+		"/tenants":  queryRoute(sm.tenantsCall),
+		"/tenants/": queryRoute(sm.tenantCall),
+	}
+}
+
+// postRoute wraps the handler of a post with the body limit, timeout and
+// idempotency of every version. Each route has its own idempotency cache.
+func postRoute(handler http.HandlerFunc) http.Handler {
+	return httpserver.Chain(handler,
+		httpserver.Timeout(postTimeout),
+		httpserver.MaxBytes(maxPostBytes),
+		httpserver.Idempotent(httpserver.NewIdempotencyCache(httpserver.IdempotencyTTL)),
+	)
+}
+
+// queryRoute wraps the handler of a query with its timeout
+func queryRoute(handler http.HandlerFunc) http.Handler {
+	return httpserver.Timeout(queryTimeout)(handler)
+}
+
+// mountAPI registers the routes of a version of the API under prefix
+func mountAPI(router *http.ServeMux, prefix string, routes map[string]http.Handler) {
+	for path, handler := range routes {
+		router.Handle(prefix+path, handler)
+	}
+}
+
+// postResponse is the body of a successful /v2/post, holding the value
+// stored
+type postResponse struct {
+	Value
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// valuesPage is the JSON body of /v2/get
+type valuesPage struct {
+	Values []Value `json:"values"`
+	Total  int     `json:"total"` // matching the filter, before paging
+	Limit  int     `json:"limit,omitempty"`
+	Offset int     `json:"offset"`
+}
+
+// getParams are the query parameters /v2/get accepts
+var getParams = map[string]bool{
+	"serviceName": true,
+	"from":        true,
+	"to":          true,
+	"limit":       true,
+	"offset":      true,
+}
+
+// postCallV2 handles the /v2/post route. On top of the checks of v1, the
+// body must be declared as JSON. The value stored is returned with a 201,
+// and its route in the Location header.
+func (sm *GlobalVarManager) postCallV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != mediaJSON {
+		writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be "+mediaJSON)
+		return
+	}
+
+	req, status, err := decodePost(r)
+	if err != nil {
+		writeRequestError(w, status, err)
+		return
+	}
+	value, err := sm.save(r.Context(), req)
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+
+	requestID, _ := httpserver.RequestIDFrom(r.Context())
+	w.Header().Set("Content-Type", mediaJSON)
+	w.Header().Set("Location", "/v2/values/"+strconv.FormatInt(value.ID, 10))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(postResponse{Value: value, RequestID: requestID})
+}
+
+// getCallV2 handles the /v2/get route. It takes the query parameters of
+// v1, rejecting any others rather than ignoring them, and wraps JSON lists
+// in a valuesPage.
+func (sm *GlobalVarManager) getCallV2(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+	for name := range r.URL.Query() {
+		if !getParams[name] {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown query parameter %q", name))
+			return
+		}
+	}
+	sm.serveValues(w, r, true)
+}
+
+// valueCall handles the /v2/values/{id} route, responding with the value
+// with the ID, or deleting it with a 204. An ID that is not a positive
+// integer is no more found than one that has been deleted.
+func (sm *GlobalVarManager) valueCall(w http.ResponseWriter, r *http.Request) {
+	id, ok := valueID(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no value with this ID")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, err := sm.store.Get(id)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, "no value with this ID")
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not read value", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, "could not read value")
+			return
+		}
+		w.Header().Set("Content-Type", mediaJSON)
+		json.NewEncoder(w).Encode(value)
+	case http.MethodDelete:
+		err := sm.store.Delete(id)
+		if errors.Is(err, ErrNotFound) {
+			writeError(w, http.StatusNotFound, "no value with this ID")
+			return
+		} else if err != nil {
+			slog.ErrorContext(r.Context(), "could not delete value", "id", id, "err", err)
+			writeError(w, http.StatusInternalServerError, "could not delete value")
+			return
+		}
+		slog.InfoContext(r.Context(), "deleted value", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET or DELETE")
+	}
+}
+
+// valueID returns the ID at the end of a /values/{id} path
+func valueID(path string) (int64, bool) {
+	_, rest, found := strings.Cut(path, "/values/")
+	if !found {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	return id, err == nil && id > 0
+}
+
+// apiSpec describes both versions of the API, from the types their
+// handlers decode and encode. v1 is described under its prefix, though it
+// is also served without one.
+func apiSpec() *openapi.Spec {
+	spec := openapi.New("serverC", "2",
+		"Stores the values of the demo pipeline and lists and summarises them. "+
+			"Version 1 is also served without the /v1 prefix.")
+
+	// The limits validate checks on a post
+	request := spec.Component(postRequest{})
+	request.Properties["serviceName"].MaxLength = openapi.IntRef(maxServiceNameLen)
+	request.Properties["value"].Minimum = openapi.IntRef(minValue)
+	request.Properties["value"].Maximum = openapi.IntRef(maxValue)
+
+	// Values are timestamped as RFC3339 strings
+	spec.Component(Value{}).Properties["timestamp"].Format = "date-time"
+
+	errorBody := spec.Schema(errorResponse{})
+	failed := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSONContent(errorBody)}
+	}
+	query := func(name, description string, schema *openapi.Schema) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+	}
+
+	idempotencyKey := openapi.Parameter{
+		Name:        "Idempotency-Key",
+		In:          "header",
+		Description: "Retries of a post with the same key are answered with the first response, rather than storing the value again",
+		Schema:      &openapi.Schema{Type: "string", MaxLength: openapi.IntRef(httpserver.MaxIdempotencyKeyLen)},
+	}
+	ifNoneMatch := openapi.Parameter{
+		Name:        "If-None-Match",
+		In:          "header",
+		Description: "The ETag of a list fetched before, to be answered with a 304 rather than the list again if it has not changed",
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+	filterParams := []openapi.Parameter{
+		query("serviceName", "Only the values of this service", &openapi.Schema{Type: "string"}),
+		query("from", "Only the values at or after this time", &openapi.Schema{Type: "string", Format: "date-time"}),
+		query("to", "Only the values before this time (exclusive)", &openapi.Schema{Type: "string", Format: "date-time"}),
+	}
+	listParams := append(filterParams[:len(filterParams):len(filterParams)],
+		query("limit", "The most values to list", &openapi.Schema{Type: "integer", Minimum: openapi.IntRef(0)}),
+		query("offset", "The number of matching values to skip", &openapi.Schema{Type: "integer", Minimum: openapi.IntRef(0)}),
+		ifNoneMatch,
+	)
+	statsParams := append(filterParams[:len(filterParams):len(filterParams)],
+		query("window", "Only the values within this duration of now, such as 1h; not combined with from or to", &openapi.Schema{Type: "string"}),
+	)
+	postBody := &openapi.RequestBody{Required: true, Content: openapi.JSONContent(spec.Schema(postRequest{}))}
+	values := func(json *openapi.Schema) map[string]openapi.MediaType {
+		return map[string]openapi.MediaType{
+			mediaJSON:     {Schema: json},
+			mediaCSV:      {Schema: &openapi.Schema{Type: "string"}},
+			mediaProtobuf: {Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+		}
+	}
+	stats := &openapi.Operation{
+		Summary:    "Summarise the values of each service",
+		Parameters: statsParams,
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The statistics of each service", Content: openapi.JSONContent(spec.Schema([]Stats{}))},
+			"400": failed("The query parameters are not valid"),
+			"408": failed("The values were not summarised in time"),
+			"500": failed("The values could not be read"),
+		},
+	}
+
+	spec.Add(http.MethodPost, "/v1/post", &openapi.Operation{
+		Summary:     "Store a value",
+		Description: "Stores the value posted plus 100.",
+		Tags:        []string{"v1"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: postBody,
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The value was stored", Content: map[string]openapi.MediaType{"text/plain": {Schema: &openapi.Schema{Type: "string"}}}},
+			"400": failed("The body is not a single JSON object with the fields of a postRequest"),
+			"405": failed("The method is not POST"),
+			"408": failed("The post was not handled in time"),
+			"409": failed("A post with the same Idempotency-Key is still being handled"),
+			"413": failed("The body is larger than the limit"),
+			"422": failed("The fields are not valid, or the Idempotency-Key was used with a different body"),
+			"429": failed("The service already has its quota of values stored"),
+			"500": failed("The value could not be stored"),
+		},
+	})
+	spec.Add(http.MethodGet, "/v1/get", &openapi.Operation{
+		Summary:     "List the values",
+		Description: "Lists the matching values as the Accept header prefers. Their number, before paging, is in the X-Total-Count header.",
+		Tags:        []string{"v1"},
+		Parameters:  listParams,
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The matching values", Content: values(spec.Schema([]Value{}))},
+			"304": {Description: "The values listed have not changed since the list tagged by If-None-Match"},
+			"400": failed("The query parameters are not valid"),
+			"406": failed("The Accept header allows none of the media types listed"),
+			"408": failed("The values were not listed in time"),
+			"500": failed("The values could not be read"),
+		},
+	})
+	v1Stats := *stats
+	v1Stats.Tags = []string{"v1"}
+	spec.Add(http.MethodGet, "/v1/stats", &v1Stats)
+
+	spec.Add(http.MethodPost, "/v2/post", &openapi.Operation{
+		Summary:     "Store a value",
+		Description: "Stores the value posted plus 100, returning the value stored.",
+		Tags:        []string{"v2"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: postBody,
+		Responses: map[string]openapi.Response{
+			"201": {Description: "The value stored", Content: openapi.JSONContent(spec.Schema(postResponse{}))},
+			"400": failed("The body is not a single JSON object with the fields of a postRequest"),
+			"405": failed("The method is not POST"),
+			"408": failed("The post was not handled in time"),
+			"409": failed("A post with the same Idempotency-Key is still being handled"),
+			"413": failed("The body is larger than the limit"),
+			"415": failed("The body is not declared as JSON"),
+			"422": failed("The fields are not valid, or the Idempotency-Key was used with a different body"),
+			"429": failed("The service already has its quota of values stored"),
+			"500": failed("The value could not be stored"),
+		},
+	})
+	spec.Add(http.MethodGet, "/v2/get", &openapi.Operation{
+		Summary:     "List the values",
+		Description: "Lists the matching values as the Accept header prefers, as a page in JSON.",
+		Tags:        []string{"v2"},
+		Parameters:  listParams,
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The matching values", Content: values(spec.Schema(valuesPage{}))},
+			"304": {Description: "The values listed have not changed since the list tagged by If-None-Match"},
+			"400": failed("The query parameters are not valid or not known"),
+			"405": failed("The method is not GET"),
+			"406": failed("The Accept header allows none of the media types listed"),
+			"408": failed("The values were not listed in time"),
+			"500": failed("The values could not be read"),
+		},
+	})
+	v2Stats := *stats
+	v2Stats.Tags = []string{"v2"}
+	spec.Add(http.MethodGet, "/v2/stats", &v2Stats)
+
+	id := openapi.Parameter{Name: "id", In: "path", Description: "The ID of the value, returned when it was posted", Required: true, Schema: &openapi.Schema{Type: "integer", Minimum: openapi.IntRef(1)}}
+	spec.Add(http.MethodGet, "/v2/values/{id}", &openapi.Operation{
+		Summary:    "Get a value",
+		Tags:       []string{"v2"},
+		Parameters: []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The value", Content: openapi.JSONContent(spec.Schema(Value{}))},
+			"404": failed("There is no value with the ID"),
+			"408": failed("The value was not read in time"),
+			"500": failed("The value could not be read"),
+		},
+	})
+	spec.Add(http.MethodDelete, "/v2/values/{id}", &openapi.Operation{
+		Summary:    "Delete a value",
+		Tags:       []string{"v2"},
+		Parameters: []openapi.Parameter{id},
+		Responses: map[string]openapi.Response{
+			"204": {Description: "The value was deleted"},
+			"404": failed("There is no value with the ID"),
+			"408": failed("The value was not deleted in time"),
+			"500": failed("The value could not be deleted"),
+		},
+	})
+
+	spec.Add(http.MethodGet, "/v2/tenants", &openapi.Operation{
+		Summary:     "List the tenants",
+		Description: "Lists the services with values stored or a quota of their own, with their number of values and quota.",
+		Tags:        []string{"v2"},
+		Responses: map[string]openapi.Response{
+			"200": {Description: "The tenants, ordered by name", Content: openapi.JSONContent(spec.Schema([]Tenant{}))},
+			"405": failed("The method is not GET"),
+			"408": failed("The tenants were not listed in time"),
+			"500": failed("The values could not be read"),
+		},
+	})
+	tenant := openapi.Parameter{Name: "name", In: "path", Description: "The serviceName of the tenant", Required: true, Schema: &openapi.Schema{Type: "string"}}
+	tenantGet := *spec.Doc.Paths["/v2/get"]["get"]
+	tenantGet.Summary = "List the values of a tenant"
+	tenantGet.Description = "Lists the tenant's values as /v2/get does, which takes the same query parameters but serviceName."
+	tenantGet.Parameters = append([]openapi.Parameter{tenant}, listParams[1:]...)
+	spec.Add(http.MethodGet, "/v2/tenants/{name}/get", &tenantGet)
+	tenantStats := v2Stats
+	tenantStats.Summary = "Summarise the values of a tenant"
+	tenantStats.Description = "Summarises the tenant's values as /v2/stats does, which takes the same query parameters but serviceName."
+	tenantStats.Parameters = append([]openapi.Parameter{tenant}, statsParams[1:]...)
+	spec.Add(http.MethodGet, "/v2/tenants/{name}/stats", &tenantStats)
+
+	return spec
+}
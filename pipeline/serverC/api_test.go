@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIVersions(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	router := http.NewServeMux()
+	v1 := gm.apiV1()
+	mountAPI(router, "", v1)
+	mountAPI(router, "/v1", v1)
+	mountAPI(router, "/v2", gm.apiV2())
+
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	// v1 is served with and without its prefix, as before
+	for _, path := range []string{"/post", "/v1/post"} {
+		if got := do(http.MethodPost, path, "", `{"serviceName":"serverB","value":8}`); got.Code != http.StatusOK || got.Body.String() != "POST done" {
+			t.Errorf("%v: got %v %q, want 200 \"POST done\"", path, got.Code, got.Body)
+		}
+	}
+
+	testCases := []struct {
+		desc        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"valid", "application/json; charset=utf-8", `{"serviceName":"serverB","value":8}`, http.StatusCreated},
+		{"no content type", "", `{"serviceName":"serverB","value":8}`, http.StatusUnsupportedMediaType},
+		{"text", "text/plain", `{"serviceName":"serverB","value":8}`, http.StatusUnsupportedMediaType},
+		{"unknown field", "application/json", `{"serviceName":"serverB","value":8,"extra":1}`, http.StatusBadRequest},
+		{"missing value", "application/json", `{"serviceName":"serverB"}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range testCases {
+		t.Run("v2 post "+tc.desc, func(t *testing.T) {
+			response := do(http.MethodPost, "/v2/post", tc.contentType, tc.body)
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v: %s", response.Code, tc.wantStatus, response.Body)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+			var got postResponse
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ServiceName != "serverB" || got.Value.Value != 108 || got.Timestamp == "" {
+				t.Errorf("got %+v, want the value stored", got)
+			}
+		})
+	}
+
+	t.Run("v2 get", func(t *testing.T) {
+		response := do(http.MethodGet, "/v2/get?serviceName=serverB&limit=2&offset=1", "", "")
+		var page valuesPage
+		if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 3 || len(page.Values) != 2 || page.Limit != 2 || page.Offset != 1 {
+			t.Errorf("got %+v, want 2 of 3 values from offset 1", page)
+		}
+	})
+
+	t.Run("v2 get unknown parameter", func(t *testing.T) {
+		if got := do(http.MethodGet, "/v2/get?service=serverB", "", "").Code; got != http.StatusBadRequest {
+			t.Errorf("got status %v, want %v", got, http.StatusBadRequest)
+		}
+		// v1 ignores it, as it always has
+		if got := do(http.MethodGet, "/v1/get?service=serverB", "", "").Code; got != http.StatusOK {
+			t.Errorf("got status %v from v1, want %v", got, http.StatusOK)
+		}
+	})
+
+	t.Run("v2 values", func(t *testing.T) {
+		posted := do(http.MethodPost, "/v2/post", "application/json", `{"serviceName":"serviceD","value":5}`)
+		var stored postResponse
+		if err := json.NewDecoder(posted.Body).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		location := posted.Header().Get("Location")
+		if stored.ID == 0 || location != fmt.Sprintf("/v2/values/%d", stored.ID) {
+			t.Fatalf("got ID %d at %q, want an ID and its route", stored.ID, location)
+		}
+
+		response := do(http.MethodGet, location, "", "")
+		var got Value
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != http.StatusOK || got != stored.Value {
+			t.Errorf("got %v %+v, want 200 %+v", response.Code, got, stored.Value)
+		}
+
+		for _, step := range []struct {
+			method, path string
+			wantStatus   int
+		}{
+			{http.MethodPut, location, http.StatusMethodNotAllowed},
+			{http.MethodDelete, location, http.StatusNoContent},
+			{http.MethodGet, location, http.StatusNotFound},
+			{http.MethodDelete, location, http.StatusNotFound},
+			{http.MethodGet, "/v2/values/first", http.StatusNotFound},
+			{http.MethodGet, "/v2/values/0", http.StatusNotFound},
+		} {
+			if got := do(step.method, step.path, "", "").Code; got != step.wantStatus {
+				t.Errorf("%v %v: got status %v, want %v", step.method, step.path, got, step.wantStatus)
+			}
+		}
+	})
+}
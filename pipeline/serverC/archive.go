@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultArchivePrefix   = "values/"
+	defaultArchiveInterval = time.Minute
+	// archiveTimeout bounds each upload of a snapshot
+	archiveTimeout = 30 * time.Second
+	// snapshotTime names the snapshots, so that sorting their keys sorts
+	// them by time
+	snapshotTime = "20060102T150405Z"
+)
+
+// ErrNoSnapshot is returned when no snapshot has been archived
+var ErrNoSnapshot = errors.New("no snapshot archived")
+
+// ObjectStore keeps the archived snapshots, such as a directory or an S3
+// bucket
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte) error
+	// Latest returns the key and body of the last object, in the order of
+	// their keys, whose key starts with prefix, or ErrNoSnapshot if there
+	// is none
+	Latest(ctx context.Context, prefix string) (key string, body []byte, err error)
+}
+
+// DirObjects keeps objects as files in a directory, the keys being their
+// paths within it
+type DirObjects struct {
+	dir string
+}
+
+func NewDirObjects(dir string) *DirObjects {
+	return &DirObjects{dir: dir}
+}
+
+// Put writes the object to a temporary file that is renamed into place,
+// so a crash cannot leave a partial snapshot to be restored
+func (o *DirObjects) Put(ctx context.Context, key string, body []byte) error {
+	path := filepath.Join(o.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (o *DirObjects) Latest(ctx context.Context, prefix string) (string, []byte, error) {
+	var keys []string
+	err := filepath.WalkDir(o.dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(o.dir, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) || err == nil && len(keys) == 0 {
+		return "", nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	sort.Strings(keys)
+	key := keys[len(keys)-1]
+	body, err := os.ReadFile(filepath.Join(o.dir, filepath.FromSlash(key)))
+	return key, body, err
+}
+
+// ArchiveStatus is the body of the /archive/status route
+type ArchiveStatus struct {
+	Location   string     `json:"location"`
+	Interval   string     `json:"interval"`
+	Snapshots  int        `json:"snapshots"` // written since the server started
+	Values     int        `json:"values"`    // in the last snapshot
+	LastKey    string     `json:"lastKey,omitempty"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+	// RestoredFrom is the snapshot restored on startup
+	RestoredFrom string `json:"restoredFrom,omitempty"`
+}
+
+// Archiver periodically writes a snapshot of every value in the store to
+// an object store, as a JSON list named after the time it was taken, so
+// the values outlive the server and its disk. A snapshot is only written
+// once the values have changed since the last one, or since the store was
+// restored.
+type Archiver struct {
+	store    Store
+	objects  ObjectStore
+	location string // names the object store in the status
+	prefix   string
+	interval time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex // protects the fields below
+	snapshots    int
+	values       int
+	lastID       int64 // of the newest value in the last snapshot
+	lastKey      string
+	archivedAt   time.Time
+	lastErr      error
+	restoredFrom string
+}
+
+// NewArchiver returns an archiver writing snapshots of store to objects
+// every interval, under keys starting with prefix. location names the
+// object store in the status.
+func NewArchiver(store Store, objects ObjectStore, location, prefix string, interval time.Duration) *Archiver {
+	return &Archiver{
+		store:    store,
+		objects:  objects,
+		location: location,
+		prefix:   prefix,
+		interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Restore adds the values of the latest snapshot to the store, so that
+// values archived before a redeploy are not lost. A store already holding
+// values, such as a database kept across the deploy, is left as it is.
+// It returns the key of the snapshot restored and the number of values,
+// or ErrNoSnapshot if there is none.
+func (a *Archiver) Restore(ctx context.Context) (key string, restored int, err error) {
+	_, total, err := a.store.Find(Filter{Limit: 1})
+	if err != nil {
+		return "", 0, err
+	}
+	if total > 0 {
+		return "", 0, nil
+	}
+
+	key, body, err := a.objects.Latest(ctx, a.prefix)
+	if err != nil {
+		return "", 0, err
+	}
+	var values []Value
+	if err := json.Unmarshal(body, &values); err != nil {
+		return key, 0, fmt.Errorf("decoding %s: %v", key, err)
+	}
+	// The store gives the values new IDs, in the same order
+	var lastID int64
+	for _, v := range values {
+		v, err := a.store.Add(v)
+		if err != nil {
+			return key, 0, err
+		}
+		lastID = v.ID
+	}
+
+	// The restored values need not be archived again until more are added
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.values = len(values)
+	a.lastID = lastID
+	a.lastKey = key
+	a.restoredFrom = key
+	return key, len(values), nil
+}
+
+// Start archives the values every interval until the returned function is
+// called, which archives any values added since the last snapshot before
+// returning
+func (a *Archiver) Start() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.archive()
+				return
+			case <-ticker.C:
+				a.archive()
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// archive writes a snapshot if values have been added since the last one,
+// recording the outcome in the status
+func (a *Archiver) archive() {
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	defer cancel()
+
+	values, _, err := a.store.Find(Filter{})
+	if err != nil {
+		a.record("", nil, err)
+		return
+	}
+	if len(values) == 0 {
+		return
+	}
+	// IDs are not reused, so the values are unchanged if both their number
+	// and the newest are, even as old ones are deleted or evicted
+	a.mu.Lock()
+	unchanged := a.lastKey != "" && len(values) == a.values && values[len(values)-1].ID == a.lastID
+	a.mu.Unlock()
+	if unchanged {
+		return
+	}
+
+	body, err := json.Marshal(values)
+	if err != nil {
+		a.record("", nil, err)
+		return
+	}
+	key := a.prefix + a.now().UTC().Format(snapshotTime) + ".json"
+	start := time.Now()
+	err = a.objects.Put(ctx, key, body)
+	a.record(key, values, err)
+	if err != nil {
+		return
+	}
+	slog.Info("archived values", "location", a.location, "key", key, "values", len(values), "bytes", len(body), "duration_ms", time.Since(start).Milliseconds())
+}
+
+// record updates the status with the outcome of a snapshot of the values
+func (a *Archiver) record(key string, values []Value, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastErr = err
+	if err != nil {
+		slog.Error("could not archive values", "location", a.location, "err", err)
+		return
+	}
+	a.snapshots++
+	a.values = len(values)
+	a.lastID = values[len(values)-1].ID
+	a.lastKey = key
+	a.archivedAt = a.now()
+}
+
+// Status returns the outcome of the latest snapshot
+func (a *Archiver) Status() ArchiveStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	status := ArchiveStatus{
+		Location:     a.location,
+		Interval:     a.interval.String(),
+		Snapshots:    a.snapshots,
+		Values:       a.values,
+		LastKey:      a.lastKey,
+		RestoredFrom: a.restoredFrom,
+	}
+	if a.snapshots > 0 {
+		archivedAt := a.archivedAt
+		status.ArchivedAt = &archivedAt
+	}
+	if a.lastErr != nil {
+		status.LastError = a.lastErr.Error()
+	}
+	return status
+}
+
+// serveStatus handles the /archive/status route
+func (a *Archiver) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(a.Status())
+}
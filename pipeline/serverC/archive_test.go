@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testObjects is an ObjectStore keeping the objects in memory
+type testObjects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	keys    []string
+	err     error
+}
+
+func (o *testObjects) Put(ctx context.Context, key string, body []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	if o.objects == nil {
+		o.objects = make(map[string][]byte)
+	}
+	o.objects[key] = body
+	o.keys = append(o.keys, key)
+	return nil
+}
+
+func (o *testObjects) Latest(ctx context.Context, prefix string) (string, []byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.keys) == 0 {
+		return "", nil, ErrNoSnapshot
+	}
+	key := o.keys[len(o.keys)-1]
+	return key, o.objects[key], nil
+}
+
+func TestArchiver(t *testing.T) {
+	store := NewMemoryStore()
+	objects := &testObjects{}
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	archiver := NewArchiver(store, objects, "s3://bucket", "values/", time.Hour)
+	archiver.now = func() time.Time { return now }
+
+	// Nothing is archived until there are values
+	archiver.archive()
+	if len(objects.keys) != 0 {
+		t.Fatalf("got %v archived from an empty store", objects.keys)
+	}
+
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	store.Add(Value{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120})
+	archiver.archive()
+	if len(objects.keys) != 1 || objects.keys[0] != "values/20201120T100000Z.json" {
+		t.Fatalf("got %v archived, want values/20201120T100000Z.json", objects.keys)
+	}
+	var values []Value
+	if err := json.Unmarshal(objects.objects[objects.keys[0]], &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[1].Value != 120 {
+		t.Errorf("got %+v archived", values)
+	}
+
+	// An unchanged store is not archived again
+	now = now.Add(time.Minute)
+	archiver.archive()
+	if len(objects.keys) != 1 {
+		t.Errorf("got %v archived, want the unchanged store skipped", objects.keys)
+	}
+
+	objects.err = errors.New("access denied")
+	store.Add(Value{Timestamp: "2020-11-20T10:01:00Z", ServiceName: "serverB", Value: 101})
+	archiver.archive()
+	if got := archiver.Status(); got.LastError != "access denied" || got.Snapshots != 1 {
+		t.Errorf("got status %+v, want the error recorded", got)
+	}
+
+	objects.err = nil
+	archiver.archive()
+	status := archiver.Status()
+	if status.LastError != "" || status.Snapshots != 2 || status.Values != 3 || status.LastKey != "values/20201120T100100Z.json" {
+		t.Errorf("got status %+v after recovering", status)
+	}
+
+	// A store kept at the same size by eviction has still changed
+	now = now.Add(time.Minute)
+	store.Add(Value{Timestamp: "2020-11-20T10:02:00Z", ServiceName: "serverB", Value: 130})
+	store.EvictOldest(3)
+	archiver.archive()
+	if status = archiver.Status(); status.Snapshots != 3 || status.Values != 3 {
+		t.Errorf("got status %+v, want the evicted store archived", status)
+	}
+
+	response := httptest.NewRecorder()
+	archiver.serveStatus(response, httptest.NewRequest(http.MethodGet, "/archive/status", nil))
+	var served ArchiveStatus
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served.LastKey != status.LastKey || served.Location != "s3://bucket" {
+		t.Errorf("got %+v served, want %+v", served, status)
+	}
+}
+
+func TestArchiverStop(t *testing.T) {
+	store := NewMemoryStore()
+	objects := &testObjects{}
+	archiver := NewArchiver(store, objects, "s3://bucket", "", time.Hour)
+	stop := archiver.Start()
+
+	// The values added since the last snapshot are archived on stopping
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	stop()
+	if len(objects.keys) != 1 {
+		t.Errorf("got %v archived, want a snapshot on stopping", objects.keys)
+	}
+}
+
+func TestDirObjects(t *testing.T) {
+	objects := NewDirObjects(filepath.Join(t.TempDir(), "archive"))
+	ctx := context.Background()
+
+	if _, _, err := objects.Latest(ctx, "values/"); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("got %v before any were written, want ErrNoSnapshot", err)
+	}
+
+	for _, key := range []string{"values/20201120T100001Z.json", "values/20201120T100000Z.json", "other/20201120T100002Z.json"} {
+		if err := objects.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatalf("writing %v: %v", key, err)
+		}
+	}
+	key, body, err := objects.Latest(ctx, "values/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "values/20201120T100001Z.json" || string(body) != key {
+		t.Errorf("got %q holding %q, want the latest of values/", key, body)
+	}
+
+	// Nothing is left behind but the objects
+	entries, err := os.ReadDir(filepath.Join(objects.dir, "values"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %v files, want 2", len(entries))
+	}
+}
+
+func TestRestore(t *testing.T) {
+	snapshot := []Value{
+		{ID: 1, Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{ID: 2, Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120},
+	}
+	body, _ := json.Marshal(snapshot)
+	objects := &testObjects{}
+	objects.Put(context.Background(), "values/20201120T100001Z.json", body)
+
+	t.Run("empty store", func(t *testing.T) {
+		store := NewMemoryStore()
+		archiver := NewArchiver(store, objects, "test", "values/", time.Hour)
+		key, n, err := archiver.Restore(context.Background())
+		if err != nil || key != "values/20201120T100001Z.json" || n != 2 {
+			t.Fatalf("got %q, %v, %v, want 2 values from values/20201120T100001Z.json", key, n, err)
+		}
+		values, _, _ := store.Find(Filter{})
+		if len(values) != 2 || values[0] != snapshot[0] || values[1] != snapshot[1] {
+			t.Errorf("got %+v restored, want %+v", values, snapshot)
+		}
+		if got := archiver.Status().RestoredFrom; got != key {
+			t.Errorf("got restored from %q, want %q", got, key)
+		}
+
+		// The restored values are not archived again
+		archiver.archive()
+		if len(objects.keys) != 1 {
+			t.Errorf("got %v archived, want the restored values skipped", objects.keys)
+		}
+	})
+
+	t.Run("store with values", func(t *testing.T) {
+		store := NewMemoryStore()
+		store.Add(Value{Timestamp: "2020-11-20T11:00:00Z", ServiceName: "serverB", Value: 101})
+		archiver := NewArchiver(store, objects, "test", "values/", time.Hour)
+		if _, n, err := archiver.Restore(context.Background()); err != nil || n != 0 {
+			t.Errorf("got %v values restored, %v, want the store left as it is", n, err)
+		}
+		if _, total, _ := store.Find(Filter{}); total != 1 {
+			t.Errorf("got %v values, want 1", total)
+		}
+	})
+
+	t.Run("no snapshot", func(t *testing.T) {
+		archiver := NewArchiver(NewMemoryStore(), &testObjects{}, "test", "values/", time.Hour)
+		if _, _, err := archiver.Restore(context.Background()); !errors.Is(err, ErrNoSnapshot) {
+			t.Errorf("got %v, want ErrNoSnapshot", err)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"shared/configfile"
+)
+
+// The defaults of the CORS settings, and the response headers scripts
+// on the allowed origins may read
+const (
+	defaultCORSMethods = "GET, HEAD, POST"
+	defaultCORSHeaders = "Content-Type, Idempotency-Key, If-None-Match, Last-Event-ID, X-Request-Id"
+	corsExposedHeaders = "ETag, X-Request-Id, X-Total-Count"
+)
+
+// Config holds the runtime settings of serverC, each tagged with the name
+// of its flag
+type Config struct {
+	ListenAddr string     `flag:"listen-addr"`
+	LogLevel   slog.Level `flag:"log-level"`
+
+	StoreKind string        `flag:"store"`
+	StoreDSN  string        `flag:"dsn"`
+	MaxValues int           `flag:"max-values"`
+	MaxAge    time.Duration `flag:"max-age"`
+	GRPCAddr  string        `flag:"grpc-addr"`
+	NATSURL   string        `flag:"nats-url"`
+
+	TenantQuota  int    `flag:"tenant-quota"`
+	TenantQuotas string `flag:"tenant-quotas"`
+
+	TLSCert     string `flag:"tls-cert"`
+	TLSKey      string `flag:"tls-key"`
+	TLSClientCA string `flag:"tls-client-ca"`
+
+	ArchiveBucket   string        `flag:"archive-bucket"`
+	ArchiveDir      string        `flag:"archive-dir"`
+	ArchivePrefix   string        `flag:"archive-prefix"`
+	ArchiveInterval time.Duration `flag:"archive-interval"`
+
+	CORSOrigins string `flag:"cors-origins"`
+	CORSMethods string `flag:"cors-methods"`
+	CORSHeaders string `flag:"cors-headers"`
+
+	AdminToken string `flag:"admin-token"`
+
+	WebhookDeadLetters string `flag:"webhook-dead-letters"`
+}
+
+// parseConfig reads the config from args, the command line without the
+// program name, writing usage and errors to output
+func parseConfig(args []string, output io.Writer) (Config, error) {
+	var c Config
+	level, err := configfile.EnvLevel("LOG_LEVEL")
+	if err != nil {
+		return c, err
+	}
+
+	fs := flag.NewFlagSet("serverC", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file of settings keyed by flag name, read again on SIGHUP, also set by CONFIG_FILE")
+	fs.StringVar(&c.ListenAddr, "listen-addr", envOr("LISTEN_ADDR", defaultListenAddr), "server listen address, also set by LISTEN_ADDR")
+	fs.TextVar(&c.LogLevel, "log-level", level, "lowest level logged: debug, info, warn or error, also set by LOG_LEVEL")
+	fs.StringVar(&c.StoreKind, "store", "memory", "where to keep the values: memory, sqlite or postgres")
+	fs.StringVar(&c.StoreDSN, "dsn", "serverc.db", "SQLite database file or Postgres connection string")
+	fs.IntVar(&c.MaxValues, "max-values", 0, "most values to keep, evicting the oldest beyond it, or 0 for no limit")
+	fs.DurationVar(&c.MaxAge, "max-age", 0, "how long to keep values before evicting them, or 0 for ever")
+	fs.IntVar(&c.TenantQuota, "tenant-quota", 0, "most values each service may have stored, refusing more, or 0 for no limit")
+	fs.StringVar(&c.TenantQuotas, "tenant-quotas", "", "comma separated name=count quotas of individual services, such as serverB=1000,serviceD=50, overriding -tenant-quota")
+	fs.StringVar(&c.TLSCert, "tls-cert", "", "certificate file to serve HTTPS with")
+	fs.StringVar(&c.TLSKey, "tls-key", "", "key file of -tls-cert")
+	fs.StringVar(&c.TLSClientCA, "tls-client-ca", "", "CA file to verify client certificates with, requiring clients to present one (mutual TLS)")
+	fs.StringVar(&c.GRPCAddr, "grpc-addr", "", "address to also serve the gRPC pipeline on, such as :15001")
+	fs.StringVar(&c.NATSURL, "nats-url", "", "NATS server, such as nats://localhost:4222, to also consume values from")
+	fs.StringVar(&c.ArchiveBucket, "archive-bucket", os.Getenv("ARCHIVE_BUCKET"), "S3 bucket to archive snapshots of the values to and restore them from, also set by ARCHIVE_BUCKET")
+	fs.StringVar(&c.ArchiveDir, "archive-dir", os.Getenv("ARCHIVE_DIR"), "directory to archive snapshots of the values to and restore them from, also set by ARCHIVE_DIR")
+	fs.StringVar(&c.ArchivePrefix, "archive-prefix", envOr("ARCHIVE_PREFIX", defaultArchivePrefix), "prefix of the archived snapshots' keys, also set by ARCHIVE_PREFIX")
+	fs.DurationVar(&c.ArchiveInterval, "archive-interval", defaultArchiveInterval, "how often to archive a snapshot of the values")
+	fs.StringVar(&c.CORSOrigins, "cors-origins", os.Getenv("CORS_ORIGINS"), "comma separated origins, such as https://dash.example.com, whose pages may call the API from a browser, or * for any, also set by CORS_ORIGINS")
+	fs.StringVar(&c.CORSMethods, "cors-methods", envOr("CORS_METHODS", defaultCORSMethods), "comma separated methods the -cors-origins may use, also set by CORS_METHODS")
+	fs.StringVar(&c.CORSHeaders, "cors-headers", envOr("CORS_HEADERS", defaultCORSHeaders), "comma separated request headers the -cors-origins may send, also set by CORS_HEADERS")
+	fs.StringVar(&c.WebhookDeadLetters, "webhook-dead-letters", os.Getenv("WEBHOOK_DEAD_LETTERS"), "file to append the values that could not be delivered to subscribers to, as lines of JSON, also set by WEBHOOK_DEAD_LETTERS")
+	fs.StringVar(&c.AdminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "token the /admin routes must be called with, as an Authorization: Bearer header; they are not served without one, also set by ADMIN_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if *configFile != "" {
+		if err := configfile.Apply(fs, *configFile); err != nil {
+			return c, err
+		}
+	}
+	return c, c.validate()
+}
+
+// validate returns the first problem found with the settings
+func (c Config) validate() error {
+	if c.ArchiveBucket != "" && c.ArchiveDir != "" {
+		return errors.New("-archive-bucket and -archive-dir cannot both be set")
+	}
+	if c.MaxValues < 0 {
+		return errors.New("-max-values must not be negative")
+	}
+	if c.MaxAge < 0 {
+		return errors.New("-max-age must not be negative")
+	}
+	if c.TenantQuota < 0 {
+		return errors.New("-tenant-quota must not be negative")
+	}
+	if _, err := parseQuotas(c.TenantQuotas); err != nil {
+		return fmt.Errorf("-tenant-quotas: %v", err)
+	}
+	if c.ArchiveInterval <= 0 {
+		return errors.New("-archive-interval must be positive")
+	}
+	return nil
+}
+
+// retention returns the bounds on the values kept in the store
+func (c Config) retention() Retention {
+	return Retention{MaxValues: c.MaxValues, MaxAge: c.MaxAge}
+}
+
+// quotas returns the quotas of the services, from the settings validate
+// has checked
+func (c Config) quotas() *Quotas {
+	limits, _ := parseQuotas(c.TenantQuotas)
+	return NewQuotas(c.TenantQuota, limits)
+}
+
+// reloader applies the configs read on SIGHUP to the running server. The
+// log level and the quotas change straight away, and the retention from
+// the next eviction; the other settings take effect on restart.
+type reloader struct {
+	current Config
+	level   *slog.LevelVar
+	evictor *Evictor
+	quotas  *Quotas
+}
+
+// apply changes the running server to the config, logging the settings
+// changed. Only the settings it applies are kept as current, so the others
+// are logged as pending on every reload until the restart.
+func (r *reloader) apply(next Config) {
+	old := r.current
+	r.level.Set(next.LogLevel)
+	r.evictor.SetRetention(next.retention())
+	limits, _ := parseQuotas(next.TenantQuotas)
+	r.quotas.Set(next.TenantQuota, limits)
+	r.current.LogLevel = next.LogLevel
+	r.current.MaxValues = next.MaxValues
+	r.current.MaxAge = next.MaxAge
+	r.current.TenantQuota = next.TenantQuota
+	r.current.TenantQuotas = next.TenantQuotas
+
+	slog.Info("reloaded config", "applied", configfile.Changed(old, r.current))
+	if pending := configfile.Changed(r.current, next); len(pending) > 0 {
+		slog.Warn("settings changed that take effect on restart", "settings", pending)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeConfigFile writes the JSON settings to a file, returning its name
+func writeConfigFile(t *testing.T, settings string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(file, []byte(settings), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestParseConfigPrecedence(t *testing.T) {
+	t.Setenv("ARCHIVE_PREFIX", "env/")
+	t.Setenv("LOG_LEVEL", "warn")
+	file := writeConfigFile(t, `{"archive-prefix": "file/", "log-level": "debug", "archive-interval": "30s", "store": "sqlite"}`)
+
+	config, err := parseConfig([]string{"-config", file, "-store", "postgres"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The command line beats the file, which beats the environment
+	if config.StoreKind != "postgres" {
+		t.Errorf("got store %q, want %q", config.StoreKind, "postgres")
+	}
+	if config.ArchivePrefix != "file/" {
+		t.Errorf("got archive prefix %q, want %q", config.ArchivePrefix, "file/")
+	}
+	if config.LogLevel != slog.LevelDebug {
+		t.Errorf("got log level %v, want %v", config.LogLevel, slog.LevelDebug)
+	}
+	if config.ArchiveInterval != 30*time.Second {
+		t.Errorf("got archive interval %v, want 30s", config.ArchiveInterval)
+	}
+}
+
+func TestParseConfigInvalid(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		settings string
+	}{
+		{"unknown setting", `{"stores": "sqlite"}`},
+		{"not an object", `["sqlite"]`},
+		{"bad value", `{"archive-interval": "often"}`},
+		{"not a string or number", `{"store": {"kind": "sqlite"}}`},
+		{"zero interval", `{"archive-interval": "0s"}`},
+		{"negative max values", `{"max-values": -1}`},
+		{"negative max age", `{"max-age": "-1h"}`},
+		{"negative tenant quota", `{"tenant-quota": -1}`},
+		{"bad tenant quotas", `{"tenant-quotas": "serverB"}`},
+		{"archive bucket and dir", `{"archive-bucket": "values", "archive-dir": "/var/lib/serverc"}`},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			if _, err := parseConfig([]string{"-config", writeConfigFile(t, tC.settings)}, io.Discard); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"shared/natsqueue"
+)
+
+// consume stores a value from serverB sent over the queue, as /post does.
+// A message that cannot be decoded, fails validation or is over its
+// service's quota is rejected; one whose value could not be stored is
+// redelivered.
+func (sm *GlobalVarManager) consume(ctx context.Context, data []byte) error {
+	var req postRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return natsqueue.Reject(fmt.Errorf("invalid JSON body: %v", err))
+	}
+	if err := req.validate(); err != nil {
+		return natsqueue.Reject(err)
+	}
+	_, err := sm.save(ctx, req)
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return natsqueue.Reject(err)
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"shared/natsqueue"
+)
+
+func TestConsume(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		data         string
+		wantRejected bool
+	}{
+		{"valid", `{"serviceName":"serverB","value":108}`, false},
+		{"invalid JSON", `{"serviceName":`, true},
+		{"unknown field", `{"serviceName":"serverB","value":108,"extra":1}`, true},
+		{"missing value", `{"serviceName":"serverB"}`, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			gm := NewGlobalVarManager(NewMemoryStore())
+
+			err := gm.consume(context.Background(), []byte(tc.data))
+			var rejected natsqueue.RejectedError
+			if tc.wantRejected != errors.As(err, &rejected) || (!tc.wantRejected && err != nil) {
+				t.Fatalf("got error %v, want rejected %v", err, tc.wantRejected)
+			}
+
+			values, _, err := gm.store.Find(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantValues := 1
+			if tc.wantRejected {
+				wantValues = 0
+			}
+			if len(values) != wantValues {
+				t.Errorf("got %v stored, want %v values", values, wantValues)
+			}
+		})
+	}
+}
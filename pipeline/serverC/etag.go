@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// etagOf returns the entity tag of a response body: a hash of the bytes,
+// so it changes whenever the values listed do, or how they are encoded.
+// It is worked out from the body rather than from a version kept by the
+// server, as several servers may share one database, and values are
+// evicted and deleted as well as added.
+func etagOf(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether a GET or HEAD with the If-None-Match header
+// already has the representation tagged etag, so it can be answered with a
+// 304 rather than the body again. Tags are compared weakly, ignoring any
+// W/ prefix, as RFC 9110 asks of If-None-Match.
+func notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	header := r.Header.Get("If-None-Match")
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotModified(t *testing.T) {
+	const etag = `"abc123"`
+	testCases := []struct {
+		desc        string
+		method      string
+		ifNoneMatch string
+		want        bool
+	}{
+		{"no header", http.MethodGet, "", false},
+		{"match", http.MethodGet, `"abc123"`, true},
+		{"head", http.MethodHead, `"abc123"`, true},
+		{"weak", http.MethodGet, `W/"abc123"`, true},
+		{"one of several", http.MethodGet, `"old", "abc123"`, true},
+		{"any", http.MethodGet, "*", true},
+		{"changed", http.MethodGet, `"old"`, false},
+		{"unquoted", http.MethodGet, "abc123", false},
+		{"post", http.MethodPost, `"abc123"`, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := httptest.NewRequest(tc.method, "/get", nil)
+			if tc.ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", tc.ifNoneMatch)
+			}
+			if got := notModified(request, etag); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// A client polling /get is answered with a 304 until the values change
+func TestGetConditional(t *testing.T) {
+	store := NewMemoryStore()
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	gm := NewGlobalVarManager(store)
+
+	get := func(path, accept, ifNoneMatch string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept", accept)
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		response := httptest.NewRecorder()
+		gm.getCall(response, request)
+		return response
+	}
+
+	first := get("/get", mediaJSON, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d and ETag %q, want 200 with an ETag", first.Code, etag)
+	}
+
+	unchanged := get("/get", mediaJSON, etag)
+	if unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Errorf("got status %d with %d bytes, want an empty 304", unchanged.Code, unchanged.Body.Len())
+	}
+	if got := unchanged.Header().Get("ETag"); got != etag {
+		t.Errorf("got ETag %q on the 304, want %q", got, etag)
+	}
+
+	// Each representation has its own tag
+	if csv := get("/get", mediaCSV, etag); csv.Code != http.StatusOK || csv.Header().Get("ETag") == etag {
+		t.Errorf("got status %d and ETag %q for CSV, want 200 with another ETag", csv.Code, csv.Header().Get("ETag"))
+	}
+	if filtered := get("/get?serviceName=serviceD", mediaJSON, etag); filtered.Code != http.StatusOK {
+		t.Errorf("got status %d for other values, want 200", filtered.Code)
+	}
+
+	store.Add(Value{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120})
+	changed := get("/get", mediaJSON, etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Errorf("got status %d and ETag %q once a value was added, want 200 with a new ETag", changed.Code, changed.Header().Get("ETag"))
+	}
+}
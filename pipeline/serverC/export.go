@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// exportPageSize is how many values are read from the store, and
+	// written to the client, at a time
+	exportPageSize = 1000
+	// exportWriteWait bounds the write of each page to a client
+	exportWriteWait = 10 * time.Second
+)
+
+// exportCall handles the /export route, streaming the values matching the
+// serviceName, from and to query parameters of /get as a CSV file, so they
+// can be opened straight in a spreadsheet. format must be csv, which is
+// also the default.
+//
+// The values are read and written a page at a time, flushing each page,
+// so an export of the whole store neither holds it in memory nor is cut
+// off by the server's write timeout. Values evicted while an export is
+// under way may shift later pages, skipping as many values.
+func (sm *GlobalVarManager) exportCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "csv" {
+		writeError(w, http.StatusBadRequest, "format must be csv")
+		return
+	}
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit != 0 || filter.Offset != 0 {
+		writeError(w, http.StatusBadRequest, "limit and offset are not supported, as every value is exported")
+		return
+	}
+
+	// The first page is read before responding, so a store that cannot be
+	// read is still answered with a 500
+	filter.Limit = exportPageSize
+	values, total, err := sm.store.Find(filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not read values", "err", err)
+		writeError(w, http.StatusInternalServerError, "could not read values")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	filename := "values-" + time.Now().UTC().Format("20060102T150405Z") + ".csv"
+	w.Header().Set("Content-Type", mediaCSV+"; charset=utf-8; header=present")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "timestamp", "serviceName", "value"})
+	exported := 0
+	for {
+		for _, v := range values {
+			cw.Write([]string{strconv.FormatInt(v.ID, 10), v.Timestamp, spreadsheetSafe(v.ServiceName), strconv.Itoa(v.Value)})
+		}
+		exported += len(values)
+
+		// The server's write timeout would cut off a long export, so each
+		// page is given its own deadline instead
+		rc.SetWriteDeadline(time.Now().Add(exportWriteWait))
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			slog.WarnContext(r.Context(), "export ended early", "exported", exported, "err", err)
+			return
+		}
+		rc.Flush()
+
+		if len(values) < exportPageSize || r.Context().Err() != nil {
+			break
+		}
+		filter.Offset += exportPageSize
+		if values, _, err = sm.store.Find(filter); err != nil {
+			// The status has been sent, so the export can only be cut
+			// short; the client sees fewer rows than X-Total-Count
+			slog.ErrorContext(r.Context(), "could not read values, export cut short", "exported", exported, "err", err)
+			return
+		}
+	}
+	slog.InfoContext(r.Context(), "exported values", "exported", exported)
+}
+
+// spreadsheetSafe stops a cell being taken for a formula by spreadsheets,
+// which would run one starting with =, +, - or @, by quoting it with a
+// leading apostrophe
+func spreadsheetSafe(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportCall(t *testing.T) {
+	// More values than fit in a page, so the export reads several
+	store := NewMemoryStore()
+	for i := 0; i < 2*exportPageSize+500; i++ {
+		store.Add(Value{Timestamp: fmt.Sprintf("2020-11-20T10:%02d:%02dZ", i/60%60, i%60), ServiceName: "serverB", Value: i})
+	}
+	store.Add(Value{Timestamp: "2020-11-20T11:00:00Z", ServiceName: "=cmd()", Value: 1})
+	gm := NewGlobalVarManager(store)
+
+	testCases := []struct {
+		desc       string
+		query      string
+		wantStatus int
+		wantRows   int
+	}{
+		{"every value", "", http.StatusOK, 2*exportPageSize + 501},
+		{"csv", "?format=csv", http.StatusOK, 2*exportPageSize + 501},
+		{"filtered", "?serviceName=serverB&from=2020-11-20T10:00:00Z&to=2020-11-20T10:00:10Z", http.StatusOK, 10},
+		{"none matching", "?serviceName=serviceD", http.StatusOK, 0},
+		{"xlsx", "?format=xlsx", http.StatusBadRequest, 0},
+		{"paged", "?limit=10", http.StatusBadRequest, 0},
+		{"bad time", "?from=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			gm.exportCall(response, httptest.NewRequest(http.MethodGet, "/export"+tc.query, nil))
+
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v: %s", response.Code, tc.wantStatus, response.Body)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := response.Header().Get("Content-Disposition"); got == "" {
+				t.Error("got no Content-Disposition, want an attachment")
+			}
+			records, err := csv.NewReader(response.Body).ReadAll()
+			if err != nil {
+				t.Fatalf("reading CSV: %v", err)
+			}
+			if fmt.Sprint(records[0]) != "[id timestamp serviceName value]" {
+				t.Errorf("got header %v", records[0])
+			}
+			if got := len(records) - 1; got != tc.wantRows {
+				t.Errorf("got %v rows, want %v", got, tc.wantRows)
+			}
+		})
+	}
+}
+
+func TestSpreadsheetSafe(t *testing.T) {
+	testCases := []struct {
+		cell string
+		want string
+	}{
+		{"serverB", "serverB"},
+		{"", ""},
+		{"=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+	}
+	for _, tc := range testCases {
+		if got := spreadsheetSafe(tc.cell); got != tc.want {
+			t.Errorf("spreadsheetSafe(%q) = %q, want %q", tc.cell, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrSlowSubscriber closes a subscriber whose buffer filled up
+	ErrSlowSubscriber = errors.New("subscriber fell behind")
+	// ErrHubClosed closes the subscribers of a hub that has been closed
+	ErrHubClosed = errors.New("hub closed")
+)
+
+// Event is a value published to a hub. Events are numbered from 1 in the
+// order they are published; the numbering starts again when the server
+// restarts.
+type Event struct {
+	ID    uint64
+	Value Value
+}
+
+// Hub passes each newly stored value on to the clients streaming them.
+// Each subscriber has its own buffer, so a slow client cannot hold up the
+// others or the handler posting the value. The most recent events are
+// kept so clients can catch up after reconnecting.
+type Hub struct {
+	historySize int
+
+	mu          sync.Mutex // protects the fields below
+	subscribers map[*Subscriber]struct{}
+	history     []Event // the most recent events, oldest first
+	lastID      uint64
+	closed      bool
+}
+
+// Subscriber receives the events published to a hub
+type Subscriber struct {
+	// C receives the events. It is closed if the subscriber falls so far
+	// behind that its buffer fills, dropping the events it missed, or if
+	// the hub is closed.
+	C chan Event
+	// Err says why C was closed, and is set before it is
+	Err error
+}
+
+// NewHub returns a hub keeping the last historySize events
+func NewHub(historySize int) *Hub {
+	return &Hub{
+		historySize: historySize,
+		subscribers: make(map[*Subscriber]struct{}),
+	}
+}
+
+// Subscribe returns a subscriber buffering up to buffer events
+func (h *Hub) Subscribe(buffer int) *Subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.add(buffer)
+}
+
+// SubscribeSince returns a subscriber buffering up to buffer events, along
+// with the events after lastID still held by the hub, so none are missed
+// or repeated in between. A lastID after the latest event is taken to be
+// from before the server restarted, and every event held is returned.
+func (h *Hub) SubscribeSince(buffer int, lastID uint64) (*Subscriber, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.add(buffer)
+	if s.Err != nil {
+		return s, nil
+	}
+	if lastID > h.lastID {
+		lastID = 0
+	}
+	var missed []Event
+	for _, e := range h.history {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+	return s, missed
+}
+
+// add returns a new subscriber, closed if the hub is. h.mu must be held.
+func (h *Hub) add(buffer int) *Subscriber {
+	s := &Subscriber{C: make(chan Event, buffer)}
+	if h.closed {
+		s.Err = ErrHubClosed
+		close(s.C)
+		return s
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops events being sent to the subscriber. It is safe to
+// call for a subscriber that has already been closed.
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.C)
+	}
+}
+
+// Publish sends the value to every subscriber without blocking, closing
+// those whose buffers are full
+func (h *Hub) Publish(v Value) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.lastID++
+	e := Event{ID: h.lastID, Value: v}
+	h.history = append(h.history, e)
+	if len(h.history) > h.historySize {
+		h.history = h.history[len(h.history)-h.historySize:]
+	}
+
+	for s := range h.subscribers {
+		select {
+		case s.C <- e:
+		default:
+			h.remove(s, ErrSlowSubscriber)
+		}
+	}
+}
+
+// Close closes every subscriber, so the streams end when the server shuts
+// down. Later subscribers are closed straight away.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subscribers {
+		h.remove(s, ErrHubClosed)
+	}
+}
+
+// remove closes the subscriber with the error. h.mu must be held.
+func (h *Hub) remove(s *Subscriber, err error) {
+	delete(h.subscribers, s)
+	s.Err = err
+	close(s.C)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHub(t *testing.T) {
+	hub := NewHub(10)
+	fast := hub.Subscribe(2)
+	slow := hub.Subscribe(1)
+
+	hub.Publish(Value{Value: 108})
+	if e := <-fast.C; e.ID != 1 || e.Value.Value != 108 {
+		t.Errorf("got %+v, want event 1 of 108", e)
+	}
+
+	// slow has not read its first value, so is evicted by the second
+	hub.Publish(Value{Value: 109})
+	if e := <-fast.C; e.ID != 2 || e.Value.Value != 109 {
+		t.Errorf("got %+v, want event 2 of 109", e)
+	}
+	if e := <-slow.C; e.Value.Value != 108 {
+		t.Errorf("got %v, want the buffered 108", e.Value.Value)
+	}
+	if _, ok := <-slow.C; ok || slow.Err != ErrSlowSubscriber {
+		t.Errorf("slow subscriber was not evicted, got error %v", slow.Err)
+	}
+	hub.Unsubscribe(slow)
+
+	hub.Unsubscribe(fast)
+	if _, ok := <-fast.C; ok {
+		t.Error("got a value after unsubscribing")
+	}
+	hub.Publish(Value{Value: 110})
+}
+
+func TestHubSubscribeSince(t *testing.T) {
+	hub := NewHub(3)
+	for i := 1; i <= 5; i++ {
+		hub.Publish(Value{Value: 100 + i})
+	}
+
+	testCases := []struct {
+		desc   string
+		lastID uint64
+		want   []uint64
+	}{
+		{"caught up", 5, nil},
+		{"missed some", 3, []uint64{4, 5}},
+		{"missed more than held", 1, []uint64{3, 4, 5}},
+		{"new client", 0, []uint64{3, 4, 5}},
+		{"before a restart", 9, []uint64{3, 4, 5}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			sub, missed := hub.SubscribeSince(1, tc.lastID)
+			defer hub.Unsubscribe(sub)
+
+			var got []uint64
+			for _, e := range missed {
+				got = append(got, e.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("got events %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub(1)
+	sub := hub.Subscribe(1)
+	hub.Close()
+
+	if _, ok := <-sub.C; ok || sub.Err != ErrHubClosed {
+		t.Errorf("subscriber was not closed, got error %v", sub.Err)
+	}
+	if later := hub.Subscribe(1); later.Err != ErrHubClosed {
+		t.Errorf("got error %v subscribing to a closed hub", later.Err)
+	}
+	hub.Publish(Value{Value: 108})
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"server/pipelinepb"
+	"shared/configfile"
+	"shared/health"
+	"shared/httpserver"
+	"shared/jsonschema"
+	"shared/logging"
+	"shared/natsqueue"
+	"shared/openapi"
+	"shared/rpc"
+	"shared/telemetry"
+	"shared/tlsconfig"
+)
+
+const defaultListenAddr string = "0.0.0.0:15000"
+
+type ScheduleType int
+
+const (
+	direct ScheduleType = iota + 1
+	response
+)
+
+func (s ScheduleType) String() string {
+	switch s {
+	case direct:
+		return "direct"
+	case response:
+		return "response"
+	default:
+		return "invalid"
+	}
+}
+
+// Value struct. ID is given by the store once the value is added.
+type Value struct {
+	ID          int64  `json:"id,omitempty"`
+	Timestamp   string `json:"timestamp"`
+	ServiceName string `json:"serviceName"`
+	Value       int    `json:"value"`
+}
+
+// GlobalVarManager holds the state shared by the handlers. The store is
+// safe for concurrent use, so needs no lock of its own.
+type GlobalVarManager struct {
+	store    Store
+	quotas   *Quotas // limits the values each tenant may have stored
+	hub      *Hub    // streams stored values to /ws and /events clients
+	webhooks *Webhooks
+	upgrader websocket.Upgrader
+}
+
+func NewGlobalVarManager(store Store) *GlobalVarManager {
+	return &GlobalVarManager{
+		store:    store,
+		quotas:   NewQuotas(0, nil),
+		hub:      NewHub(eventHistory),
+		webhooks: NewWebhooks(nil, nil),
+	}
+}
+
+// Limits on the fields posted to the /post route
+const (
+	maxServiceNameLen = 64
+	minValue          = -1000000
+	maxValue          = 1000000
+)
+
+// postRequest is the body of the /post route. Value is a pointer so a
+// missing value can be told apart from zero.
+type postRequest struct {
+	ServiceName string `json:"serviceName"`
+	Value       *int   `json:"value"`
+}
+
+// validate returns the first problem found with the fields of the request
+func (p postRequest) validate() error {
+	switch {
+	case strings.TrimSpace(p.ServiceName) == "":
+		return errors.New("serviceName is required")
+	case len(p.ServiceName) > maxServiceNameLen:
+		return fmt.Errorf("serviceName must be at most %d characters", maxServiceNameLen)
+	case p.Value == nil:
+		return errors.New("value is required")
+	case *p.Value < minValue || *p.Value > maxValue:
+		return fmt.Errorf("value must be between %d and %d", minValue, maxValue)
+	}
+	return nil
+}
+
+// postCall handles the /post route, storing the value posted plus 100.
+// The body must be a single JSON object with only the fields of
+// postRequest; one that cannot be decoded is a 400 and one that fails
+// validation a 422.
+func (sm *GlobalVarManager) postCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "method must be POST")
+		return
+	}
+
+	req, status, err := decodePost(r)
+	if err != nil {
+		writeRequestError(w, status, err)
+		return
+	}
+	if _, err := sm.save(r.Context(), req); err != nil {
+		writeSaveError(w, err)
+		return
+	}
+
+	fmt.Fprint(w, "POST done")
+}
+
+// decodePost reads and validates the body of a post, returning the status
+// to respond with if it is not acceptable. The body is checked against
+// the post schema before it is decoded: one that is not JSON, or holds
+// fields of the wrong type or unknown ones, is a 400, and one whose fields
+// are out of bounds a 422.
+func decodePost(r *http.Request) (postRequest, int, error) {
+	var req postRequest
+	body, err := io.ReadAll(r.Body)
+	if httpserver.BodyTooLarge(err) {
+		return req, http.StatusRequestEntityTooLarge, fmt.Errorf("body must be at most %d bytes", maxPostBytes)
+	} else if err != nil {
+		return req, http.StatusBadRequest, fmt.Errorf("reading body: %v", err)
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return req, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return req, http.StatusBadRequest, errors.New("body must hold a single JSON object")
+	}
+	if err := postSchema.Validate(doc); err != nil {
+		var se *jsonschema.Error
+		if errors.As(err, &se) && se.Malformed() {
+			return req, http.StatusBadRequest, err
+		}
+		return req, http.StatusUnprocessableEntity, err
+	}
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return req, http.StatusOK, nil
+}
+
+// save stores the value of a validated request plus 100, streams it to
+// the /ws and /events clients and queues it for the subscribers. It
+// returns the value stored, or a *QuotaError if the service is at its
+// quota.
+func (sm *GlobalVarManager) save(ctx context.Context, req postRequest) (Value, error) {
+	slog.InfoContext(ctx, "received value", "service_name", req.ServiceName, "value", *req.Value)
+
+	value := Value{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		ServiceName: req.ServiceName,
+		Value:       *req.Value + 100,
+	}
+	value, err := sm.quotas.add(sm.store, value)
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		slog.WarnContext(ctx, "refused value over quota", "service_name", quotaErr.Tenant, "quota", quotaErr.Quota)
+		return value, err
+	} else if err != nil {
+		slog.ErrorContext(ctx, "could not store value", "err", err)
+		return value, err
+	}
+	sm.hub.Publish(value)
+	sm.webhooks.Publish(value)
+	return value, nil
+}
+
+// writeSaveError responds to a post whose value save did not store: a 429
+// if the service is at its quota, which a retry may get past once values
+// are evicted or deleted, and a 500 otherwise
+func writeSaveError(w http.ResponseWriter, err error) {
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		writeError(w, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, "could not store value")
+}
+
+// getCall handles the /get route. The values can be filtered by the
+// serviceName, from and to query parameters and paged through with limit
+// and offset; the number of matching values is returned in the
+// X-Total-Count header. They are listed as JSON, CSV or protobuf, as the
+// Accept header prefers, and tagged with an ETag for conditional GETs.
+func (sm *GlobalVarManager) getCall(w http.ResponseWriter, r *http.Request) {
+	sm.serveValues(w, r, false)
+}
+
+// serveValues lists the values matching the query parameters as the
+// Accept header prefers. If paged, JSON lists are wrapped in a valuesPage.
+func (sm *GlobalVarManager) serveValues(w http.ResponseWriter, r *http.Request, paged bool) {
+	w.Header().Add("Vary", "Accept")
+	mediaType, ok := negotiate(r.Header.Get("Accept"), mediaJSON, mediaCSV, mediaProtobuf)
+	if !ok {
+		writeError(w, http.StatusNotAcceptable, fmt.Sprintf("Accept must allow one of %s, %s or %s", mediaJSON, mediaCSV, mediaProtobuf))
+		return
+	}
+
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	values, total, err := sm.store.Find(filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not read values", "err", err)
+		writeError(w, http.StatusInternalServerError, "could not read values")
+		return
+	}
+
+	var body []byte
+	if paged && mediaType == mediaJSON {
+		body, err = json.Marshal(valuesPage{Values: values, Total: total, Limit: filter.Limit, Offset: filter.Offset})
+	} else {
+		body, err = encodeValues(mediaType, values)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "could not encode values")
+		return
+	}
+
+	// Polling clients can send the ETag back in If-None-Match, and are
+	// answered with a 304 until the values they listed change
+	etag := etagOf(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if notModified(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Write(body)
+}
+
+// statsCall handles the /stats route, summarising the values of each
+// service as JSON. The values can be filtered by the serviceName, from and
+// to query parameters as for /get, or window can give the duration up to
+// now to summarise, such as 1h.
+func (sm *GlobalVarManager) statsCall(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if query.Get("window") != "" {
+		window, err := time.ParseDuration(query.Get("window"))
+		if err != nil || window <= 0 {
+			writeError(w, http.StatusBadRequest, "window must be a positive duration")
+			return
+		}
+		if filter.From != "" || filter.To != "" {
+			writeError(w, http.StatusBadRequest, "window cannot be combined with from or to")
+			return
+		}
+		filter.From = time.Now().Add(-window).UTC().Format(time.RFC3339)
+	}
+
+	stats, err := sm.store.Stats(filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not summarise values", "err", err)
+		writeError(w, http.StatusInternalServerError, "could not summarise values")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// errorResponse is the body of the responses to requests that failed
+type errorResponse struct {
+	Error string `json:"error"`
+	// The fields of a body failing its schema
+	Fields []jsonschema.FieldError `json:"fields,omitempty"`
+}
+
+// writeError responds with the status and a JSON body describing the error
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: message})
+}
+
+// writeRequestError responds with the status and a JSON body describing
+// err, listing the fields at fault if it is a *jsonschema.Error
+func writeRequestError(w http.ResponseWriter, status int, err error) {
+	body := errorResponse{Error: err.Error()}
+	var schemaErr *jsonschema.Error
+	if errors.As(err, &schemaErr) {
+		body.Fields = schemaErr.Fields
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// parseFilter reads the filter of the /get route from the query parameters
+func parseFilter(query url.Values) (Filter, error) {
+	filter := Filter{ServiceName: query.Get("serviceName")}
+
+	for _, bound := range []struct {
+		name string
+		dst  *string
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		if query.Get(bound.name) == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, query.Get(bound.name))
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC3339 time", bound.name)
+		}
+		*bound.dst = t.UTC().Format(time.RFC3339)
+	}
+
+	for _, page := range []struct {
+		name string
+		dst  *int
+	}{
+		{"limit", &filter.Limit},
+		{"offset", &filter.Offset},
+	} {
+		if query.Get(page.name) == "" {
+			continue
+		}
+		n, err := strconv.Atoi(query.Get(page.name))
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("%s must be a non-negative integer", page.name)
+		}
+		*page.dst = n
+	}
+
+	return filter, nil
+}
+
+func main() {
+	config, err := parseConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	var level slog.LevelVar
+	level.Set(config.LogLevel)
+	logger := logging.New(os.Stdout, &level)
+	slog.SetDefault(logger)
+	if err != nil {
+		logger.Error("invalid config", "err", err)
+		os.Exit(1)
+	}
+	logger.Info("server is starting")
+
+	serverTLS, serverCert, err := tlsconfig.Server(config.TLSCert, config.TLSKey, config.TLSClientCA)
+	if err != nil {
+		logger.Error("invalid server TLS", "err", err)
+		os.Exit(1)
+	}
+	tlsconfig.ReloadOnSIGHUP(serverCert)
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "serverC")
+	if err != nil {
+		logger.Error("could not set up tracing", "err", err)
+		os.Exit(1)
+	}
+
+	store, err := OpenStore(config.StoreKind, config.StoreDSN)
+	if err != nil {
+		logger.Error("could not open store", "store", config.StoreKind, "err", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	logger.Info("storing values", "store", config.StoreKind, "max_values", config.MaxValues, "max_age", config.MaxAge, "tenant_quota", config.TenantQuota, "tenant_quotas", config.TenantQuotas)
+
+	// The values beyond the retention are evicted, so the store does not
+	// grow for as long as the server runs
+	evictor := NewEvictor(store, config.retention(), evictionInterval)
+	quotas := config.quotas()
+	reload := &reloader{current: config, level: &level, evictor: evictor, quotas: quotas}
+	configfile.ReloadOnSIGHUP(os.Args[1:], parseConfig, reload.apply)
+
+	// Snapshots of the values are archived if somewhere is given to keep
+	// them, and the latest is restored into an empty store before any
+	// values arrive, so they are not lost when the server is redeployed
+	var archiver *Archiver
+	if config.ArchiveDir != "" {
+		archiver = NewArchiver(store, NewDirObjects(config.ArchiveDir), config.ArchiveDir, config.ArchivePrefix, config.ArchiveInterval)
+	}
+	if config.ArchiveBucket != "" {
+		objects, err := NewS3Objects(context.Background(), config.ArchiveBucket)
+		if err != nil {
+			logger.Error("could not connect to S3", "bucket", config.ArchiveBucket, "err", err)
+			os.Exit(1)
+		}
+		archiver = NewArchiver(store, objects, "s3://"+config.ArchiveBucket, config.ArchivePrefix, config.ArchiveInterval)
+	}
+	if archiver != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		key, restored, err := archiver.Restore(ctx)
+		cancel()
+		switch {
+		case errors.Is(err, ErrNoSnapshot):
+			logger.Info("no snapshot to restore")
+		case err != nil:
+			logger.Error("could not restore values", "key", key, "err", err)
+			os.Exit(1)
+		case key != "":
+			logger.Info("restored values", "key", key, "values", restored)
+		}
+	}
+
+	gm := NewGlobalVarManager(store)
+	gm.quotas = quotas
+
+	// Each value stored is posted to the subscribers, and those that
+	// cannot be delivered are appended to the dead-letter log
+	var deadLetters io.Writer
+	if config.WebhookDeadLetters != "" {
+		file, err := os.OpenFile(config.WebhookDeadLetters, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			logger.Error("could not open dead-letter log", "path", config.WebhookDeadLetters, "err", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		deadLetters = file
+	}
+	gm.webhooks = NewWebhooks(telemetry.Transport(newWebhookTransport()), deadLetters)
+	corsConfig := httpserver.NewCORSConfig(config.CORSOrigins, config.CORSMethods, config.CORSHeaders, corsExposedHeaders)
+	gm.upgrader.CheckOrigin = wsCheckOrigin(corsConfig)
+
+	// Values from serverB are consumed from the queue as well as posted
+	checks := []health.Check{{Name: "store", Check: store.Ping}}
+	stopConsuming := func() {}
+	if config.NATSURL != "" {
+		queue, err := natsqueue.Connect(context.Background(), config.NATSURL, "serverC")
+		if err != nil {
+			logger.Error("could not connect to NATS", "url", config.NATSURL, "err", err)
+			os.Exit(1)
+		}
+		defer queue.Close()
+		stopConsuming, err = queue.Consume(context.Background(), "serverC", natsqueue.SubjectServerC, logger, gm.consume)
+		if err != nil {
+			logger.Error("could not consume values", "subject", natsqueue.SubjectServerC, "err", err)
+			os.Exit(1)
+		}
+		checks = append(checks, health.Check{Name: "queue", Check: queue.Check})
+		logger.Info("consuming values", "url", config.NATSURL, "subject", natsqueue.SubjectServerC)
+	}
+
+	router := http.NewServeMux()
+	router.Handle("/", httpserver.Index("This is Server C!"))
+	v1 := gm.apiV1()
+	mountAPI(router, "", v1)
+	mountAPI(router, "/v1", v1)
+	mountAPI(router, "/v2", gm.apiV2())
+	router.HandleFunc("/openapi.json", openapi.Serve(apiSpec()))
+	router.HandleFunc("/docs", openapi.SwaggerUI("serverC API", "/openapi.json"))
+	router.HandleFunc("/ws", gm.wsCall)
+	router.HandleFunc("/events", gm.eventsCall)
+	router.HandleFunc("/export", gm.exportCall)
+	router.HandleFunc("/healthz", health.Healthz)
+	router.HandleFunc("/readyz", health.Readyz(checks...))
+
+	// Failures can be injected into the requests at /admin/chaos, to show
+	// the retries and circuit breakers of the callers working, and the
+	// health checks failed at /admin/health, to drill draining an instance.
+	// /admin/vars serves the panics recovered along with the runtime's
+	// memory statistics. Subscriptions need the token too, as serverC
+	// posts to whatever URL they name, which may be inside its network.
+	chaos := httpserver.NewChaos()
+	if config.AdminToken != "" {
+		admin := httpserver.Admin(config.AdminToken)
+		router.Handle("/admin/chaos", admin(chaos))
+		router.Handle("/admin/health", admin(http.HandlerFunc(health.Admin)))
+		router.Handle("/admin/vars", admin(expvar.Handler()))
+		router.Handle("/subscriptions", admin(subscriptionsRoute(gm.subscriptionsCall)))
+		router.Handle("/subscriptions/", admin(queryRoute(gm.subscriptionCall)))
+	} else {
+		logger.Info("subscriptions disabled, as no admin token is set")
+	}
+
+	router.HandleFunc("/retention/status", evictor.serveStatus)
+	stopEvicting := evictor.Start()
+	stopWebhooks := gm.webhooks.Start(webhookWorkers)
+
+	stopArchiving := func() {}
+	if archiver != nil {
+		router.HandleFunc("/archive/status", archiver.serveStatus)
+		stopArchiving = archiver.Start()
+		logger.Info("archiving values", "location", archiver.location, "prefix", config.ArchivePrefix, "interval", config.ArchiveInterval)
+	}
+
+	server := httpserver.NewServer(httpserver.Options{
+		Addr: config.ListenAddr,
+		Handler: httpserver.Chain(router,
+			telemetry.Middleware("serverC"),
+			httpserver.RequestID(httpserver.NewRequestID),
+			httpserver.Logging(logger),
+			httpserver.Recover(logger),
+			chaos.Middleware(),
+			httpserver.CORS(corsConfig),
+		),
+		TLSConfig: serverTLS,
+		Logger:    logger,
+	})
+
+	// Shutdown waits for requests to finish, so the streams are ended
+	server.RegisterOnShutdown(gm.hub.Close)
+	server.OnDrain(func() { health.SetHealthy(false) })
+	server.BeforeShutdown(func(context.Context) { stopConsuming() })
+
+	// The gRPC pipeline shares the HTTP server's TLS config and is stopped
+	// along with it
+	if config.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", config.GRPCAddr)
+		if err != nil {
+			logger.Error("could not listen", "addr", config.GRPCAddr, "err", err)
+			os.Exit(1)
+		}
+		grpcServer, grpcHealth := rpc.NewServer(logger, serverTLS)
+		pipelinepb.RegisterPipelineServer(grpcServer, &pipelineServer{gm: gm})
+		go func() {
+			logger.Info("serving gRPC", "addr", config.GRPCAddr)
+			if err := grpcServer.Serve(listener); err != nil {
+				logger.Error("could not serve gRPC", "addr", config.GRPCAddr, "err", err)
+				os.Exit(1)
+			}
+		}()
+		server.BeforeShutdown(func(ctx context.Context) {
+			grpcHealth.Shutdown()
+			rpc.Stop(ctx, grpcServer)
+		})
+	}
+
+	// Once no more values can arrive, eviction stops, the values posted
+	// since the last snapshot are archived and those queued for the
+	// subscribers are delivered
+	server.AfterShutdown(func(ctx context.Context) {
+		stopEvicting()
+		stopArchiving()
+		stopWebhooks(ctx)
+	})
+
+	health.SetHealthy(true)
+	if err := server.Run(context.Background()); err != nil {
+		logger.Error("server failed", "addr", config.ListenAddr, "err", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("could not flush traces", "err", err)
+	}
+	logger.Info("server stopped")
+}
+
+// envOr returns the value of the environment variable, or def if it is unset
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
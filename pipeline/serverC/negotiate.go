@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"server/pipelinepb"
+)
+
+// The media types /get can respond with
+const (
+	mediaJSON     = "application/json"
+	mediaCSV      = "text/csv"
+	mediaProtobuf = "application/x-protobuf"
+)
+
+// negotiate picks the media type to respond with from the Accept header:
+// the supported type the client gives the highest quality, or the first
+// of those with the same quality. Each type takes the quality of the most
+// specific range matching it, so "text/*;q=0.5, text/csv" prefers CSV. No
+// header accepts anything; ok is false if no supported type is acceptable.
+func negotiate(accept string, supported ...string) (mediaType string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return supported[0], true
+	}
+
+	type mediaRange struct {
+		typ, subtype string
+		q            float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, found := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !found {
+			continue
+		}
+		r := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) == "q" {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		ranges = append(ranges, r)
+	}
+
+	bestQ := 0.0
+	for _, candidate := range supported {
+		typ, subtype, _ := strings.Cut(candidate, "/")
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			var s int
+			switch {
+			case r.typ == typ && r.subtype == subtype:
+				s = 2
+			case r.typ == typ && r.subtype == "*":
+				s = 1
+			case r.typ == "*" && r.subtype == "*":
+				s = 0
+			default:
+				continue
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			mediaType, bestQ = candidate, q
+		}
+	}
+	return mediaType, bestQ > 0
+}
+
+// encodeValues encodes the values as the media type, which must be one
+// of those /get supports
+func encodeValues(mediaType string, values []Value) ([]byte, error) {
+	switch mediaType {
+	case mediaJSON:
+		return json.Marshal(values)
+	case mediaCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"timestamp", "serviceName", "value"})
+		for _, v := range values {
+			w.Write([]string{v.Timestamp, v.ServiceName, strconv.Itoa(v.Value)})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	case mediaProtobuf:
+		list := &pipelinepb.ValueList{Values: make([]*pipelinepb.Value, len(values))}
+		for i, v := range values {
+			list.Values[i] = &pipelinepb.Value{
+				Timestamp:   v.Timestamp,
+				ServiceName: v.ServiceName,
+				Value:       int64(v.Value),
+			}
+		}
+		return proto.Marshal(list)
+	default:
+		return nil, fmt.Errorf("unsupported media type %q", mediaType)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"server/pipelinepb"
+)
+
+func TestNegotiate(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   string
+		wantOK bool
+	}{
+		{"", mediaJSON, true},
+		{"*/*", mediaJSON, true},
+		{"text/csv", mediaCSV, true},
+		{"application/x-protobuf", mediaProtobuf, true},
+		{"application/*", mediaJSON, true},
+		{"application/json;q=0.5, text/csv", mediaCSV, true},
+		{"text/*;q=0.5, text/csv", mediaCSV, true},
+		{"*/*;q=0.1, application/x-protobuf;q=0.9", mediaProtobuf, true},
+		{"TEXT/CSV", mediaCSV, true},
+		{"text/html", "", false},
+		{"application/json;q=0", "", false},
+		{"*/*, application/json;q=0", mediaCSV, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.accept, func(t *testing.T) {
+			got, ok := negotiate(tc.accept, mediaJSON, mediaCSV, mediaProtobuf)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestGetContentNegotiation(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	for _, v := range []Value{
+		{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120},
+	} {
+		if _, err := gm.store.Add(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "/get", nil)
+		request.Header.Set("Accept", accept)
+		response := httptest.NewRecorder()
+		gm.getCall(response, request)
+		if got := response.Header().Get("Vary"); got != "Accept" {
+			t.Errorf("got Vary %q, want Accept", got)
+		}
+		return response
+	}
+
+	t.Run("json", func(t *testing.T) {
+		response := get(mediaJSON)
+		var values []Value
+		if err := json.NewDecoder(response.Body).Decode(&values); err != nil {
+			t.Fatal(err)
+		}
+		if response.Header().Get("Content-Type") != mediaJSON || len(values) != 2 || values[1].Value != 120 {
+			t.Errorf("got %v as %q", values, response.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		response := get(mediaCSV)
+		records, err := csv.NewReader(response.Body).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := [][]string{
+			{"timestamp", "serviceName", "value"},
+			{"2020-11-20T10:00:00Z", "serverB", "108"},
+			{"2020-11-20T10:00:01Z", "serverB", "120"},
+		}
+		if response.Header().Get("Content-Type") != mediaCSV || len(records) != len(want) {
+			t.Fatalf("got %v as %q, want %v", records, response.Header().Get("Content-Type"), want)
+		}
+		for i := range want {
+			for j := range want[i] {
+				if records[i][j] != want[i][j] {
+					t.Errorf("got %v, want %v", records, want)
+				}
+			}
+		}
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		response := get(mediaProtobuf)
+		var list pipelinepb.ValueList
+		if err := proto.Unmarshal(response.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if response.Header().Get("Content-Type") != mediaProtobuf || len(list.Values) != 2 || list.Values[0].Value != 108 || list.Values[0].ServiceName != "serverB" {
+			t.Errorf("got %v as %q", &list, response.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("not acceptable", func(t *testing.T) {
+		if got := get("text/html").Code; got != http.StatusNotAcceptable {
+			t.Errorf("got status %v, want %v", got, http.StatusNotAcceptable)
+		}
+	})
+}
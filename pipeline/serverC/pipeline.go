@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"server/pipelinepb"
+)
+
+// pipelineServer serves the gRPC variant of the /post route
+type pipelineServer struct {
+	pipelinepb.UnimplementedPipelineServer
+	gm *GlobalVarManager
+}
+
+// Send stores the value plus 100. A request failing validation is
+// INVALID_ARGUMENT, as /post responds 422, a value over its service's
+// quota RESOURCE_EXHAUSTED, as /post responds 429, and a value that could
+// not be stored is INTERNAL.
+func (s *pipelineServer) Send(ctx context.Context, req *pipelinepb.SendRequest) (*pipelinepb.SendResponse, error) {
+	value := int(req.Value)
+	post := postRequest{ServiceName: req.ServiceName, Value: &value}
+	if err := post.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	_, err := s.gm.save(ctx, post)
+	var quotaErr *QuotaError
+	if errors.As(err, &quotaErr) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.Internal, "could not store value")
+	}
+	return &pipelinepb.SendResponse{}, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"server/pipelinepb"
+	"shared/httpserver"
+	"shared/logging"
+	"shared/rpc"
+)
+
+// newTestPipeline serves gm's gRPC pipeline on a local port, logging to
+// logs, and returns a client connected to it
+func newTestPipeline(t *testing.T, gm *GlobalVarManager, logs *bytes.Buffer) pipelinepb.PipelineClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := rpc.NewServer(logging.New(logs, nil), nil)
+	pipelinepb.RegisterPipelineServer(server, &pipelineServer{gm: gm})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := rpc.Dial(listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pipelinepb.NewPipelineClient(conn)
+}
+
+func TestPipelineSend(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		req      *pipelinepb.SendRequest
+		wantCode codes.Code
+	}{
+		{"valid", &pipelinepb.SendRequest{ServiceName: "serverB", Value: 108}, codes.OK},
+		{"missing serviceName", &pipelinepb.SendRequest{Value: 108}, codes.InvalidArgument},
+		{"value out of range", &pipelinepb.SendRequest{ServiceName: "serverB", Value: maxValue + 1}, codes.InvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			gm := NewGlobalVarManager(NewMemoryStore())
+			client := newTestPipeline(t, gm, new(bytes.Buffer))
+
+			_, err := client.Send(context.Background(), tc.req)
+			if got := status.Code(err); got != tc.wantCode {
+				t.Fatalf("got code %v, want %v (%v)", got, tc.wantCode, err)
+			}
+
+			values, _, err := gm.store.Find(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantCode != codes.OK {
+				if len(values) != 0 {
+					t.Errorf("got %v stored, want none", values)
+				}
+				return
+			}
+			if len(values) != 1 || values[0].Value != int(tc.req.Value)+100 {
+				t.Errorf("got %v stored, want the value %v", values, tc.req.Value+100)
+			}
+		})
+	}
+}
+
+func TestPipelineRequestID(t *testing.T) {
+	logs := new(bytes.Buffer)
+	client := newTestPipeline(t, NewGlobalVarManager(NewMemoryStore()), logs)
+
+	var trailer metadata.MD
+	ctx := httpserver.WithRequestID(context.Background(), "abc123")
+	if _, err := client.Send(ctx, &pipelinepb.SendRequest{ServiceName: "serverB", Value: 8}, grpc.Trailer(&trailer)); err != nil {
+		t.Fatal(err)
+	}
+	if got := trailer.Get(rpc.RequestIDMetadata); len(got) != 1 || got[0] != "abc123" {
+		t.Errorf("got request ID trailer %v, want abc123", got)
+	}
+
+	// The interceptor's line carries the ID
+	decoder := json.NewDecoder(logs)
+	var lines int
+	for decoder.More() {
+		var line map[string]interface{}
+		if err := decoder.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines++
+		if line["request_id"] != "abc123" {
+			t.Errorf("got request_id %v in %v, want abc123", line["request_id"], line)
+		}
+		if line["msg"] != "rpc" || line["code"] != "OK" {
+			t.Errorf("got %v logged, want an rpc line with code OK", line)
+		}
+	}
+	if lines != 1 {
+		t.Errorf("got %v log lines, want 1", lines)
+	}
+}
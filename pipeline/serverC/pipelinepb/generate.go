@@ -0,0 +1,6 @@
+// Package pipelinepb holds the gRPC definitions of the pipeline, generated
+// from pipeline.proto, and the protobuf encoding of the values listed by
+// /get, generated from values.proto
+package pipelinepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pipeline.proto values.proto
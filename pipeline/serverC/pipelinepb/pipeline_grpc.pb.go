@@ -0,0 +1,120 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: pipeline.proto
+
+package pipelinepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Pipeline_Send_FullMethodName = "/pipeline.v1.Pipeline/Send"
+)
+
+// PipelineClient is the client API for Pipeline service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Pipeline is the gRPC variant of the pipeline, carrying the same values
+// as the /post routes from serviceA through serverB to serverC. It is
+// served by serverB and serverC.
+type PipelineClient interface {
+	// Send passes a value on to the service, as POST /post does
+	Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*SendResponse, error)
+}
+
+type pipelineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPipelineClient(cc grpc.ClientConnInterface) PipelineClient {
+	return &pipelineClient{cc}
+}
+
+func (c *pipelineClient) Send(ctx context.Context, in *SendRequest, opts ...grpc.CallOption) (*SendResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendResponse)
+	err := c.cc.Invoke(ctx, Pipeline_Send_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PipelineServer is the server API for Pipeline service.
+// All implementations must embed UnimplementedPipelineServer
+// for forward compatibility
+//
+// Pipeline is the gRPC variant of the pipeline, carrying the same values
+// as the /post routes from serviceA through serverB to serverC. It is
+// served by serverB and serverC.
+type PipelineServer interface {
+	// Send passes a value on to the service, as POST /post does
+	Send(context.Context, *SendRequest) (*SendResponse, error)
+	mustEmbedUnimplementedPipelineServer()
+}
+
+// UnimplementedPipelineServer must be embedded to have forward compatible implementations.
+type UnimplementedPipelineServer struct {
+}
+
+func (UnimplementedPipelineServer) Send(context.Context, *SendRequest) (*SendResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Send not implemented")
+}
+func (UnimplementedPipelineServer) mustEmbedUnimplementedPipelineServer() {}
+
+// UnsafePipelineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PipelineServer will
+// result in compilation errors.
+type UnsafePipelineServer interface {
+	mustEmbedUnimplementedPipelineServer()
+}
+
+func RegisterPipelineServer(s grpc.ServiceRegistrar, srv PipelineServer) {
+	s.RegisterService(&Pipeline_ServiceDesc, srv)
+}
+
+func _Pipeline_Send_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PipelineServer).Send(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Pipeline_Send_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PipelineServer).Send(ctx, req.(*SendRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Pipeline_ServiceDesc is the grpc.ServiceDesc for Pipeline service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Pipeline_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pipeline.v1.Pipeline",
+	HandlerType: (*PipelineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Send",
+			Handler:    _Pipeline_Send_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "pipeline.proto",
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// evictionInterval is how often the values beyond the retention are
+// evicted, so the store may briefly hold more
+const evictionInterval = 10 * time.Second
+
+// Retention bounds the values kept in the store. The zero Retention keeps
+// every value.
+type Retention struct {
+	MaxValues int           // 0 for no limit
+	MaxAge    time.Duration // 0 for no limit
+}
+
+// RetentionStatus is the body of the /retention/status route
+type RetentionStatus struct {
+	MaxValues int    `json:"maxValues"`
+	MaxAge    string `json:"maxAge"`
+	Interval  string `json:"interval"`
+	// The values evicted since the server started, for being too old or
+	// beyond the most kept
+	EvictedByAge   int        `json:"evictedByAge"`
+	EvictedByCount int        `json:"evictedByCount"`
+	LastEvictedAt  *time.Time `json:"lastEvictedAt,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+}
+
+// Evictor periodically removes the values beyond the retention from the
+// store, first those older than the maximum age and then the oldest of
+// any more than the maximum number, so the store does not grow without
+// bound. The retention can be changed while it runs.
+type Evictor struct {
+	store    Store
+	interval time.Duration
+	now      func() time.Time
+
+	mu             sync.Mutex // protects the fields below
+	retention      Retention
+	evictedByAge   int
+	evictedByCount int
+	lastEvictedAt  time.Time
+	lastErr        error
+}
+
+// NewEvictor returns an evictor removing the values beyond the retention
+// from store every interval
+func NewEvictor(store Store, retention Retention, interval time.Duration) *Evictor {
+	return &Evictor{
+		store:     store,
+		retention: retention,
+		interval:  interval,
+		now:       time.Now,
+	}
+}
+
+// SetRetention changes the retention, from the next eviction
+func (e *Evictor) SetRetention(retention Retention) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.retention = retention
+}
+
+// Start evicts values every interval until the returned function is
+// called
+func (e *Evictor) Start() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				e.evict()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// evict removes the values beyond the retention, recording the outcome in
+// the status
+func (e *Evictor) evict() {
+	e.mu.Lock()
+	retention := e.retention
+	e.mu.Unlock()
+
+	var byAge, byCount int
+	var err error
+	if retention.MaxAge > 0 {
+		before := e.now().Add(-retention.MaxAge).UTC().Format(time.RFC3339)
+		byAge, err = e.store.EvictBefore(before)
+	}
+	if err == nil && retention.MaxValues > 0 {
+		byCount, err = e.store.EvictOldest(retention.MaxValues)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lastErr = err
+	e.evictedByAge += byAge
+	e.evictedByCount += byCount
+	if err != nil {
+		slog.Error("could not evict values", "err", err)
+		return
+	}
+	if byAge+byCount > 0 {
+		e.lastEvictedAt = e.now()
+		slog.Info("evicted values", "by_age", byAge, "by_count", byCount)
+	}
+}
+
+// Status returns the retention and the values evicted so far
+func (e *Evictor) Status() RetentionStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := RetentionStatus{
+		MaxValues:      e.retention.MaxValues,
+		MaxAge:         e.retention.MaxAge.String(),
+		Interval:       e.interval.String(),
+		EvictedByAge:   e.evictedByAge,
+		EvictedByCount: e.evictedByCount,
+	}
+	if !e.lastEvictedAt.IsZero() {
+		lastEvictedAt := e.lastEvictedAt
+		status.LastEvictedAt = &lastEvictedAt
+	}
+	if e.lastErr != nil {
+		status.LastError = e.lastErr.Error()
+	}
+	return status
+}
+
+// serveStatus handles the /retention/status route
+func (e *Evictor) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(e.Status())
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEvictor(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		store.Add(Value{Timestamp: now.Add(time.Duration(i) * time.Minute).Format(time.RFC3339), ServiceName: "serverB", Value: 100 + i})
+	}
+	evictor := NewEvictor(store, Retention{}, time.Hour)
+	evictor.now = func() time.Time { return now.Add(4 * time.Minute) }
+
+	// Nothing is evicted without a retention
+	evictor.evict()
+	if _, total, _ := store.Find(Filter{}); total != 5 {
+		t.Fatalf("got %v values with no retention, want 5", total)
+	}
+
+	// The values more than two minutes old go first, then the oldest of
+	// any more than two
+	evictor.SetRetention(Retention{MaxValues: 2, MaxAge: 2 * time.Minute})
+	evictor.evict()
+	values, _, _ := store.Find(Filter{})
+	if len(values) != 2 || values[0].Value != 103 || values[1].Value != 104 {
+		t.Errorf("got %+v left, want the newest two", values)
+	}
+	status := evictor.Status()
+	if status.EvictedByAge != 2 || status.EvictedByCount != 1 || status.LastEvictedAt == nil || status.MaxValues != 2 || status.MaxAge != "2m0s" {
+		t.Errorf("got status %+v, want 2 evicted by age and 1 by count", status)
+	}
+
+	response := httptest.NewRecorder()
+	evictor.serveStatus(response, httptest.NewRequest(http.MethodGet, "/retention/status", nil))
+	var served RetentionStatus
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if served.EvictedByAge != 2 || served.EvictedByCount != 1 || served.Interval != "1h0m0s" {
+		t.Errorf("got %+v served, want %+v", served, status)
+	}
+}
+
+func TestEvictorStop(t *testing.T) {
+	store := NewMemoryStore()
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	store.Add(Value{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120})
+	evictor := NewEvictor(store, Retention{MaxValues: 1}, time.Millisecond)
+
+	stop := evictor.Start()
+	deadline := time.Now().Add(time.Second)
+	for evictor.Status().EvictedByCount == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for an eviction")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	if _, total, _ := store.Find(Filter{}); total != 1 {
+		t.Errorf("got %v values, want 1", total)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Objects keeps objects in an S3 bucket
+type S3Objects struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Objects connects to the bucket with the credentials and region of
+// the environment, such as AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_REGION, or of the instance's role when run on EC2. AWS_ENDPOINT_URL
+// points it at an S3-compatible store such as MinIO instead, which is
+// addressed by path rather than by the bucket's hostname.
+func NewS3Objects(ctx context.Context, bucket string) (*S3Objects, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = os.Getenv("AWS_ENDPOINT_URL") != ""
+	})
+	return &S3Objects{client: client, bucket: bucket}, nil
+}
+
+func (o *S3Objects) Put(ctx context.Context, key string, body []byte) error {
+	_, err := o.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (o *S3Objects) Latest(ctx context.Context, prefix string) (string, []byte, error) {
+	var key string
+	paginator := s3.NewListObjectsV2Paginator(o.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(o.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, object := range page.Contents {
+			if k := aws.ToString(object.Key); k > key {
+				key = k
+			}
+		}
+	}
+	if key == "" {
+		return "", nil, ErrNoSnapshot
+	}
+
+	object, err := o.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return key, nil, err
+	}
+	defer object.Body.Close()
+	body, err := io.ReadAll(object.Body)
+	return key, body, err
+}
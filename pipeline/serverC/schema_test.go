@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"shared/jsonschema"
+)
+
+func TestPostSchema(t *testing.T) {
+	testCases := []struct {
+		desc string
+		body string
+		want []jsonschema.FieldError
+	}{
+		{"valid", `{"serviceName":"serverB","value":8}`, nil},
+		{"at the limits", `{"serviceName":"` + strings.Repeat("é", maxServiceNameLen) + `","value":-1000000}`, nil},
+		{"not an object", `[8]`, []jsonschema.FieldError{{Field: "", Keyword: "type", Message: "must be an object"}}},
+		{"empty", `{}`, []jsonschema.FieldError{
+			{Field: "/serviceName", Keyword: "required", Message: "is required"},
+			{Field: "/value", Keyword: "required", Message: "is required"},
+		}},
+		{"every field at fault", `{"extra":true,"serviceName":"  ","value":1000001}`, []jsonschema.FieldError{
+			{Field: "/extra", Keyword: "additionalProperties", Message: "is not allowed"},
+			{Field: "/serviceName", Keyword: "pattern", Message: `must match \S`},
+			{Field: "/value", Keyword: "maximum", Message: "must be at most 1000000"},
+		}},
+		{"wrong types", `{"serviceName":8,"value":"8"}`, []jsonschema.FieldError{
+			{Field: "/serviceName", Keyword: "type", Message: "must be a string"},
+			{Field: "/value", Keyword: "type", Message: "must be an integer"},
+		}},
+		{"fraction", `{"serviceName":"serverB","value":8.5}`, []jsonschema.FieldError{{Field: "/value", Keyword: "type", Message: "must be an integer"}}},
+		{"too long", `{"serviceName":"` + strings.Repeat("a", maxServiceNameLen+1) + `","value":8}`, []jsonschema.FieldError{
+			{Field: "/serviceName", Keyword: "maxLength", Message: "must be at most 64 characters"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var doc interface{}
+			decoder := json.NewDecoder(strings.NewReader(tc.body))
+			decoder.UseNumber()
+			if err := decoder.Decode(&doc); err != nil {
+				t.Fatal(err)
+			}
+
+			err := postSchema.Validate(doc)
+			if tc.want == nil {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			schemaErr, ok := err.(*jsonschema.Error)
+			if !ok {
+				t.Fatalf("got %v, want a *jsonschema.Error", err)
+			}
+			if !reflect.DeepEqual(schemaErr.Fields, tc.want) {
+				t.Errorf("got %+v, want %+v", schemaErr.Fields, tc.want)
+			}
+		})
+	}
+}
+
+// The schema and the limits postRequest.validate checks, for values that
+// do not arrive over HTTP, must agree
+func TestPostSchemaLimits(t *testing.T) {
+	serviceName, value := postSchema.Properties["serviceName"], postSchema.Properties["value"]
+	if serviceName.MaxLength == nil || *serviceName.MaxLength != maxServiceNameLen {
+		t.Errorf("got serviceName maxLength %v, want %v", serviceName.MaxLength, maxServiceNameLen)
+	}
+	if value.Minimum == nil || *value.Minimum != minValue || value.Maximum == nil || *value.Maximum != maxValue {
+		t.Errorf("got value between %v and %v, want %v and %v", value.Minimum, value.Maximum, minValue, maxValue)
+	}
+}
+
+func TestPostFieldErrors(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	request := httptest.NewRequest(http.MethodPost, "/v2/post", strings.NewReader(`{"serviceName":"","value":-1000001}`))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	gm.postCallV2(response, request)
+
+	if response.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got status %v, want %v", response.Code, http.StatusUnprocessableEntity)
+	}
+	var got errorResponse
+	if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []jsonschema.FieldError{
+		{Field: "/serviceName", Keyword: "minLength", Message: "must not be empty"},
+		{Field: "/serviceName", Keyword: "pattern", Message: `must match \S`},
+		{Field: "/value", Keyword: "minimum", Message: "must be at least -1000000"},
+	}
+	if !reflect.DeepEqual(got.Fields, want) || !strings.HasPrefix(got.Error, "body does not match the schema") {
+		t.Errorf("got %+v, want the fields %+v", got, want)
+	}
+}
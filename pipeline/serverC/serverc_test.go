@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"shared/httpserver"
+	"shared/logging"
+)
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		val    int
+		valAdd int
+		want   int
+	}{
+		{
+			"add 100 to 8",
+			8,
+			100,
+			108,
+		}, {
+			"add 100 to 20",
+			20,
+			100,
+			120,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+
+			body := &postRequest{
+				ServiceName: "serverB",
+				Value:       &testCase.val,
+			}
+
+			payloadBuf := new(bytes.Buffer)
+			err := json.NewEncoder(payloadBuf).Encode(body)
+			if err != nil {
+				fmt.Println("JSON Encode error in Test")
+			}
+			request, _ := http.NewRequest(http.MethodPost, "/post", payloadBuf)
+			response := httptest.NewRecorder()
+			gm := NewGlobalVarManager(NewMemoryStore())
+			gm.postCall(response, request)
+
+			requestGet, _ := http.NewRequest(http.MethodPost, "/get", nil)
+			responseGet := httptest.NewRecorder()
+			results := []Value{}
+
+			gm.getCall(responseGet, requestGet)
+
+			err = json.NewDecoder(responseGet.Body).Decode(&results)
+
+			if err != nil {
+				fmt.Printf("JSON Decode error in Test, %v", err)
+			}
+			if len(results) == len(testCases) {
+				fmt.Println(results)
+				for index := range results {
+					if results[index].Value != testCases[index].want {
+						t.Errorf("Test Failed - got %v, want %v", results[index].Value, testCases[index].want)
+					}
+
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestPostValidation(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		method string
+		body   string
+		want   int
+	}{
+		{"valid", http.MethodPost, `{"serviceName":"serverB","value":8}`, http.StatusOK},
+		{"zero value", http.MethodPost, `{"serviceName":"serverB","value":0}`, http.StatusOK},
+		{"wrong method", http.MethodPut, `{"serviceName":"serverB","value":8}`, http.StatusMethodNotAllowed},
+		{"not JSON", http.MethodPost, `8`, http.StatusBadRequest},
+		{"empty body", http.MethodPost, ``, http.StatusBadRequest},
+		{"unknown field", http.MethodPost, `{"serviceName":"serverB","value":8,"extra":true}`, http.StatusBadRequest},
+		{"trailing data", http.MethodPost, `{"serviceName":"serverB","value":8}{}`, http.StatusBadRequest},
+		{"value not an integer", http.MethodPost, `{"serviceName":"serverB","value":"8"}`, http.StatusBadRequest},
+		{"missing serviceName", http.MethodPost, `{"value":8}`, http.StatusUnprocessableEntity},
+		{"blank serviceName", http.MethodPost, `{"serviceName":"  ","value":8}`, http.StatusUnprocessableEntity},
+		{"missing value", http.MethodPost, `{"serviceName":"serverB"}`, http.StatusUnprocessableEntity},
+		{"value too large", http.MethodPost, `{"serviceName":"serverB","value":1000001}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			store := NewMemoryStore()
+			gm := NewGlobalVarManager(store)
+			response := httptest.NewRecorder()
+			gm.postCall(response, httptest.NewRequest(tc.method, "/post", strings.NewReader(tc.body)))
+
+			if response.Code != tc.want {
+				t.Errorf("got status %v, want %v", response.Code, tc.want)
+			}
+			values, _, _ := store.Find(Filter{})
+			if stored := len(values) == 1; stored != (tc.want == http.StatusOK) {
+				t.Errorf("got %d values stored", len(values))
+			}
+			if tc.want == http.StatusOK {
+				return
+			}
+			var got struct {
+				Error string `json:"error"`
+			}
+			if ct := response.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("got Content-Type %q, want application/json", ct)
+			}
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Error == "" {
+				t.Errorf("got body error %q (%v), want a JSON error", got.Error, err)
+			}
+		})
+	}
+}
+
+// TestConcurrentPostAndGet exercises the handlers from many goroutines at
+// once, for the race detector: go test -race
+func TestConcurrentPostAndGet(t *testing.T) {
+	sqlite, err := OpenSQLStore("sqlite3", filepath.Join(t.TempDir(), "values.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlite.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "sqlite": sqlite} {
+		t.Run(name, func(t *testing.T) {
+			gm := NewGlobalVarManager(store)
+
+			const posts = 50
+			var wg sync.WaitGroup
+			for i := 0; i < posts; i++ {
+				wg.Add(2)
+				go func(value int) {
+					defer wg.Done()
+					body := fmt.Sprintf(`{"serviceName":"serverB","value":%d}`, value)
+					response := httptest.NewRecorder()
+					gm.postCall(response, httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(body)))
+					if response.Code != http.StatusOK {
+						t.Errorf("post: got status %v, want %v", response.Code, http.StatusOK)
+					}
+				}(i)
+				go func() {
+					defer wg.Done()
+					response := httptest.NewRecorder()
+					gm.getCall(response, httptest.NewRequest(http.MethodGet, "/get", nil))
+					if response.Code != http.StatusOK {
+						t.Errorf("get: got status %v, want %v", response.Code, http.StatusOK)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if _, total, _ := store.Find(Filter{}); total != posts {
+				t.Errorf("got %v values, want %v", total, posts)
+			}
+		})
+	}
+}
+
+func TestGetFilter(t *testing.T) {
+	store := NewMemoryStore()
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	store.Add(Value{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120})
+	gm := NewGlobalVarManager(store)
+
+	testCases := []struct {
+		desc       string
+		query      string
+		wantStatus int
+		wantCount  int
+		wantTotal  string
+	}{
+		{"no filter", "", http.StatusOK, 2, "2"},
+		{"from with an offset", "?from=2020-11-20T11:00:01%2B01:00", http.StatusOK, 1, "1"},
+		{"limit", "?serviceName=serverB&limit=1", http.StatusOK, 1, "2"},
+		{"bad time", "?to=yesterday", http.StatusBadRequest, 0, ""},
+		{"negative offset", "?offset=-1", http.StatusBadRequest, 0, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/get"+tc.query, nil)
+			response := httptest.NewRecorder()
+			gm.getCall(response, request)
+
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v", response.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := response.Header().Get("X-Total-Count"); got != tc.wantTotal {
+				t.Errorf("got total %q, want %q", got, tc.wantTotal)
+			}
+			var values []Value
+			if err := json.NewDecoder(response.Body).Decode(&values); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			if len(values) != tc.wantCount {
+				t.Errorf("got %v values, want %v", len(values), tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestLogging(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{
+			"status written",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			},
+			http.StatusTeapot,
+		},
+		{
+			"body written",
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			},
+			http.StatusOK,
+		},
+		{
+			"nothing written",
+			func(w http.ResponseWriter, r *http.Request) {},
+			http.StatusOK,
+		},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			var buf bytes.Buffer
+			handler := httpserver.RequestID(func() string { return "generated" })(httpserver.Logging(logging.New(&buf, nil))(tC.handler))
+
+			req := httptest.NewRequest("GET", "/get?serviceName=serverB", nil)
+			req.Header.Set("X-Request-Id", "abc")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			var line struct {
+				Msg        string  `json:"msg"`
+				RequestID  string  `json:"request_id"`
+				Method     string  `json:"method"`
+				Path       string  `json:"path"`
+				Status     int     `json:"status"`
+				DurationMS float64 `json:"duration_ms"`
+				RemoteAddr string  `json:"remote_addr"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", buf.String(), err)
+			}
+			if line.Msg != "request" || line.RequestID != "abc" || line.Method != "GET" || line.Path != "/get" {
+				t.Errorf("unexpected log line %q", buf.String())
+			}
+			if line.Status != tC.want {
+				t.Errorf("got status %d, want %d", line.Status, tC.want)
+			}
+			if line.RemoteAddr != req.RemoteAddr {
+				t.Errorf("got remote addr %q, want %q", line.RemoteAddr, req.RemoteAddr)
+			}
+		})
+	}
+}
+
+func TestStatsCall(t *testing.T) {
+	store := NewMemoryStore()
+	store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108})
+	store.Add(Value{Timestamp: time.Now().UTC().Format(time.RFC3339), ServiceName: "serverB", Value: 120})
+	gm := NewGlobalVarManager(store)
+
+	testCases := []struct {
+		desc       string
+		query      string
+		wantStatus int
+		wantCount  int
+	}{
+		{"no filter", "", http.StatusOK, 2},
+		{"from", "?from=2020-11-20T10:00:01Z", http.StatusOK, 1},
+		{"window", "?window=1h", http.StatusOK, 1},
+		{"other service", "?serviceName=serviceD", http.StatusOK, 0},
+		{"bad window", "?window=soon", http.StatusBadRequest, 0},
+		{"window and from", "?window=1h&from=2020-11-20T10:00:01Z", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			gm.statsCall(response, httptest.NewRequest(http.MethodGet, "/stats"+tc.query, nil))
+
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %v, want %v", response.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var stats []Stats
+			if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			var count int
+			for _, st := range stats {
+				count += st.Count
+			}
+			if count != tc.wantCount {
+				t.Errorf("got %v values summarised, want %v", count, tc.wantCount)
+			}
+			if count > 0 && stats[0].Latest.Value != 120 {
+				t.Errorf("got latest %+v, want 120", stats[0].Latest)
+			}
+		})
+	}
+}
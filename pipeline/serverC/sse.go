@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// eventHistory is how many events are kept for clients resuming with
+	// Last-Event-ID
+	eventHistory = 256
+	// sseSendBuffer is how many values a client may fall behind by before
+	// its stream is ended; it can then resume from its last event
+	sseSendBuffer = 16
+	// sseWriteWait bounds each write to a client
+	sseWriteWait = 10 * time.Second
+	// sseKeepAlive is how often a comment is sent to an idle stream, so
+	// proxies do not close it
+	sseKeepAlive = 15 * time.Second
+	// sseRetry is how long clients should wait before reconnecting
+	sseRetry = 3 * time.Second
+)
+
+// eventsCall handles the /events route, a Server-Sent Events stream of
+// each value stored from then on. Each event carries its ID, and a client
+// reconnecting with the Last-Event-ID header is first sent the events it
+// missed, as far back as the server still holds them.
+func (sm *GlobalVarManager) eventsCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+
+	var lastID uint64
+	if header := r.Header.Get("Last-Event-ID"); header != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(header, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "Last-Event-ID must be a non-negative integer")
+			return
+		}
+	}
+
+	// The server's write timeout would end the stream, so each write is
+	// given its own deadline instead
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	sub, missed := sm.hub.SubscribeSince(sseSendBuffer, lastID)
+	defer sm.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(format string, args ...interface{}) bool {
+		rc.SetWriteDeadline(time.Now().Add(sseWriteWait))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	writeEvent := func(e Event) bool {
+		data, err := json.Marshal(e.Value)
+		if err != nil {
+			return false
+		}
+		return write("id: %d\ndata: %s\n\n", e.ID, data)
+	}
+
+	if !write("retry: %d\n\n", sseRetry.Milliseconds()) {
+		return
+	}
+	for _, e := range missed {
+		if !writeEvent(e) {
+			return
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// The client reconnects after the retry delay, and
+				// catches up from its last event
+				slog.InfoContext(r.Context(), "ending event stream", "reason", sub.Err)
+				return
+			}
+			if !writeEvent(e) {
+				return
+			}
+		case <-keepAlive.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
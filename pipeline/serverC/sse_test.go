@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"shared/httpserver"
+	"shared/logging"
+	"shared/telemetry"
+)
+
+// readEvent returns the id and data of the next event in the stream,
+// skipping the retry field and comments
+func readEvent(t *testing.T, stream *bufio.Reader) (string, Value) {
+	var id string
+	var value Value
+	for {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &value); err != nil {
+				t.Fatalf("decoding %q: %v", line, err)
+			}
+		case line == "" && id != "":
+			return id, value
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	router := http.NewServeMux()
+	router.HandleFunc("/post", gm.postCall)
+	router.HandleFunc("/events", gm.eventsCall)
+
+	// The middleware must let the stream be flushed
+	var logs bytes.Buffer
+	server := httptest.NewServer(httpserver.Chain(router, telemetry.Middleware("serverC"), httpserver.RequestID(httpserver.NewRequestID), httpserver.Logging(logging.New(&logs, nil))))
+	defer server.Close()
+
+	post := func(value int) {
+		body := fmt.Sprintf(`{"serviceName":"serverB","value":%d}`, value)
+		resp, err := http.Post(server.URL+"/post", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	post(8)
+	post(20)
+
+	// Resuming after the first event replays the second
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got Content-Type %q, want text/event-stream", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	if id, v := readEvent(t, stream); id != "2" || v.Value != 120 {
+		t.Errorf("got event %s of %v, want event 2 of 120", id, v.Value)
+	}
+
+	// The stream subscribed before replaying, so sees new values too
+	post(30)
+	if id, v := readEvent(t, stream); id != "3" || v.Value != 130 {
+		t.Errorf("got event %s of %v, want event 3 of 130", id, v.Value)
+	}
+}
+
+func TestEventsBadLastEventID(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	response := httptest.NewRecorder()
+	gm.eventsCall(response, req)
+
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("got status %v, want %v", response.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"shared/health"
+)
+
+// ErrNotFound is returned for a value ID that is not in the store
+var ErrNotFound = errors.New("value not found")
+
+// Store holds the values posted to the server. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Add stores the value, returning it with the ID the store gave it.
+	// IDs increase in the order values are added, and are not reused.
+	Add(v Value) (Value, error)
+	// Get returns the value with the ID, or ErrNotFound
+	Get(id int64) (Value, error)
+	// Delete removes the value with the ID, or returns ErrNotFound
+	Delete(id int64) error
+	// EvictBefore removes the values timestamped before the time, as
+	// RFC3339 in UTC, returning how many were removed
+	EvictBefore(timestamp string) (int, error)
+	// EvictOldest removes the values added first, so that at most keep
+	// remain, returning how many were removed
+	EvictOldest(keep int) (int, error)
+	// Find returns the values matching the filter in the order they were
+	// added, along with the total number matching before the limit and
+	// offset are applied.
+	Find(f Filter) ([]Value, int, error)
+	// Stats summarises the values matching the filter for each service,
+	// ordered by service name. The limit and offset are ignored.
+	Stats(f Filter) ([]Stats, error)
+	// Ping checks the store can be reached
+	Ping() error
+	Close() error
+}
+
+// Filter selects values from a store. The zero Filter selects every value.
+type Filter struct {
+	ServiceName string // empty for every service
+	// From and To bound the timestamps, as RFC3339 in UTC. From is
+	// inclusive and To exclusive; either may be empty.
+	From   string
+	To     string
+	Limit  int // 0 for no limit
+	Offset int
+}
+
+// match reports whether the value passes the filter, ignoring the limit
+// and offset
+func (f Filter) match(v Value) bool {
+	return (f.ServiceName == "" || v.ServiceName == f.ServiceName) &&
+		(f.From == "" || v.Timestamp >= f.From) &&
+		(f.To == "" || v.Timestamp < f.To)
+}
+
+// Stats summarises the values of a service
+type Stats struct {
+	ServiceName string  `json:"serviceName"`
+	Count       int     `json:"count"`
+	Min         int     `json:"min"`
+	Max         int     `json:"max"`
+	Mean        float64 `json:"mean"`
+	// Latest is the value added most recently
+	Latest Value `json:"latest"`
+}
+
+// OpenStore opens the store of the given kind: "memory", "sqlite" or
+// "postgres". dsn is the database file for SQLite or the connection
+// string for Postgres, and is unused for the memory store.
+func OpenStore(kind, dsn string) (Store, error) {
+	switch kind {
+	case "memory":
+		return NewMemoryStore(), nil
+	case "sqlite":
+		return OpenSQLStore("sqlite3", dsn)
+	case "postgres":
+		return OpenSQLStore("postgres", dsn)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// MemoryStore keeps the values in memory, so they are lost on restart
+type MemoryStore struct {
+	mu     sync.RWMutex // protects the fields below
+	values []Value      // in the order they were added, so by ID
+	lastID int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values: make([]Value, 0),
+	}
+}
+
+func (s *MemoryStore) Add(v Value) (Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	v.ID = s.lastID
+	s.values = append(s.values, v)
+	return v, nil
+}
+
+// index returns the index of the value with the ID, or -1. The caller
+// must hold the lock.
+func (s *MemoryStore) index(id int64) int {
+	i := sort.Search(len(s.values), func(i int) bool { return s.values[i].ID >= id })
+	if i == len(s.values) || s.values[i].ID != id {
+		return -1
+	}
+	return i
+}
+
+func (s *MemoryStore) Get(id int64) (Value, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return Value{}, ErrNotFound
+	}
+	return s.values[i], nil
+}
+
+func (s *MemoryStore) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.values = append(s.values[:i], s.values[i+1:]...)
+	return nil
+}
+
+func (s *MemoryStore) Find(f Filter) ([]Value, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make([]Value, 0)
+	var total int
+	for _, v := range s.values {
+		if !f.match(v) {
+			continue
+		}
+		total++
+		if total > f.Offset && (f.Limit == 0 || len(values) < f.Limit) {
+			values = append(values, v)
+		}
+	}
+	return values, total, nil
+}
+
+func (s *MemoryStore) EvictBefore(timestamp string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.values[:0]
+	for _, v := range s.values {
+		if v.Timestamp >= timestamp {
+			kept = append(kept, v)
+		}
+	}
+	evicted := len(s.values) - len(kept)
+	s.values = kept
+	return evicted, nil
+}
+
+func (s *MemoryStore) EvictOldest(keep int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.values) <= keep {
+		return 0, nil
+	}
+	evicted := len(s.values) - keep
+	// Copied, so the evicted values are not held by the slice's array
+	s.values = append(make([]Value, 0, keep), s.values[evicted:]...)
+	return evicted, nil
+}
+
+func (s *MemoryStore) Stats(f Filter) ([]Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byService := make(map[string]*Stats)
+	sums := make(map[string]int)
+	for _, v := range s.values {
+		if !f.match(v) {
+			continue
+		}
+		st, ok := byService[v.ServiceName]
+		if !ok {
+			st = &Stats{ServiceName: v.ServiceName, Min: v.Value, Max: v.Value}
+			byService[v.ServiceName] = st
+		}
+		st.Count++
+		st.Min = min(st.Min, v.Value)
+		st.Max = max(st.Max, v.Value)
+		st.Latest = v
+		sums[v.ServiceName] += v.Value
+	}
+
+	stats := make([]Stats, 0, len(byService))
+	for name, st := range byService {
+		st.Mean = float64(sums[name]) / float64(st.Count)
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ServiceName < stats[j].ServiceName })
+	return stats, nil
+}
+
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// migrations holds the schema changes of each database driver, applied in
+// order. New changes must be appended, never edited, as databases record
+// how many they have applied.
+var migrations = map[string][]string{
+	"sqlite3": {
+		`CREATE TABLE service_values (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp    TEXT NOT NULL,
+			service_name TEXT NOT NULL,
+			value        INTEGER NOT NULL
+		)`,
+	},
+	"postgres": {
+		`CREATE TABLE service_values (
+			id           BIGSERIAL PRIMARY KEY,
+			timestamp    TEXT NOT NULL,
+			service_name TEXT NOT NULL,
+			value        INTEGER NOT NULL
+		)`,
+	},
+}
+
+// SQLStore keeps the values in a SQL database, so they survive restarts
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// OpenSQLStore connects to the database and brings its schema up to date
+func OpenSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, so share a single connection
+	// rather than failing concurrent posts with "database is locked"
+	if driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+	if err := migrate(db, migrations[driver]); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating schema: %v", err)
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// migrate applies the migrations the database has not yet had, recording
+// each in the schema_migrations table in the same transaction.
+func migrate(db *sql.DB, migrations []string) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) Add(v Value) (Value, error) {
+	err := s.db.QueryRow(`INSERT INTO service_values (timestamp, service_name, value) VALUES ($1, $2, $3) RETURNING id`,
+		v.Timestamp, v.ServiceName, v.Value).Scan(&v.ID)
+	return v, err
+}
+
+func (s *SQLStore) Get(id int64) (Value, error) {
+	var v Value
+	err := s.db.QueryRow(`SELECT id, timestamp, service_name, value FROM service_values WHERE id = $1`, id).
+		Scan(&v.ID, &v.Timestamp, &v.ServiceName, &v.Value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+func (s *SQLStore) Delete(id int64) error {
+	result, err := s.db.Exec(`DELETE FROM service_values WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLStore) EvictBefore(timestamp string) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM service_values WHERE timestamp < $1`, timestamp)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (s *SQLStore) EvictOldest(keep int) (int, error) {
+	// The subquery is the ID of the newest value to evict, or NULL if
+	// there are no more than keep, which matches none
+	result, err := s.db.Exec(`DELETE FROM service_values WHERE id <= (
+		SELECT id FROM service_values ORDER BY id DESC LIMIT 1 OFFSET $1
+	)`, keep)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// where returns the WHERE clause selecting the values matching the filter,
+// ignoring the limit and offset, and its arguments
+func (f Filter) where() (string, []interface{}) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.ServiceName != "" {
+		where("service_name = $%d", f.ServiceName)
+	}
+	if f.From != "" {
+		where("timestamp >= $%d", f.From)
+	}
+	if f.To != "" {
+		where("timestamp < $%d", f.To)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (s *SQLStore) Find(f Filter) ([]Value, int, error) {
+	clause, args := f.where()
+
+	var total int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM service_values`+clause, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, timestamp, service_name, value FROM service_values` + clause + ` ORDER BY id`
+	switch {
+	case f.Limit > 0:
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", f.Limit, f.Offset)
+	case f.Offset > 0 && s.driver == "sqlite3":
+		// SQLite only accepts an offset after a limit
+		query += fmt.Sprintf(" LIMIT -1 OFFSET %d", f.Offset)
+	case f.Offset > 0:
+		query += fmt.Sprintf(" OFFSET %d", f.Offset)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	values := make([]Value, 0)
+	for rows.Next() {
+		var v Value
+		if err := rows.Scan(&v.ID, &v.Timestamp, &v.ServiceName, &v.Value); err != nil {
+			return nil, 0, err
+		}
+		values = append(values, v)
+	}
+	return values, total, rows.Err()
+}
+
+func (s *SQLStore) Stats(f Filter) ([]Stats, error) {
+	clause, args := f.where()
+	// The latest value of each service is the one with its highest id
+	rows, err := s.db.Query(`SELECT s.service_name, s.count, s.min, s.max, s.mean, v.id, v.timestamp, v.value
+		FROM (
+			SELECT service_name, COUNT(*) AS count, MIN(value) AS min, MAX(value) AS max,
+				AVG(CAST(value AS REAL)) AS mean, MAX(id) AS latest
+			FROM service_values`+clause+`
+			GROUP BY service_name
+		) s JOIN service_values v ON v.id = s.latest
+		ORDER BY s.service_name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]Stats, 0)
+	for rows.Next() {
+		var st Stats
+		if err := rows.Scan(&st.ServiceName, &st.Count, &st.Min, &st.Max, &st.Mean, &st.Latest.ID, &st.Latest.Timestamp, &st.Latest.Value); err != nil {
+			return nil, err
+		}
+		st.Latest.ServiceName = st.ServiceName
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (s *SQLStore) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), health.ReadinessTimeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
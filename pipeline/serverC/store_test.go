@@ -0,0 +1,284 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStores(t *testing.T) {
+	want := []Value{
+		{ID: 1, Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{ID: 2, Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 120},
+	}
+	check := func(t *testing.T, store Store) {
+		got, total, err := store.Find(Filter{})
+		if err != nil {
+			t.Fatalf("reading values: %v", err)
+		}
+		if total != len(want) {
+			t.Errorf("got total %v, want %v", total, len(want))
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %v values, want %v", len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("value %v: got %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	}
+
+	for _, kind := range []string{"memory", "sqlite"} {
+		t.Run(kind, func(t *testing.T) {
+			dsn := filepath.Join(t.TempDir(), "serverc.db")
+			store, err := OpenStore(kind, dsn)
+			if err != nil {
+				t.Fatalf("opening store: %v", err)
+			}
+			for _, v := range want {
+				if _, err := store.Add(v); err != nil {
+					t.Fatalf("adding value: %v", err)
+				}
+			}
+			check(t, store)
+			store.Close()
+
+			// The SQLite store keeps its values across a restart, without
+			// migrating the schema again
+			if kind == "sqlite" {
+				store, err = OpenStore(kind, dsn)
+				if err != nil {
+					t.Fatalf("reopening store: %v", err)
+				}
+				defer store.Close()
+				check(t, store)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	values := []Value{
+		{ID: 1, Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{ID: 2, Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serviceD", Value: 150},
+		{ID: 3, Timestamp: "2020-11-20T10:00:02Z", ServiceName: "serverB", Value: 120},
+		{ID: 4, Timestamp: "2020-11-20T10:00:03Z", ServiceName: "serverB", Value: 101},
+	}
+
+	testCases := []struct {
+		desc      string
+		filter    Filter
+		want      []int // indexes into values
+		wantTotal int
+	}{
+		{"everything", Filter{}, []int{0, 1, 2, 3}, 4},
+		{"service", Filter{ServiceName: "serverB"}, []int{0, 2, 3}, 3},
+		{"time range", Filter{From: "2020-11-20T10:00:01Z", To: "2020-11-20T10:00:03Z"}, []int{1, 2}, 2},
+		{"limit", Filter{Limit: 2}, []int{0, 1}, 4},
+		{"offset", Filter{Offset: 3}, []int{3}, 4},
+		{"page of a service", Filter{ServiceName: "serverB", Limit: 1, Offset: 1}, []int{2}, 3},
+		{"past the end", Filter{Offset: 10}, []int{}, 4},
+	}
+
+	for _, kind := range []string{"memory", "sqlite"} {
+		store, err := OpenStore(kind, filepath.Join(t.TempDir(), "serverc.db"))
+		if err != nil {
+			t.Fatalf("opening %v store: %v", kind, err)
+		}
+		defer store.Close()
+		for _, v := range values {
+			if _, err := store.Add(v); err != nil {
+				t.Fatalf("adding value: %v", err)
+			}
+		}
+
+		for _, tc := range testCases {
+			t.Run(kind+"/"+tc.desc, func(t *testing.T) {
+				got, total, err := store.Find(tc.filter)
+				if err != nil {
+					t.Fatalf("finding values: %v", err)
+				}
+				if total != tc.wantTotal {
+					t.Errorf("got total %v, want %v", total, tc.wantTotal)
+				}
+				if len(got) != len(tc.want) {
+					t.Fatalf("got %v values, want %v", len(got), len(tc.want))
+				}
+				for i, index := range tc.want {
+					if got[i] != values[index] {
+						t.Errorf("value %v: got %+v, want %+v", i, got[i], values[index])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	values := []Value{
+		{ID: 1, Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{ID: 2, Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serviceD", Value: 150},
+		{ID: 3, Timestamp: "2020-11-20T10:00:02Z", ServiceName: "serverB", Value: 120},
+		{ID: 4, Timestamp: "2020-11-20T10:00:03Z", ServiceName: "serverB", Value: 101},
+	}
+
+	testCases := []struct {
+		desc   string
+		filter Filter
+		want   []Stats
+	}{
+		{"everything", Filter{}, []Stats{
+			{ServiceName: "serverB", Count: 3, Min: 101, Max: 120, Mean: 329.0 / 3, Latest: values[3]},
+			{ServiceName: "serviceD", Count: 1, Min: 150, Max: 150, Mean: 150, Latest: values[1]},
+		}},
+		{"service", Filter{ServiceName: "serviceD"}, []Stats{
+			{ServiceName: "serviceD", Count: 1, Min: 150, Max: 150, Mean: 150, Latest: values[1]},
+		}},
+		{"time range", Filter{From: "2020-11-20T10:00:02Z", Limit: 1}, []Stats{
+			{ServiceName: "serverB", Count: 2, Min: 101, Max: 120, Mean: 110.5, Latest: values[3]},
+		}},
+		{"nothing", Filter{ServiceName: "serviceE"}, []Stats{}},
+	}
+
+	for _, kind := range []string{"memory", "sqlite"} {
+		store, err := OpenStore(kind, filepath.Join(t.TempDir(), "serverc.db"))
+		if err != nil {
+			t.Fatalf("opening %v store: %v", kind, err)
+		}
+		defer store.Close()
+		for _, v := range values {
+			if _, err := store.Add(v); err != nil {
+				t.Fatalf("adding value: %v", err)
+			}
+		}
+
+		for _, tc := range testCases {
+			t.Run(kind+"/"+tc.desc, func(t *testing.T) {
+				got, err := store.Stats(tc.filter)
+				if err != nil {
+					t.Fatalf("summarising values: %v", err)
+				}
+				if len(got) != len(tc.want) {
+					t.Fatalf("got %+v, want %+v", got, tc.want)
+				}
+				for i := range tc.want {
+					if got[i] != tc.want[i] {
+						t.Errorf("service %v: got %+v, want %+v", i, got[i], tc.want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGetDelete(t *testing.T) {
+	for _, kind := range []string{"memory", "sqlite"} {
+		t.Run(kind, func(t *testing.T) {
+			store, err := OpenStore(kind, filepath.Join(t.TempDir(), "serverc.db"))
+			if err != nil {
+				t.Fatalf("opening store: %v", err)
+			}
+			defer store.Close()
+
+			var added []Value
+			for _, value := range []int{108, 120, 101} {
+				v, err := store.Add(Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: value})
+				if err != nil {
+					t.Fatalf("adding value: %v", err)
+				}
+				added = append(added, v)
+			}
+			if added[0].ID >= added[1].ID || added[1].ID >= added[2].ID {
+				t.Fatalf("got IDs %d, %d, %d, want them increasing", added[0].ID, added[1].ID, added[2].ID)
+			}
+
+			if got, err := store.Get(added[1].ID); err != nil || got != added[1] {
+				t.Errorf("got %+v, %v, want %+v", got, err, added[1])
+			}
+			if err := store.Delete(added[1].ID); err != nil {
+				t.Fatalf("deleting value: %v", err)
+			}
+			if _, err := store.Get(added[1].ID); err != ErrNotFound {
+				t.Errorf("got error %v getting a deleted value, want %v", err, ErrNotFound)
+			}
+			if err := store.Delete(added[1].ID); err != ErrNotFound {
+				t.Errorf("got error %v deleting it again, want %v", err, ErrNotFound)
+			}
+			if got, err := store.Get(added[2].ID); err != nil || got != added[2] {
+				t.Errorf("got %+v, %v after a delete, want %+v", got, err, added[2])
+			}
+
+			// IDs are not reused once the latest value is deleted
+			store.Delete(added[2].ID)
+			v, err := store.Add(Value{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serverB", Value: 130})
+			if err != nil {
+				t.Fatalf("adding value: %v", err)
+			}
+			if v.ID <= added[2].ID {
+				t.Errorf("got ID %d, want above %d", v.ID, added[2].ID)
+			}
+		})
+	}
+}
+
+func TestEvict(t *testing.T) {
+	values := []Value{
+		{ID: 1, Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{ID: 2, Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serviceD", Value: 150},
+		{ID: 3, Timestamp: "2020-11-20T10:00:02Z", ServiceName: "serverB", Value: 120},
+		{ID: 4, Timestamp: "2020-11-20T10:00:03Z", ServiceName: "serverB", Value: 101},
+		{ID: 5, Timestamp: "2020-11-20T10:00:04Z", ServiceName: "serverB", Value: 133},
+	}
+
+	for _, kind := range []string{"memory", "sqlite"} {
+		t.Run(kind, func(t *testing.T) {
+			store, err := OpenStore(kind, filepath.Join(t.TempDir(), "serverc.db"))
+			if err != nil {
+				t.Fatalf("opening store: %v", err)
+			}
+			defer store.Close()
+			for _, v := range values {
+				if _, err := store.Add(v); err != nil {
+					t.Fatalf("adding value: %v", err)
+				}
+			}
+
+			steps := []struct {
+				desc  string
+				evict func() (int, error)
+				want  int // the index of the first value left
+			}{
+				{"before the first", func() (int, error) { return store.EvictBefore(values[0].Timestamp) }, 0},
+				{"before the second", func() (int, error) { return store.EvictBefore(values[1].Timestamp) }, 1},
+				{"keeping more than there are", func() (int, error) { return store.EvictOldest(10) }, 1},
+				{"keeping the newest two", func() (int, error) { return store.EvictOldest(2) }, 3},
+				{"keeping as many as there are", func() (int, error) { return store.EvictOldest(2) }, 3},
+			}
+			left := 0
+			for _, step := range steps {
+				n, err := step.evict()
+				if err != nil {
+					t.Fatalf("%v: %v", step.desc, err)
+				}
+				if n != step.want-left {
+					t.Errorf("%v: got %v evicted, want %v", step.desc, n, step.want-left)
+				}
+				left = step.want
+
+				got, _, err := store.Find(Filter{})
+				if err != nil {
+					t.Fatalf("finding values: %v", err)
+				}
+				if len(got) != len(values)-left || len(got) > 0 && got[0] != values[left] {
+					t.Errorf("%v: got %+v left, want from %+v", step.desc, got, values[left])
+				}
+			}
+		})
+	}
+}
+
+func TestOpenStoreUnknown(t *testing.T) {
+	if _, err := OpenStore("redis", ""); err == nil {
+		t.Error("got no error for an unknown store")
+	}
+}
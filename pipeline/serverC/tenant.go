@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Each service posting values is a tenant of serverC, named by its
+// serviceName. The values of a tenant are kept apart from the others':
+// each may be given a quota of values stored, and has its own views of
+// /get and /stats under /v2/tenants/{name}.
+
+// QuotaError is the error of a value refused because its tenant already
+// has as many values stored as its quota
+type QuotaError struct {
+	Tenant string
+	Quota  int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s has reached its quota of %d values", e.Tenant, e.Quota)
+}
+
+// Quotas limits how many values each tenant may have stored. The quotas
+// can be changed while values are added.
+type Quotas struct {
+	mu     sync.Mutex // protects the quotas
+	def    int        // the quota of the tenants not in limits, 0 for none
+	limits map[string]int
+
+	// admitting serialises the values added to tenants with a quota, so
+	// concurrent posts cannot together take a tenant past it
+	admitting sync.Mutex
+}
+
+// NewQuotas returns the quotas of def values for every tenant but those
+// in limits. A quota of 0 is no limit.
+func NewQuotas(def int, limits map[string]int) *Quotas {
+	q := &Quotas{}
+	q.Set(def, limits)
+	return q
+}
+
+// Set changes the quotas, from the next value added
+func (q *Quotas) Set(def int, limits map[string]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.def = def
+	q.limits = limits
+}
+
+// Limit returns the quota of the tenant, or 0 if it has none
+func (q *Quotas) Limit(tenant string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if limit, ok := q.limits[tenant]; ok {
+		return limit
+	}
+	return q.def
+}
+
+// tenants returns the tenants given their own quota
+func (q *Quotas) tenants() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var tenants []string
+	for tenant := range q.limits {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// add stores the value, unless its tenant already has its quota of values
+// stored, when the error is a *QuotaError
+func (q *Quotas) add(store Store, v Value) (Value, error) {
+	quota := q.Limit(v.ServiceName)
+	if quota == 0 {
+		return store.Add(v)
+	}
+
+	q.admitting.Lock()
+	defer q.admitting.Unlock()
+	_, stored, err := store.Find(Filter{ServiceName: v.ServiceName, Limit: 1})
+	if err != nil {
+		return v, err
+	}
+	if stored >= quota {
+		return v, &QuotaError{Tenant: v.ServiceName, Quota: quota}
+	}
+	return store.Add(v)
+}
+
+// parseQuotas reads the quotas of individual tenants from a comma
+// separated list of name=quota, such as serverB=1000,serviceD=50
+func parseQuotas(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tenant, quota, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(quota))
+		if !ok || strings.TrimSpace(tenant) == "" || err != nil || n < 0 {
+			return nil, fmt.Errorf("quota %q must be name=count with a count of at least 0", item)
+		}
+		limits[strings.TrimSpace(tenant)] = n
+	}
+	return limits, nil
+}
+
+// Tenant is an entry of the /v2/tenants list
+type Tenant struct {
+	Name   string `json:"name"`
+	Values int    `json:"values"`
+	Quota  int    `json:"quota,omitempty"` // 0 for no limit
+}
+
+// tenantsCall handles the /v2/tenants route, listing the tenants with
+// values stored or a quota of their own, ordered by name
+func (sm *GlobalVarManager) tenantsCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+
+	stats, err := sm.store.Stats(Filter{})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not summarise values", "err", err)
+		writeError(w, http.StatusInternalServerError, "could not list tenants")
+		return
+	}
+	values := make(map[string]int)
+	for _, s := range stats {
+		values[s.ServiceName] = s.Count
+	}
+	for _, tenant := range sm.quotas.tenants() {
+		if _, ok := values[tenant]; !ok {
+			values[tenant] = 0
+		}
+	}
+
+	tenants := make([]Tenant, 0, len(values))
+	for name, n := range values {
+		tenants = append(tenants, Tenant{Name: name, Values: n, Quota: sm.quotas.Limit(name)})
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	w.Header().Set("Content-Type", mediaJSON)
+	json.NewEncoder(w).Encode(tenants)
+}
+
+// tenantCall handles the /v2/tenants/{name}/get and /stats routes, the
+// views of /v2/get and /v2/stats of the tenant's values alone. They take
+// the same query parameters but serviceName, which the path gives.
+func (sm *GlobalVarManager) tenantCall(w http.ResponseWriter, r *http.Request) {
+	tenant, view, ok := tenantView(r.URL.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such tenant view, want /v2/tenants/{name}/get or /stats")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET")
+		return
+	}
+	query := r.URL.Query()
+	if query.Has("serviceName") {
+		writeError(w, http.StatusBadRequest, "serviceName is given by the path")
+		return
+	}
+
+	query.Set("serviceName", tenant)
+	r = r.Clone(r.Context())
+	r.URL.RawQuery = query.Encode()
+	if view == "get" {
+		sm.getCallV2(w, r)
+	} else {
+		sm.statsCall(w, r)
+	}
+}
+
+// tenantView returns the tenant and the view, get or stats, of a
+// /tenants/{name}/{view} path
+func tenantView(path string) (tenant, view string, ok bool) {
+	_, rest, found := strings.Cut(path, "/tenants/")
+	if !found {
+		return "", "", false
+	}
+	tenant, view, found = strings.Cut(rest, "/")
+	return tenant, view, found && tenant != "" && (view == "get" || view == "stats")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestQuotas(t *testing.T) {
+	store := NewMemoryStore()
+	quotas := NewQuotas(2, map[string]int{"serviceD": 1, "serviceE": 0})
+	add := func(service string) error {
+		_, err := quotas.add(store, Value{Timestamp: "2020-11-20T10:00:00Z", ServiceName: service, Value: 108})
+		return err
+	}
+
+	for _, tc := range []struct {
+		service string
+		wantErr bool
+	}{
+		{"serverB", false},
+		{"serverB", false},
+		{"serverB", true},
+		{"serviceD", false},
+		{"serviceD", true},
+		// A quota of 0 is no limit, even overriding the default
+		{"serviceE", false},
+		{"serviceE", false},
+		{"serviceE", false},
+	} {
+		err := add(tc.service)
+		var quotaErr *QuotaError
+		if gotErr := errors.As(err, &quotaErr); gotErr != tc.wantErr {
+			t.Fatalf("%v: got %v, want a quota error %v", tc.service, err, tc.wantErr)
+		}
+	}
+
+	// Raising the quota lets more values in
+	quotas.Set(3, nil)
+	if err := add("serverB"); err != nil {
+		t.Errorf("got %v after raising the quota", err)
+	}
+	if err := add("serviceD"); err != nil {
+		t.Errorf("got %v after dropping the override", err)
+	}
+	if _, total, _ := store.Find(Filter{ServiceName: "serverB"}); total != 3 {
+		t.Errorf("got %v values of serverB, want 3", total)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		quotas  string
+		want    map[string]int
+		wantErr bool
+	}{
+		{"none", "", map[string]int{}, false},
+		{"some", "serverB=1000, serviceD = 50,", map[string]int{"serverB": 1000, "serviceD": 50}, false},
+		{"no count", "serverB", nil, true},
+		{"no name", "=5", nil, true},
+		{"negative", "serverB=-1", nil, true},
+		{"not a number", "serverB=many", nil, true},
+	}
+
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			got, err := parseQuotas(tC.quotas)
+			if gotErr := err != nil; gotErr != tC.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tC.wantErr)
+			}
+			if !tC.wantErr && !reflect.DeepEqual(got, tC.want) {
+				t.Errorf("got %v, want %v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestTenantRoutes(t *testing.T) {
+	store := NewMemoryStore()
+	for _, v := range []Value{
+		{Timestamp: "2020-11-20T10:00:00Z", ServiceName: "serverB", Value: 108},
+		{Timestamp: "2020-11-20T10:00:01Z", ServiceName: "serviceD", Value: 150},
+		{Timestamp: "2020-11-20T10:00:02Z", ServiceName: "serverB", Value: 120},
+	} {
+		store.Add(v)
+	}
+	gm := NewGlobalVarManager(store)
+	gm.quotas.Set(0, map[string]int{"serverB": 2, "serviceE": 10})
+	router := http.NewServeMux()
+	mountAPI(router, "/v2", gm.apiV2())
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("list", func(t *testing.T) {
+		var got []Tenant
+		if err := json.NewDecoder(do(http.MethodGet, "/v2/tenants", "").Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		want := []Tenant{{"serverB", 2, 2}, {"serviceD", 1, 0}, {"serviceE", 0, 10}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		var page valuesPage
+		if err := json.NewDecoder(do(http.MethodGet, "/v2/tenants/serverB/get?limit=1", "").Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 2 || len(page.Values) != 1 || page.Values[0].ServiceName != "serverB" {
+			t.Errorf("got %+v, want 1 of serverB's 2 values", page)
+		}
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		var stats []Stats
+		if err := json.NewDecoder(do(http.MethodGet, "/v2/tenants/serviceD/stats", "").Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 || stats[0].ServiceName != "serviceD" || stats[0].Count != 1 {
+			t.Errorf("got %+v, want serviceD's alone", stats)
+		}
+	})
+
+	for _, tc := range []struct {
+		desc, method, path string
+		want               int
+	}{
+		{"serviceName given", http.MethodGet, "/v2/tenants/serverB/get?serviceName=serviceD", http.StatusBadRequest},
+		{"no view", http.MethodGet, "/v2/tenants/serverB", http.StatusNotFound},
+		{"unknown view", http.MethodGet, "/v2/tenants/serverB/values", http.StatusNotFound},
+		{"wrong method", http.MethodDelete, "/v2/tenants/serverB/get", http.StatusMethodNotAllowed},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			if got := do(tc.method, tc.path, "").Code; got != tc.want {
+				t.Errorf("got status %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// The router cleans a path without a name before it gets this far
+	if _, _, ok := tenantView("/v2/tenants//get"); ok {
+		t.Error("got a view of the tenant with no name")
+	}
+
+	t.Run("post over quota", func(t *testing.T) {
+		response := do(http.MethodPost, "/v2/post", `{"serviceName":"serverB","value":8}`)
+		if response.Code != http.StatusTooManyRequests {
+			t.Fatalf("got status %v, want %v", response.Code, http.StatusTooManyRequests)
+		}
+		if got := do(http.MethodPost, "/v2/post", `{"serviceName":"serviceD","value":8}`).Code; got != http.StatusCreated {
+			t.Errorf("got status %v posting for another tenant, want %v", got, http.StatusCreated)
+		}
+	})
+}
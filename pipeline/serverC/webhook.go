@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"shared/httpserver"
+)
+
+// Clients register a callback URL at /subscriptions to be sent each value
+// stored from then on, rather than holding a /ws or /events stream open.
+// Each value is posted to every subscriber, signed with the subscriber's
+// secret, and retried with backoff; one that cannot be delivered is
+// written to the dead-letter log.
+
+// Limits on the deliveries of values to subscribers
+const (
+	webhookQueueSize      = 256 // deliveries waiting for a worker
+	webhookWorkers        = 4
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 500 * time.Millisecond
+	webhookMaxBackoff     = 10 * time.Second
+	webhookTimeout        = 5 * time.Second // of each attempt
+	maxSubscriptionBytes  = 4 << 10
+	maxSecretLen          = 256
+)
+
+// Subscription is a callback URL sent each value stored
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+	// The values delivered and dead-lettered since it was created
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+
+	secret []byte
+}
+
+// subscriptionRequest is the body of a post to /subscriptions. A secret is
+// generated if none is given.
+type subscriptionRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// subscriptionCreated is the body of a successful post to /subscriptions.
+// The secret is only ever returned here.
+type subscriptionCreated struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+// DeadLetter is a line of the dead-letter log, recording a value that
+// could not be delivered to a subscriber
+type DeadLetter struct {
+	Time           time.Time `json:"time"`
+	SubscriptionID string    `json:"subscriptionId"`
+	URL            string    `json:"url"`
+	Value          Value     `json:"value"`
+	Attempts       int       `json:"attempts"`
+	Error          string    `json:"error"`
+}
+
+// delivery is a value to post to a subscriber
+type delivery struct {
+	sub   *Subscription
+	value Value
+}
+
+// Webhooks holds the subscriptions and posts each value published to
+// them. Subscriptions are kept in memory, so are lost when the server
+// restarts. Values are queued for a pool of workers, so a slow subscriber
+// does not hold up the handler posting the value; a value that finds the
+// queue full is dead-lettered.
+type Webhooks struct {
+	client      *http.Client
+	queue       chan delivery
+	deadLetters io.Writer // nil to only log them
+	now         func() time.Time
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu     sync.Mutex // protects the fields below and writes to deadLetters
+	subs   map[string]*Subscription
+	closed bool
+}
+
+// NewWebhooks returns webhooks posting through the transport, which is
+// http.DefaultTransport if nil, and writing dead letters to deadLetters
+// as lines of JSON if it is not nil
+func NewWebhooks(transport http.RoundTripper, deadLetters io.Writer) *Webhooks {
+	return &Webhooks{
+		client:         &http.Client{Transport: transport, Timeout: webhookTimeout},
+		queue:          make(chan delivery, webhookQueueSize),
+		deadLetters:    deadLetters,
+		now:            time.Now,
+		initialBackoff: webhookInitialBackoff,
+		maxBackoff:     webhookMaxBackoff,
+		subs:           make(map[string]*Subscription),
+	}
+}
+
+// newWebhookTransport returns the transport for the posts to subscribers.
+// They are on hosts the server does not control, so each stage of an
+// attempt is bounded.
+func newWebhookTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 2 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 2 * time.Second
+	transport.ResponseHeaderTimeout = 3 * time.Second
+	return transport
+}
+
+// Subscribe registers the callback URL, signing its deliveries with
+// secret, and returns the subscription
+func (wh *Webhooks) Subscribe(callback string, secret []byte) Subscription {
+	sub := &Subscription{
+		ID:        newSubscriptionID(),
+		URL:       callback,
+		CreatedAt: wh.now().UTC(),
+		secret:    secret,
+	}
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.subs[sub.ID] = sub
+	return *sub
+}
+
+// Unsubscribe removes the subscription, reporting whether there was one.
+// Its deliveries still queued are dropped.
+func (wh *Webhooks) Unsubscribe(id string) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	_, ok := wh.subs[id]
+	delete(wh.subs, id)
+	return ok
+}
+
+// Subscription returns a copy of the subscription with the ID
+func (wh *Webhooks) Subscription(id string) (Subscription, bool) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	sub, ok := wh.subs[id]
+	if !ok {
+		return Subscription{}, false
+	}
+	return *sub, true
+}
+
+// Subscriptions returns copies of the subscriptions, oldest first
+func (wh *Webhooks) Subscriptions() []Subscription {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	subs := make([]Subscription, 0, len(wh.subs))
+	for _, sub := range wh.subs {
+		subs = append(subs, *sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
+
+// Publish queues the value for every subscriber without blocking
+func (wh *Webhooks) Publish(v Value) {
+	var dropped []delivery
+	wh.mu.Lock()
+	if !wh.closed {
+		for _, sub := range wh.subs {
+			select {
+			case wh.queue <- delivery{sub: sub, value: v}:
+			default:
+				dropped = append(dropped, delivery{sub: sub, value: v})
+			}
+		}
+	}
+	wh.mu.Unlock()
+
+	for _, d := range dropped {
+		wh.deadLetter(d, 0, errors.New("delivery queue full"))
+	}
+}
+
+// Start delivers the queued values with workers until the returned
+// function is called. It stops taking new values and waits for those
+// queued to be delivered, dead-lettering the rest once ctx is done.
+func (wh *Webhooks) Start(workers int) (stop func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range wh.queue {
+				wh.deliver(ctx, d)
+			}
+		}()
+	}
+	return func(stopCtx context.Context) {
+		wh.mu.Lock()
+		wh.closed = true
+		close(wh.queue)
+		wh.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+			cancel()
+			<-done
+		}
+		cancel()
+	}
+}
+
+// deliver posts the value to the subscriber, retrying until it is
+// accepted or webhookMaxAttempts have been made. Connection errors, 429s
+// and 5xx responses are retried; any other response is dead-lettered
+// straight away, as sending the value again would not change it.
+func (wh *Webhooks) deliver(ctx context.Context, d delivery) {
+	body, err := json.Marshal(d.value)
+	if err != nil {
+		wh.deadLetter(d, 0, err)
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !wh.subscribed(d.sub) {
+			return
+		}
+		retry, err := wh.post(ctx, d, body)
+		if err == nil {
+			wh.mu.Lock()
+			d.sub.Delivered++
+			wh.mu.Unlock()
+			return
+		}
+		if !retry || attempt == webhookMaxAttempts {
+			wh.deadLetter(d, attempt, err)
+			return
+		}
+
+		wait := wh.backoff(attempt)
+		slog.Warn("webhook delivery failed, retrying", "subscription", d.sub.ID, "value_id", d.value.ID, "attempt", attempt, "wait", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			wh.deadLetter(d, attempt, fmt.Errorf("server shutting down: %v", err))
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// subscribed reports whether the subscription has not been removed
+func (wh *Webhooks) subscribed(sub *Subscription) bool {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return wh.subs[sub.ID] == sub
+}
+
+// post makes a single attempt at posting the body to the subscriber,
+// reporting whether a failure is worth retrying. The body is signed with
+// the subscriber's secret, along with the time it is sent so a captured
+// request cannot be replayed later.
+func (wh *Webhooks) post(ctx context.Context, d delivery, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(wh.now().Unix(), 10)
+	req.Header.Set("Content-Type", mediaJSON)
+	req.Header.Set("User-Agent", "serverC")
+	req.Header.Set("X-Webhook-Id", d.sub.ID+"/"+strconv.FormatInt(d.value.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(d.sub.secret, timestamp, body))
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("subscriber responded %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("subscriber responded %s", resp.Status)
+	default:
+		return false, nil
+	}
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the timestamp and body, as
+// "timestamp.body", keyed by the secret
+func signWebhook(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns how long to wait after the given attempt, doubling
+// with each one up to the maximum
+func (wh *Webhooks) backoff(attempt int) time.Duration {
+	wait := wh.maxBackoff
+	if attempt < 30 && wh.initialBackoff<<uint(attempt-1) < wait {
+		wait = wh.initialBackoff << uint(attempt-1)
+	}
+	return wait
+}
+
+// deadLetter records a value that could not be delivered, in the log and,
+// if there is one, the dead-letter log
+func (wh *Webhooks) deadLetter(d delivery, attempts int, err error) {
+	slog.Error("could not deliver value to subscriber", "subscription", d.sub.ID, "url", d.sub.URL, "value_id", d.value.ID, "attempts", attempts, "err", err)
+
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	d.sub.Failed++
+	if wh.deadLetters == nil {
+		return
+	}
+	line, _ := json.Marshal(DeadLetter{
+		Time:           wh.now().UTC(),
+		SubscriptionID: d.sub.ID,
+		URL:            d.sub.URL,
+		Value:          d.value,
+		Attempts:       attempts,
+		Error:          err.Error(),
+	})
+	if _, err := wh.deadLetters.Write(append(line, '\n')); err != nil {
+		slog.Error("could not write dead letter", "err", err)
+	}
+}
+
+// newSubscriptionID returns a random ID for a subscription
+func newSubscriptionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validate returns the first problem found with the fields of the request
+func (s subscriptionRequest) validate() error {
+	u, err := url.Parse(s.URL)
+	switch {
+	case s.URL == "":
+		return errors.New("url is required")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+		return errors.New("url must be an absolute http or https URL")
+	case len(s.Secret) > maxSecretLen:
+		return fmt.Errorf("secret must be at most %d characters", maxSecretLen)
+	}
+	return nil
+}
+
+// subscriptionsRoute wraps the handler of /subscriptions with the body
+// limit and timeout of a post
+func subscriptionsRoute(handler http.HandlerFunc) http.Handler {
+	return httpserver.Chain(handler,
+		httpserver.Timeout(postTimeout),
+		httpserver.MaxBytes(maxSubscriptionBytes),
+	)
+}
+
+// subscriptionsCall handles the /subscriptions route. GET lists the
+// subscriptions, and POST registers a callback URL, returning the
+// subscription with its secret with a 201.
+func (sm *GlobalVarManager) subscriptionsCall(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", mediaJSON)
+		json.NewEncoder(w).Encode(sm.webhooks.Subscriptions())
+	case http.MethodPost:
+		var req subscriptionRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&req); httpserver.BodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", maxSubscriptionBytes))
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		if err := req.validate(); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		if req.Secret == "" {
+			b := make([]byte, 32)
+			rand.Read(b)
+			req.Secret = hex.EncodeToString(b)
+		}
+
+		sub := sm.webhooks.Subscribe(req.URL, []byte(req.Secret))
+		slog.InfoContext(r.Context(), "subscribed", "subscription", sub.ID, "url", sub.URL)
+		w.Header().Set("Content-Type", mediaJSON)
+		w.Header().Set("Location", "/subscriptions/"+sub.ID)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(subscriptionCreated{Subscription: sub, Secret: req.Secret})
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET or POST")
+	}
+}
+
+// subscriptionCall handles the /subscriptions/{id} route, responding with
+// the subscription or deleting it with a 204
+func (sm *GlobalVarManager) subscriptionCall(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/subscriptions/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		sub, ok := sm.webhooks.Subscription(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no subscription with this ID")
+			return
+		}
+		w.Header().Set("Content-Type", mediaJSON)
+		json.NewEncoder(w).Encode(sub)
+	case http.MethodDelete:
+		if !sm.webhooks.Unsubscribe(id) {
+			writeError(w, http.StatusNotFound, "no subscription with this ID")
+			return
+		}
+		slog.InfoContext(r.Context(), "unsubscribed", "subscription", id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET or DELETE")
+	}
+}
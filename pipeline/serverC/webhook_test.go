@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhooksDeliver(t *testing.T) {
+	secret := []byte("s3cret")
+	var calls int32
+	received := make(chan Value, 1)
+	subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + signWebhook(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if got := r.Header.Get("X-Webhook-Signature"); got != want {
+			t.Errorf("got signature %q, want %q", got, want)
+		}
+		// The first attempt fails, so the value is retried
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var v Value
+		json.Unmarshal(body, &v)
+		received <- v
+	}))
+	defer subscriber.Close()
+
+	var deadLetters bytes.Buffer
+	wh := NewWebhooks(nil, &deadLetters)
+	wh.initialBackoff = time.Millisecond
+	sub := wh.Subscribe(subscriber.URL, secret)
+	stop := wh.Start(1)
+
+	wh.Publish(Value{ID: 7, ServiceName: "serverB", Value: 108})
+	select {
+	case v := <-received:
+		if v.ID != 7 || v.Value != 108 {
+			t.Errorf("got %+v, want value 7 of 108", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("value was not delivered")
+	}
+	stop(context.Background())
+
+	if got, _ := wh.Subscription(sub.ID); got.Delivered != 1 || got.Failed != 0 {
+		t.Errorf("got %d delivered and %d failed, want 1 and 0", got.Delivered, got.Failed)
+	}
+	if deadLetters.Len() != 0 {
+		t.Errorf("got dead letters %s", deadLetters.String())
+	}
+}
+
+func TestWebhooksDeadLetter(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		status    int
+		wantCalls int32
+	}{
+		{"rejected", http.StatusBadRequest, 1},
+		{"failing", http.StatusInternalServerError, webhookMaxAttempts},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var calls int32
+			subscriber := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.WriteHeader(tc.status)
+			}))
+			defer subscriber.Close()
+
+			var deadLetters bytes.Buffer
+			wh := NewWebhooks(nil, &deadLetters)
+			wh.initialBackoff = time.Millisecond
+			wh.maxBackoff = time.Millisecond
+			sub := wh.Subscribe(subscriber.URL, []byte("s3cret"))
+			stop := wh.Start(1)
+			wh.Publish(Value{ID: 7, ServiceName: "serverB", Value: 108})
+			stop(context.Background())
+
+			if calls := atomic.LoadInt32(&calls); calls != tc.wantCalls {
+				t.Errorf("got %d attempts, want %d", calls, tc.wantCalls)
+			}
+			var letter DeadLetter
+			if err := json.Unmarshal(deadLetters.Bytes(), &letter); err != nil {
+				t.Fatalf("could not decode dead letter %q: %v", deadLetters.String(), err)
+			}
+			if letter.SubscriptionID != sub.ID || letter.Value.ID != 7 || letter.Attempts != int(tc.wantCalls) {
+				t.Errorf("got dead letter %+v", letter)
+			}
+		})
+	}
+}
+
+func TestSubscriptionsCall(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+
+	testCases := []struct {
+		desc       string
+		body       string
+		wantStatus int
+	}{
+		{"with secret", `{"url":"https://hooks.example.com/values","secret":"s3cret"}`, http.StatusCreated},
+		{"without secret", `{"url":"http://hooks.example.com/values"}`, http.StatusCreated},
+		{"relative url", `{"url":"/values"}`, http.StatusUnprocessableEntity},
+		{"not http", `{"url":"ftp://hooks.example.com"}`, http.StatusUnprocessableEntity},
+		{"unknown field", `{"url":"https://hooks.example.com","token":"x"}`, http.StatusBadRequest},
+	}
+
+	var created []subscriptionCreated
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			gm.subscriptionsCall(response, httptest.NewRequest(http.MethodPost, "/subscriptions", strings.NewReader(tc.body)))
+			if response.Code != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", response.Code, tc.wantStatus, response.Body)
+			}
+			if tc.wantStatus != http.StatusCreated {
+				return
+			}
+			var sub subscriptionCreated
+			json.NewDecoder(response.Body).Decode(&sub)
+			if sub.ID == "" || sub.Secret == "" || response.Header().Get("Location") != "/subscriptions/"+sub.ID {
+				t.Errorf("got subscription %+v at %q", sub, response.Header().Get("Location"))
+			}
+			created = append(created, sub)
+		})
+	}
+
+	// Secrets are not listed
+	response := httptest.NewRecorder()
+	gm.subscriptionsCall(response, httptest.NewRequest(http.MethodGet, "/subscriptions", nil))
+	if strings.Contains(response.Body.String(), "secret") {
+		t.Errorf("listed secrets: %s", response.Body)
+	}
+	var subs []Subscription
+	json.NewDecoder(response.Body).Decode(&subs)
+	if len(subs) != len(created) {
+		t.Fatalf("got %d subscriptions, want %d", len(subs), len(created))
+	}
+
+	path := "/subscriptions/" + created[0].ID
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		response := httptest.NewRecorder()
+		gm.subscriptionCall(response, httptest.NewRequest(http.MethodDelete, path, nil))
+		if response.Code != want {
+			t.Errorf("got status %d deleting, want %d", response.Code, want)
+		}
+	}
+	response = httptest.NewRecorder()
+	gm.subscriptionCall(response, httptest.NewRequest(http.MethodGet, path, nil))
+	if response.Code != http.StatusNotFound {
+		t.Errorf("got status %d for a deleted subscription, want 404", response.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shared/httpserver"
+)
+
+const (
+	// wsSendBuffer is how many values a client may fall behind by before
+	// it is disconnected
+	wsSendBuffer = 16
+	// wsWriteWait bounds each write to a client
+	wsWriteWait = 10 * time.Second
+	// wsPongWait is how long a client has to answer a ping
+	wsPongWait = 60 * time.Second
+	// wsPingPeriod must be less than wsPongWait, so a live client always
+	// has a ping to answer in time
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// wsCheckOrigin returns the check of the Origin of the requests to /ws.
+// Pages from the server's own origin may connect, which is all the
+// upgrader allows by default, as may those from the origins CORS allows.
+func wsCheckOrigin(config httpserver.CORSConfig) func(*http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return config.AllowOrigin(origin)
+	}
+}
+
+// wsCall handles the /ws route, sending each value posted from then on to
+// the client as a JSON message. Clients that fall behind are disconnected
+// with the close code 1013 (try again later), and all clients with 1001
+// (going away) when the server shuts down. Messages from the client are
+// ignored.
+func (sm *GlobalVarManager) wsCall(w http.ResponseWriter, r *http.Request) {
+	conn, err := sm.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded with the error
+		slog.WarnContext(r.Context(), "could not upgrade to a websocket", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub := sm.hub.Subscribe(wsSendBuffer)
+	defer sm.hub.Unsubscribe(sub)
+
+	// Reading is needed to process pings, pongs and close messages, and
+	// ends once the client has gone
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				code := websocket.CloseGoingAway
+				if sub.Err == ErrSlowSubscriber {
+					code = websocket.CloseTryAgainLater
+				}
+				slog.InfoContext(r.Context(), "closing websocket", "reason", sub.Err)
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, sub.Err.Error()))
+				return
+			}
+			if err := conn.WriteJSON(e.Value); err != nil {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
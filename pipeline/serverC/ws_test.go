@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"shared/httpserver"
+	"shared/logging"
+	"shared/telemetry"
+)
+
+func TestWebSocket(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	router := http.NewServeMux()
+	router.HandleFunc("/post", gm.postCall)
+	router.HandleFunc("/ws", gm.wsCall)
+
+	// The middleware must let the connection be hijacked
+	var logs bytes.Buffer
+	server := httptest.NewServer(httpserver.Chain(router, telemetry.Middleware("serverC"), httpserver.RequestID(httpserver.NewRequestID), httpserver.Logging(logging.New(&logs, nil))))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The post may be handled before the subscription is made, so keep
+	// posting until a value arrives
+	received := make(chan Value)
+	go func() {
+		var v Value
+		if err := conn.ReadJSON(&v); err == nil {
+			received <- v
+		}
+	}()
+	deadline := time.After(5 * time.Second)
+	for {
+		resp, err := http.Post(server.URL+"/post", "application/json", strings.NewReader(`{"serviceName":"serverB","value":8}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		select {
+		case v := <-received:
+			if v.ServiceName != "serverB" || v.Value != 108 {
+				t.Errorf("got %+v, want serverB's 108", v)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no value received")
+		}
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	gm := NewGlobalVarManager(NewMemoryStore())
+	gm.upgrader.CheckOrigin = wsCheckOrigin(httpserver.NewCORSConfig("https://dash.example.com", defaultCORSMethods, defaultCORSHeaders, corsExposedHeaders))
+	server := httptest.NewServer(http.HandlerFunc(gm.wsCall))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	testCases := []struct {
+		origin string
+		wantOK bool
+	}{
+		{"", true},
+		{server.URL, true},
+		{"https://dash.example.com", true},
+		{"https://evil.example.com", false},
+	}
+	for _, tc := range testCases {
+		header := http.Header{}
+		if tc.origin != "" {
+			header.Set("Origin", tc.origin)
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+		if err == nil {
+			conn.Close()
+		}
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("origin %q: got connected %v, want %v (%v)", tc.origin, gotOK, tc.wantOK, resp)
+		}
+	}
+}
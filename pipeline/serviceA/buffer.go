@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"shared/natsqueue"
+)
+
+const (
+	defaultBufferSize     = 1000
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+)
+
+// BufferStatus is the state of the buffer served at /status
+type BufferStatus struct {
+	Length   int   `json:"length"`
+	Capacity int   `json:"capacity"`
+	Sent     int64 `json:"sent"`
+	Dropped  int64 `json:"dropped"`  // because the buffer was full
+	Rejected int64 `json:"rejected"` // by serverB, so not retried
+}
+
+// bufferedValue is a value waiting to be sent, with the context of the
+// request it started, which carries its request ID and trace
+type bufferedValue struct {
+	ctx   context.Context
+	value int
+}
+
+// done ends the span of the value's request, once it has been sent,
+// rejected, dropped or abandoned
+func (v bufferedValue) done() {
+	trace.SpanFromContext(v.ctx).End()
+}
+
+// Buffer holds the values generated while serverB is down, so they are
+// sent once it recovers rather than lost. Values are sent one at a time in
+// the order they were added; a failed send is retried with exponential
+// backoff, holding up the values behind it. The buffer is bounded, and
+// values added while it is full are dropped and counted.
+type Buffer struct {
+	send   func(ctx context.Context, value int) error
+	values chan bufferedValue
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	sent, dropped, rejected atomic.Int64
+}
+
+// NewBuffer returns a buffer of size values, sending each with send
+func NewBuffer(send func(ctx context.Context, value int) error, size int) *Buffer {
+	return &Buffer{
+		send:           send,
+		values:         make(chan bufferedValue, size),
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+}
+
+// Add queues the value to be sent, returning false if it was dropped as
+// the buffer is full. It does not block. The span carried by ctx is ended
+// once the value is done with, so the trace covers every attempt to send
+// it.
+func (b *Buffer) Add(ctx context.Context, value int) bool {
+	v := bufferedValue{ctx, value}
+	select {
+	case b.values <- v:
+		return true
+	default:
+		b.dropped.Add(1)
+		slog.WarnContext(ctx, "buffer full, dropping value", "value", value, "capacity", cap(b.values))
+		v.done()
+		return false
+	}
+}
+
+// Run sends the buffered values until ctx is cancelled. Values rejected by
+// serverB are dropped, as sending them again would not change the answer;
+// any other error is retried.
+func (b *Buffer) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case v := <-b.values:
+			if !b.deliver(ctx, v) {
+				return
+			}
+		}
+	}
+}
+
+// deliver sends the value, retrying until it succeeds or is rejected. It
+// returns false if ctx was cancelled first.
+func (b *Buffer) deliver(ctx context.Context, v bufferedValue) bool {
+	defer v.done()
+	backoff := b.initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := b.send(v.ctx, v.value)
+		var rejected natsqueue.RejectedError
+		switch {
+		case err == nil:
+			b.sent.Add(1)
+			return true
+		case errors.As(err, &rejected):
+			b.rejected.Add(1)
+			trace.SpanFromContext(v.ctx).RecordError(err)
+			slog.WarnContext(v.ctx, "value rejected", "value", v.value, "err", err)
+			return true
+		}
+
+		slog.WarnContext(v.ctx, "error sending value", "err", err, "attempt", attempt, "backoff", backoff, "buffered", len(b.values))
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, b.maxBackoff)
+	}
+}
+
+// Status returns the current state of the buffer
+func (b *Buffer) Status() BufferStatus {
+	return BufferStatus{
+		Length:   len(b.values),
+		Capacity: cap(b.values),
+		Sent:     b.sent.Load(),
+		Dropped:  b.dropped.Load(),
+		Rejected: b.rejected.Load(),
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"shared/natsqueue"
+)
+
+// testSend answers each value sent with the next of errs in turn, then
+// nil, passing the value on to sent
+type testSend struct {
+	errs []error
+	sent chan int
+}
+
+func newTestSend(errs ...error) *testSend {
+	return &testSend{errs: errs, sent: make(chan int, 100)}
+}
+
+func (s *testSend) send(ctx context.Context, value int) error {
+	s.sent <- value
+	if len(s.errs) == 0 {
+		return nil
+	}
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+func newTestBuffer(send func(context.Context, int) error, size int) *Buffer {
+	b := NewBuffer(send, size)
+	b.initialBackoff = time.Millisecond
+	b.maxBackoff = 5 * time.Millisecond
+	return b
+}
+
+// waitFor polls until the condition holds, failing the test if it takes
+// longer than a second
+func waitFor(t *testing.T, desc string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", desc)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBufferSendsInOrder(t *testing.T) {
+	s := newTestSend(errors.New("serverB down"), errors.New("serverB down"))
+	b := newTestBuffer(s.send, 10)
+	for value := 1; value <= 3; value++ {
+		if !b.Add(context.Background(), value) {
+			t.Fatalf("value %d dropped", value)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	// The first value is retried until it is sent, holding up the others
+	want := []int{1, 1, 1, 2, 3}
+	for i, w := range want {
+		if got := <-s.sent; got != w {
+			t.Fatalf("send %d: got value %d, want %d", i, got, w)
+		}
+	}
+	waitFor(t, "the values to be sent", func() bool { return b.Status().Sent == 3 })
+}
+
+func TestBufferFull(t *testing.T) {
+	b := newTestBuffer(newTestSend().send, 2)
+	for value := 1; value <= 2; value++ {
+		if !b.Add(context.Background(), value) {
+			t.Fatalf("value %d dropped", value)
+		}
+	}
+	if b.Add(context.Background(), 3) {
+		t.Error("value added to a full buffer")
+	}
+
+	want := BufferStatus{Length: 2, Capacity: 2, Dropped: 1}
+	if got := b.Status(); got != want {
+		t.Errorf("got status %+v, want %+v", got, want)
+	}
+}
+
+func TestBufferRejected(t *testing.T) {
+	s := newTestSend(natsqueue.Reject(errors.New("serverB responded 400 Bad Request")))
+	b := newTestBuffer(s.send, 10)
+	b.Add(context.Background(), 1)
+	b.Add(context.Background(), 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+
+	// The rejected value is not sent again, so the next is sent straight away
+	for _, want := range []int{1, 2} {
+		if got := <-s.sent; got != want {
+			t.Fatalf("got value %d, want %d", got, want)
+		}
+	}
+	waitFor(t, "the second value to be sent", func() bool { return b.Status().Sent == 1 })
+	if got := b.Status().Rejected; got != 1 {
+		t.Errorf("got %d rejected, want 1", got)
+	}
+}
+
+func TestBufferStopsOnCancel(t *testing.T) {
+	failing := func(ctx context.Context, value int) error { return errors.New("serverB down") }
+	b := newTestBuffer(failing, 10)
+	b.maxBackoff = time.Hour
+	b.initialBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	delivered := make(chan bool)
+	go func() {
+		delivered <- b.deliver(ctx, bufferedValue{context.Background(), 1})
+	}()
+	cancel()
+
+	select {
+	case ok := <-delivered:
+		if ok {
+			t.Error("got delivered, want false once cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still backing off after being cancelled")
+	}
+}
+
+// The span of a value's request covers every attempt to send it, ending
+// once it has been sent rather than once it has been buffered
+func TestBufferEndsSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	s := newTestSend(errors.New("serverB down"))
+	b := newTestBuffer(s.send, 10)
+	ctx, _ := provider.Tracer("test").Start(context.Background(), "send value")
+	b.Add(ctx, 1)
+	if n := len(recorder.Ended()); n != 0 {
+		t.Fatalf("got %d spans ended once buffered, want 0", n)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(runCtx)
+
+	waitFor(t, "the span to end", func() bool { return len(recorder.Ended()) == 1 })
+	if got := b.Status().Sent; got != 1 {
+		t.Errorf("got %d sent once the span ended, want 1", got)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"shared/configfile"
+	"shared/discovery"
+)
+
+// Config holds the runtime settings of serviceA, each tagged with the name
+// of its flag
+type Config struct {
+	StatusAddr string     `flag:"status-addr"`
+	LogLevel   slog.Level `flag:"log-level"`
+
+	DownstreamURL  string `flag:"downstream-url"`
+	DownstreamSRV  string `flag:"downstream-srv"`
+	DownstreamGRPC string `flag:"downstream-grpc"`
+	NATSURL        string `flag:"nats-url"`
+	DownstreamCert string `flag:"downstream-cert"`
+	DownstreamKey  string `flag:"downstream-key"`
+	DownstreamCA   string `flag:"downstream-ca"`
+
+	DiscoveryInterval time.Duration `flag:"discovery-interval"`
+
+	Interval     time.Duration `flag:"interval"`
+	Distribution string        `flag:"distribution"`
+	Min          int           `flag:"min"`
+	Max          int           `flag:"max"`
+	Senders      int           `flag:"senders"`
+	BufferSize   int           `flag:"buffer-size"`
+
+	LoadTest     bool          `flag:"loadtest"`
+	LoadRPS      int           `flag:"rps"`
+	LoadWorkers  int           `flag:"workers"`
+	LoadDuration time.Duration `flag:"duration"`
+
+	AdminToken string `flag:"admin-token"`
+}
+
+// parseConfig reads the config from args, the command line without the
+// program name, writing usage and errors to output
+func parseConfig(args []string, output io.Writer) (Config, error) {
+	var c Config
+	level, err := configfile.EnvLevel("LOG_LEVEL")
+	if err != nil {
+		return c, err
+	}
+
+	fs := flag.NewFlagSet("serviceA", flag.ContinueOnError)
+	fs.SetOutput(output)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON file of settings keyed by flag name, read again on SIGHUP, also set by CONFIG_FILE")
+	fs.StringVar(&c.StatusAddr, "status-addr", defaultStatusAddr, "address serving /status, /healthz and /readyz")
+	fs.TextVar(&c.LogLevel, "log-level", level, "lowest level logged: debug, info, warn or error, also set by LOG_LEVEL")
+	// The downstream URL can be set in the environment, for deployments
+	// where the services run on different hosts, and the flag overrides it
+	fs.StringVar(&c.DownstreamURL, "downstream-url", envOr("DOWNSTREAM_URL", defaultDownstreamURL), "URL of serverB's /post endpoint, also set by DOWNSTREAM_URL")
+	fs.StringVar(&c.DownstreamSRV, "downstream-srv", os.Getenv("DOWNSTREAM_SRV"), "DNS SRV record, such as _http._tcp.serverb.service.consul, listing the serverB instances to send to in place of the host of -downstream-url, also set by DOWNSTREAM_SRV")
+	fs.DurationVar(&c.DiscoveryInterval, "discovery-interval", discovery.DefaultInterval, "how often to look up -downstream-srv again")
+	fs.StringVar(&c.DownstreamCert, "downstream-cert", "", "certificate file to present to serverB (mutual TLS)")
+	fs.StringVar(&c.DownstreamKey, "downstream-key", "", "key file of -downstream-cert")
+	fs.StringVar(&c.DownstreamCA, "downstream-ca", "", "CA file to verify serverB's certificate with, instead of the system's")
+	fs.StringVar(&c.DownstreamGRPC, "downstream-grpc", "", "address of serverB's gRPC pipeline, such as localhost:9001, to send values over gRPC instead of HTTP")
+	fs.StringVar(&c.NATSURL, "nats-url", "", "NATS server, such as nats://localhost:4222, to publish values for serverB to, instead of HTTP")
+	fs.DurationVar(&c.Interval, "interval", defaultInterval, "how often each sender sends a value")
+	fs.StringVar(&c.Distribution, "distribution", distributionUniform, "distribution of the values sent: uniform, normal or ramp")
+	fs.IntVar(&c.Min, "min", 0, "smallest value sent")
+	fs.IntVar(&c.Max, "max", 9, "largest value sent")
+	fs.IntVar(&c.Senders, "senders", 1, "number of senders sending values concurrently")
+	fs.IntVar(&c.BufferSize, "buffer-size", defaultBufferSize, "how many values to hold while serverB is down before dropping them")
+	fs.BoolVar(&c.LoadTest, "loadtest", false, "send values at -rps from -workers for -duration, print the throughput and latencies, and exit")
+	fs.IntVar(&c.LoadRPS, "rps", 100, "values a second a load test sends")
+	fs.IntVar(&c.LoadWorkers, "workers", 10, "number of workers a load test sends values from concurrently")
+	fs.DurationVar(&c.LoadDuration, "duration", 10*time.Second, "how long a load test sends values for")
+	fs.StringVar(&c.AdminToken, "admin-token", os.Getenv("ADMIN_TOKEN"), "token the /admin routes must be called with, as an Authorization: Bearer header; they are not served without one, also set by ADMIN_TOKEN")
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	if *configFile != "" {
+		if err := configfile.Apply(fs, *configFile); err != nil {
+			return c, err
+		}
+	}
+	return c, c.validate()
+}
+
+// validate returns the first problem found with the settings
+func (c Config) validate() error {
+	if c.DownstreamGRPC != "" && c.NATSURL != "" {
+		return errors.New("-downstream-grpc and -nats-url cannot both be set")
+	}
+	if c.DownstreamSRV != "" && (c.DownstreamGRPC != "" || c.NATSURL != "") {
+		return errors.New("-downstream-srv only applies to sending over HTTP")
+	}
+	if c.DiscoveryInterval <= 0 {
+		return errors.New("-discovery-interval must be positive")
+	}
+	if c.Interval <= 0 {
+		return errors.New("-interval must be positive")
+	}
+	if c.Senders < 1 {
+		return errors.New("-senders must be at least 1")
+	}
+	if c.BufferSize < 1 {
+		return errors.New("-buffer-size must be at least 1")
+	}
+	if c.LoadTest {
+		if c.DownstreamGRPC != "" || c.NATSURL != "" {
+			return errors.New("-loadtest sends values over HTTP, so -downstream-grpc and -nats-url cannot be set")
+		}
+		if c.LoadRPS < 1 || c.LoadWorkers < 1 || c.LoadDuration <= 0 {
+			return errors.New("-rps and -workers must be at least 1, and -duration positive")
+		}
+	}
+	if _, err := newGenerator(c.Distribution, c.Min, c.Max, 0); err != nil {
+		return err
+	}
+	return validateURL(c.DownstreamURL)
+}
+
+// reloader applies the configs read on SIGHUP to the running service. The
+// log level, the downstream URL and the values sent change straight away,
+// and the interval from the value after next; the other settings take
+// effect on restart.
+type reloader struct {
+	current Config
+	level   *slog.LevelVar
+	// downstreamURL is nil unless values are sent to serverB over HTTP, as
+	// the URL is not used otherwise
+	downstreamURL *atomic.Value
+	schedule      *schedule
+}
+
+// apply changes the running service to the config, logging the settings
+// changed. Only the settings it applies are kept as current, so the others
+// are logged as pending on every reload until the restart.
+func (r *reloader) apply(next Config) {
+	old := r.current
+
+	// The generators are only replaced if they change, so a ramp carries
+	// on through other reloads
+	if next.Distribution != r.current.Distribution || next.Min != r.current.Min || next.Max != r.current.Max {
+		if err := r.schedule.setValues(next.Distribution, next.Min, next.Max); err != nil {
+			slog.Error("could not reload config", "err", err)
+			return
+		}
+	}
+	r.schedule.setInterval(next.Interval)
+	r.level.Set(next.LogLevel)
+	r.current.Distribution = next.Distribution
+	r.current.Min = next.Min
+	r.current.Max = next.Max
+	r.current.Interval = next.Interval
+	r.current.LogLevel = next.LogLevel
+	if r.downstreamURL != nil {
+		r.downstreamURL.Store(next.DownstreamURL)
+		r.current.DownstreamURL = next.DownstreamURL
+	}
+
+	slog.Info("reloaded config", "applied", configfile.Changed(old, r.current))
+	if pending := configfile.Changed(r.current, next); len(pending) > 0 {
+		slog.Warn("settings changed that take effect on restart", "settings", pending)
+	}
+}
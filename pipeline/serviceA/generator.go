@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// The distributions the generated values can follow
+const (
+	distributionUniform = "uniform"
+	distributionNormal  = "normal"
+	distributionRamp    = "ramp"
+)
+
+// generator produces the values to send, between min and max inclusive.
+// It is not safe for concurrent use, so each sender has its own, held by
+// the schedule.
+type generator struct {
+	rnd          *rand.Rand
+	distribution string
+	min, max     int
+	next         int // the next value of a ramp
+}
+
+// newGenerator returns a generator of values following the distribution
+// between min and max. Uniform values are equally likely, normal values
+// cluster around the middle of the range with six standard deviations
+// spanning it, and a ramp counts up from min to max and starts again.
+func newGenerator(distribution string, min, max int, seed int64) (*generator, error) {
+	switch distribution {
+	case distributionUniform, distributionNormal, distributionRamp:
+	default:
+		return nil, fmt.Errorf("unknown distribution %q, want %s, %s or %s", distribution, distributionUniform, distributionNormal, distributionRamp)
+	}
+	if min > max {
+		return nil, fmt.Errorf("min %d is greater than max %d", min, max)
+	}
+	return &generator{
+		rnd:          rand.New(rand.NewSource(seed)),
+		distribution: distribution,
+		min:          min,
+		max:          max,
+		next:         min,
+	}, nil
+}
+
+// value returns the next value to send
+func (g *generator) value() int {
+	switch g.distribution {
+	case distributionNormal:
+		mean := float64(g.min+g.max) / 2
+		stddev := float64(g.max-g.min) / 6
+		v := int(math.Round(g.rnd.NormFloat64()*stddev + mean))
+		return max(g.min, min(g.max, v))
+	case distributionRamp:
+		v := g.next
+		if g.next == g.max {
+			g.next = g.min
+		} else {
+			g.next++
+		}
+		return v
+	default:
+		return g.min + g.rnd.Intn(g.max-g.min+1)
+	}
+}
+
+// schedule holds the generator of each sender and how often they send a
+// value, both of which can be changed while the senders run
+type schedule struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	generators []*generator
+}
+
+// newSchedule returns the schedule of the number of senders
+func newSchedule(senders int, distribution string, min, max int, interval time.Duration) (*schedule, error) {
+	s := &schedule{interval: interval, generators: make([]*generator, senders)}
+	return s, s.setValues(distribution, min, max)
+}
+
+// setValues replaces the generators of the senders. The new generators
+// start afresh, so a ramp starts again from min.
+func (s *schedule) setValues(distribution string, min, max int) error {
+	seed := time.Now().UnixNano()
+	generators := make([]*generator, len(s.generators))
+	for i := range generators {
+		gen, err := newGenerator(distribution, min, max, seed+int64(i))
+		if err != nil {
+			return err
+		}
+		generators[i] = gen
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generators = generators
+	return nil
+}
+
+// setInterval changes how often each sender sends a value, from the value
+// after next
+func (s *schedule) setInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+}
+
+// value returns the next value of the sender's generator
+func (s *schedule) value(sender int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.generators[sender].value()
+}
+
+// every returns how often each sender sends a value
+func (s *schedule) every() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interval
+}
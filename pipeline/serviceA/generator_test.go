@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewGenerator(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		distribution string
+		min, max     int
+		wantErr      bool
+	}{
+		{"uniform", distributionUniform, 0, 10, false},
+		{"normal", distributionNormal, -5, 5, false},
+		{"ramp", distributionRamp, 0, 3, false},
+		{"single value", distributionUniform, 7, 7, false},
+		{"min above max", distributionUniform, 10, 0, true},
+		{"unknown distribution", "poisson", 0, 10, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			_, err := newGenerator(testCase.distribution, testCase.min, testCase.max, 1)
+			if gotErr := err != nil; gotErr != testCase.wantErr {
+				t.Errorf("got error %v, want error %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestGeneratorRange(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		distribution string
+		min, max     int
+	}{
+		{"uniform", distributionUniform, -3, 3},
+		// A narrow range, so the tails of the distribution are clamped
+		{"normal", distributionNormal, 0, 2},
+		{"ramp", distributionRamp, 5, 8},
+		{"single value", distributionNormal, 7, 7},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			g, err := newGenerator(testCase.distribution, testCase.min, testCase.max, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10000; i++ {
+				if v := g.value(); v < testCase.min || v > testCase.max {
+					t.Fatalf("got value %d, want between %d and %d", v, testCase.min, testCase.max)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorRamp(t *testing.T) {
+	g, err := newGenerator(distributionRamp, 1, 3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int{1, 2, 3, 1, 2, 3, 1}
+	for i, w := range want {
+		if got := g.value(); got != w {
+			t.Errorf("value %d: got %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestScheduleReload(t *testing.T) {
+	s, err := newSchedule(2, distributionRamp, 1, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.value(0)
+	s.value(0)
+
+	// A new interval keeps the generators, so the ramp carries on
+	s.setInterval(time.Minute)
+	if got := s.value(0); got != 3 {
+		t.Errorf("got value %d after changing the interval, want 3", got)
+	}
+	if got := s.every(); got != time.Minute {
+		t.Errorf("got interval %v, want %v", got, time.Minute)
+	}
+
+	if err := s.setValues(distributionRamp, 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	for sender := 0; sender < 2; sender++ {
+		if got := s.value(sender); got != 10 {
+			t.Errorf("sender %d: got value %d after changing the range, want 10", sender, got)
+		}
+	}
+	if err := s.setValues(distributionRamp, 20, 10); err == nil {
+		t.Error("got no error for min above max")
+	}
+}
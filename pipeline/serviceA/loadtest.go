@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"shared/httpserver"
+)
+
+// loadTestTimeout is how long a load test waits for each value to be
+// acknowledged before counting it as failed
+const loadTestTimeout = 10 * time.Second
+
+// LoadTest sends values at a target rate from a number of workers for a
+// fixed duration, timing each from being sent to being acknowledged
+type LoadTest struct {
+	RPS      int
+	Workers  int
+	Duration time.Duration
+}
+
+// LoadReport is the outcome of a load test. Latencies are of the values
+// acknowledged; those failing are only counted.
+type LoadReport struct {
+	Workers   int
+	Elapsed   time.Duration
+	Sent      int
+	Succeeded int
+	Failed    int
+	// Missed counts the values due while every worker was busy, which
+	// were not sent, so the target rate was not reached
+	Missed        int
+	P50, P95, P99 time.Duration
+}
+
+// Run sends the value returned by next every 1/RPS seconds, through the
+// first idle worker, until the duration is up or ctx is done. The workers
+// finish the values they are sending before Run returns.
+func (t LoadTest) Run(ctx context.Context, next func() int, send func(context.Context, int) error) LoadReport {
+	ctx, cancel := context.WithTimeout(ctx, t.Duration)
+	defer cancel()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failed    int
+	)
+	values := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < t.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for value := range values {
+				// The values in flight are finished even once the test is
+				// over, so their latencies are not cut short
+				sendCtx := httpserver.WithRequestID(context.WithoutCancel(ctx), httpserver.NewRequestID())
+				start := time.Now()
+				err := send(sendCtx, value)
+				latency := time.Since(start)
+
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					latencies = append(latencies, latency)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	report := LoadReport{Workers: t.Workers}
+	start := time.Now()
+	ticker := time.NewTicker(time.Second / time.Duration(t.RPS))
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+			select {
+			case values <- next():
+				report.Sent++
+			default:
+				report.Missed++
+			}
+		}
+	}
+	close(values)
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.Succeeded = len(latencies)
+	report.Failed = failed
+	report.P50 = percentile(latencies, 50)
+	report.P95 = percentile(latencies, 95)
+	report.P99 = percentile(latencies, 99)
+	return report
+}
+
+// percentile returns the nearest-rank percentile p of the sorted
+// latencies, or 0 if there are none
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// Throughput returns the values acknowledged a second
+func (r LoadReport) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Succeeded) / r.Elapsed.Seconds()
+}
+
+// Write prints the report for a person to read
+func (r LoadReport) Write(w io.Writer) {
+	fmt.Fprintf(w, "sent %d values in %v with %d workers: %d succeeded, %d failed, %d missed\n",
+		r.Sent, r.Elapsed.Round(time.Millisecond), r.Workers, r.Succeeded, r.Failed, r.Missed)
+	fmt.Fprintf(w, "throughput %.1f values/s\n", r.Throughput())
+	fmt.Fprintf(w, "latency p50 %v p95 %v p99 %v\n", r.P50, r.P95, r.P99)
+}
+
+// correlatedSender returns a function posting values to serverB's /post
+// endpoint at downstreamURL, for load tests. serverB answers once it has
+// forwarded the value to serverC, passing back the request ID serverC
+// stored it with in the X-Downstream-Request-Id header. The request ID is
+// sent on by both, so a response carrying the ID sent is the value's
+// end-to-end acknowledgement. Anything else, including serverB queueing
+// the value for serverC rather than forwarding it, is a failure.
+func correlatedSender(client *http.Client, downstreamURL string) func(context.Context, int) error {
+	return func(ctx context.Context, value int) error {
+		body, err := json.Marshal(&Service{
+			ServiceName: "serviceA",
+			Value:       value,
+		})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", downstreamURL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		requestID, _ := httpserver.RequestIDFrom(ctx)
+		req.Header.Set("X-Request-Id", requestID)
+		req.Header.Set("Idempotency-Key", requestID)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		// The body is read to the end, so the connection can be reused
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("serverB responded %s: %s", resp.Status, respBody)
+		}
+		if acknowledged := resp.Header.Get("X-Downstream-Request-Id"); acknowledged != requestID {
+			return fmt.Errorf("got request ID %q acknowledged by serverC, want %q", acknowledged, requestID)
+		}
+		return nil
+	}
+}
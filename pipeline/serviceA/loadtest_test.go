@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shared/httpserver"
+)
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	testCases := []struct {
+		desc      string
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{"none", nil, 50, 0},
+		{"one", latencies[:1], 99, time.Millisecond},
+		{"median", latencies, 50, 50 * time.Millisecond},
+		{"p95", latencies, 95, 95 * time.Millisecond},
+		{"p99", latencies, 99, 99 * time.Millisecond},
+		{"p99 of ten", latencies[:10], 99, 10 * time.Millisecond},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			if got := percentile(testCase.latencies, testCase.p); got != testCase.want {
+				t.Errorf("got %v, want %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestLoadTestRun(t *testing.T) {
+	var sent int32
+	send := func(ctx context.Context, value int) error {
+		if _, ok := httpserver.RequestIDFrom(ctx); !ok {
+			t.Error("got no request ID")
+		}
+		// Every other value fails
+		if atomic.AddInt32(&sent, 1)%2 == 0 {
+			return errors.New("serverB is down")
+		}
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	test := LoadTest{RPS: 200, Workers: 4, Duration: 100 * time.Millisecond}
+	report := test.Run(context.Background(), func() int { return 1 }, send)
+	if report.Sent == 0 || report.Sent != int(atomic.LoadInt32(&sent)) {
+		t.Fatalf("got %v sent, want the %v values sent", report.Sent, sent)
+	}
+	if report.Succeeded+report.Failed != report.Sent || report.Failed != report.Sent/2 {
+		t.Errorf("got %+v, want half of the values failed", report)
+	}
+	if report.P50 < time.Millisecond || report.P99 < report.P50 {
+		t.Errorf("got latencies p50 %v p99 %v, want at least 1ms and rising", report.P50, report.P99)
+	}
+
+	var out bytes.Buffer
+	report.Write(&out)
+	if !strings.Contains(out.String(), "throughput") || !strings.Contains(out.String(), "p99") {
+		t.Errorf("got report %q", out.String())
+	}
+}
+
+func TestCorrelatedSender(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{"acknowledged", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+			w.Header().Set("X-Downstream-Request-Id", r.Header.Get("X-Request-Id"))
+		}, false},
+		// serverB echoes the ID whether or not serverC had the value
+		{"only echoed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+		}, true},
+		{"another ID", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Downstream-Request-Id", "other")
+		}, true},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Downstream-Request-Id", r.Header.Get("X-Request-Id"))
+			w.WriteHeader(http.StatusBadGateway)
+		}, true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.desc, func(t *testing.T) {
+			server := httptest.NewServer(testCase.handler)
+			defer server.Close()
+
+			send := correlatedSender(server.Client(), server.URL)
+			err := send(httpserver.WithRequestID(context.Background(), "abc"), 5)
+			if gotErr := err != nil; gotErr != testCase.wantErr {
+				t.Errorf("got error %v, want error %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"servicea/pipelinepb"
+	"shared/circuit"
+	"shared/configfile"
+	"shared/discovery"
+	"shared/health"
+	"shared/httpserver"
+	"shared/logging"
+	"shared/natsqueue"
+	"shared/rpc"
+	"shared/telemetry"
+	"shared/tlsconfig"
+)
+
+const (
+	defaultDownstreamURL string = "http://localhost:9000/post"
+	defaultStatusAddr    string = ":9100"
+	defaultInterval             = 500 * time.Millisecond
+
+	// grpcSendTimeout is the deadline of each gRPC call to serverB
+	grpcSendTimeout = 10 * time.Second
+
+	// The status routes take no body, and answer within statusTimeout
+	// unless a readiness check hangs
+	maxStatusBytes = 1 << 10
+	statusTimeout  = 5 * time.Second
+)
+
+// Service struct
+type Service struct {
+	ServiceName string `json:"serviceName"`
+	Value       int    `json:"value"`
+}
+
+func main() {
+	var mainErr error
+	config, err := parseConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	var level slog.LevelVar
+	level.Set(config.LogLevel)
+	logger := logging.New(os.Stdout, &level)
+	slog.SetDefault(logger)
+
+	// Deferred functions run in reverse order so this will be the last
+	// one called, after any tidy up.
+	defer func() {
+		if mainErr != nil {
+			logger.Error("error encountered", "err", mainErr)
+			os.Exit(1)
+		} else {
+			logger.Info("exiting")
+		}
+	}()
+
+	if err != nil {
+		mainErr = fmt.Errorf("invalid config: %v", err)
+		return
+	}
+	// Each sender has its own generator, as they are not safe for
+	// concurrent use
+	sched, err := newSchedule(config.Senders, config.Distribution, config.Min, config.Max, config.Interval)
+	if err != nil {
+		mainErr = fmt.Errorf("invalid values: %v", err)
+		return
+	}
+	// The downstream URL is looked up for each value, as it changes on
+	// reload. Load tests keep the one they started with, and values sent
+	// to the queue or over gRPC do not use it, so it is not reloaded then.
+	var downstreamURL atomic.Value
+	downstreamURL.Store(config.DownstreamURL)
+	reload := &reloader{current: config, level: &level, schedule: sched}
+	if !config.LoadTest && config.NATSURL == "" && config.DownstreamGRPC == "" {
+		reload.downstreamURL = &downstreamURL
+	}
+	configfile.ReloadOnSIGHUP(os.Args[1:], parseConfig, reload.apply)
+
+	clientTLS, clientCert, err := tlsconfig.Client(config.DownstreamCert, config.DownstreamKey, config.DownstreamCA)
+	if err != nil {
+		mainErr = fmt.Errorf("invalid downstream TLS: %v", err)
+		return
+	}
+	tlsconfig.ReloadOnSIGHUP(clientCert)
+
+	// With a SRV record, values fail over between the serverB instances it
+	// lists, in place of the host of the downstream URL
+	transport := tlsconfig.Transport(clientTLS)
+	if config.DownstreamSRV != "" {
+		resolver := discovery.NewResolver(transport, config.DownstreamSRV, config.DiscoveryInterval)
+		if err := resolver.Refresh(context.Background()); err != nil {
+			mainErr = fmt.Errorf("discovering serverB: %v", err)
+			return
+		}
+		defer resolver.Start()()
+		transport = resolver
+	}
+
+	// A load test sends values as fast as asked for a while and reports
+	// how quickly they were acknowledged, instead of running as a service
+	if config.LoadTest {
+		test := LoadTest{RPS: config.LoadRPS, Workers: config.LoadWorkers, Duration: config.LoadDuration}
+		client := &http.Client{Transport: transport, Timeout: loadTestTimeout}
+		logger.Info("load testing", "downstream", config.DownstreamURL, "rps", test.RPS, "workers", test.Workers, "duration", test.Duration)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		next := func() int { return sched.value(0) }
+		test.Run(ctx, next, correlatedSender(client, config.DownstreamURL)).Write(os.Stdout)
+		return
+	}
+
+	shutdownTracing, err := telemetry.Setup(context.Background(), "serviceA")
+	if err != nil {
+		mainErr = fmt.Errorf("setting up tracing: %v", err)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("could not flush traces", "err", err)
+		}
+	}()
+
+	errs := make(chan error)
+
+	// The breaker stops values being sent while serverB is down, and its
+	// state is served at /status. Values are published to the queue or
+	// sent over gRPC if either is given, and HTTP otherwise. The queue
+	// holds values while serverB is down, so needs no breaker.
+	var (
+		breaker         *circuit.Breaker
+		send            func(ctx context.Context, value int) error
+		downstreamCheck func() error
+	)
+	if config.NATSURL != "" {
+		logger.Info("sending values", "downstream", config.NATSURL, "subject", natsqueue.SubjectServerB)
+		queue, err := natsqueue.Connect(context.Background(), config.NATSURL, "serviceA")
+		if err != nil {
+			mainErr = fmt.Errorf("connecting to NATS: %v", err)
+			return
+		}
+		defer queue.Close()
+		breaker = circuit.NewBreaker(nil, config.NATSURL, circuit.DefaultFailures, circuit.DefaultCooldown)
+		send = func(ctx context.Context, value int) error {
+			if err := queue.Publish(ctx, natsqueue.SubjectServerB, "serviceA", value); err != nil {
+				return err
+			}
+			logger.InfoContext(ctx, "published value")
+			return nil
+		}
+		downstreamCheck = queue.Check
+	} else if config.DownstreamGRPC != "" {
+		logger.Info("sending values", "downstream", config.DownstreamGRPC, "grpc", true)
+		breaker = circuit.NewBreaker(nil, config.DownstreamGRPC, circuit.DefaultFailures, circuit.DefaultCooldown)
+		conn, err := rpc.Dial(config.DownstreamGRPC, clientTLS, breaker.UnaryClientInterceptor())
+		if err != nil {
+			mainErr = fmt.Errorf("invalid downstream gRPC address: %v", err)
+			return
+		}
+		defer conn.Close()
+		send = grpcSender(pipelinepb.NewPipelineClient(conn))
+		downstreamCheck = rpc.CheckHealth(conn)
+	} else {
+		logger.Info("sending values", "downstream", config.DownstreamURL, "srv", config.DownstreamSRV)
+		breaker = circuit.NewBreaker(transport, config.DownstreamURL, circuit.DefaultFailures, circuit.DefaultCooldown)
+		url := func() string { return downstreamURL.Load().(string) }
+		send = httpSender(&http.Client{Transport: telemetry.Transport(breaker)}, url)
+		downstreamCheck = func() error { return health.CheckHealthz(url(), transport)() }
+	}
+
+	// Values wait in the buffer while serverB is down, and are sent in
+	// order once it recovers
+	buffer := NewBuffer(send, config.BufferSize)
+	ctx, stopBuffer := context.WithCancel(context.Background())
+	defer func() {
+		stopBuffer()
+		if n := buffer.Status().Length; n > 0 {
+			logger.Warn("values left unsent", "count", n)
+		}
+	}()
+	go buffer.Run(ctx)
+
+	router := http.NewServeMux()
+	router.HandleFunc("/status", serveStatus(breaker, buffer))
+	router.HandleFunc("/healthz", health.Healthz)
+	router.HandleFunc("/readyz", health.Readyz(health.Check{Name: "downstream", Check: downstreamCheck}))
+
+	// Failures can be injected into the requests at /admin/chaos, to show
+	// the retries and circuit breakers of the callers working, and the
+	// health checks failed at /admin/health, to drill draining an instance.
+	// /admin/vars serves the panics recovered along with the runtime's
+	// memory statistics.
+	chaos := httpserver.NewChaos()
+	if config.AdminToken != "" {
+		admin := httpserver.Admin(config.AdminToken)
+		router.Handle("/admin/chaos", admin(chaos))
+		router.Handle("/admin/health", admin(http.HandlerFunc(health.Admin)))
+		router.Handle("/admin/vars", admin(expvar.Handler()))
+	}
+	statusServer := httpserver.NewServer(httpserver.Options{
+		Addr: config.StatusAddr,
+		Handler: httpserver.Chain(router,
+			httpserver.Recover(logger),
+			httpserver.Timeout(statusTimeout),
+			httpserver.MaxBytes(maxStatusBytes),
+			chaos.Middleware(),
+		),
+		Logger: logger,
+	})
+	statusServer.OnDrain(func() { health.SetHealthy(false) })
+
+	// The status server runs until SIGINT or SIGTERM, and once it has shut
+	// down so does serviceA
+	go func() {
+		if err := statusServer.Run(context.Background()); err != nil {
+			errs <- fmt.Errorf("status server: %v", err)
+			return
+		}
+		errs <- nil
+	}()
+
+	// Go-routines to send mock values to Server B
+	logger.Info("generating values", "interval", config.Interval, "distribution", config.Distribution, "min", config.Min, "max", config.Max, "senders", config.Senders)
+	for i := 0; i < config.Senders; i++ {
+		go func(sender int) {
+			interval := sched.every()
+			ticker := time.NewTicker(interval)
+			for range ticker.C {
+				value := sched.value(sender)
+
+				// Each value starts a new request, whose ID is passed along
+				// to serverB and serverC so it can be traced through the logs.
+				// The buffer ends its span once the value has been sent.
+				ctx, _ := otel.Tracer("servicea").Start(httpserver.WithRequestID(context.Background(), httpserver.NewRequestID()), "send value")
+				logger.InfoContext(ctx, "sending value", "value", value)
+				buffer.Add(ctx, value)
+
+				if next := sched.every(); next != interval {
+					ticker.Reset(next)
+					interval = next
+				}
+			}
+
+			errs <- fmt.Errorf("ticker loop closed")
+		}(i)
+	}
+
+	health.SetHealthy(true)
+
+	// Block execution until the status server has shut down or any errors
+	// are encountered. Deferred functions will be run afterwards.
+	mainErr = <-errs
+}
+
+// httpSender returns a function posting values to serverB's /post
+// endpoint at the URL returned by downstreamURL
+func httpSender(client *http.Client, downstreamURL func() string) func(context.Context, int) error {
+	return func(ctx context.Context, value int) error {
+		body, err := json.Marshal(&Service{
+			ServiceName: "serviceA",
+			Value:       value,
+		})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", downstreamURL(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		// The request ID is unique to the value, so also serves as its
+		// idempotency key
+		if requestID, ok := httpserver.RequestIDFrom(ctx); ok {
+			req.Header.Set("X-Request-Id", requestID)
+			req.Header.Set("Idempotency-Key", requestID)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		switch {
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("serverB responded %s: %s", resp.Status, respBody)
+		case resp.StatusCode >= 400:
+			// Sending the value again would get the same answer
+			return natsqueue.Reject(fmt.Errorf("serverB responded %s: %s", resp.Status, respBody))
+		}
+		slog.InfoContext(ctx, "serverB responded", "status", resp.Status, "body", string(respBody))
+		return nil
+	}
+}
+
+// grpcSender returns a function sending values to serverB's gRPC
+// pipeline. Each call has a deadline of grpcSendTimeout, which leaves
+// serverB time to retry forwarding the value to serverC.
+func grpcSender(client pipelinepb.PipelineClient) func(context.Context, int) error {
+	return func(ctx context.Context, value int) error {
+		ctx, cancel := context.WithTimeout(ctx, grpcSendTimeout)
+		defer cancel()
+
+		_, err := client.Send(ctx, &pipelinepb.SendRequest{
+			ServiceName: "serviceA",
+			Value:       int64(value),
+		})
+		if status.Code(err) == codes.InvalidArgument {
+			return natsqueue.Reject(err)
+		}
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "serverB accepted value")
+		return nil
+	}
+}
+
+// Status is the body of the /status route
+type Status struct {
+	circuit.Status
+	Buffer BufferStatus `json:"buffer"`
+}
+
+// serveStatus returns the handler of the /status route, reporting the
+// state of the breaker and the buffer
+func serveStatus(breaker *circuit.Breaker, buffer *Buffer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		json.NewEncoder(w).Encode(Status{breaker.Status(), buffer.Status()})
+	}
+}
+
+// envOr returns the value of the environment variable, or def if it is unset
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+// validateURL checks that rawURL is an absolute http or https URL
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use http or https", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", rawURL)
+	}
+	return nil
+}
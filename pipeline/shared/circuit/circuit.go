@@ -0,0 +1,194 @@
+// Package circuit stops the services calling a next hop that is down
+// until it has had time to recover.
+package circuit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultFailures and DefaultCooldown are the consecutive failures that
+// open a breaker, and how long it then stays open
+const (
+	DefaultFailures = 5
+	DefaultCooldown = 10 * time.Second
+)
+
+// ErrOpen is returned for requests made while the breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// Closed lets requests through, counting consecutive failures
+	Closed State = iota
+	// Open fails requests straight away until the cooldown has passed
+	Open
+	// HalfOpen lets a single trial request through to test the next hop
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "invalid"
+	}
+}
+
+// Status is the body of the /status route
+type Status struct {
+	Downstream string     `json:"downstream"`
+	State      string     `json:"state"`
+	Failures   int        `json:"failures"`
+	OpenedAt   *time.Time `json:"openedAt,omitempty"`
+}
+
+// Breaker is a circuit breaker wrapping the transport of an http.Client.
+// Once the next hop has failed enough times in a row the breaker opens and
+// requests fail fast with ErrOpen, rather than each waiting to time out.
+// After the cooldown a single trial request is let through, closing the
+// breaker again if it succeeds. Connection errors and 5xx responses
+// count as failures.
+type Breaker struct {
+	next       http.RoundTripper
+	downstream string
+	failures   int           // consecutive failures that open the breaker
+	cooldown   time.Duration // how long the breaker stays open
+	now        func() time.Time
+
+	mu       sync.Mutex // protects the fields below
+	state    State
+	count    int // consecutive failures so far
+	openedAt time.Time
+}
+
+// NewBreaker wraps the transport next, which is http.DefaultTransport if
+// nil. downstream names the next hop in the status.
+func NewBreaker(next http.RoundTripper, downstream string, failures int, cooldown time.Duration) *Breaker {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Breaker{
+		next:       next,
+		downstream: downstream,
+		failures:   failures,
+		cooldown:   cooldown,
+		now:        time.Now,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (b *Breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	b.record(req.Context(), err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// UnaryClientInterceptor applies the breaker to the calls of a gRPC
+// client. Calls failing with codes that mean the server is down or
+// overloaded count as failures, as 5xx responses do over HTTP.
+func (b *Breaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := b.allow(); err != nil {
+			return err
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+			b.record(ctx, false)
+		default:
+			b.record(ctx, true)
+		}
+		return err
+	}
+}
+
+// allow reports whether a request may be made, moving an open breaker to
+// half-open once its cooldown has passed
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrOpen
+		}
+		b.state = HalfOpen
+		return nil
+	case HalfOpen:
+		// Only the trial request is let through
+		return ErrOpen
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of the request made with ctx
+func (b *Breaker) record(ctx context.Context, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		if b.state != Closed {
+			slog.InfoContext(ctx, "circuit breaker closed", "downstream", b.downstream)
+		}
+		b.state = Closed
+		b.count = 0
+		return
+	}
+
+	b.count++
+	if b.state == HalfOpen || b.count >= b.failures {
+		if b.state != Open {
+			slog.WarnContext(ctx, "circuit breaker opened", "downstream", b.downstream, "failures", b.count)
+		}
+		b.state = Open
+		b.openedAt = b.now()
+	}
+}
+
+// Status returns the current state of the breaker
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := Status{
+		Downstream: b.downstream,
+		State:      b.state.String(),
+		Failures:   b.count,
+	}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+// ServeStatus handles the /status route
+func (b *Breaker) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(b.Status())
+}
@@ -0,0 +1,86 @@
+package circuit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTripFunc lets a function stand in for the next hop
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBreaker(t *testing.T) {
+	var (
+		down  = true
+		calls int
+		now   = time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	)
+	next := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	b := NewBreaker(next, "serverC", 3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	request := func() error {
+		_, err := b.RoundTrip(httptest.NewRequest(http.MethodPost, "http://serverc/post", nil))
+		return err
+	}
+
+	// Failures below the threshold are passed through
+	for i := 0; i < 3; i++ {
+		if err := request(); err == nil || errors.Is(err, ErrOpen) {
+			t.Fatalf("request %v: got %v, want the next hop's error", i, err)
+		}
+	}
+	if got := b.Status(); got.State != "open" || got.Failures != 3 || got.OpenedAt == nil {
+		t.Fatalf("got status %+v, want open after 3 failures", got)
+	}
+
+	// Once open, requests fail fast without reaching the next hop
+	if err := request(); !errors.Is(err, ErrOpen) || calls != 3 {
+		t.Fatalf("got %v after %v calls, want the breaker open", err, calls)
+	}
+
+	// After the cooldown a failed trial opens the breaker again
+	now = now.Add(10 * time.Second)
+	if err := request(); err == nil || errors.Is(err, ErrOpen) {
+		t.Fatalf("got %v, want the trial to reach the next hop", err)
+	}
+	if err := request(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("got %v, want the breaker open again", err)
+	}
+
+	// and a successful one closes it
+	now = now.Add(10 * time.Second)
+	down = false
+	if err := request(); err != nil {
+		t.Fatalf("got %v, want the trial to succeed", err)
+	}
+	if got := b.Status(); got.State != "closed" || got.Failures != 0 || got.OpenedAt != nil {
+		t.Errorf("got status %+v, want closed", got)
+	}
+}
+
+func TestBreakerServerErrors(t *testing.T) {
+	next := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody}, nil
+	})
+	b := NewBreaker(next, "serverC", 1, time.Minute)
+
+	if _, err := b.RoundTrip(httptest.NewRequest(http.MethodPost, "http://serverc/post", nil)); err != nil {
+		t.Fatalf("got %v, want the 503 passed through", err)
+	}
+	if got := b.Status().State; got != "open" {
+		t.Errorf("got state %v, want a 5xx response to open the breaker", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Package configfile reads the settings of a service from a JSON file
+// named by -config or CONFIG_FILE, whose keys are flag names:
+//
+//	{"listen-addr": ":15000", "log-level": "debug"}
+//
+// The command line takes precedence over the file, and the file over the
+// environment. The settings are read again on SIGHUP, and those that can
+// change while the service runs are applied without restarting the
+// listeners.
+package configfile
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"syscall"
+)
+
+// Apply sets the flags of fs named in the file to its values, skipping
+// those given on the command line
+func Apply(fs *flag.FlagSet, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", file, name)
+		}
+		if given[name] {
+			continue
+		}
+		value, err := settingString(settings[name])
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", file, name, err)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %v", file, name, err)
+		}
+	}
+	return nil
+}
+
+// settingString returns a setting as it would be given on the command
+// line. Strings are unquoted, and numbers and booleans used as written.
+func settingString(raw json.RawMessage) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return string(raw), nil
+	default:
+		return "", errors.New("must be a string, number or boolean")
+	}
+}
+
+// EnvLevel returns the log level in the environment variable, or info if
+// it is unset
+func EnvLevel(key string) (slog.Level, error) {
+	var level slog.Level
+	if v, ok := os.LookupEnv(key); ok {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			return level, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return level, nil
+}
+
+// Changed returns the flag names of the settings that differ between the
+// configs, which are structs of the same type whose fields are tagged
+// with the names of their flags
+func Changed(old, next interface{}) []string {
+	var changed []string
+	o, n := reflect.ValueOf(old), reflect.ValueOf(next)
+	for i := 0; i < o.NumField(); i++ {
+		name := o.Type().Field(i).Tag.Get("flag")
+		if name != "" && o.Field(i).Interface() != n.Field(i).Interface() {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
+// ReloadOnSIGHUP parses the config from args again each time the process
+// receives SIGHUP, passing it to apply. A config that cannot be parsed is
+// logged, and the running one kept.
+func ReloadOnSIGHUP[C any](args []string, parse func([]string, io.Writer) (C, error), apply func(C)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			config, err := parse(args, io.Discard)
+			if err != nil {
+				slog.Error("could not reload config", "err", err)
+				continue
+			}
+			apply(config)
+		}
+	}()
+}
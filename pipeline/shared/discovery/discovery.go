@@ -0,0 +1,204 @@
+// Package discovery finds the instances of the next hop from DNS SRV
+// records.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often the record is looked up again
+	DefaultInterval = 30 * time.Second
+	// discoveryCooldown is how long an instance that failed is passed over
+	// in favour of the others
+	discoveryCooldown = 10 * time.Second
+	discoveryTimeout  = 5 * time.Second
+)
+
+// Resolver finds the instances of the next hop by looking up a DNS SRV
+// record, such as _http._tcp.serverc.service.consul from Consul's DNS
+// interface, instead of them being fixed by the downstream URL. The record
+// is looked up again every interval, so instances can come and go while
+// the service runs.
+//
+// Resolver wraps the transport of an http.Client: each request is sent to
+// the preferred instance, in the order of their SRV priority and weight,
+// in place of the host of its URL. A request that cannot connect fails
+// over to the next instance straight away, and an instance that fails, or
+// responds with a 5xx, is passed over for discoveryCooldown.
+type Resolver struct {
+	next     http.RoundTripper
+	name     string
+	interval time.Duration
+	lookup   func(ctx context.Context, name string) ([]*net.SRV, error)
+	now      func() time.Time
+
+	mu          sync.Mutex // protects the fields below
+	instances   []string   // host:port, most preferred first
+	failedUntil map[string]time.Time
+}
+
+// NewResolver wraps the transport next, which is http.DefaultTransport if
+// nil, resolving the instances from the SRV record name every interval
+func NewResolver(next http.RoundTripper, name string, interval time.Duration) *Resolver {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Resolver{
+		next:     next,
+		name:     name,
+		interval: interval,
+		lookup: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return addrs, err
+		},
+		now:         time.Now,
+		failedUntil: make(map[string]time.Time),
+	}
+}
+
+// Refresh looks up the instances. If the lookup fails, the instances found
+// last time are kept, as a stale list is more use than none.
+func (r *Resolver) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	addrs, err := r.lookup(ctx, r.name)
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no instances")
+	}
+	if err != nil {
+		return fmt.Errorf("looking up %s: %v", r.name, err)
+	}
+
+	// LookupSRV orders the records by priority, and randomly by weight
+	// within a priority
+	instances := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		instances = append(instances, net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if strings.Join(instances, ",") != strings.Join(r.instances, ",") {
+		slog.Info("discovered instances", "name", r.name, "instances", instances)
+	}
+	r.instances = instances
+	for instance := range r.failedUntil {
+		if !slices.Contains(instances, instance) {
+			delete(r.failedUntil, instance)
+		}
+	}
+	return nil
+}
+
+// Start refreshes the instances every interval until the returned
+// function is called
+func (r *Resolver) Start() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := r.Refresh(context.Background()); err != nil {
+					slog.Warn("could not refresh instances, keeping the last found", "err", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// Instances returns the instances found, most preferred first
+func (r *Resolver) Instances() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.instances...)
+}
+
+// candidates returns the instances in the order to try them: those that
+// have not failed recently first, and those that have last, in case they
+// have recovered
+func (r *Resolver) candidates() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	var up, down []string
+	for _, instance := range r.instances {
+		if now.Before(r.failedUntil[instance]) {
+			down = append(down, instance)
+		} else {
+			up = append(up, instance)
+		}
+	}
+	return append(up, down...)
+}
+
+// fail passes over the instance for discoveryCooldown
+func (r *Resolver) fail(instance string, err error) {
+	slog.Warn("instance failed, failing over", "instance", instance, "err", err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failedUntil[instance] = r.now().Add(discoveryCooldown)
+}
+
+// RoundTrip sends the request to the preferred instance, failing over to
+// the next if it cannot connect. A request whose body cannot be read
+// again is only tried once.
+func (r *Resolver) RoundTrip(req *http.Request) (*http.Response, error) {
+	candidates := r.candidates()
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no instances of %s found", r.name)
+	}
+
+	var lastErr error
+	for i, instance := range candidates {
+		attempt := req.Clone(req.Context())
+		attempt.URL.Host = instance
+		attempt.Host = ""
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				break
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attempt.Body = body
+		}
+
+		resp, err := r.next.RoundTrip(attempt)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			r.fail(instance, err)
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			r.fail(instance, errors.New(resp.Status))
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
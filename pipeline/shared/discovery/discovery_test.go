@@ -0,0 +1,97 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// srvOf returns the SRV record of the test server
+func srvOf(t *testing.T, server *httptest.Server) *net.SRV {
+	t.Helper()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return &net.SRV{Target: u.Hostname() + ".", Port: uint16(port)}
+}
+
+func TestResolverFailover(t *testing.T) {
+	var hits []string
+	serve := func(name string, status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits = append(hits, name)
+			w.WriteHeader(status)
+		}))
+	}
+	failing := serve("failing", http.StatusServiceUnavailable)
+	defer failing.Close()
+	healthy := serve("healthy", http.StatusOK)
+	defer healthy.Close()
+	// An instance that is down refuses connections
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	records := []*net.SRV{srvOf(t, down), srvOf(t, failing), srvOf(t, healthy)}
+	resolver := NewResolver(nil, "_http._tcp.serverc.test", DefaultInterval)
+	resolver.lookup = func(ctx context.Context, name string) ([]*net.SRV, error) { return records, nil }
+	if err := resolver.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: resolver}
+
+	post := func() int {
+		resp, err := client.Post("http://serverc/post", "application/json", strings.NewReader(`{"value":8}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The instance that is down fails over to the next, whose 5xx is
+	// returned for the caller to retry
+	if got := post(); got != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want 503 from the failing instance", got)
+	}
+	// Both are then passed over for the healthy one
+	if got := post(); got != http.StatusOK {
+		t.Errorf("got status %d, want 200 from the healthy instance", got)
+	}
+	if fmt.Sprint(hits) != "[failing healthy]" {
+		t.Errorf("got instances %v, want [failing healthy]", hits)
+	}
+}
+
+func TestResolverRefresh(t *testing.T) {
+	records := []*net.SRV{{Target: "c1.example.com.", Port: 15000}, {Target: "c2.example.com.", Port: 15000}}
+	var lookupErr error
+	resolver := NewResolver(nil, "_http._tcp.serverc.test", DefaultInterval)
+	resolver.lookup = func(ctx context.Context, name string) ([]*net.SRV, error) { return records, lookupErr }
+
+	if err := resolver.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "[c1.example.com:15000 c2.example.com:15000]"
+	if got := fmt.Sprint(resolver.Instances()); got != want {
+		t.Errorf("got instances %v, want %v", got, want)
+	}
+
+	// A failed lookup keeps the instances found before
+	lookupErr = errors.New("no such host")
+	if err := resolver.Refresh(context.Background()); err == nil {
+		t.Error("got no error from a failed lookup")
+	}
+	if got := fmt.Sprint(resolver.Instances()); got != want {
+		t.Errorf("got instances %v after a failed lookup, want %v", got, want)
+	}
+
+	if _, err := (&http.Client{Transport: NewResolver(nil, "none", DefaultInterval)}).Get("http://serverc/healthz"); err == nil {
+		t.Error("got no error with no instances")
+	}
+}
@@ -0,0 +1,158 @@
+// Package health serves the /healthz and /readyz routes the deployment
+// pipeline and load balancers poll, and the /admin/health switch to drain
+// a server by hand.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+// ReadinessTimeout bounds each readiness check, so a hung dependency
+// cannot hold up the deployment pipeline polling /readyz
+const ReadinessTimeout = 2 * time.Second
+
+// healthy is 1 while the server is handling requests, read by /healthz
+var healthy int32
+
+// SetHealthy sets whether the server is handling requests: true once it
+// is listening, and false when it starts shutting down
+func SetHealthy(ok bool) {
+	if ok {
+		atomic.StoreInt32(&healthy, 1)
+	} else {
+		atomic.StoreInt32(&healthy, 0)
+	}
+}
+
+// Check is a dependency the server needs to handle requests
+type Check struct {
+	Name  string
+	Check func() error
+}
+
+// Status is the body of the /healthz and /readyz routes
+type Status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz handles the /healthz route, reporting whether the server is
+// running. It fails once the server starts shutting down.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&healthy) != 1 {
+		writeStatus(w, http.StatusServiceUnavailable, Status{Status: "unavailable"})
+		return
+	}
+	writeStatus(w, http.StatusOK, Status{Status: "ok"})
+}
+
+// Readyz returns the handler of the /readyz route, reporting whether the
+// server is running and every check passes, so it can be sent traffic.
+func Readyz(checks ...Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := Status{Status: "ok", Checks: make(map[string]string)}
+		code := http.StatusOK
+		if atomic.LoadInt32(&healthy) != 1 {
+			status.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+
+		for _, c := range checks {
+			if err := c.Check(); err != nil {
+				status.Checks[c.Name] = err.Error()
+				status.Status = "unavailable"
+				code = http.StatusServiceUnavailable
+				continue
+			}
+			status.Checks[c.Name] = "ok"
+		}
+		writeStatus(w, code, status)
+	}
+}
+
+// healthSwitch is the body of the /admin/health route. Healthy is a
+// pointer so a missing field can be told apart from false.
+type healthSwitch struct {
+	Healthy *bool `json:"healthy"`
+}
+
+// Admin handles the /admin/health route, for deployment drills. PUT
+// with {"healthy":false} fails /healthz and /readyz as shutting down does,
+// so load balancers drain the instance while it keeps serving, and
+// {"healthy":true} brings it back. GET reports the flag.
+func Admin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		var body healthSwitch
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&body)
+		if err == nil && body.Healthy == nil {
+			err = errors.New("healthy is required")
+		}
+		if err != nil {
+			writeHealthError(w, http.StatusBadRequest, err)
+			return
+		}
+		SetHealthy(*body.Healthy)
+		slog.WarnContext(r.Context(), "health set by admin", "healthy", *body.Healthy)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		writeHealthError(w, http.StatusMethodNotAllowed, errors.New("method must be GET or PUT"))
+		return
+	}
+	isHealthy := atomic.LoadInt32(&healthy) == 1
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(healthSwitch{Healthy: &isHealthy})
+}
+
+// writeHealthError responds to an /admin/health request that failed with
+// the status and a JSON body describing the error
+func writeHealthError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// CheckHealthz returns a check that the /healthz route of the server at
+// rawURL responds with 200, called through the transport. Only the scheme
+// and host of rawURL are used.
+func CheckHealthz(rawURL string, transport http.RoundTripper) func() error {
+	client := &http.Client{Transport: transport, Timeout: ReadinessTimeout}
+	return func() error {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		u.Path = "/healthz"
+		u.RawQuery = ""
+
+		resp, err := client.Get(u.String())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s responded %s", u, resp.Status)
+		}
+		return nil
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	ok := Check{"store", func() error { return nil }}
+	failing := Check{"downstream", func() error { return errors.New("connection refused") }}
+
+	testCases := []struct {
+		desc        string
+		healthy     int32
+		checks      []Check
+		wantHealthz int
+		wantReadyz  int
+		wantChecks  map[string]string
+	}{
+		{"ready", 1, []Check{ok}, http.StatusOK, http.StatusOK, map[string]string{"store": "ok"}},
+		{"dependency down", 1, []Check{ok, failing}, http.StatusOK, http.StatusServiceUnavailable, map[string]string{"store": "ok", "downstream": "connection refused"}},
+		{"shutting down", 0, []Check{ok}, http.StatusServiceUnavailable, http.StatusServiceUnavailable, map[string]string{"store": "ok"}},
+	}
+
+	defer atomic.StoreInt32(&healthy, atomic.LoadInt32(&healthy))
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			atomic.StoreInt32(&healthy, tc.healthy)
+
+			response := httptest.NewRecorder()
+			Healthz(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if response.Code != tc.wantHealthz {
+				t.Errorf("healthz: got %v, want %v", response.Code, tc.wantHealthz)
+			}
+
+			response = httptest.NewRecorder()
+			Readyz(tc.checks...)(response, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if response.Code != tc.wantReadyz {
+				t.Errorf("readyz: got %v, want %v", response.Code, tc.wantReadyz)
+			}
+			var status Status
+			if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+			for name, want := range tc.wantChecks {
+				if status.Checks[name] != want {
+					t.Errorf("check %v: got %q, want %q", name, status.Checks[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckHealthz(t *testing.T) {
+	var status int32 = http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			t.Errorf("got path %v, want /healthz", r.URL.Path)
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+
+	check := CheckHealthz(server.URL+"/post", nil)
+	if err := check(); err != nil {
+		t.Errorf("got %v, want a healthy downstream", err)
+	}
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	if err := check(); err == nil {
+		t.Error("got no error from an unhealthy downstream")
+	}
+}
+
+func TestAdminHealth(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		method      string
+		body        string
+		wantStatus  int
+		wantHealthz int
+	}{
+		{"drain", http.MethodPut, `{"healthy":false}`, http.StatusOK, http.StatusServiceUnavailable},
+		{"report", http.MethodGet, "", http.StatusOK, http.StatusServiceUnavailable},
+		{"missing field", http.MethodPut, `{}`, http.StatusBadRequest, http.StatusServiceUnavailable},
+		{"not a bool", http.MethodPut, `{"healthy":1}`, http.StatusBadRequest, http.StatusServiceUnavailable},
+		{"restore", http.MethodPut, `{"healthy":true}`, http.StatusOK, http.StatusOK},
+		{"post", http.MethodPost, `{"healthy":false}`, http.StatusMethodNotAllowed, http.StatusOK},
+	}
+
+	defer atomic.StoreInt32(&healthy, atomic.LoadInt32(&healthy))
+	atomic.StoreInt32(&healthy, 1)
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			Admin(response, httptest.NewRequest(tc.method, "/admin/health", strings.NewReader(tc.body)))
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v: %s", response.Code, tc.wantStatus, response.Body)
+			}
+
+			response = httptest.NewRecorder()
+			Healthz(response, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if response.Code != tc.wantHealthz {
+				t.Errorf("healthz: got %v, want %v", response.Code, tc.wantHealthz)
+			}
+		})
+	}
+}
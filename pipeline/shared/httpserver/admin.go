@@ -0,0 +1,24 @@
+package httpserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Admin allows only the requests bearing the token, as an
+// "Authorization: Bearer <token>" header, through to the admin routes.
+// Others are refused with a 401.
+func Admin(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeError(w, http.StatusUnauthorized, "admin routes need the admin token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
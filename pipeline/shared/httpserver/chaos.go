@@ -0,0 +1,214 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChaosConfig is the failures Chaos injects into requests. Each rate is the
+// fraction of requests, from 0 to 1, given the failure; the zero
+// ChaosConfig injects none.
+type ChaosConfig struct {
+	// Latency delays requests by up to this long before they are handled
+	Latency     time.Duration
+	LatencyRate float64
+	// ErrorRate answers requests with a 500 without handling them
+	ErrorRate float64
+	// DropRate closes the connection without responding
+	DropRate float64
+}
+
+// chaosJSON is a ChaosConfig as it is read and written by the
+// /admin/chaos route, with the latency as a duration such as 500ms
+type chaosJSON struct {
+	Latency     string  `json:"latency"`
+	LatencyRate float64 `json:"latencyRate"`
+	ErrorRate   float64 `json:"errorRate"`
+	DropRate    float64 `json:"dropRate"`
+}
+
+// ChaosStatus is the body of the /admin/chaos route: the failures being
+// injected and those injected since the service started
+type ChaosStatus struct {
+	chaosJSON
+	Delayed int `json:"delayed"`
+	Failed  int `json:"failed"`
+	Dropped int `json:"dropped"`
+}
+
+// validate returns the first problem found with the config
+func (c ChaosConfig) validate() error {
+	if c.Latency < 0 {
+		return errors.New("latency must not be negative")
+	}
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"latencyRate", c.LatencyRate},
+		{"errorRate", c.ErrorRate},
+		{"dropRate", c.DropRate},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", rate.name)
+		}
+	}
+	return nil
+}
+
+// Chaos injects latency, errors and dropped connections into a random
+// share of requests, so the retries and circuit breakers of the services
+// calling this one can be shown working. It starts injecting nothing, and
+// is changed while the service runs through its /admin/chaos route.
+type Chaos struct {
+	mu      sync.Mutex // protects the fields below
+	config  ChaosConfig
+	rnd     *rand.Rand
+	delayed int
+	failed  int
+	dropped int
+}
+
+// NewChaos returns a Chaos injecting no failures
+func NewChaos() *Chaos {
+	return &Chaos{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Set changes the failures injected, from the next request
+func (c *Chaos) Set(config ChaosConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.config = config
+	return nil
+}
+
+// Status returns the failures being injected and the counts of those
+// injected so far
+func (c *Chaos) Status() ChaosStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ChaosStatus{
+		chaosJSON: chaosJSON{
+			Latency:     c.config.Latency.String(),
+			LatencyRate: c.config.LatencyRate,
+			ErrorRate:   c.config.ErrorRate,
+			DropRate:    c.config.DropRate,
+		},
+		Delayed: c.delayed,
+		Failed:  c.failed,
+		Dropped: c.dropped,
+	}
+}
+
+// pick decides the failures to inject into a request
+func (c *Chaos) pick() (delay time.Duration, fail, drop bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.Latency > 0 && c.rnd.Float64() < c.config.LatencyRate {
+		delay = time.Duration(c.rnd.Int63n(int64(c.config.Latency) + 1))
+		c.delayed++
+	}
+	switch r := c.rnd.Float64(); {
+	case r < c.config.DropRate:
+		drop = true
+		c.dropped++
+	case r < c.config.DropRate+c.config.ErrorRate:
+		fail = true
+		c.failed++
+	}
+	return delay, fail, drop
+}
+
+// Middleware injects the failures into the requests to next. The /admin/
+// routes are exempt, so chaos can always be turned off again. It should
+// be wrapped by Logging, so the failures injected are logged.
+func (c *Chaos) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/admin/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			delay, fail, drop := c.pick()
+			if delay > 0 {
+				slog.WarnContext(r.Context(), "chaos: delaying request", "delay", delay.String())
+				timer := time.NewTimer(delay)
+				select {
+				case <-r.Context().Done():
+					timer.Stop()
+					return
+				case <-timer.C:
+				}
+			}
+			switch {
+			case drop:
+				slog.WarnContext(r.Context(), "chaos: dropping connection")
+				if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+					conn.Close()
+					return
+				}
+				// HTTP/2 connections cannot be hijacked, but aborting
+				// the handler resets the stream
+				panic(http.ErrAbortHandler)
+			case fail:
+				slog.WarnContext(r.Context(), "chaos: failing request")
+				writeError(w, http.StatusInternalServerError, "chaos: injected failure")
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// ServeHTTP handles the /admin/chaos route. GET reports the failures
+// being injected, PUT replaces them with those in the body, such as
+// {"latency":"500ms","latencyRate":0.5,"errorRate":0.1}, and DELETE stops
+// injecting any.
+func (c *Chaos) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPut:
+		var body chaosJSON
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		config := ChaosConfig{LatencyRate: body.LatencyRate, ErrorRate: body.ErrorRate, DropRate: body.DropRate}
+		if body.Latency != "" {
+			latency, err := time.ParseDuration(body.Latency)
+			if err != nil {
+				writeError(w, http.StatusUnprocessableEntity, "latency must be a duration, such as 500ms")
+				return
+			}
+			config.Latency = latency
+		}
+		if err := c.Set(config); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		slog.WarnContext(r.Context(), "chaos changed", "latency", config.Latency.String(), "latency_rate", config.LatencyRate, "error_rate", config.ErrorRate, "drop_rate", config.DropRate)
+	case http.MethodDelete:
+		c.Set(ChaosConfig{})
+		slog.InfoContext(r.Context(), "chaos stopped")
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method must be GET, PUT or DELETE")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(c.Status())
+}
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChaos(t *testing.T) {
+	chaos := NewChaos()
+	handler := chaos.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) int {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response.Code
+	}
+
+	if got := serve("/get"); got != http.StatusOK {
+		t.Errorf("got status %d with no chaos, want 200", got)
+	}
+
+	if err := chaos.Set(ChaosConfig{ErrorRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := serve("/get"); got != http.StatusInternalServerError {
+		t.Errorf("got status %d with an error rate of 1, want 500", got)
+	}
+	if got := serve("/admin/chaos"); got != http.StatusOK {
+		t.Errorf("got status %d for an admin route, want it exempt", got)
+	}
+
+	chaos.Set(ChaosConfig{Latency: 20 * time.Millisecond, LatencyRate: 1})
+	start := time.Now()
+	serve("/get")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("delayed by %v, want at most 20ms", elapsed)
+	}
+
+	if status := chaos.Status(); status.Failed != 1 || status.Delayed != 1 || status.Latency != "20ms" {
+		t.Errorf("got status %+v", status)
+	}
+}
+
+func TestChaosServeHTTP(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{"set", http.MethodPut, `{"latency":"500ms","latencyRate":0.5,"errorRate":0.1}`, http.StatusOK},
+		{"bad latency", http.MethodPut, `{"latency":"slow"}`, http.StatusUnprocessableEntity},
+		{"rate above 1", http.MethodPut, `{"dropRate":1.5}`, http.StatusUnprocessableEntity},
+		{"unknown field", http.MethodPut, `{"errors":0.1}`, http.StatusBadRequest},
+		{"stop", http.MethodDelete, "", http.StatusOK},
+		{"post", http.MethodPost, "", http.StatusMethodNotAllowed},
+	}
+
+	chaos := NewChaos()
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			chaos.ServeHTTP(response, httptest.NewRequest(tc.method, "/admin/chaos", strings.NewReader(tc.body)))
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %d, want %d: %s", response.Code, tc.wantStatus, response.Body)
+			}
+		})
+	}
+	if status := chaos.Status(); status.ErrorRate != 0 {
+		t.Errorf("got %+v after stopping, want no chaos", status)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	handler := Admin("s3cret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	testCases := []struct {
+		desc          string
+		authorization string
+		wantStatus    int
+	}{
+		{"token", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "Bearer guess", http.StatusUnauthorized},
+		{"no token", "", http.StatusUnauthorized},
+		{"basic", "Basic czNjcmV0", http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/admin/chaos", nil)
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %d, want %d", response.Code, tc.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsMaxAge is how long browsers may cache the response to a preflight
+const corsMaxAge = 10 * time.Minute
+
+// CORSConfig is what pages served from other origins, such as a
+// dashboard, may request from the server in a browser
+type CORSConfig struct {
+	// AllowedOrigins are the origins, such as https://dash.example.com,
+	// allowed to make requests; * allows any. None disables CORS.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers allowed besides those
+	// browsers always allow
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read, beyond
+	// the few browsers always expose
+	ExposedHeaders []string
+}
+
+// NewCORSConfig returns the config of comma separated lists of origins,
+// methods, request headers and exposed response headers
+func NewCORSConfig(origins, methods, headers, exposed string) CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: splitList(origins),
+		AllowedMethods: splitList(methods),
+		AllowedHeaders: splitList(headers),
+		ExposedHeaders: splitList(exposed),
+	}
+}
+
+// AllowOrigin returns whether requests from origin are allowed
+func (c CORSConfig) AllowOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowAny returns whether requests from every origin are allowed
+func (c CORSConfig) allowAny() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// CORS adds the CORS headers to the responses to requests from the
+// allowed origins, so browsers let pages from them read the responses, and
+// answers their preflight requests. Preflights from other origins are a
+// 403; their other requests are handled as usual, but without the headers
+// browsers cannot read the responses. Credentials are not allowed.
+func CORS(config CORSConfig) Middleware {
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	exposed := strings.Join(config.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(corsMaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		if len(config.AllowedOrigins) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// Responses differ by origin, so caches must keep them apart
+			w.Header().Add("Vary", "Origin")
+			if !config.AllowOrigin(origin) {
+				if preflight {
+					writeError(w, http.StatusForbidden, "origin not allowed")
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if config.allowAny() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if !preflight {
+				if exposed != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// splitList returns the items of a comma separated list, without the
+// spaces around them
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,74 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	const (
+		methods = "GET, HEAD, POST"
+		headers = "Content-Type, X-Request-Id"
+		exposed = "X-Request-Id, X-Total-Count"
+	)
+
+	handled := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled++
+		w.Write([]byte("ok"))
+	})
+
+	testCases := []struct {
+		desc          string
+		origins       string
+		method        string
+		origin        string
+		preflight     bool
+		wantStatus    int
+		wantAllow     string
+		wantHandled   bool
+		wantAllowHdrs bool
+	}{
+		{"disabled", "", http.MethodGet, "https://dash.example.com", false, http.StatusOK, "", true, false},
+		{"same origin", "https://dash.example.com", http.MethodGet, "", false, http.StatusOK, "", true, false},
+		{"allowed", "https://dash.example.com, https://ops.example.com", http.MethodGet, "https://ops.example.com", false, http.StatusOK, "https://ops.example.com", true, false},
+		{"not allowed", "https://dash.example.com", http.MethodGet, "https://evil.example.com", false, http.StatusOK, "", true, false},
+		{"any", "*", http.MethodGet, "https://evil.example.com", false, http.StatusOK, "*", true, false},
+		{"preflight", "https://dash.example.com", http.MethodOptions, "https://dash.example.com", true, http.StatusNoContent, "https://dash.example.com", false, true},
+		{"preflight not allowed", "https://dash.example.com", http.MethodOptions, "https://evil.example.com", true, http.StatusForbidden, "", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			handled = 0
+			handler := CORS(NewCORSConfig(tc.origins, methods, headers, exposed))(next)
+
+			request := httptest.NewRequest(tc.method, "/get", nil)
+			if tc.origin != "" {
+				request.Header.Set("Origin", tc.origin)
+			}
+			if tc.preflight {
+				request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+				request.Header.Set("Access-Control-Request-Headers", "content-type")
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", response.Code, tc.wantStatus)
+			}
+			if got := response.Header().Get("Access-Control-Allow-Origin"); got != tc.wantAllow {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tc.wantAllow)
+			}
+			if gotHandled := handled == 1; gotHandled != tc.wantHandled {
+				t.Errorf("got handled %v, want %v", gotHandled, tc.wantHandled)
+			}
+			if got := response.Header().Get("Access-Control-Allow-Methods"); (got == methods) != tc.wantAllowHdrs {
+				t.Errorf("got Access-Control-Allow-Methods %q", got)
+			}
+			if tc.wantAllow != "" && !tc.preflight && response.Header().Get("Access-Control-Expose-Headers") != exposed {
+				t.Errorf("got Access-Control-Expose-Headers %q, want %q", response.Header().Get("Access-Control-Expose-Headers"), exposed)
+			}
+		})
+	}
+}
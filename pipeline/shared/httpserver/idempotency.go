@@ -0,0 +1,219 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyTTL is how long the response to a request is replayed
+	// to retries carrying its Idempotency-Key
+	IdempotencyTTL = 10 * time.Minute
+	// MaxIdempotencyKeyLen bounds the keys accepted
+	MaxIdempotencyKeyLen = 255
+)
+
+const idempotencyKeyKey key = 1
+
+// WithIdempotencyKey returns a copy of ctx carrying the idempotency key,
+// which is sent on in the Idempotency-Key header of requests made with it
+func WithIdempotencyKey(ctx context.Context, idempotencyKey string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey, idempotencyKey)
+}
+
+// IdempotencyKeyFrom returns the idempotency key carried by ctx, if any
+func IdempotencyKeyFrom(ctx context.Context) (string, bool) {
+	idempotencyKey, ok := ctx.Value(idempotencyKeyKey).(string)
+	return idempotencyKey, ok
+}
+
+// idempotentResponse is the response recorded for a key
+type idempotentResponse struct {
+	key         string
+	fingerprint [sha256.Size]byte // of the request body
+	expires     time.Time
+	done        bool // false while the first request is being handled
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// IdempotencyCache remembers the responses to recent requests by their
+// Idempotency-Key header
+type IdempotencyCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex // protects the fields below
+	responses map[string]*idempotentResponse
+	order     []*idempotentResponse // oldest first, for expiring them
+}
+
+// NewIdempotencyCache returns a cache keeping each response for ttl
+func NewIdempotencyCache(ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:       ttl,
+		now:       time.Now,
+		responses: make(map[string]*idempotentResponse),
+	}
+}
+
+// begin claims the key for a request with the body's fingerprint. If the
+// key is already held, a copy of its response is returned instead, with
+// ok false.
+func (c *IdempotencyCache) begin(key string, fingerprint [sha256.Size]byte) (prev idempotentResponse, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	for len(c.order) > 0 && now.After(c.order[0].expires) {
+		if c.responses[c.order[0].key] == c.order[0] {
+			delete(c.responses, c.order[0].key)
+		}
+		c.order = c.order[1:]
+	}
+
+	if r, held := c.responses[key]; held {
+		return *r, false
+	}
+	r := &idempotentResponse{key: key, fingerprint: fingerprint, expires: now.Add(c.ttl)}
+	c.responses[key] = r
+	c.order = append(c.order, r)
+	return idempotentResponse{}, true
+}
+
+// finish records the response to the request holding the key. Server
+// errors and values refused over a quota are not kept, so the request can
+// be retried.
+func (c *IdempotencyCache) finish(key string, status int, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, held := c.responses[key]
+	if !held {
+		return
+	}
+	if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests {
+		delete(c.responses, key)
+		return
+	}
+	r.done = true
+	r.status = status
+	r.header = header
+	r.body = body
+}
+
+// forget releases the key held by a request whose handler panicked, so
+// the request can be retried
+func (c *IdempotencyCache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if r, held := c.responses[key]; held && !r.done {
+		delete(c.responses, key)
+	}
+}
+
+// responseCapture copies the response written by a handler, so it can be
+// replayed
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	c.body.Write(b)
+	return c.ResponseWriter.Write(b)
+}
+
+// Idempotent replays the response to a POST with an Idempotency-Key
+// header already seen in the last IdempotencyTTL, marked with the
+// Idempotent-Replayed header, rather than handling it again. Reusing a key
+// with a different body is a 422, and while the first request with a key
+// is still being handled, retries are a 409; if its handler panics, the
+// key is released so it can be retried. The key is also carried by the
+// request's context, to be sent on with requests made while handling it.
+func Idempotent(cache *IdempotencyCache) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" || r.Method != http.MethodPost {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > MaxIdempotencyKeyLen {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", MaxIdempotencyKeyLen))
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", tooLarge.Limit))
+				return
+			} else if err != nil {
+				writeError(w, http.StatusBadRequest, "could not read body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fingerprint := sha256.Sum256(body)
+
+			prev, ok := cache.begin(key, fingerprint)
+			switch {
+			case ok:
+			case prev.fingerprint != fingerprint:
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different body")
+				return
+			case !prev.done:
+				writeError(w, http.StatusConflict, "a request with this Idempotency-Key is still being handled")
+				return
+			default:
+				for name, values := range prev.header {
+					// The request ID is this request's own
+					if name != "X-Request-Id" {
+						w.Header()[name] = values
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(prev.status)
+				w.Write(prev.body)
+				return
+			}
+
+			defer func() {
+				if p := recover(); p != nil {
+					cache.forget(key)
+					panic(p)
+				}
+			}()
+			capture := &responseCapture{ResponseWriter: w}
+			next.ServeHTTP(capture, r.WithContext(WithIdempotencyKey(r.Context(), key)))
+			if capture.status == 0 {
+				// Nothing was written, which net/http sends as a 200
+				capture.status = http.StatusOK
+				capture.header = w.Header().Clone()
+			}
+			cache.finish(key, capture.status, capture.header, capture.body.Bytes())
+		})
+	}
+}
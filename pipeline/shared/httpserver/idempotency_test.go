@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotent(t *testing.T) {
+	var (
+		calls  int
+		status = http.StatusOK
+		now    = time.Date(2020, 11, 20, 10, 0, 0, 0, time.UTC)
+	)
+	cache := NewIdempotencyCache(time.Minute)
+	cache.now = func() time.Time { return now }
+	handler := Idempotent(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got, _ := IdempotencyKeyFrom(r.Context()); got != r.Header.Get("Idempotency-Key") {
+			t.Errorf("got key %q in the context, want %q", got, r.Header.Get("Idempotency-Key"))
+		}
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "call %d", calls)
+	}))
+
+	post := func(key, body, requestID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		request.Header.Set("X-Request-Id", requestID)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	first := post("a", "8", "1")
+	replay := post("a", "8", "2")
+	if calls != 1 {
+		t.Fatalf("got %v calls, want the retry replayed", calls)
+	}
+	if replay.Code != first.Code || replay.Body.String() != "call 1" {
+		t.Errorf("got %v %q replayed, want %v %q", replay.Code, replay.Body, first.Code, "call 1")
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("want only the replay marked with Idempotent-Replayed")
+	}
+	if got := replay.Header().Get("X-Request-Id"); got != "" {
+		t.Errorf("got request ID %q replayed, want the original's left out", got)
+	}
+
+	if got := post("a", "9", "3").Code; got != http.StatusUnprocessableEntity {
+		t.Errorf("got status %v reusing a key with a different body, want %v", got, http.StatusUnprocessableEntity)
+	}
+
+	post("", "8", "4")
+	post("", "8", "5")
+	if calls != 3 {
+		t.Errorf("got %v calls, want requests without a key handled each time", calls)
+	}
+
+	// Server errors are not kept, so the retry is handled
+	status = http.StatusBadGateway
+	post("b", "8", "6")
+	status = http.StatusOK
+	if got := post("b", "8", "7"); got.Code != http.StatusOK || calls != 5 {
+		t.Errorf("got status %v after %v calls retrying a failure, want 200 after 5", got.Code, calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if got := post("a", "9", "8"); got.Code != http.StatusOK || calls != 6 {
+		t.Errorf("got status %v after %v calls once expired, want 200 after 6", got.Code, calls)
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	cache := NewIdempotencyCache(time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := Idempotent(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	post := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader("8"))
+		request.Header.Set("Idempotency-Key", "a")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post() }()
+	<-started
+	if got := post().Code; got != http.StatusConflict {
+		t.Errorf("got status %v while in progress, want %v", got, http.StatusConflict)
+	}
+	close(release)
+	if got := (<-done).Code; got != http.StatusOK {
+		t.Errorf("got status %v for the first request, want %v", got, http.StatusOK)
+	}
+}
+
+func TestIdempotentPanic(t *testing.T) {
+	cache := NewIdempotencyCache(time.Minute)
+	panicked := false
+	handler := Idempotent(cache)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !panicked {
+			panicked = true
+			panic("handler failed")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post := func() *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/post", strings.NewReader("8"))
+		request.Header.Set("Idempotency-Key", "a")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	func() {
+		defer func() {
+			if p := recover(); p != "handler failed" {
+				t.Errorf("got panic %v, want the handler's", p)
+			}
+		}()
+		post()
+	}()
+	if got := post().Code; got != http.StatusCreated {
+		t.Errorf("got status %v for the retry, want %v", got, http.StatusCreated)
+	}
+}
@@ -0,0 +1,158 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// MaxBytes limits the bodies of requests to n bytes, so a client cannot
+// tie up the handler with an oversized one. A body declared larger is
+// refused with a 413 before the handler runs; reading one that turns out
+// larger fails with an error for which BodyTooLarge is true, which the
+// handler should answer with a 413.
+func MaxBytes(n int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > n {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body must be at most %d bytes", n))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// BodyTooLarge returns whether err is from reading a body larger than
+// MaxBytes allows
+func BodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// Timeout responds with a 408 if the handler has not responded within d,
+// such as because the client is slow to send its body. The handler's
+// context is done once d has passed, and what it writes after that is
+// discarded. The response is buffered until the handler returns, so
+// Timeout must not wrap streams. A panic in the handler is raised again
+// along with the handler's stack, for Recover to log.
+func Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{header: make(http.Header)}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					p := recover()
+					if p == nil {
+						return
+					}
+					if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+						panicked <- p
+						return
+					}
+					// The stack is taken here, as once the panic is
+					// raised again in the serving goroutine it no
+					// longer includes the handler
+					panicked <- &handlerPanic{value: p, stack: debug.Stack()}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicked:
+				// Panic in the serving goroutine, as the handler would
+				// have, for Recover to respond to
+				panic(p)
+			case <-done:
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				for name, values := range tw.header {
+					w.Header()[name] = values
+				}
+				if tw.status == 0 {
+					tw.status = http.StatusOK
+				}
+				w.WriteHeader(tw.status)
+				w.Write(tw.body.Bytes())
+			case <-ctx.Done():
+				tw.mu.Lock()
+				defer tw.mu.Unlock()
+				tw.timedOut = true
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					writeError(w, http.StatusRequestTimeout, fmt.Sprintf("request not handled within %v", d))
+				}
+				// Otherwise the client has gone, and there is no one to
+				// respond to
+			}
+		})
+	}
+}
+
+// handlerPanic is a panic raised by a handler wrapped by Timeout, passed
+// on from the goroutine it ran in along with the stack there
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+func (p *handlerPanic) Error() string {
+	return fmt.Sprintf("%v\n\n%s", p.value, p.stack)
+}
+
+// timeoutWriter buffers the response of a handler wrapped by Timeout
+type timeoutWriter struct {
+	header http.Header
+
+	mu       sync.Mutex // protects the fields below
+	status   int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.status == 0 && !tw.timedOut {
+		tw.status = status
+	}
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// writeError responds with the status and a JSON body describing the
+// error, as the services' handlers do
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{message})
+}
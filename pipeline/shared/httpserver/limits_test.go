@@ -0,0 +1,84 @@
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxBytes(t *testing.T) {
+	handler := MaxBytes(8)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); BodyTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, "too large")
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}))
+
+	testCases := []struct {
+		desc       string
+		body       string
+		declared   bool
+		wantStatus int
+	}{
+		{"within", "12345678", true, http.StatusOK},
+		{"declared larger", "123456789", true, http.StatusRequestEntityTooLarge},
+		{"undeclared larger", "123456789", false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			if !tc.declared {
+				request.ContentLength = -1
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", response.Code, tc.wantStatus)
+			}
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	t.Run("in time", func(t *testing.T) {
+		handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handled", "yes")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("done"))
+		}))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+		if response.Code != http.StatusCreated || response.Body.String() != "done" || response.Header().Get("X-Handled") != "yes" {
+			t.Errorf("got %v %q %v, want the handler's response", response.Code, response.Body, response.Header())
+		}
+	})
+
+	t.Run("too slow", func(t *testing.T) {
+		finished := make(chan error)
+		handler := Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			time.Sleep(10 * time.Millisecond)
+			_, err := w.Write([]byte("late"))
+			finished <- err
+		}))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+		if response.Code != http.StatusRequestTimeout {
+			t.Errorf("got status %v, want %v", response.Code, http.StatusRequestTimeout)
+		}
+		if got := response.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("got Content-Type %q, want a JSON error", got)
+		}
+		if err := <-finished; err != http.ErrHandlerTimeout {
+			t.Errorf("got %v writing after the timeout, want %v", err, http.ErrHandlerTimeout)
+		}
+		if strings.Contains(response.Body.String(), "late") {
+			t.Error("the late write was sent")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps a handler, such as to log the requests it handles
+type Middleware func(http.Handler) http.Handler
+
+// Chain returns h wrapped by the middleware, the first given being the
+// outermost, so the first to see each request
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+type key int
+
+const requestIDKey key = 0
+
+// NewRequestID returns an ID for a request that arrived without one: 16
+// random bytes, hex encoded, so IDs made at once by several goroutines or
+// services do not collide. They are also used as idempotency keys.
+func NewRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID, which is
+// sent on in the X-Request-Id header of requests made with it
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFrom returns the request ID carried by ctx, if any
+func RequestIDFrom(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey).(string)
+	return requestID, ok
+}
+
+// RequestID gives each request the ID in its X-Request-Id header, or a new
+// one from nextRequestID if it has none, and echoes the ID in the
+// response. The ID is also recorded on the request's span.
+func RequestID(nextRequestID func() string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestID := r.Header.Get("X-Request-Id")
+			if requestID == "" {
+				requestID = nextRequestID()
+			}
+			w.Header().Set("X-Request-Id", requestID)
+			trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", requestID))
+			next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+		})
+	}
+}
+
+// Logging logs each request once it has been handled, as a structured
+// line with the response status and how long it took. It must be wrapped
+// by RequestID for the line to include the request ID.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				status := recorder.status
+				if status == 0 {
+					// Nothing was written, which net/http sends as a 200
+					status = http.StatusOK
+				}
+				logger.InfoContext(r.Context(), "request",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"status", status,
+					"duration_ms", float64(time.Since(start).Microseconds())/1000,
+					"remote_addr", r.RemoteAddr,
+					"user_agent", r.UserAgent(),
+				)
+			}()
+			next.ServeHTTP(recorder, r)
+		})
+	}
+}
+
+// statusRecorder captures the status code written by a handler, so it can
+// be logged
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack hands the connection over to the handler, for websockets
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
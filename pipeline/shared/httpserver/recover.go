@@ -0,0 +1,94 @@
+package httpserver
+
+import (
+	"bufio"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+)
+
+// Panics counts the panics Recover has recovered from since the service
+// started. It is published by expvar as http_panics, so it is served
+// along with the runtime's memory statistics by expvar.Handler.
+var Panics = expvar.NewInt("http_panics")
+
+// Recover responds with a 500 and a JSON error if the handler panics,
+// rather than net/http dropping the connection, and logs the panic with
+// its stack. It must be wrapped by RequestID for the line to include the
+// request ID, and by Logging so the 500 is logged as the request's status.
+//
+// A handler that has already started its response, such as a stream,
+// cannot be given a 500, so the connection is dropped as before.
+// http.ErrAbortHandler, which handlers panic with to drop the connection
+// on purpose, is passed on without being logged.
+func Recover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recoverWriter{ResponseWriter: w}
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(p)
+				}
+
+				// A panic passed on by Timeout carries the stack of the
+				// handler, which is not in this goroutine's
+				stack := debug.Stack()
+				if hp, ok := p.(*handlerPanic); ok {
+					p, stack = hp.value, hp.stack
+				}
+				Panics.Add(1)
+				logger.ErrorContext(r.Context(), "handler panicked",
+					"method", r.Method,
+					"path", r.URL.Path,
+					"panic", fmt.Sprint(p),
+					"stack", string(stack),
+				)
+				if rw.started {
+					panic(http.ErrAbortHandler)
+				}
+				writeError(w, http.StatusInternalServerError, "internal server error")
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// recoverWriter records whether a handler has started its response, so
+// Recover knows whether a 500 can still be sent
+type recoverWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoverWriter) WriteHeader(status int) {
+	// Informational responses, such as 103 Early Hints, may be followed
+	// by the real one
+	if status >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recoverWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (w *recoverWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack hands the connection over to the handler, for websockets
+func (w *recoverWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.started = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,132 @@
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecover(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantPanic  bool
+	}{
+		{"no panic", func(w http.ResponseWriter, r *http.Request) {}, http.StatusOK, false},
+		{"panic", func(w http.ResponseWriter, r *http.Request) {
+			var m map[string]int
+			m["value"]++
+		}, http.StatusInternalServerError, true},
+		{"panic with an error", func(w http.ResponseWriter, r *http.Request) {
+			panic(errors.New("store closed"))
+		}, http.StatusInternalServerError, true},
+		{"panic after responding", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			panic("too late")
+		}, http.StatusAccepted, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			var logs bytes.Buffer
+			handler := Chain(tc.handler,
+				RequestID(func() string { return "abc" }),
+				Recover(slog.New(slog.NewJSONHandler(&logs, nil))),
+			)
+			before := Panics.Value()
+
+			response := httptest.NewRecorder()
+			aborted := func() (aborted bool) {
+				defer func() { aborted = recover() == http.ErrAbortHandler }()
+				handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/get", nil))
+				return false
+			}()
+
+			if response.Code != tc.wantStatus {
+				t.Errorf("got status %d, want %d", response.Code, tc.wantStatus)
+			}
+			if !tc.wantPanic {
+				if got := Panics.Value() - before; got != 0 {
+					t.Errorf("got %d panics counted, want none", got)
+				}
+				return
+			}
+			if got := Panics.Value() - before; got != 1 {
+				t.Errorf("got %d panics counted, want 1", got)
+			}
+			if !strings.Contains(logs.String(), `"stack"`) {
+				t.Errorf("got log %q, want the stack", logs.String())
+			}
+			if tc.wantStatus == http.StatusInternalServerError {
+				var body struct {
+					Error string `json:"error"`
+				}
+				if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+					t.Errorf("got body %q, want a JSON error", response.Body)
+				}
+			} else if !aborted {
+				t.Error("got the connection kept after the response started, want it dropped")
+			}
+		})
+	}
+}
+
+// Handlers dropping the connection on purpose are not reported
+func TestRecoverAbort(t *testing.T) {
+	var logs bytes.Buffer
+	handler := Recover(slog.New(slog.NewJSONHandler(&logs, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	before := Panics.Value()
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("got panic %v, want http.ErrAbortHandler passed on", p)
+		}
+		if Panics.Value() != before || logs.Len() != 0 {
+			t.Errorf("got the abort counted or logged: %s", logs.String())
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+// A handler wrapped by Timeout runs in another goroutine, whose stack is
+// the one logged
+func TestRecoverTimeout(t *testing.T) {
+	var logs bytes.Buffer
+	handler := Chain(http.HandlerFunc(panickingHandler),
+		Recover(slog.New(slog.NewJSONHandler(&logs, nil))),
+		Timeout(time.Second),
+	)
+
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/get", nil))
+
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", response.Code, http.StatusInternalServerError)
+	}
+	var line struct {
+		Panic string `json:"panic"`
+		Stack string `json:"stack"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+		t.Fatalf("got log %q: %v", logs.String(), err)
+	}
+	if line.Panic != "store closed" {
+		t.Errorf("got panic %q, want %q", line.Panic, "store closed")
+	}
+	if !strings.Contains(line.Stack, "httpserver.panickingHandler") {
+		t.Errorf("got stack %q, want the handler's frame", line.Stack)
+	}
+}
+
+func panickingHandler(w http.ResponseWriter, r *http.Request) {
+	panic("store closed")
+}
@@ -0,0 +1,191 @@
+// Package httpserver holds what the HTTP servers of the services share:
+// the middleware every request passes through, the index route, and a
+// server that shuts down gracefully on SIGINT or SIGTERM.
+//
+// It is in the shared module, which each service's go.mod points at with
+// a replace directive, so there is one copy for all three.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// The defaults of the zero fields of Options
+const (
+	DefaultReadTimeout     = 5 * time.Second
+	DefaultWriteTimeout    = 10 * time.Second
+	DefaultIdleTimeout     = 15 * time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+)
+
+// Options configure a Server. Zero timeouts take their defaults.
+type Options struct {
+	Addr    string
+	Handler http.Handler
+	// TLSConfig serves HTTPS if set, and HTTP otherwise
+	TLSConfig *tls.Config
+	Logger    *slog.Logger
+
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout bounds the wait for requests in flight, and for the
+	// shutdown hooks, once the server is stopping
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long requests are still served once shutdown
+	// starts, so load balancers can move traffic away first
+	DrainDelay time.Duration
+}
+
+// Server is an HTTP server that shuts down gracefully, running the hooks
+// registered with it as it does
+type Server struct {
+	server          *http.Server
+	logger          *slog.Logger
+	shutdownTimeout time.Duration
+	drainDelay      time.Duration
+
+	onDrain        []func()
+	beforeShutdown []func(context.Context)
+	afterShutdown  []func(context.Context)
+}
+
+// NewServer returns a server configured by opts, which is started by Run
+func NewServer(opts Options) *Server {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	return &Server{
+		server: &http.Server{
+			Addr:         opts.Addr,
+			Handler:      opts.Handler,
+			TLSConfig:    opts.TLSConfig,
+			ErrorLog:     slog.NewLogLogger(opts.Logger.Handler(), slog.LevelError),
+			ReadTimeout:  orDefault(opts.ReadTimeout, DefaultReadTimeout),
+			WriteTimeout: orDefault(opts.WriteTimeout, DefaultWriteTimeout),
+			IdleTimeout:  orDefault(opts.IdleTimeout, DefaultIdleTimeout),
+		},
+		logger:          opts.Logger,
+		shutdownTimeout: orDefault(opts.ShutdownTimeout, DefaultShutdownTimeout),
+		drainDelay:      opts.DrainDelay,
+	}
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// OnDrain registers f to be called as soon as shutdown starts, while
+// requests are still being served, such as to fail readiness checks
+func (s *Server) OnDrain(f func()) {
+	s.onDrain = append(s.onDrain, f)
+}
+
+// BeforeShutdown registers f to be called once the drain delay is over,
+// before the server stops taking requests, such as to stop other sources
+// of work. ctx is done when the shutdown timeout is reached.
+func (s *Server) BeforeShutdown(f func(ctx context.Context)) {
+	s.beforeShutdown = append(s.beforeShutdown, f)
+}
+
+// AfterShutdown registers f to be called once the requests in flight have
+// been handled, such as to flush work they left behind. ctx is done when
+// the shutdown timeout is reached.
+func (s *Server) AfterShutdown(f func(ctx context.Context)) {
+	s.afterShutdown = append(s.afterShutdown, f)
+}
+
+// RegisterOnShutdown registers f to be called as the server starts
+// waiting for the requests in flight, such as to end long-lived streams
+// that would otherwise hold it up
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.server.RegisterOnShutdown(f)
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
+
+// TLS returns whether the server serves HTTPS
+func (s *Server) TLS() bool {
+	return s.server.TLSConfig != nil
+}
+
+// Run serves until SIGINT or SIGTERM is received, or ctx is done, and then
+// shuts down: the drain hooks are called and requests are served for the
+// drain delay, the before shutdown hooks are called, the requests in
+// flight are waited for, and the after shutdown hooks are called, each in
+// the order they were registered. It returns once the server has shut
+// down, or with the error that stopped it from serving.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serving := make(chan error, 1)
+	go func() {
+		s.logger.Info("server is ready to handle requests", "addr", s.server.Addr, "tls", s.TLS())
+		if s.server.TLSConfig != nil {
+			serving <- s.server.ListenAndServeTLS("", "")
+		} else {
+			serving <- s.server.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-serving:
+		return err
+	case <-ctx.Done():
+	}
+	return s.shutdown()
+}
+
+func (s *Server) shutdown() error {
+	s.logger.Info("server is shutting down", "drain_delay", s.drainDelay.String())
+	for _, f := range s.onDrain {
+		f()
+	}
+	time.Sleep(s.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	for _, f := range s.beforeShutdown {
+		f(ctx)
+	}
+	s.server.SetKeepAlivesEnabled(false)
+	if err := s.server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("could not gracefully shut down the server: %w", err)
+	}
+	for _, f := range s.afterShutdown {
+		f(ctx)
+	}
+	return nil
+}
+
+// Index returns the handler of the / route, greeting with text. Any other
+// path not routed elsewhere is a 404.
+func Index(text string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, text)
+	})
+}
@@ -0,0 +1,157 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mark := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}), mark("first"), mark("second"))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got %v, want %v", order, want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var got string
+	handler := RequestID(func() string { return "generated" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = RequestIDFrom(r.Context())
+	}))
+
+	for _, tc := range []struct{ header, want string }{{"", "generated"}, {"given", "given"}} {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			request.Header.Set("X-Request-Id", tc.header)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if got != tc.want || response.Header().Get("X-Request-Id") != tc.want {
+			t.Errorf("header %q: got %q in the context and %q in the response, want %q", tc.header, got, response.Header().Get("X-Request-Id"), tc.want)
+		}
+	}
+}
+
+// IDs made at the same time are still distinct
+func TestNewRequestID(t *testing.T) {
+	const n = 1000
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- NewRequestID()
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+			t.Fatalf("got ID %q, want 32 hex digits", id)
+		}
+		if seen[id] {
+			t.Fatalf("got ID %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestRun(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	// Run listens itself, so find a free port for it first
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	server := NewServer(Options{Addr: listener.Addr().String(), Handler: handler})
+
+	var order []string
+	server.OnDrain(func() { order = append(order, "drain") })
+	server.BeforeShutdown(func(context.Context) { order = append(order, "before") })
+	server.AfterShutdown(func(context.Context) { order = append(order, "after") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error)
+	go func() { ran <- server.Run(ctx) }()
+
+	// A request in flight when shutdown starts is still answered
+	var body string
+	answered := make(chan struct{})
+	go func() {
+		defer close(answered)
+		for i := 0; i < 50; i++ {
+			resp, err := http.Get("http://" + server.Addr())
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			body = string(b)
+			return
+		}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not start")
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-answered
+	if err := <-ran; err != nil {
+		t.Fatal(err)
+	}
+	if body != "done" {
+		t.Errorf("got body %q, want the request in flight answered", body)
+	}
+	if want := []string{"drain", "before", "after"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got hooks %v, want %v", order, want)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+	}{{"/", http.StatusOK}, {"/missing", http.StatusNotFound}} {
+		response := httptest.NewRecorder()
+		Index("This is a server!").ServeHTTP(response, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if response.Code != tc.wantStatus {
+			t.Errorf("%v: got status %v, want %v", tc.path, response.Code, tc.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package jsonschema checks JSON bodies against the JSON Schemas of the
+// services' APIs, listing every field at fault.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a JSON Schema. Only the keywords the services' schemas use
+// are supported, and a schema with any other is refused rather than
+// partly enforced.
+type Schema struct {
+	Schema      string `json:"$schema"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type                 string             `json:"type"` // object, string, integer, number or boolean
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Pattern              string             `json:"pattern"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+
+	pattern *regexp.Regexp
+}
+
+// typeNames name the types of the schemas in messages
+var typeNames = map[string]string{
+	"object":  "an object",
+	"string":  "a string",
+	"integer": "an integer",
+	"number":  "a number",
+	"boolean": "a boolean",
+}
+
+// FieldError is a field of a body failing its schema
+type FieldError struct {
+	Field   string `json:"field"`   // a JSON Pointer, such as /serviceName, or empty for the whole body
+	Keyword string `json:"keyword"` // the keyword of the schema failed, such as maxLength
+	Message string `json:"message"`
+}
+
+// Error lists every field of a body failing its schema
+type Error struct {
+	Fields []FieldError
+}
+
+func (e *Error) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = strings.TrimSpace(strings.TrimPrefix(f.Field, "/") + " " + f.Message)
+	}
+	return "body does not match the schema: " + strings.Join(problems, "; ")
+}
+
+// Malformed reports whether the body is not of the shape of the schema at
+// all, holding fields of the wrong type or unknown ones, rather than
+// having fields whose values are out of bounds
+func (e *Error) Malformed() bool {
+	for _, f := range e.Fields {
+		if f.Keyword == "type" || f.Keyword == "additionalProperties" {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse reads a JSON Schema, refusing keywords it does not support
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %v", err)
+	}
+	return &s, s.compile()
+}
+
+// MustParse is Parse for the schemas built in, which are known to be
+// valid
+func MustParse(data []byte) *Schema {
+	s, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// compile checks the schema and its properties' schemas, compiling their
+// patterns
+func (s *Schema) compile() error {
+	if _, ok := typeNames[s.Type]; !ok {
+		return fmt.Errorf("unsupported schema type %q", s.Type)
+	}
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+		s.pattern = pattern
+	}
+	for name, property := range s.Properties {
+		if err := property.compile(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a JSON document, decoded with numbers as json.Number,
+// against the schema. The error is an *Error listing every field at
+// fault.
+func (s *Schema) Validate(doc interface{}) error {
+	var fields []FieldError
+	s.validate(doc, "", &fields)
+	if len(fields) > 0 {
+		return &Error{Fields: fields}
+	}
+	return nil
+}
+
+// validate appends the ways v, at the JSON Pointer path, fails the schema
+// to fields
+func (s *Schema) validate(v interface{}, path string, fields *[]FieldError) {
+	fail := func(keyword, format string, args ...interface{}) {
+		*fields = append(*fields, FieldError{Field: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := v.(map[string]interface{})
+		if !ok {
+			fail("type", "must be %s", typeNames[s.Type])
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				*fields = append(*fields, FieldError{Field: path + "/" + name, Keyword: "required", Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				property.validate(object[name], path+"/"+name, fields)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*fields = append(*fields, FieldError{Field: path + "/" + name, Keyword: "additionalProperties", Message: "is not allowed"})
+			}
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("type", "must be %s", typeNames[s.Type])
+			return
+		}
+		// Lengths are in characters, as JSON Schema counts them
+		length := utf8.RuneCountInString(str)
+		if s.MinLength != nil && length < *s.MinLength {
+			if *s.MinLength == 1 {
+				fail("minLength", "must not be empty")
+			} else {
+				fail("minLength", "must be at least %d characters", *s.MinLength)
+			}
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("maxLength", "must be at most %d characters", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			fail("pattern", "must match %s", s.Pattern)
+		}
+
+	case "integer", "number":
+		number, ok := v.(json.Number)
+		n, err := number.Float64()
+		if s.Type == "integer" {
+			_, err = number.Int64()
+		}
+		if !ok || err != nil {
+			fail("type", "must be %s", typeNames[s.Type])
+			return
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("minimum", "must be at least %s", formatNumber(*s.Minimum))
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("maximum", "must be at most %s", formatNumber(*s.Maximum))
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("type", "must be %s", typeNames[s.Type])
+		}
+	}
+}
+
+// formatNumber writes a limit of a schema as it appears in the schema,
+// rather than in exponent form
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
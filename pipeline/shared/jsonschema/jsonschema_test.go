@@ -0,0 +1,15 @@
+package jsonschema
+
+import "testing"
+
+func TestParseInvalid(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "object", "minProperties": 1}`,
+		`{"type": "array"}`,
+		`{"type": "object", "properties": {"name": {"type": "string", "pattern": "("}}}`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("%v: got no error", schema)
+		}
+	}
+}
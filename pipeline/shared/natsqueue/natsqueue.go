@@ -0,0 +1,219 @@
+// Package natsqueue passes values between the services over NATS
+// JetStream, as an alternative to calling the next hop directly.
+package natsqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"shared/httpserver"
+)
+
+const (
+	// queueStream holds the values passed along the pipeline, with a
+	// subject for each hop
+	queueStream = "PIPELINE"
+	// SubjectServerB and SubjectServerC carry the values sent to each
+	SubjectServerB = "pipeline.serverB"
+	SubjectServerC = "pipeline.serverC"
+
+	// queuePublishTimeout bounds waiting for the stream to store a message
+	queuePublishTimeout = 5 * time.Second
+	// queueDuplicateWindow is how long the stream remembers message IDs,
+	// so a publish retried within it is only stored once
+	queueDuplicateWindow = 2 * time.Minute
+	// queueAckWait is how long a consumer has to handle a message before
+	// it is redelivered
+	queueAckWait = 30 * time.Second
+	// queueRetryDelay is how long a message that failed waits before it
+	// is redelivered
+	queueRetryDelay = 2 * time.Second
+	// queueMaxDeliver bounds the deliveries of a message that keeps
+	// failing, after which it is dropped
+	queueMaxDeliver = 10
+)
+
+// Message is the body of the messages on the queue, the same as the
+// body posted to /post
+type Message struct {
+	ServiceName string `json:"serviceName"`
+	Value       int    `json:"value"`
+}
+
+// RejectedError marks a message that can never be handled, such as one
+// that cannot be decoded, so it is dropped rather than redelivered
+type RejectedError struct {
+	err error
+}
+
+func (e RejectedError) Error() string { return e.err.Error() }
+func (e RejectedError) Unwrap() error { return e.err }
+
+// Reject marks err as a rejection of the message being handled
+func Reject(err error) error {
+	return RejectedError{err}
+}
+
+// Queue passes values between the services over a NATS JetStream stream.
+// Messages are kept until a consumer acknowledges them, so a value sent
+// while the next service is down is handled once it is back, and each
+// value is handled at least once.
+type Queue struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+}
+
+// Connect connects to the NATS server at url as name, creating the
+// stream if it does not exist yet. The connection is re-established for
+// as long as the server is down.
+func Connect(ctx context.Context, url, name string) (*Queue, error) {
+	conn, err := nats.Connect(url, nats.Name(name), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       queueStream,
+		Subjects:   []string{SubjectServerB, SubjectServerC},
+		Duplicates: queueDuplicateWindow,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("creating stream %s: %v", queueStream, err)
+	}
+	return &Queue{conn: conn, js: js}, nil
+}
+
+// Close closes the connection to the NATS server. Messages being handled
+// are not acknowledged, so are redelivered.
+func (q *Queue) Close() {
+	q.conn.Close()
+}
+
+// Check reports whether the connection to the NATS server is up, for
+// /readyz
+func (q *Queue) Check() error {
+	if status := q.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("NATS is %s", status)
+	}
+	return nil
+}
+
+// Publish sends the value to subject, with the request ID and trace
+// context of ctx in its headers, and returns once the stream has stored
+// it. The request ID is also the message ID, so the stream stores a value
+// only once if it is published again, such as by a consumer handling a
+// redelivered message.
+func (q *Queue) Publish(ctx context.Context, subject, serviceName string, value int) error {
+	ctx, cancel := context.WithTimeout(ctx, queuePublishTimeout)
+	defer cancel()
+	ctx, span := otel.Tracer("queue").Start(ctx, "publish "+subject, trace.WithSpanKind(trace.SpanKindProducer))
+	defer span.End()
+
+	data, err := json.Marshal(&Message{ServiceName: serviceName, Value: value})
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(msg.Header))
+
+	var opts []jetstream.PublishOpt
+	if requestID, ok := httpserver.RequestIDFrom(ctx); ok {
+		msg.Header.Set("X-Request-Id", requestID)
+		opts = append(opts, jetstream.WithMsgID(subject+"/"+requestID))
+	}
+	if _, err := q.js.PublishMsg(ctx, msg, opts...); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Consume calls handle with the body of each message sent to subject, as
+// the durable consumer name, until the returned function is called. The
+// consumer picks up where it left off when the service restarts.
+func (q *Queue) Consume(ctx context.Context, name, subject string, logger *slog.Logger, handle func(context.Context, []byte) error) (func(), error) {
+	consumer, err := q.js.CreateOrUpdateConsumer(ctx, queueStream, jetstream.ConsumerConfig{
+		Durable:       name,
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       queueAckWait,
+		MaxDeliver:    queueMaxDeliver,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating consumer %s: %v", name, err)
+	}
+	consuming, err := consumer.Consume(func(msg jetstream.Msg) {
+		handleMessage(logger, msg, handle)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return consuming.Stop, nil
+}
+
+// handleMessage calls handle with the message, as the HTTP middleware
+// does handlers: it is traced, given the request ID in its headers or a
+// new one, and logged. The message is acknowledged if it was handled,
+// dropped if it was rejected, and otherwise redelivered after
+// queueRetryDelay.
+func handleMessage(logger *slog.Logger, msg jetstream.Msg, handle func(context.Context, []byte) error) {
+	start := time.Now()
+	header := msg.Headers()
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+	ctx, span := otel.Tracer("queue").Start(ctx, "receive "+msg.Subject(), trace.WithSpanKind(trace.SpanKindConsumer))
+	defer span.End()
+
+	requestID := header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID = httpserver.NewRequestID()
+	}
+	span.SetAttributes(attribute.String("request.id", requestID))
+	ctx = httpserver.WithRequestID(ctx, requestID)
+
+	err := handle(ctx, msg.Data())
+	var outcome string
+	var rejected RejectedError
+	switch {
+	case err == nil:
+		outcome = "ack"
+		msg.Ack()
+	case errors.As(err, &rejected):
+		outcome = "term"
+		msg.Term()
+	default:
+		outcome = "nak"
+		msg.NakWithDelay(queueRetryDelay)
+	}
+
+	attrs := []interface{}{
+		"subject", msg.Subject(),
+		"outcome", outcome,
+		"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		attrs = append(attrs, "delivered", meta.NumDelivered)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		attrs = append(attrs, "err", err)
+	}
+	logger.InfoContext(ctx, "message", attrs...)
+}
@@ -0,0 +1,83 @@
+package natsqueue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"shared/httpserver"
+	"shared/logging"
+)
+
+// testMsg is a message delivered for the first time, recording how it was
+// acknowledged. The methods handleMessage does not use are left nil.
+type testMsg struct {
+	jetstream.Msg
+	header  nats.Header
+	data    []byte
+	outcome string
+}
+
+func (m *testMsg) Subject() string      { return SubjectServerB }
+func (m *testMsg) Headers() nats.Header { return m.header }
+func (m *testMsg) Data() []byte         { return m.data }
+func (m *testMsg) Ack() error           { m.outcome = "ack"; return nil }
+func (m *testMsg) Term() error          { m.outcome = "term"; return nil }
+
+func (m *testMsg) NakWithDelay(time.Duration) error {
+	m.outcome = "nak"
+	return nil
+}
+
+func (m *testMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: 1}, nil
+}
+
+func TestHandleMessage(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		err         error
+		wantOutcome string
+	}{
+		{"handled", nil, "ack"},
+		{"failed", errors.New("serverC is down"), "nak"},
+		{"rejected", Reject(errors.New("invalid JSON body")), "term"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			logs := new(bytes.Buffer)
+			msg := &testMsg{
+				header: nats.Header{"X-Request-Id": []string{"abc123"}},
+				data:   []byte(`{"serviceName":"serviceA","value":8}`),
+			}
+
+			var gotRequestID string
+			handleMessage(logging.New(logs, nil), msg, func(ctx context.Context, data []byte) error {
+				gotRequestID, _ = httpserver.RequestIDFrom(ctx)
+				return tc.err
+			})
+
+			if msg.outcome != tc.wantOutcome {
+				t.Errorf("got outcome %q, want %q", msg.outcome, tc.wantOutcome)
+			}
+			if gotRequestID != "abc123" {
+				t.Errorf("got request ID %q, want abc123", gotRequestID)
+			}
+
+			var line map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+			if line["msg"] != "message" || line["outcome"] != tc.wantOutcome || line["request_id"] != "abc123" {
+				t.Errorf("got %v logged, want a message line with outcome %q and request_id abc123", line, tc.wantOutcome)
+			}
+		})
+	}
+}
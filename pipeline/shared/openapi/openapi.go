@@ -0,0 +1,247 @@
+// Package openapi describes the HTTP APIs of the services in OpenAPI 3,
+// and serves the description and a page to browse it.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Document is an OpenAPI 3 description of the HTTP API. Only the
+// parts of the specification the services use are modelled.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type Operation struct {
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // query, header or path
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	MaxLength   *int               `json:"maxLength,omitempty"`
+	Minimum     *int               `json:"minimum,omitempty"`
+	Maximum     *int               `json:"maximum,omitempty"`
+}
+
+// Spec builds the description of an API from the Go types of its
+// requests and responses, so the two cannot drift apart
+type Spec struct {
+	Doc Document
+}
+
+// New returns the description of an API with no operations yet
+func New(title, version, description string) *Spec {
+	return &Spec{Doc: Document{
+		OpenAPI:    "3.0.3",
+		Info:       Info{Title: title, Version: version, Description: description},
+		Paths:      make(map[string]map[string]*Operation),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}}
+}
+
+// Add describes the operation of method on path
+func (o *Spec) Add(method, path string, op *Operation) {
+	if o.Doc.Paths[path] == nil {
+		o.Doc.Paths[path] = make(map[string]*Operation)
+	}
+	o.Doc.Paths[path][strings.ToLower(method)] = op
+}
+
+// Schema returns the schema of the JSON encoding of v's type. Named struct
+// types are added to the components and referred to, under their Go name.
+func (o *Spec) Schema(v interface{}) *Schema {
+	return o.schemaOf(reflect.TypeOf(v))
+}
+
+// Component returns the schema of the named struct type of v, as added to
+// the components, so it can be refined, such as with the limits checked
+// on its fields
+func (o *Spec) Component(v interface{}) *Schema {
+	t := reflect.TypeOf(v)
+	o.schemaOf(t)
+	return o.Doc.Components.Schemas[componentName(t)]
+}
+
+func (o *Spec) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: o.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return o.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := o.Doc.Components.Schemas[name]; !ok {
+			// Claim the name first, in case the type refers to itself
+			o.Doc.Components.Schemas[name] = nil
+			o.Doc.Components.Schemas[name] = o.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema describes the fields of a struct as encoding/json encodes
+// them: named by their json tags, with embedded structs' fields promoted.
+// Fields without omitempty are required.
+func (o *Spec) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				promoted := o.structSchema(embedded)
+				for k, v := range promoted.Properties {
+					s.Properties[k] = v
+				}
+				s.Required = append(s.Required, promoted.Required...)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = o.schemaOf(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// componentName names the schema of a Go type, such as postRequest
+func componentName(t reflect.Type) string {
+	return t.Name()
+}
+
+// IntRef returns a pointer to n, for the optional limits of a schema
+func IntRef(n int) *int {
+	return &n
+}
+
+// JSONContent describes a JSON body of the schema
+func JSONContent(s *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// Serve returns the handler of the /openapi.json route. The document is
+// encoded once, as it does not change while the server runs.
+func Serve(o *Spec) http.HandlerFunc {
+	body, err := json.MarshalIndent(o.Doc, "", "  ")
+	if err != nil {
+		panic(fmt.Sprintf("encoding OpenAPI document: %v", err))
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+// swaggerUITemplate is the page of the /docs route, which loads Swagger
+// UI from a CDN to browse the OpenAPI document
+var swaggerUITemplate = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.onload = () => { window.ui = SwaggerUIBundle({url: {{.SpecURL}}, dom_id: "#swagger-ui"}); };
+</script>
+</body>
+</html>
+`))
+
+// SwaggerUI returns the handler of the /docs route, browsing the document
+// served at specURL
+func SwaggerUI(title, specURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		swaggerUITemplate.Execute(w, struct{ Title, SpecURL string }{title, specURL})
+	}
+}
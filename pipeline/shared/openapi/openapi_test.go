@@ -0,0 +1,56 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISchema(t *testing.T) {
+	type inner struct {
+		Name string `json:"name"`
+	}
+	type outer struct {
+		inner
+		Count   int      `json:"count,omitempty"`
+		Tags    []string `json:"tags"`
+		Ignored string   `json:"-"`
+		hidden  string
+		Child   *inner `json:"child,omitempty"`
+	}
+
+	spec := New("test", "1", "")
+	got := spec.Component(outer{})
+
+	var properties []string
+	for name := range got.Properties {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+	if want := []string{"child", "count", "name", "tags"}; !reflect.DeepEqual(properties, want) {
+		t.Errorf("got properties %v, want %v", properties, want)
+	}
+	if want := []string{"name", "tags"}; !reflect.DeepEqual(got.Required, want) {
+		t.Errorf("got required %v, want %v", got.Required, want)
+	}
+	if tags := got.Properties["tags"]; tags.Type != "array" || tags.Items.Type != "string" {
+		t.Errorf("got tags %+v, want an array of strings", tags)
+	}
+	if child := got.Properties["child"]; child.Ref != "#/components/schemas/inner" {
+		t.Errorf("got child %+v, want a reference to inner", child)
+	}
+	if _, ok := spec.Doc.Components.Schemas["inner"]; !ok {
+		t.Error("inner was not added to the components")
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	response := httptest.NewRecorder()
+	SwaggerUI("test API", "/openapi.json")(response, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if body := response.Body.String(); !strings.Contains(body, `"/openapi.json"`) || !strings.Contains(body, "SwaggerUIBundle") {
+		t.Errorf("got %s, want a Swagger UI page loading /openapi.json", body)
+	}
+}
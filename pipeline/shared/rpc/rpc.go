@@ -0,0 +1,168 @@
+// Package rpc sets up the gRPC servers and clients of the pipeline, so
+// calls carry request IDs and trace context as HTTP requests do.
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"shared/health"
+	"shared/httpserver"
+)
+
+// RequestIDMetadata carries the request ID of gRPC calls, as the
+// X-Request-Id header does over HTTP
+const RequestIDMetadata = "x-request-id"
+
+// grpcServiceConfig retries calls to the pipeline while the next hop is
+// unavailable, backing off as the HTTP forwarder does. The call's deadline
+// bounds the retries.
+const grpcServiceConfig = `{
+	"methodConfig": [{
+		"name": [{"service": "pipeline.v1.Pipeline"}],
+		"retryPolicy": {
+			"maxAttempts": 5,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "2s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// grpcCredentials returns the credentials for the TLS config, which are
+// plaintext if it is nil
+func grpcCredentials(config *tls.Config) credentials.TransportCredentials {
+	if config == nil {
+		return insecure.NewCredentials()
+	}
+	return credentials.NewTLS(config)
+}
+
+// NewServer returns a gRPC server whose interceptors mirror the HTTP
+// middleware: each call is traced, given a request ID and logged. The gRPC
+// health service is registered, and should be shut down along with the
+// server.
+func NewServer(logger *slog.Logger, config *tls.Config) (*grpc.Server, *grpchealth.Server) {
+	server := grpc.NewServer(
+		grpc.Creds(grpcCredentials(config)),
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			requestIDInterceptor(httpserver.NewRequestID),
+			loggingInterceptor(logger),
+		),
+	)
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	return server, healthServer
+}
+
+// Stop stops the server once its calls have finished, or straight away
+// once ctx is done
+func Stop(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
+	}
+}
+
+// requestIDInterceptor gives each call the ID in its x-request-id metadata,
+// or a new one if it has none, and echoes the ID in the response trailer.
+// A header would stop clients retrying failed calls, as it commits them to
+// the first attempt. The ID is also recorded on the call's span.
+func requestIDInterceptor(nextRequestID func() string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var requestID string
+		if ids := metadata.ValueFromIncomingContext(ctx, RequestIDMetadata); len(ids) > 0 {
+			requestID = ids[0]
+		}
+		if requestID == "" {
+			requestID = nextRequestID()
+		}
+		grpc.SetTrailer(ctx, metadata.Pairs(RequestIDMetadata, requestID))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", requestID))
+		return handler(httpserver.WithRequestID(ctx, requestID), req)
+	}
+}
+
+// loggingInterceptor logs each call once it has been handled, as the
+// logging middleware does requests
+func loggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		var remoteAddr string
+		if p, ok := peer.FromContext(ctx); ok {
+			remoteAddr = p.Addr.String()
+		}
+		logger.InfoContext(ctx, "rpc",
+			"method", info.FullMethod,
+			"code", status.Code(err).String(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", remoteAddr,
+		)
+		return resp, err
+	}
+}
+
+// Dial returns a connection to the gRPC server at target, which passes
+// on the request ID and trace context of each call. The interceptors are
+// called in order for each call.
+func Dial(target string, config *tls.Config, interceptors ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	return grpc.NewClient(target,
+		grpc.WithTransportCredentials(grpcCredentials(config)),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{propagateRequestID}, interceptors...)...),
+		grpc.WithDefaultServiceConfig(grpcServiceConfig),
+	)
+}
+
+// propagateRequestID sends the request ID of the call's context, if any,
+// in its metadata
+func propagateRequestID(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if requestID, ok := httpserver.RequestIDFrom(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadata, requestID)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// CheckHealth returns a check that the gRPC server at the other end of
+// the connection reports it is serving
+func CheckHealth(conn *grpc.ClientConn) func() error {
+	client := healthpb.NewHealthClient(conn)
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), health.ReadinessTimeout)
+		defer cancel()
+
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if err != nil {
+			return err
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("%s is %s", conn.Target(), resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,183 @@
+// Package tlsconfig builds the TLS configs the services serve with and call
+// the next hop with, from certificate files that can be rotated without a
+// restart.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// CertReloader holds a certificate and key loaded from files, so they can
+// be reloaded when rotated without restarting the service
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex // protects cert
+	cert *tls.Certificate
+}
+
+// NewCertReloader loads the certificate and key in the files
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the files again. The certificate in use is kept if they
+// cannot be loaded.
+func (c *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading %s: %v", c.certFile, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+func (c *CertReloader) certificate() *tls.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert
+}
+
+// GetCertificate presents the certificate to clients, for tls.Config
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.certificate(), nil
+}
+
+// GetClientCertificate presents the certificate to servers, for tls.Config
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.certificate(), nil
+}
+
+// ReloadOnSIGHUP reloads the certificates each time the process receives
+// SIGHUP, logging any that fail to load. Nil reloaders are skipped.
+func ReloadOnSIGHUP(all ...*CertReloader) {
+	var reloaders []*CertReloader
+	for _, c := range all {
+		if c != nil {
+			reloaders = append(reloaders, c)
+		}
+	}
+	if len(reloaders) == 0 {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, c := range reloaders {
+				if err := c.Reload(); err != nil {
+					slog.Error("could not reload certificate", "err", err)
+					continue
+				}
+				slog.Info("reloaded certificate", "cert", c.certFile)
+			}
+		}
+	}()
+}
+
+// loadCertPool reads the PEM encoded CA certificates in the file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// Server returns the config for serving HTTPS with the certificate and
+// key in the files, or nil if they are not set. If clientCAFile is set,
+// clients must present a certificate signed by one of its CAs (mutual TLS).
+func Server(certFile, keyFile, clientCAFile string) (*tls.Config, *CertReloader, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, nil, errors.New("a client CA needs a certificate and key to serve")
+		}
+		return nil, nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, nil, errors.New("both a certificate and key are needed to serve")
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, reloader, nil
+}
+
+// Client returns the config for calling the next hop over HTTPS, or nil
+// if none of the files are set. The next hop's certificate is checked
+// against the CAs in caFile, or the system's if it is not set, and the
+// certificate and key, if set, are presented for mutual TLS.
+func Client(certFile, keyFile, caFile string) (*tls.Config, *CertReloader, error) {
+	if certFile == "" && keyFile == "" && caFile == "" {
+		return nil, nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, nil, errors.New("both a certificate and key are needed to present")
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	var reloader *CertReloader
+	if certFile != "" {
+		var err error
+		reloader, err = NewCertReloader(certFile, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return config, reloader, nil
+}
+
+// Transport returns the transport for calls to the next hop, which is
+// http.DefaultTransport unless there is a TLS config
+func Transport(config *tls.Config) http.RoundTripper {
+	if config == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}